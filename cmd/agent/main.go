@@ -50,8 +50,12 @@ func main() {
 	lg.Debug(
 		"config values",
 		zap.String("address", cfg.URL),
+		zap.String("agent id", cfg.AgentID),
+		zap.String("version", agent.Version),
 		zap.Int("report interval", cfg.ReportInterval),
 		zap.Int("poll interval", cfg.PollInterval),
+		zap.String("scrape targets", cfg.ScrapeTargets),
+		zap.String("scrape config", cfg.ScrapeConfigPath),
 	)
 
 	var wg sync.WaitGroup
@@ -61,7 +65,23 @@ func main() {
 	go mAgent.Run(ctx, &wg)
 	lg.Info("Ran agent")
 
-	client := agent.NewMetricClient(ctx, mAgent, cfg)
+	var sources []agent.Source
+	scrapeCfg, err := agent.LoadScrapeConfig(cfg)
+	if err != nil {
+		lg.Fatal("error loading scrape config", zap.Error(err))
+	}
+	if len(scrapeCfg.Targets) > 0 {
+		scraper, err := agent.NewScraper(ctx, scrapeCfg)
+		if err != nil {
+			lg.Fatal("error creating scraper", zap.Error(err))
+		}
+		wg.Add(1)
+		go scraper.Run(ctx, &wg)
+		lg.Info("Ran scraper", zap.Int("targets", len(scrapeCfg.Targets)))
+		sources = append(sources, scraper)
+	}
+
+	client := agent.NewMetricClient(ctx, mAgent, cfg, sources...)
 	wg.Add(1)
 	go client.Run(ctx, &wg)
 	lg.Info("Ran client")
@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server"
 	"github.com/AA122AA/metring/internal/server/config"
@@ -14,8 +16,28 @@ import (
 	"github.com/AA122AA/metring/internal/server/database/query"
 	mHandler "github.com/AA122AA/metring/internal/server/handler"
 	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/agents"
+	"github.com/AA122AA/metring/internal/server/service/alerts"
+	"github.com/AA122AA/metring/internal/server/service/cardinality"
+	"github.com/AA122AA/metring/internal/server/service/expiry"
+	"github.com/AA122AA/metring/internal/server/service/export"
+	"github.com/AA122AA/metring/internal/server/service/federation"
+	"github.com/AA122AA/metring/internal/server/service/forward"
+	"github.com/AA122AA/metring/internal/server/service/graphite"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/importer"
+	"github.com/AA122AA/metring/internal/server/service/metadata"
 	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/notify"
+	"github.com/AA122AA/metring/internal/server/service/otlp"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/AA122AA/metring/internal/server/service/ratelimit"
+	"github.com/AA122AA/metring/internal/server/service/recording"
+	"github.com/AA122AA/metring/internal/server/service/remotewrite"
 	"github.com/AA122AA/metring/internal/server/service/saver"
+	"github.com/AA122AA/metring/internal/server/service/statsd"
+	"github.com/AA122AA/metring/internal/server/service/stream"
+	"github.com/AA122AA/metring/internal/server/service/tenants"
 	"github.com/AA122AA/metring/internal/zapcfg"
 	"github.com/creasty/defaults"
 	"github.com/go-faster/sdk/zctx"
@@ -72,11 +94,28 @@ func run() error {
 		zap.String("file storage path", cfg.SaverCfg.FileStoragePath),
 		zap.Int("store interval", cfg.SaverCfg.StoreInterval),
 		zap.Bool("restore", cfg.SaverCfg.Restore),
+		zap.String("statsd address", cfg.StatsdCfg.Addr),
+		zap.String("graphite address", cfg.GraphiteCfg.Addr),
+		zap.String("histogram buckets", cfg.MetricsCfg.HistogramBuckets),
+		zap.Int("ttl", cfg.MetricsCfg.TTL),
+		zap.Int("set interval", cfg.MetricsCfg.SetInterval),
+		zap.Int("max series", cfg.MetricsCfg.MaxSeries),
+		zap.Int("max series per tenant", cfg.MetricsCfg.MaxSeriesPerTenant),
+		zap.String("alert rules", cfg.AlertsCfg.RulesFile),
+		zap.String("notify receivers", cfg.NotifyCfg.ReceiversFile),
+		zap.String("forward sinks", cfg.ForwardCfg.SinksFile),
+		zap.String("federation sources", cfg.FederationCfg.SourcesFile),
+		zap.String("tenants file", cfg.TenantsCfg.File),
+		zap.Int64("max body size", cfg.MaxBodySize),
+		zap.Int("max batch", cfg.MaxBatch),
+		zap.Float64("rate limit", cfg.RateLimitCfg.Rate),
 	)
 
 	// Init repo
 	var repo repository.MetricsRepository
-	repo = repository.NewMemStorage()
+	var metaRepo repository.MetadataRepository
+	memStorage := repository.NewMemStorage()
+	repo, metaRepo = memStorage, memStorage
 
 	var dBase *database.Database
 	// Init DB
@@ -95,14 +134,70 @@ func run() error {
 		}
 		d := dBase.DB()
 		q := query.New(d)
-		repo = repository.NewPSQLStorage(ctx, q, dBase)
+		psqlStorage := repository.NewPSQLStorage(ctx, q, dBase)
+		repo, metaRepo = psqlStorage, psqlStorage
 	}
 
 	// Create wait group
 	var wg sync.WaitGroup
 
 	// Init services
-	srv := metrics.NewMetrics(ctx, repo)
+	buckets, err := metrics.ParseBuckets(cfg.MetricsCfg.HistogramBuckets)
+	if err != nil {
+		lg.Fatal("bad histogram buckets", zap.Error(err))
+	}
+	var namePattern *regexp.Regexp
+	if cfg.MetricsCfg.NamePattern != "" {
+		namePattern, err = regexp.Compile(cfg.MetricsCfg.NamePattern)
+		if err != nil {
+			lg.Fatal("bad metric name pattern", zap.Error(err))
+		}
+	}
+	registry := metadata.NewRegistry(ctx, metaRepo)
+	hub := stream.NewHub(ctx, cfg.StreamCfg)
+	hist := history.NewHistory(cfg.HistoryCfg)
+	var sinks []*forward.Sink
+	if cfg.ForwardCfg.SinksFile != "" {
+		sinks, err = forward.LoadSinks(cfg.ForwardCfg.SinksFile)
+		if err != nil {
+			lg.Fatal("can not load forward sinks", zap.Error(err))
+		}
+	}
+	chain := federation.NewChain(cfg.FederationCfg.ID)
+	forwarder := forward.NewForwarder(ctx, sinks, forward.WithVia(chain.List))
+	wg.Add(1)
+	go forwarder.Run(ctx, &wg)
+	lg.Debug("Ran forwarder", zap.Int("sinks", len(sinks)))
+	seriesLimiter := cardinality.NewLimiter(ctx, repo, cfg.MetricsCfg.MaxSeries, cfg.MetricsCfg.MaxSeriesPerTenant)
+	srv := metrics.NewMetrics(ctx, repo,
+		metrics.WithBuckets(buckets),
+		metrics.WithSetInterval(time.Duration(cfg.MetricsCfg.SetInterval)*time.Second),
+		metrics.WithMetadata(registry),
+		metrics.WithSeriesLimiter(seriesLimiter),
+		metrics.WithNameRules(cfg.MetricsCfg.MaxNameLength, namePattern),
+		metrics.WithStrictNames(cfg.MetricsCfg.StrictNames),
+		metrics.WithPublisher(hub),
+		metrics.WithPublisher(hist),
+		metrics.WithPublisher(forwarder),
+	)
+	var federationSettings *federation.Settings
+	if cfg.FederationCfg.SourcesFile != "" {
+		federationSettings, err = federation.LoadSettings(cfg.FederationCfg.SourcesFile)
+		if err != nil {
+			lg.Fatal("can not load federation sources", zap.Error(err))
+		}
+	}
+	federator := federation.NewFederator(ctx, cfg.FederationCfg, srv, federationSettings, chain)
+	wg.Add(1)
+	go federator.Run(ctx, &wg)
+	lg.Debug("Ran federator", zap.String("id", chain.ID()))
+	tenantRegistry, err := tenants.NewRegistry(ctx, cfg.TenantsCfg)
+	if err != nil {
+		lg.Fatal("can not load tenants", zap.Error(err))
+	}
+	agentRegistry := agents.NewRegistry(ctx, cfg.AgentsCfg)
+	otlpSrv := otlp.NewReceiver(ctx, srv)
+	remoteWriteSrv := remotewrite.NewReceiver(ctx, srv)
 
 	var saverSvc *saver.Saver
 	if cfg.SaverCfg.FileStoragePath != "" {
@@ -116,12 +211,122 @@ func run() error {
 		lg.Debug("saver should be nil", zap.Any("saverSvc", saverSvc))
 	}
 
+	if cfg.MetricsCfg.TTL > 0 {
+		var remover expiry.Remover
+		if saverSvc != nil {
+			remover = saverSvc
+		}
+		expirer := expiry.NewExpirer(ctx, time.Duration(cfg.MetricsCfg.TTL)*time.Minute, srv, remover)
+		wg.Add(1)
+		go expirer.Run(ctx, &wg)
+		lg.Debug("Ran expiry")
+	}
+
+	var rules []*alerts.Rule
+	if cfg.AlertsCfg.RulesFile != "" {
+		rules, err = alerts.LoadRules(cfg.AlertsCfg.RulesFile)
+		if err != nil {
+			lg.Fatal("can not load alert rules", zap.Error(err))
+		}
+	}
+	var receivers []*notify.Receiver
+	if cfg.NotifyCfg.ReceiversFile != "" {
+		receivers, err = notify.LoadReceivers(cfg.NotifyCfg.ReceiversFile)
+		if err != nil {
+			lg.Fatal("can not load notification receivers", zap.Error(err))
+		}
+	}
+	notifier, err := notify.NewNotifier(ctx, cfg.NotifyCfg, receivers)
+	if err != nil {
+		lg.Fatal("can not create notifier", zap.Error(err))
+	}
+	wg.Add(1)
+	go notifier.Run(ctx, &wg)
+	lg.Debug("Ran notifier", zap.Int("receivers", len(receivers)))
+
+	queryEngine := promql.NewEngine(ctx, srv, hist)
+	alertEngine := alerts.NewEngine(ctx, cfg.AlertsCfg, srv, rules, alerts.WithNotifier(notifier), alerts.WithQuerier(queryEngine))
+	wg.Add(1)
+	go alertEngine.Run(ctx, &wg)
+	lg.Debug("Ran alerts engine", zap.Int("rules", len(rules)))
+
+	var recordingRules []*recording.Rule
+	if cfg.RecordingCfg.RulesFile != "" {
+		recordingRules, err = recording.LoadRules(cfg.RecordingCfg.RulesFile)
+		if err != nil {
+			lg.Fatal("can not load recording rules", zap.Error(err))
+		}
+	}
+	recordingEngine := recording.NewEngine(ctx, cfg.RecordingCfg, srv, queryEngine, recordingRules)
+	wg.Add(1)
+	go recordingEngine.Run(ctx, &wg)
+	lg.Debug("Ran recording engine", zap.Int("rules", len(recordingRules)))
+
+	limiter := ratelimit.NewLimiter(ctx, cfg.RateLimitCfg)
+	wg.Add(1)
+	go limiter.Run(ctx, &wg)
+
+	if cfg.StatsdCfg.Addr != "" {
+		statsdListener := statsd.NewListener(ctx, cfg.StatsdCfg, srv)
+		wg.Add(1)
+		go statsdListener.Run(ctx, &wg)
+		lg.Debug("Ran statsd listener")
+	}
+
+	if cfg.GraphiteCfg.Addr != "" {
+		graphiteListener, err := graphite.NewListener(ctx, cfg.GraphiteCfg, srv)
+		if err != nil {
+			lg.Fatal("can not create graphite listener", zap.Error(err))
+		}
+		wg.Add(1)
+		go graphiteListener.Run(ctx, &wg)
+		lg.Debug("Ran graphite listener")
+	}
+
 	// Init handlers
-	metricHandler := mHandler.NewMetricsHandler(ctx, cfg.TemplatePath, srv, saverSvc)
+	metricHandler := mHandler.NewMetricsHandler(ctx, cfg.TemplatePath, srv, saverSvc, mHandler.WithMaxBatch(cfg.MaxBatch), mHandler.WithAgents(agentRegistry), mHandler.WithHistory(hist))
 	pingHandler := mHandler.NewPingHandler(ctx, dBase)
+	otlpHandler := mHandler.NewOTLPHandler(ctx, otlpSrv)
+	remoteWriteHandler := mHandler.NewRemoteWriteHandler(ctx, remoteWriteSrv, cfg.MaxBodySize)
+	metadataHandler := mHandler.NewMetadataHandler(ctx, registry)
+	agentsHandler := mHandler.NewAgentsHandler(ctx, agentRegistry)
+	alertsHandler := mHandler.NewAlertsHandler(ctx, alertEngine)
+	recordingHandler := mHandler.NewRecordingHandler(ctx, recordingEngine)
+	silencesHandler := mHandler.NewSilencesHandler(ctx, notifier)
+	streamHandler := mHandler.NewStreamHandler(ctx, hub, stream.ParseOrigins(cfg.StreamCfg.AllowedOrigins))
+	promAPIHandler := mHandler.NewPromAPIHandler(ctx, queryEngine)
+	exportHandler := mHandler.NewExportHandler(ctx, export.NewExporter(ctx, srv, hist))
+	importHandler := mHandler.NewImportHandler(ctx, importer.NewImporter(ctx, srv, hist))
+	tenantsHandler := mHandler.NewTenantsHandler(ctx, tenantRegistry)
+	cardinalityHandler := mHandler.NewCardinalityHandler(ctx, seriesLimiter)
+	forwardingHandler := mHandler.NewForwardingHandler(ctx, forwarder)
+	federationHandler := mHandler.NewFederationHandler(ctx, federator, chain)
 
 	// Init routers
-	router := server.NewRouter(ctx, metricHandler, pingHandler)
+	router := server.NewRouter(ctx, server.Handlers{
+		Metrics:     metricHandler,
+		Ping:        pingHandler,
+		OTLP:        otlpHandler,
+		RemoteWrite: remoteWriteHandler,
+		Metadata:    metadataHandler,
+		Agents:      agentsHandler,
+		Alerts:      alertsHandler,
+		Recording:   recordingHandler,
+		Silences:    silencesHandler,
+		Stream:      streamHandler,
+		PromAPI:     promAPIHandler,
+		Export:      exportHandler,
+		Import:      importHandler,
+		Tenants:     tenantsHandler,
+		Cardinality: cardinalityHandler,
+		Forwarding:  forwardingHandler,
+		Federation:  federationHandler,
+	}, server.RouterOptions{
+		Tenants:  tenantRegistry,
+		AdminKey: cfg.TenantsCfg.AdminKey,
+		Limiter:  limiter,
+		MaxBody:  cfg.MaxBodySize,
+	})
 
 	// Init server
 	server := server.NewServer(ctx, cfg, router)
@@ -0,0 +1,20 @@
+-- name: GetMetadata :one
+SELECT * FROM metadata
+WHERE tenant = $1 AND name = $2 LIMIT 1;
+
+-- name: GetAllMetadata :many
+SELECT * FROM metadata
+WHERE tenant = $1
+ORDER BY name;
+
+-- name: WriteMetadata :exec
+INSERT INTO metadata (
+  name, type, unit, description, owner, tenant
+) VALUES (
+  $1,$2,$3,$4,$5,$6
+)
+ON CONFLICT (tenant, name) DO UPDATE
+SET type = EXCLUDED.type,
+    unit = EXCLUDED.unit,
+    description = EXCLUDED.description,
+    owner = EXCLUDED.owner;
@@ -1,18 +1,37 @@
 -- name: Get :one
 SELECT * FROM metrics
-WHERE name = $1 LIMIT 1;
+WHERE tenant = $1 AND series = $2 LIMIT 1;
 
 -- name: GetAll :many
 SELECT * FROM metrics
+WHERE tenant = $1
 ORDER BY id;
 
 -- name: Write :exec
 INSERT INTO metrics (
-  name, type, delta, value, hash
+  name, type, delta, value, hash, labels, series, data, updated_at, tenant
 ) VALUES (
-  $1,$2,$3,$4,$5
-);
+  $1,$2,$3,$4,$5,$6,$7,$8,$9,$10
+)
+ON CONFLICT (tenant, series) DO UPDATE SET
+  name = EXCLUDED.name,
+  type = EXCLUDED.type,
+  delta = EXCLUDED.delta,
+  value = EXCLUDED.value,
+  hash = EXCLUDED.hash,
+  labels = EXCLUDED.labels,
+  data = EXCLUDED.data,
+  updated_at = EXCLUDED.updated_at;
 
 -- name: Update :exec
-UPDATE metrics SET (delta, value) = ($1, $2)
-WHERE name = $3;
+UPDATE metrics SET (delta, value, data, updated_at) = ($1, $2, $3, $4)
+WHERE tenant = $5 AND series = $6;
+
+-- name: Delete :many
+DELETE FROM metrics
+WHERE tenant = @tenant AND series = ANY(@series::text[]) AND updated_at < @before
+RETURNING series;
+
+-- name: Tenants :many
+SELECT DISTINCT tenant FROM metrics
+ORDER BY tenant;
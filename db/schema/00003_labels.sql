@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE metrics
+    ADD COLUMN labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    ADD COLUMN series TEXT;
+
+UPDATE metrics SET series = name;
+
+ALTER TABLE metrics
+    ALTER COLUMN series SET NOT NULL;
+
+CREATE INDEX metrics_series_idx ON metrics (series);
+CREATE INDEX metrics_labels_idx ON metrics USING GIN (labels);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX metrics_labels_idx;
+DROP INDEX metrics_series_idx;
+
+ALTER TABLE metrics
+    DROP COLUMN series,
+    DROP COLUMN labels;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE metrics
+    ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+DROP INDEX metrics_series_idx;
+CREATE INDEX metrics_tenant_series_idx ON metrics (tenant, series);
+
+ALTER TABLE metadata
+    ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE metadata
+    DROP CONSTRAINT metadata_pkey,
+    ADD PRIMARY KEY (tenant, name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE metadata
+    DROP CONSTRAINT metadata_pkey,
+    ADD PRIMARY KEY (name);
+
+ALTER TABLE metadata
+    DROP COLUMN tenant;
+
+DROP INDEX metrics_tenant_series_idx;
+CREATE INDEX metrics_series_idx ON metrics (series);
+
+ALTER TABLE metrics
+    DROP COLUMN tenant;
+-- +goose StatementEnd
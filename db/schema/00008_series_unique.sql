@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Гонка двух INSERT могла оставить дубликаты серии, оставляем последнюю запись
+DELETE FROM metrics m
+USING metrics newer
+WHERE m.tenant = newer.tenant AND m.series = newer.series AND m.id < newer.id;
+
+DROP INDEX metrics_tenant_series_idx;
+CREATE UNIQUE INDEX metrics_tenant_series_key ON metrics (tenant, series);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX metrics_tenant_series_key;
+CREATE INDEX metrics_tenant_series_idx ON metrics (tenant, series);
+-- +goose StatementEnd
@@ -10,16 +10,17 @@ import (
 	"sync"
 	"time"
 
-	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/wire"
 	"github.com/go-faster/sdk/zctx"
 	"go.uber.org/zap"
 )
 
 type Metric struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type MetricAgent struct {
@@ -51,6 +52,11 @@ func (ma *MetricAgent) Run(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+// Name имя сборщика, которое агент сообщает серверу.
+func (ma *MetricAgent) Name() string {
+	return "runtime"
+}
+
 func (ma *MetricAgent) GetMetrics() map[string]*Metric {
 	ma.mu.Lock()
 	defer ma.mu.Unlock()
@@ -81,14 +87,14 @@ func (ma *MetricAgent) GatherMetrics() {
 	d := int64(1)
 	ma.mm["PollCount"] = &Metric{
 		ID:    "PollCount",
-		MType: domain.Counter,
+		MType: wire.Counter,
 		Delta: &d,
 	}
 
 	r := rand.Float64()
 	ma.mm["RandomValue"] = &Metric{
 		ID:    "RandomValue",
-		MType: domain.Gauge,
+		MType: wire.Gauge,
 		Value: &r,
 	}
 
@@ -126,7 +132,7 @@ func createMetric(v reflect.Value, t reflect.Type, i int) (*Metric, error) {
 	}
 
 	m.Value = &floatValue
-	m.MType = domain.Gauge
+	m.MType = wire.Gauge
 
 	return m, nil
 }
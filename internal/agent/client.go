@@ -7,20 +7,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/AA122AA/metring/internal/wire"
 	"github.com/go-faster/sdk/zctx"
 	"go.uber.org/zap"
 )
 
+// ErrTooLarge сервер отказался принять пачку целиком (413), её нужно разбить.
+var ErrTooLarge = errors.New("batch is too large")
+
+// ReqError ошибка, после которой запрос стоит повторить: сеть, 5xx или 429.
 type ReqError struct {
 	err error
+	// retryAfter сколько ждать перед повтором по заголовку Retry-After, 0 - по расписанию
+	retryAfter time.Duration
 }
 
 func (re *ReqError) Unwrap() error {
@@ -42,28 +52,85 @@ func NewReqError(err error) *ReqError {
 	}
 }
 
+// Version версия агента, задаётся при сборке: -ldflags "-X github.com/AA122AA/metring/internal/agent.Version=1.2.3".
+var Version = "dev"
+
+// metadataInterval как часто повторять метаданные, чтобы сервер восстановил закреплённые типы после рестарта.
+const metadataInterval = 5 * time.Minute
+
+// Source источник метрик, которые клиент отправляет на сервер.
+type Source interface {
+	GetMetrics() map[string]*Metric
+}
+
+// Acker источник, которому сообщают, что отданные им метрики дошли до сервера.
+// Такой источник не сбрасывает своё состояние в GetMetrics, а ждёт Ack.
+type Acker interface {
+	Ack(mm map[string]*Metric)
+}
+
+// NamedSource источник, который сообщает серверу своё имя в списке сборщиков.
+type NamedSource interface {
+	Name() string
+}
+
 type MetricClient struct {
 	reportInterval int
 	baseURL        string
 
 	client         *http.Client
-	agent          *MetricAgent
+	sources        []Source
+	labels         map[string]string
 	lg             *zap.Logger
 	maxRetry       int
 	retryIntervals []int
+	// metadataSent когда метаданные последний раз дошли до сервера
+	metadataSent time.Time
+
+	agentID    string
+	hostname   string
+	collectors string
 }
 
-func NewMetricClient(ctx context.Context, mAgent *MetricAgent, cfg *Config) *MetricClient {
+func NewMetricClient(ctx context.Context, mAgent *MetricAgent, cfg *Config, extra ...Source) *MetricClient {
+	lg := zctx.From(ctx).Named("metrics client")
+
+	labels, err := ParseLabels(cfg.Labels)
+	if err != nil {
+		lg.Error("bad labels, sending metrics without them", zap.Error(err))
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		lg.Error("cannot get hostname", zap.Error(err))
+	}
+	agentID := cfg.AgentID
+	if agentID == "" {
+		agentID = hostname
+	}
+
+	sources := append([]Source{mAgent}, extra...)
+	collectors := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if ns, ok := s.(NamedSource); ok {
+			collectors = append(collectors, ns.Name())
+		}
+	}
+
 	return &MetricClient{
 		reportInterval: cfg.ReportInterval,
 		baseURL:        cfg.URL,
 		client: &http.Client{
 			Timeout: 2 * time.Second,
 		},
-		agent:          mAgent,
-		lg:             zctx.From(ctx).Named("metrics client"),
+		sources:        sources,
+		labels:         labels,
+		lg:             lg,
 		maxRetry:       3,
 		retryIntervals: []int{1, 3, 5},
+		agentID:        agentID,
+		hostname:       hostname,
+		collectors:     strings.Join(collectors, ","),
 	}
 }
 
@@ -78,34 +145,130 @@ func (mc *MetricClient) Run(ctx context.Context, wg *sync.WaitGroup) {
 			mc.lg.Info("got cancellation, returning")
 			return
 		case <-timer.C:
-			mc.withRetry(ctx, mc.SendUpdateJSONBatch, mc.agent.GetMetrics())
+			mc.resendMetadata()
+			mm, got := mc.collect()
+			sent := make(map[string]struct{}, len(mm))
+			err := mc.withRetry(ctx, func(rest map[string]*Metric) error {
+				return mc.sendSplit(rest, sent)
+			}, mm)
+			if err != nil {
+				mc.lg.Error("metrics are not sent, keeping them for the next report", zap.Int("left", len(mm)), zap.Error(err))
+			}
+			mc.ack(got, sent)
 			timer.Reset(time.Duration(mc.reportInterval) * time.Second)
 		}
 	}
 }
 
-func (mc *MetricClient) withRetry(ctx context.Context, f func(map[string]*Metric) error, mm map[string]*Metric) {
+// collect собирает метрики со всех источников и добавляет к ним общие метки агента.
+// Метки самой метрики имеют приоритет над общими. Вторым значением возвращается то,
+// что отдал каждый источник, чтобы после отправки подтвердить это через ack.
+func (mc *MetricClient) collect() (map[string]*Metric, []map[string]*Metric) {
+	mm := make(map[string]*Metric)
+	got := make([]map[string]*Metric, len(mc.sources))
+	for i, s := range mc.sources {
+		got[i] = s.GetMetrics()
+		maps.Copy(mm, got[i])
+	}
+	if len(mc.labels) == 0 {
+		return mm, got
+	}
+
+	for k, m := range mm {
+		labeled := *m
+		labeled.Labels = maps.Clone(mc.labels)
+		maps.Copy(labeled.Labels, m.Labels)
+		mm[k] = &labeled
+	}
+
+	return mm, got
+}
+
+// ack сообщает источникам, какие из отданных ими метрик записаны (ключи из sent).
+func (mc *MetricClient) ack(got []map[string]*Metric, sent map[string]struct{}) {
+	for i, s := range mc.sources {
+		a, ok := s.(Acker)
+		if !ok {
+			continue
+		}
+		done := make(map[string]*Metric, len(got[i]))
+		for k, m := range got[i] {
+			if _, ok := sent[k]; ok {
+				done[k] = m
+			}
+		}
+		if len(done) > 0 {
+			a.Ack(done)
+		}
+	}
+}
+
+// sendSplit отправляет mm пачкой, а если сервер ответил 413 - половинами.
+// Записанные метрики удаляются из mm и попадают в sent, поэтому повтор
+// через withRetry досылает только остаток и не дублирует приросты счётчиков.
+func (mc *MetricClient) sendSplit(mm map[string]*Metric, sent map[string]struct{}) error {
+	if len(mm) == 0 {
+		return nil
+	}
+	err := mc.SendUpdateJSONBatch(mm)
+	if err == nil {
+		for k := range mm {
+			sent[k] = struct{}{}
+			delete(mm, k)
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrTooLarge) || len(mm) == 1 {
+		return err
+	}
+
+	half := make(map[string]*Metric, len(mm)/2)
+	for k, m := range mm {
+		if len(half) == len(mm)/2 {
+			break
+		}
+		half[k] = m
+	}
+	for k := range half {
+		delete(mm, k)
+	}
+	err = mc.sendSplit(half, sent)
+	// неотправленное из первой половины возвращается в остаток
+	maps.Copy(mm, half)
+	if err != nil {
+		return err
+	}
+
+	return mc.sendSplit(mm, sent)
+}
+
+// withRetry вызывает f с повторами и возвращает nil, только если отправка удалась.
+func (mc *MetricClient) withRetry(ctx context.Context, f func(map[string]*Metric) error, mm map[string]*Metric) error {
 	timer := time.NewTimer(0)
 	defer timer.Stop()
 
+	var err error
 	for try := 0; try <= mc.maxRetry; try++ {
 		select {
 		case <-ctx.Done():
 			mc.lg.Warn("got cancellation in retry")
-			return
+			return ctx.Err()
 		case <-timer.C:
-			err := f(mm)
+			err = f(mm)
 			if err == nil {
-				return
+				return nil
 			}
 			var re *ReqError
 			if !errors.Is(err, re) {
 				t := reflect.TypeOf(err)
 				mc.lg.Debug("type of err", zap.Any("type", t))
 				mc.lg.Error("error not in request", zap.Error(err))
-				return
+				return err
 			}
-			if try < len(mc.retryIntervals) {
+			switch {
+			case errors.As(err, &re) && re.retryAfter > 0:
+				timer.Reset(re.retryAfter)
+			case try < len(mc.retryIntervals):
 				timer.Reset(time.Duration(mc.retryIntervals[try]) * time.Second)
 			}
 			mc.lg.Warn("trying to connect after false", zap.Int("try", try))
@@ -117,9 +280,9 @@ func (mc *MetricClient) withRetry(ctx context.Context, f func(map[string]*Metric
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return err
 		case <-timer.C:
-			return
+			return err
 		}
 	}
 }
@@ -159,9 +322,23 @@ func (mc *MetricClient) SendUpdateJSONBatch(mm map[string]*Metric) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests:
+		mc.lg.Warn("rate limited", zap.String("retry after", resp.Header.Get("Retry-After")))
+		return &ReqError{
+			err:        fmt.Errorf("wrong status %v", resp.Status),
+			retryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %d metrics", ErrTooLarge, len(metrics))
+	case resp.StatusCode >= http.StatusInternalServerError:
 		mc.lg.Error("wrong status", zap.Int("status code", resp.StatusCode), zap.String("status", resp.Status))
-		return err
+		return NewReqError(fmt.Errorf("wrong status %v", resp.Status))
+	default:
+		// повтор не поможет, но и прирост не потерян: источники отправят его в следующий раз
+		mc.lg.Error("metrics rejected", zap.Int("status code", resp.StatusCode), zap.String("status", resp.Status))
+		return fmt.Errorf("metrics rejected: %v", resp.Status)
 	}
 	// mc.lg.Debug("sent update successfully")
 	mc.lg.Warn("sent update successfully", zap.Any("metrics", metrics))
@@ -169,6 +346,71 @@ func (mc *MetricClient) SendUpdateJSONBatch(mm map[string]*Metric) error {
 	return nil
 }
 
+// resendMetadata отправляет метаданные, если они ещё не дошли до сервера или с прошлой
+// отправки прошло metadataInterval: после рестарта сервер мог потерять реестр и закреплённые типы.
+func (mc *MetricClient) resendMetadata() {
+	if !mc.metadataSent.IsZero() && time.Since(mc.metadataSent) < metadataInterval {
+		return
+	}
+	if mc.SendMetadata() == nil {
+		mc.metadataSent = time.Now()
+	}
+}
+
+// SendMetadata публикует описание метрик источников в реестр сервера.
+// Конфликт типов (409) считается успехом: повтор его не исправит.
+func (mc *MetricClient) SendMetadata() error {
+	metas := make([]*Metadata, 0)
+	for _, s := range mc.sources {
+		if ms, ok := s.(MetadataSource); ok {
+			metas = append(metas, ms.GetMetadata()...)
+		}
+	}
+	if len(metas) == 0 {
+		return nil
+	}
+
+	u, err := buildURL(mc.baseURL, "api/v1/metadata/")
+	if err != nil {
+		mc.lg.Error("error building url", zap.Error(err))
+		return err
+	}
+
+	body, err := json.Marshal(metas)
+	if err != nil {
+		mc.lg.Error("error marshling body", zap.Error(err))
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(body)
+	if err != nil {
+		mc.lg.Error("error compressing body", zap.Error(err))
+		return err
+	}
+	w.Close()
+
+	resp, err := mc.makeRequest(u, &buf)
+	if err != nil {
+		mc.lg.Error("error doing request", zap.String("url", u.String()), zap.Error(err))
+		return NewReqError(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		mc.lg.Debug("sent metadata")
+		return nil
+	case http.StatusConflict:
+		mc.lg.Warn("metadata type conflict on server")
+		return nil
+	default:
+		mc.lg.Error("wrong status", zap.Int("status code", resp.StatusCode), zap.String("status", resp.Status))
+		return fmt.Errorf("wrong status %v", resp.Status)
+	}
+}
+
 func (mc *MetricClient) SendUpdateJSON(mm map[string]*Metric) {
 	for _, v := range mm {
 		u, err := buildURL(mc.baseURL, "update")
@@ -270,6 +512,22 @@ func (mc *MetricClient) makeRequest(u *url.URL, buf *bytes.Buffer) (*http.Respon
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept-Encoding", "gzip")
 	req.Header.Set("Content-Encoding", "gzip")
+	if mc.agentID != "" {
+		req.Header.Set(wire.HeaderAgentID, mc.agentID)
+		req.Header.Set(wire.HeaderAgentHostname, mc.hostname)
+		req.Header.Set(wire.HeaderAgentVersion, Version)
+		req.Header.Set(wire.HeaderAgentCollectors, mc.collectors)
+	}
 
 	return mc.client.Do(req)
 }
+
+// retryAfter разбирает Retry-After в секундах, 0 - заголовка нет или он не число.
+func retryAfter(v string) time.Duration {
+	sec, err := strconv.Atoi(v)
+	if err != nil || sec <= 0 {
+		return 0
+	}
+
+	return time.Duration(sec) * time.Second
+}
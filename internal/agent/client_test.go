@@ -4,13 +4,17 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/wire"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,7 +26,7 @@ func TestSendUpdate_always_pass(t *testing.T) {
 	testMap := map[string]*Metric{
 		testMName: {
 			ID:    "counter",
-			MType: domain.Counter,
+			MType: wire.Counter,
 			// Value: "1",
 			Delta: &d,
 		},
@@ -107,3 +111,219 @@ func TestSendJSON(t *testing.T) {
 	ma.GatherMetrics()
 	mc.SendUpdateJSON(ma.mm)
 }
+
+func TestCollectLabels(t *testing.T) {
+	ctx := context.Background()
+	cfg := &Config{
+		PollInterval:   2,
+		ReportInterval: 10,
+		Labels:         "host=a,service=api",
+	}
+	ma := NewMetricAgent(ctx, cfg)
+	ma.GatherMetrics()
+
+	v := float64(1)
+	s := &staticSource{mm: map[string]*Metric{
+		"up": {ID: "up", MType: wire.Gauge, Value: &v, Labels: map[string]string{"host": "b"}},
+	}}
+	mc := NewMetricClient(ctx, ma, cfg, s)
+
+	mm, _ := mc.collect()
+	require.Equal(t, map[string]string{"host": "a", "service": "api"}, mm["PollCount"].Labels)
+	require.Equal(t, map[string]string{"host": "b", "service": "api"}, mm["up"].Labels)
+	// исходные метрики не меняются
+	require.Equal(t, map[string]string{"host": "b"}, s.mm["up"].Labels)
+}
+
+type staticSource struct {
+	mm map[string]*Metric
+}
+
+func (s *staticSource) GetMetrics() map[string]*Metric {
+	return s.mm
+}
+
+func TestSendMetadata(t *testing.T) {
+	ctx := context.Background()
+	var got []*Metadata
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/metadata/", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		cr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		defer cr.Close()
+
+		require.NoError(t, json.NewDecoder(cr).Decode(&got))
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := &Config{URL: server.URL}
+	mc := NewMetricClient(ctx, NewMetricAgent(ctx, cfg), cfg)
+
+	// конфликт типов не считается ошибкой, повторять его не нужно
+	require.NoError(t, mc.SendMetadata())
+
+	byName := make(map[string]*Metadata, len(got))
+	for _, m := range got {
+		byName[m.Name] = m
+	}
+	require.Equal(t, &Metadata{Name: "HeapAlloc", Type: "gauge", Unit: "bytes", Description: "Bytes of allocated heap objects"}, byName["HeapAlloc"])
+	require.Equal(t, "counter", byName["PollCount"].Type)
+	require.Equal(t, "count", byName["NumGC"].Unit)
+}
+
+func TestResendMetadata(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/metadata/", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := &Config{URL: server.URL}
+	mc := NewMetricClient(ctx, NewMetricAgent(ctx, cfg), cfg)
+
+	mc.resendMetadata()
+	mc.resendMetadata()
+	require.Equal(t, int32(1), calls.Load())
+
+	// сервер мог перезапуститься и потерять закреплённые типы
+	mc.metadataSent = mc.metadataSent.Add(-metadataInterval)
+	mc.resendMetadata()
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestSendIdentity(t *testing.T) {
+	ctx := context.Background()
+	var got http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /updates/", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := &Config{URL: server.URL, AgentID: "agent-1"}
+	// у staticSource нет имени, в список сборщиков он не попадает
+	mc := NewMetricClient(ctx, NewMetricAgent(ctx, cfg), cfg, &staticSource{})
+	require.NoError(t, mc.SendUpdateJSONBatch(map[string]*Metric{}))
+
+	require.Equal(t, "agent-1", got.Get(wire.HeaderAgentID))
+	require.Equal(t, Version, got.Get(wire.HeaderAgentVersion))
+	require.Equal(t, "runtime", got.Get(wire.HeaderAgentCollectors))
+}
+
+func TestSendBatchStatus(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name       string
+		status     int
+		retry      bool
+		retryAfter time.Duration
+		tooLarge   bool
+		pass       bool
+	}{
+		{name: "Positive", status: http.StatusOK, pass: true},
+		// подтверждается только 200, остальное остаётся у источников
+		{name: "Rejected", status: http.StatusConflict},
+		{name: "Rate limited", status: http.StatusTooManyRequests, retry: true, retryAfter: 2 * time.Second},
+		{name: "Too large", status: http.StatusRequestEntityTooLarge, tooLarge: true},
+		{name: "Server error", status: http.StatusServiceUnavailable, retry: true},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "2")
+				w.WriteHeader(tCase.status)
+			}))
+			defer srv.Close()
+
+			cfg := &Config{PollInterval: 2, ReportInterval: 10, URL: srv.URL}
+			mc := NewMetricClient(ctx, NewMetricAgent(ctx, cfg), cfg)
+
+			d := int64(1)
+			err := mc.SendUpdateJSONBatch(map[string]*Metric{"c": {ID: "c", MType: wire.Counter, Delta: &d}})
+			if tCase.pass {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, tCase.tooLarge, errors.Is(err, ErrTooLarge))
+			var re *ReqError
+			require.Equal(t, tCase.retry, errors.As(err, &re))
+			if tCase.retry && tCase.status == http.StatusTooManyRequests {
+				require.Equal(t, tCase.retryAfter, re.retryAfter)
+			}
+		})
+	}
+}
+
+func TestSendSplit(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu       sync.Mutex
+		received = make(map[string]int)
+	)
+	// сервер принимает не больше двух метрик за раз, метрика "bad" не проходит никогда
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var batch []*Metric
+		require.NoError(t, json.NewDecoder(zr).Decode(&batch))
+		if len(batch) > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		for _, m := range batch {
+			if m.ID == "bad" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		mu.Lock()
+		for _, m := range batch {
+			received[m.ID]++
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &Config{PollInterval: 2, ReportInterval: 10, URL: srv.URL}
+	mc := NewMetricClient(ctx, NewMetricAgent(ctx, cfg), cfg)
+
+	v := float64(1)
+	mm := make(map[string]*Metric)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		mm[id] = &Metric{ID: id, MType: wire.Gauge, Value: &v}
+	}
+	sent := make(map[string]struct{})
+	require.NoError(t, mc.sendSplit(mm, sent))
+	require.Len(t, sent, 5)
+	require.Empty(t, mm)
+	require.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1}, received)
+
+	// неотправленное остаётся в mm, отправленное не повторяется
+	received = make(map[string]int)
+	mm = make(map[string]*Metric)
+	for _, id := range []string{"bad", "f", "g"} {
+		mm[id] = &Metric{ID: id, MType: wire.Gauge, Value: &v}
+	}
+	sent = make(map[string]struct{})
+	require.Error(t, mc.sendSplit(mm, sent))
+	require.Contains(t, mm, "bad")
+	require.Len(t, mm, 3-len(sent))
+	for k := range sent {
+		require.NotContains(t, mm, k)
+		require.Equal(t, 1, received[k])
+	}
+}
@@ -2,6 +2,8 @@ package agent
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 
 	"github.com/AA122AA/metring/internal/flags"
 )
@@ -10,6 +12,13 @@ type Config struct {
 	PollInterval   int    `json:"pollInterval" yaml:"pollInterval" env:"POLL_INTERVAL" default:"2"`
 	URL            string `json:"url" yaml:"url" env:"ADDRESS" default:"http://localhost:8080"`
 	ReportInterval int    `json:"reportInterval" yaml:"reportInterval" env:"REPORT_INTERVAL" default:"10"`
+	Labels         string `json:"labels" yaml:"labels" env:"LABELS"`
+	// AgentID имя агента в реестре сервера, по умолчанию hostname.
+	AgentID string `json:"agentID" yaml:"agentID" env:"AGENT_ID"`
+
+	ScrapeTargets    string `json:"scrapeTargets" yaml:"scrapeTargets" env:"SCRAPE_TARGETS"`
+	ScrapeInterval   int    `json:"scrapeInterval" yaml:"scrapeInterval" env:"SCRAPE_INTERVAL" default:"10"`
+	ScrapeConfigPath string `json:"scrapeConfig" yaml:"scrapeConfig" env:"SCRAPE_CONFIG"`
 }
 
 func (c *Config) ParseFlags() {
@@ -19,9 +28,33 @@ func (c *Config) ParseFlags() {
 		return flags.ParseAddr(flagArgs, &c.URL)
 	})
 
+	flag.StringVar(&c.Labels, "labels", "", "labels added to every metric, e.g. host=a,service=b")
+	flag.StringVar(&c.AgentID, "agent-id", "", "agent id reported to the server, hostname if empty")
+	flag.StringVar(&c.ScrapeTargets, "scrape-targets", "", "comma separated prometheus endpoints to scrape")
+	flag.IntVar(&c.ScrapeInterval, "scrape-interval", 10, "scrape interval value (seconds)")
+	flag.StringVar(&c.ScrapeConfigPath, "scrape-config", "", "yaml file with scrape targets and relabel rules")
+
 	flag.Parse()
 }
 
+// ParseLabels разбирает метки вида host=a,service=b.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("bad label %q, want key=value", pair)
+		}
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
 // func Read(path string) (*Config, error) {
 // 	if path == "" {
 // 		return &Config{}, nil
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"reflect"
+	"runtime"
+
+	"github.com/AA122AA/metring/internal/wire"
+)
+
+// Metadata описание метрики, которое агент публикует в реестр сервера.
+type Metadata struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// MetadataSource источник метрик, который знает их описание.
+type MetadataSource interface {
+	GetMetadata() []*Metadata
+}
+
+// runtimeUnits единицы измерения полей runtime.MemStats, остальные поля - байты.
+var runtimeUnits = map[string]string{
+	"Lookups":       "count",
+	"Mallocs":       "count",
+	"Frees":         "count",
+	"HeapObjects":   "count",
+	"NumGC":         "count",
+	"NumForcedGC":   "count",
+	"PauseTotalNs":  "nanoseconds",
+	"LastGC":        "nanoseconds",
+	"GCCPUFraction": "ratio",
+}
+
+// runtimeDescriptions описания основных полей runtime.MemStats.
+var runtimeDescriptions = map[string]string{
+	"Alloc":         "Bytes of allocated heap objects",
+	"TotalAlloc":    "Cumulative bytes allocated for heap objects",
+	"Sys":           "Total bytes of memory obtained from the OS",
+	"HeapAlloc":     "Bytes of allocated heap objects",
+	"HeapSys":       "Bytes of heap memory obtained from the OS",
+	"HeapIdle":      "Bytes in idle (unused) spans",
+	"HeapInuse":     "Bytes in in-use spans",
+	"HeapReleased":  "Bytes of physical memory returned to the OS",
+	"HeapObjects":   "Number of allocated heap objects",
+	"Mallocs":       "Cumulative count of heap objects allocated",
+	"Frees":         "Cumulative count of heap objects freed",
+	"NumGC":         "Number of completed GC cycles",
+	"PauseTotalNs":  "Cumulative GC stop-the-world pause time",
+	"GCCPUFraction": "Fraction of CPU time used by the GC",
+	"StackInuse":    "Bytes in stack spans",
+	"NextGC":        "Target heap size of the next GC cycle",
+}
+
+// GetMetadata описывает метрики runtime.MemStats, PollCount и RandomValue.
+func (ma *MetricAgent) GetMetadata() []*Metadata {
+	t := reflect.TypeOf(runtime.MemStats{})
+	metas := make([]*Metadata, 0, t.NumField()+2)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Uint64, reflect.Uint32, reflect.Float64:
+		default:
+			continue
+		}
+
+		unit, ok := runtimeUnits[field.Name]
+		if !ok {
+			unit = "bytes"
+		}
+		metas = append(metas, &Metadata{
+			Name:        field.Name,
+			Type:        wire.Gauge,
+			Unit:        unit,
+			Description: runtimeDescriptions[field.Name],
+		})
+	}
+
+	return append(metas,
+		&Metadata{Name: "PollCount", Type: wire.Counter, Unit: "count", Description: "Number of agent polls"},
+		&Metadata{Name: "RandomValue", Type: wire.Gauge, Description: "Random value updated on every poll"},
+	)
+}
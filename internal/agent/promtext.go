@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	promCounter   = "counter"
+	promGauge     = "gauge"
+	promHistogram = "histogram"
+	promSummary   = "summary"
+)
+
+// promSample одна строка Prometheus text exposition format.
+type promSample struct {
+	name    string
+	labels  map[string]string
+	value   float64
+	counter bool
+}
+
+// parsePromText разбирает Prometheus text format (version 0.0.4).
+// Строки с ошибками пропускаются, их ошибки возвращаются вместе с результатом.
+func parsePromText(r io.Reader) ([]*promSample, []error) {
+	var (
+		samples []*promSample
+		errs    []error
+	)
+	types := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		s, err := parsePromLine(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.counter = isPromCounter(s.name, types)
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return samples, errs
+}
+
+func parsePromLine(line string) (*promSample, error) {
+	s := &promSample{labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return nil, fmt.Errorf("bad line %q: no value", line)
+	}
+	s.name = line[:end]
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		n, err := parsePromLabels(rest[1:], s.labels)
+		if err != nil {
+			return nil, fmt.Errorf("bad line %q: %w", line, err)
+		}
+		rest = rest[1+n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) != 1 && len(fields) != 2 {
+		return nil, fmt.Errorf("bad line %q: want value [timestamp]", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad line %q: bad value: %w", line, err)
+	}
+	s.value = v
+
+	return s, nil
+}
+
+// parsePromLabels читает метки до закрывающей } и возвращает число прочитанных байт.
+func parsePromLabels(s string, labels map[string]string) (int, error) {
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated labels")
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return 0, fmt.Errorf("bad label at %q", s[i:])
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("label %q value is not quoted", name)
+		}
+		i++
+
+		var b strings.Builder
+		for {
+			if i >= len(s) {
+				return 0, fmt.Errorf("unterminated label %q value", name)
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				default:
+					b.WriteByte(s[i])
+				}
+				i++
+				continue
+			}
+			b.WriteByte(c)
+			i++
+		}
+		labels[name] = b.String()
+	}
+}
+
+// isPromCounter решает, является ли серия накопительным счётчиком.
+// Для гистограмм и summary счётчиками считаются _count и _bucket, а _sum и квантили - gauge.
+func isPromCounter(name string, types map[string]string) bool {
+	if t, ok := types[name]; ok {
+		return t == promCounter
+	}
+
+	for _, suffix := range []string{"_total", "_count", "_bucket", "_sum", "_created"} {
+		family, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		t, ok := types[family]
+		if !ok {
+			continue
+		}
+		switch t {
+		case promCounter:
+			return suffix == "_total"
+		case promHistogram, promSummary:
+			return suffix == "_count" || suffix == "_bucket"
+		default:
+			return false
+		}
+	}
+
+	// TYPE не указан - угадываем по суффиксу
+	return strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_bucket")
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+
+	nameLabel = "__name__"
+)
+
+// RelabelRule правило перемаркировки в духе relabel_configs из Prometheus.
+// Имя метрики доступно как метка __name__.
+type RelabelRule struct {
+	SourceLabels []string `json:"sourceLabels" yaml:"sourceLabels"`
+	Separator    string   `json:"separator" yaml:"separator"`
+	Regex        string   `json:"regex" yaml:"regex"`
+	TargetLabel  string   `json:"targetLabel" yaml:"targetLabel"`
+	Replacement  string   `json:"replacement" yaml:"replacement"`
+	Action       string   `json:"action" yaml:"action"`
+}
+
+type relabeler struct {
+	rule RelabelRule
+	re   *regexp.Regexp
+}
+
+func newRelabeler(rule RelabelRule) (*relabeler, error) {
+	if rule.Separator == "" {
+		rule.Separator = ";"
+	}
+	if rule.Regex == "" {
+		rule.Regex = "(.*)"
+	}
+	if rule.Replacement == "" {
+		rule.Replacement = "$1"
+	}
+	if rule.Action == "" {
+		rule.Action = relabelReplace
+	}
+
+	switch rule.Action {
+	case relabelReplace:
+		if rule.TargetLabel == "" {
+			return nil, fmt.Errorf("relabel action %q needs targetLabel", rule.Action)
+		}
+	case relabelKeep, relabelDrop, relabelLabelDrop, relabelLabelKeep:
+	default:
+		return nil, fmt.Errorf("unknown relabel action %q", rule.Action)
+	}
+
+	re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("bad relabel regex %q: %w", rule.Regex, err)
+	}
+
+	return &relabeler{rule: rule, re: re}, nil
+}
+
+// apply изменяет метки на месте и возвращает false, если серию надо отбросить.
+func (r *relabeler) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(r.rule.SourceLabels))
+	for _, l := range r.rule.SourceLabels {
+		values = append(values, labels[l])
+	}
+	val := strings.Join(values, r.rule.Separator)
+
+	switch r.rule.Action {
+	case relabelReplace:
+		m := r.re.FindStringSubmatchIndex(val)
+		if m == nil {
+			return true
+		}
+		res := string(r.re.ExpandString(nil, r.rule.Replacement, val, m))
+		if res == "" {
+			delete(labels, r.rule.TargetLabel)
+			return true
+		}
+		labels[r.rule.TargetLabel] = res
+	case relabelKeep:
+		return r.re.MatchString(val)
+	case relabelDrop:
+		return !r.re.MatchString(val)
+	case relabelLabelDrop:
+		for k := range labels {
+			if k != nameLabel && r.re.MatchString(k) {
+				delete(labels, k)
+			}
+		}
+	case relabelLabelKeep:
+		for k := range labels {
+			if k != nameLabel && !r.re.MatchString(k) {
+				delete(labels, k)
+			}
+		}
+	}
+
+	return true
+}
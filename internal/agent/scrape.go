@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/delta"
+	"github.com/AA122AA/metring/internal/wire"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+type ScrapeTarget struct {
+	URL    string            `json:"url" yaml:"url"`
+	Labels map[string]string `json:"labels" yaml:"labels"`
+}
+
+type ScrapeConfig struct {
+	Interval int            `json:"interval" yaml:"interval"`
+	Targets  []ScrapeTarget `json:"targets" yaml:"targets"`
+	Relabel  []RelabelRule  `json:"relabel" yaml:"relabel"`
+}
+
+// LoadScrapeConfig собирает настройки скрейпа из yaml файла (если указан)
+// и списка адресов через запятую из флага/переменной окружения.
+func LoadScrapeConfig(cfg *Config) (*ScrapeConfig, error) {
+	sc := &ScrapeConfig{}
+	if cfg.ScrapeConfigPath != "" {
+		f, err := os.ReadFile(cfg.ScrapeConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read scrape config: %w", err)
+		}
+		if err = yaml.Unmarshal(f, sc); err != nil {
+			return nil, fmt.Errorf("cannot parse scrape config: %w", err)
+		}
+	}
+
+	for _, t := range strings.Split(cfg.ScrapeTargets, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		sc.Targets = append(sc.Targets, ScrapeTarget{URL: t})
+	}
+
+	if sc.Interval == 0 {
+		sc.Interval = cfg.ScrapeInterval
+	}
+
+	return sc, nil
+}
+
+// Scraper периодически забирает метрики из Prometheus эндпоинтов.
+// Накопительные счётчики переводятся в дельты и копятся, пока отправка не подтверждена
+// через Ack. Для gauge хранятся значения последнего цикла скрейпа. Метки серий сохраняются,
+// буферы хранятся по ключу серии (wire.SeriesKey).
+type Scraper struct {
+	targets  []ScrapeTarget
+	relabel  []*relabeler
+	interval int
+
+	client *http.Client
+	deltas *delta.Tracker
+
+	mu       sync.Mutex
+	gauges   map[string]*Metric
+	counters map[string]*Metric
+
+	lg *zap.Logger
+}
+
+func NewScraper(ctx context.Context, cfg *ScrapeConfig) (*Scraper, error) {
+	relabel := make([]*relabeler, 0, len(cfg.Relabel))
+	for _, rule := range cfg.Relabel {
+		r, err := newRelabeler(rule)
+		if err != nil {
+			return nil, err
+		}
+		relabel = append(relabel, r)
+	}
+
+	for _, t := range cfg.Targets {
+		if _, err := url.Parse(t.URL); err != nil {
+			return nil, fmt.Errorf("bad scrape target %q: %w", t.URL, err)
+		}
+	}
+
+	return &Scraper{
+		targets:  cfg.Targets,
+		relabel:  relabel,
+		interval: cfg.Interval,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		deltas:   delta.NewTracker(),
+		gauges:   make(map[string]*Metric),
+		counters: make(map[string]*Metric),
+		lg:       zctx.From(ctx).Named("metrics scraper"),
+	}, nil
+}
+
+func (s *Scraper) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(time.Duration(s.interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			s.ScrapeAll(ctx)
+		}
+	}
+}
+
+// Name имя сборщика, которое агент сообщает серверу.
+func (s *Scraper) Name() string {
+	return "scrape"
+}
+
+// GetMetrics отдаёт копию накопленных метрик. Дельты счётчиков не сбрасываются:
+// отправленный прирост вычитается в Ack, а при неудачной отправке уйдёт в следующий раз.
+func (s *Scraper) GetMetrics() map[string]*Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mm := make(map[string]*Metric, len(s.gauges)+len(s.counters))
+	maps.Copy(mm, s.gauges)
+	for k, m := range s.counters {
+		d := *m.Delta
+		c := *m
+		c.Delta = &d
+		mm[k] = &c
+	}
+
+	return mm
+}
+
+// Ack вычитает отправленные дельты. Прирост, накопленный после GetMetrics, остаётся.
+func (s *Scraper) Ack(mm map[string]*Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, sent := range mm {
+		m, ok := s.counters[k]
+		if !ok || sent.Delta == nil {
+			continue
+		}
+		*m.Delta -= *sent.Delta
+		if *m.Delta == 0 {
+			delete(s.counters, k)
+		}
+	}
+}
+
+// ScrapeAll опрашивает все цели. Gauge заменяются значениями этого цикла,
+// чтобы серии, пропавшие у целей, не отправлялись бесконечно.
+func (s *Scraper) ScrapeAll(ctx context.Context) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		gauges = make(map[string]*Metric)
+	)
+	for _, t := range s.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := s.scrape(ctx, t)
+			if err != nil {
+				s.lg.Error("error while scraping target", zap.String("url", t.URL), zap.Error(err))
+				return
+			}
+			mu.Lock()
+			maps.Copy(gauges, got)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.gauges = gauges
+	s.mu.Unlock()
+}
+
+// scrape опрашивает цель: дельты счётчиков добавляет в общий буфер, gauge возвращает.
+func (s *Scraper) scrape(ctx context.Context, t ScrapeTarget) (map[string]*Metric, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error making new request: %w", err)
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error doing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wrong status %v", resp.Status)
+	}
+
+	samples, errs := parsePromText(resp.Body)
+	for _, err := range errs {
+		s.lg.Debug("skip bad line", zap.String("url", t.URL), zap.Error(err))
+	}
+
+	instance := t.URL
+	if u, err := url.Parse(t.URL); err == nil {
+		instance = u.Host
+	}
+
+	gauges := make(map[string]*Metric)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sample := range samples {
+		labels := sample.labels
+		labels[nameLabel] = sample.name
+		labels["instance"] = instance
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		if !s.applyRelabel(labels) {
+			continue
+		}
+		name := labels[nameLabel]
+		if name == "" {
+			continue
+		}
+		delete(labels, nameLabel)
+		if len(labels) == 0 {
+			labels = nil
+		}
+		key := wire.SeriesKey(name, labels)
+
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			continue
+		}
+		if sample.counter {
+			d := s.deltas.Delta(key, sample.value, 0)
+			if m, ok := s.counters[key]; ok {
+				*m.Delta += d
+				continue
+			}
+			s.counters[key] = &Metric{
+				ID:     name,
+				MType:  wire.Counter,
+				Delta:  &d,
+				Labels: labels,
+			}
+			continue
+		}
+
+		v := sample.value
+		gauges[key] = &Metric{
+			ID:     name,
+			MType:  wire.Gauge,
+			Value:  &v,
+			Labels: labels,
+		}
+	}
+	s.lg.Debug("scraped target", zap.String("url", t.URL), zap.Int("samples", len(samples)))
+
+	return gauges, nil
+}
+
+func (s *Scraper) applyRelabel(labels map[string]string) bool {
+	for _, r := range s.relabel {
+		if !r.apply(labels) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+const promText = `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",path="/a\"b"} %d
+http_requests_total{code="500"} 1
+# TYPE temperature gauge
+temperature 36.6 1700000000000
+# TYPE latency histogram
+latency_bucket{le="0.1"} 2
+latency_bucket{le="+Inf"} 3
+latency_sum 0.45
+latency_count 3
+go_goroutines 7
+broken{ 1
+`
+
+func TestParsePromText(t *testing.T) {
+	samples, errs := parsePromText(strings.NewReader(fmt.Sprintf(promText, 10)))
+	require.Len(t, errs, 1)
+	require.Len(t, samples, 8)
+
+	require.Equal(t, "http_requests_total", samples[0].name)
+	require.Equal(t, map[string]string{"code": "200", "path": `/a"b`}, samples[0].labels)
+	require.True(t, samples[0].counter)
+
+	require.Equal(t, "temperature", samples[2].name)
+	require.Equal(t, 36.6, samples[2].value)
+	require.False(t, samples[2].counter)
+
+	require.True(t, samples[3].counter)  // latency_bucket
+	require.False(t, samples[5].counter) // latency_sum
+	require.True(t, samples[6].counter)  // latency_count
+	require.False(t, samples[7].counter) // go_goroutines
+}
+
+func TestRelabel(t *testing.T) {
+	r, err := newRelabeler(RelabelRule{
+		SourceLabels: []string{nameLabel, "code"},
+		Regex:        "(http_requests_total);(.+)",
+		TargetLabel:  nameLabel,
+		Replacement:  "${1}_${2}",
+	})
+	require.NoError(t, err)
+
+	labels := map[string]string{nameLabel: "http_requests_total", "code": "200"}
+	require.True(t, r.apply(labels))
+	require.Equal(t, "http_requests_total_200", labels[nameLabel])
+
+	drop, err := newRelabeler(RelabelRule{SourceLabels: []string{nameLabel}, Regex: "go_.*", Action: relabelDrop})
+	require.NoError(t, err)
+	require.False(t, drop.apply(map[string]string{nameLabel: "go_goroutines"}))
+	require.True(t, drop.apply(map[string]string{nameLabel: "temperature"}))
+
+	_, err = newRelabeler(RelabelRule{Action: "lol"})
+	require.Error(t, err)
+	_, err = newRelabeler(RelabelRule{Action: relabelReplace})
+	require.Error(t, err)
+}
+
+func TestScraper(t *testing.T) {
+	ctx := context.Background()
+	requests := 10
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, promText, requests)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	s, err := NewScraper(ctx, &ScrapeConfig{
+		Interval: 10,
+		Targets:  []ScrapeTarget{{URL: srv.URL}},
+		Relabel:  []RelabelRule{{SourceLabels: []string{nameLabel}, Regex: "go_.*", Action: relabelDrop}},
+	})
+	require.NoError(t, err)
+
+	// Суммируем дельты по имени, а gauge ищем по имени среди серий
+	byName := func(mm map[string]*Metric) map[string]*Metric {
+		res := make(map[string]*Metric)
+		for _, m := range mm {
+			require.Equal(t, host, m.Labels["instance"])
+			if prev, ok := res[m.ID]; ok && m.Delta != nil {
+				d := *prev.Delta + *m.Delta
+				prev.Delta = &d
+				continue
+			}
+			// копия, чтобы суммирование не меняло метрики, которые потом подтверждаются через Ack
+			c := *m
+			res[m.ID] = &c
+		}
+		return res
+	}
+
+	s.ScrapeAll(ctx)
+	mm := s.GetMetrics()
+	require.Contains(t, mm, `http_requests_total{code="500",instance="`+host+`"}`)
+	named := byName(mm)
+	// первый скрейп - точка отсчёта накопительных счётчиков
+	require.Equal(t, int64(0), *named["http_requests_total"].Delta)
+	require.Equal(t, wire.Gauge, named["temperature"].MType)
+	require.Equal(t, int64(0), *named["latency_count"].Delta)
+	require.NotContains(t, named, "go_goroutines")
+
+	// Отправка подтверждена - повторный GetMetrics без скрейпа счётчиков не содержит
+	s.Ack(mm)
+	named = byName(s.GetMetrics())
+	require.NotContains(t, named, "http_requests_total")
+	require.Contains(t, named, "temperature")
+
+	requests = 15
+	s.ScrapeAll(ctx)
+	mm = s.GetMetrics()
+	named = byName(mm)
+	require.Equal(t, int64(5), *named["http_requests_total"].Delta)
+	require.Equal(t, int64(0), *named["latency_count"].Delta)
+
+	// Без Ack прирост не теряется и копится дальше
+	requests = 20
+	s.ScrapeAll(ctx)
+	named = byName(s.GetMetrics())
+	require.Equal(t, int64(10), *named["http_requests_total"].Delta)
+
+	// Ack вычитает только отправленное
+	s.Ack(mm)
+	named = byName(s.GetMetrics())
+	require.Equal(t, int64(5), *named["http_requests_total"].Delta)
+
+	// Цель пропала - её gauge больше не отправляются
+	srv.Close()
+	s.ScrapeAll(ctx)
+	named = byName(s.GetMetrics())
+	require.NotContains(t, named, "temperature")
+	require.Contains(t, named, "http_requests_total")
+}
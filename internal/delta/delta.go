@@ -0,0 +1,154 @@
+package delta
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultExpiry через сколько без наблюдений серия забывается.
+	defaultExpiry = time.Hour
+	// sweepEvery как часто искать забытые серии.
+	sweepEvery = time.Minute
+)
+
+type point struct {
+	value float64
+	start uint64
+	seen  time.Time
+}
+
+// Tracker переводит накопительные (cumulative) счётчики в дельты,
+// которые понимает counter в metring. Состояние хранится по серии.
+type Tracker struct {
+	mu     sync.Mutex
+	last   map[string]point
+	since  uint64
+	expiry time.Duration
+	swept  time.Time
+	now    func() time.Time
+}
+
+type Option func(t *Tracker)
+
+// WithExpiry задаёт, через сколько без наблюдений серия забывается.
+func WithExpiry(d time.Duration) Option {
+	return func(t *Tracker) {
+		t.expiry = d
+	}
+}
+
+func NewTracker(opts ...Option) *Tracker {
+	t := &Tracker{
+		last:   make(map[string]point),
+		expiry: defaultExpiry,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.since = uint64(t.now().UnixNano())
+	t.swept = t.now()
+
+	return t
+}
+
+// Batch наблюдения одного запроса. Трекер меняется только в Commit после успешной
+// записи, поэтому повтор неудачного запроса даёт те же дельты.
+type Batch struct {
+	t      *Tracker
+	points map[string]point
+}
+
+func (t *Tracker) Batch() *Batch {
+	return &Batch{t: t, points: make(map[string]point)}
+}
+
+// Delta возвращает прирост счётчика серии с прошлого наблюдения.
+// При сбросе счётчика (значение уменьшилось или изменилось время старта)
+// возвращается всё значение целиком. Первое наблюдение серии только запоминается:
+// неизвестно, сколько из значения уже учтено до рестарта metring. Исключение -
+// счётчик, стартовавший позже трекера, он целиком новый.
+// start - время старта счётчика в наносекундах, 0 если неизвестно.
+func (b *Batch) Delta(series string, value float64, start uint64) int64 {
+	prev, ok := b.get(series)
+	b.points[series] = point{value: value, start: start}
+
+	switch {
+	case !ok && start != 0 && start >= b.t.since:
+		return int64(math.Round(value))
+	case !ok:
+		return 0
+	case value < prev.value || (start != 0 && prev.start != 0 && start != prev.start):
+		return int64(math.Round(value))
+	}
+
+	return int64(math.Round(value)) - int64(math.Round(prev.value))
+}
+
+// Add прибавляет дельту к сохранённому значению серии и возвращает новое значение.
+// Нужен для тех случаев, когда источник шлёт дельты, а хранить надо сумму (например, gauge суммы гистограммы).
+func (b *Batch) Add(series string, delta float64) float64 {
+	p, _ := b.get(series)
+	p.value += delta
+	b.points[series] = p
+
+	return p.value
+}
+
+// Commit сохраняет наблюдения пачки в трекере.
+func (b *Batch) Commit() {
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+
+	now := b.t.now()
+	for series, p := range b.points {
+		p.seen = now
+		b.t.last[series] = p
+	}
+	b.t.sweep(now)
+}
+
+func (b *Batch) get(series string) (point, bool) {
+	if p, ok := b.points[series]; ok {
+		return p, true
+	}
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	p, ok := b.t.last[series]
+
+	return p, ok
+}
+
+// Delta как Batch.Delta, но наблюдение сохраняется сразу.
+func (t *Tracker) Delta(series string, value float64, start uint64) int64 {
+	b := t.Batch()
+	d := b.Delta(series, value, start)
+	b.Commit()
+
+	return d
+}
+
+// Add как Batch.Add, но наблюдение сохраняется сразу.
+func (t *Tracker) Add(series string, delta float64) float64 {
+	b := t.Batch()
+	v := b.Add(series, delta)
+	b.Commit()
+
+	return v
+}
+
+// sweep забывает серии без наблюдений дольше expiry, вызывается под t.mu.
+func (t *Tracker) sweep(now time.Time) {
+	if t.expiry <= 0 || now.Sub(t.swept) < sweepEvery {
+		return
+	}
+	t.swept = now
+	for series, p := range t.last {
+		if now.Sub(p.seen) > t.expiry {
+			delete(t.last, series)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package delta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelta(t *testing.T) {
+	tr := NewTracker()
+
+	// первое наблюдение - точка отсчёта, значение могло быть учтено до рестарта
+	require.Equal(t, int64(0), tr.Delta("a", 10, 1))
+	require.Equal(t, int64(5), tr.Delta("a", 15, 1))
+	require.Equal(t, int64(0), tr.Delta("a", 15, 1))
+	// сброс счётчика
+	require.Equal(t, int64(3), tr.Delta("a", 3, 1))
+	// рестарт источника с тем же значением
+	require.Equal(t, int64(7), tr.Delta("a", 7, 2))
+	// другие серии не влияют
+	require.Equal(t, int64(0), tr.Delta("b", 1, 0))
+	require.Equal(t, int64(1), tr.Delta("a", 8, 2))
+	// счётчик стартовал после трекера - он целиком новый
+	require.Equal(t, int64(4), tr.Delta("c", 4, uint64(time.Now().UnixNano())))
+}
+
+func TestBatch(t *testing.T) {
+	tr := NewTracker()
+	tr.Delta("a", 10, 0)
+
+	// неудачная запись не меняет трекер, повтор даёт ту же дельту
+	for range 2 {
+		b := tr.Batch()
+		require.Equal(t, int64(5), b.Delta("a", 15, 0))
+		require.Equal(t, int64(3), b.Delta("a", 18, 0))
+		require.Equal(t, 2.5, b.Add("sum", 2.5))
+	}
+
+	b := tr.Batch()
+	b.Delta("a", 18, 0)
+	b.Add("sum", 2.5)
+	b.Commit()
+	require.Equal(t, int64(2), tr.Delta("a", 20, 0))
+	require.Equal(t, 3.0, tr.Add("sum", 0.5))
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	tr := NewTracker(WithExpiry(time.Hour))
+	tr.now = func() time.Time { return now }
+
+	tr.Delta("a", 10, 0)
+	now = now.Add(30 * time.Minute)
+	tr.Delta("b", 10, 0)
+	now = now.Add(31 * time.Minute)
+	tr.Delta("b", 11, 0)
+
+	require.Len(t, tr.last, 1)
+	require.Contains(t, tr.last, "b")
+}
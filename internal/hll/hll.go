@@ -0,0 +1,82 @@
+// Package hll реализует HyperLogLog для оценки числа уникальных значений.
+// Скетч - это массив регистров, поэтому скетчи с разных агентов
+// объединяются поэлементным максимумом и хранятся как []byte.
+package hll
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// Precision число бит хэша под индекс регистра, погрешность ~1.6%.
+	Precision = 12
+	// Size размер скетча в байтах.
+	Size = 1 << Precision
+)
+
+// New возвращает пустой скетч.
+func New() []byte {
+	return make([]byte, Size)
+}
+
+// Add добавляет значение в скетч.
+func Add(regs []byte, item string) {
+	h := hash(item)
+	idx := h >> (64 - Precision)
+	// защитный бит ограничивает rho при нулевом остатке хэша
+	w := h<<Precision | 1<<(Precision-1)
+	rho := byte(bits.LeadingZeros64(w) + 1)
+	if rho > regs[idx] {
+		regs[idx] = rho
+	}
+}
+
+// Merge объединяет src в dst.
+func Merge(dst, src []byte) {
+	for i, v := range src {
+		if v > dst[i] {
+			dst[i] = v
+		}
+	}
+}
+
+// Count оценивает число уникальных значений.
+func Count(regs []byte) uint64 {
+	m := float64(len(regs))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, v := range regs {
+		sum += math.Ldexp(1, -int(v))
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	// на малых значениях точнее линейный подсчёт
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(est))
+}
+
+// hash fnv-64a с перемешиванием splitmix64, чтобы старшие биты были равномерны.
+func hash(item string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(item))
+	h := f.Sum64()
+
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	return h
+}
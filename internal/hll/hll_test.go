@@ -0,0 +1,43 @@
+package hll
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCount(t *testing.T) {
+	cases := []struct {
+		name string
+		n    int
+	}{
+		{name: "Empty", n: 0},
+		{name: "Small", n: 100},
+		{name: "Medium", n: 10000},
+		{name: "Large", n: 200000},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			regs := New()
+			for i := 0; i < tCase.n; i++ {
+				Add(regs, "user-"+strconv.Itoa(i))
+				// повторы не влияют на оценку
+				Add(regs, "user-"+strconv.Itoa(i))
+			}
+			require.InEpsilon(t, float64(tCase.n)+1, float64(Count(regs))+1, 0.05)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 5000; i++ {
+		Add(a, strconv.Itoa(i))
+		Add(b, strconv.Itoa(i+2500))
+	}
+
+	Merge(a, b)
+	require.InEpsilon(t, 7500, float64(Count(a)), 0.05)
+}
@@ -5,15 +5,45 @@ import (
 	"log"
 
 	"github.com/AA122AA/metring/internal/flags"
+	"github.com/AA122AA/metring/internal/server/service/agents"
+	"github.com/AA122AA/metring/internal/server/service/alerts"
+	"github.com/AA122AA/metring/internal/server/service/federation"
+	"github.com/AA122AA/metring/internal/server/service/forward"
+	"github.com/AA122AA/metring/internal/server/service/graphite"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/notify"
+	"github.com/AA122AA/metring/internal/server/service/ratelimit"
+	"github.com/AA122AA/metring/internal/server/service/recording"
 	"github.com/AA122AA/metring/internal/server/service/saver"
+	"github.com/AA122AA/metring/internal/server/service/statsd"
+	"github.com/AA122AA/metring/internal/server/service/stream"
+	"github.com/AA122AA/metring/internal/server/service/tenants"
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
 	HostAddr     string `json:"hostAddr" yaml:"hostAddr" env:"ADDRESS" default:"localhost:8080"`
-	TemplatePath string `json:"templatePath" yaml:"templatePath" env:"TEMPLATE_PATH" default:"internal/server/templates/*.html"`
+	TemplatePath string `json:"templatePath" yaml:"templatePath" env:"TEMPLATE_PATH"`
 	DatabaseDSN  string `json:"databaseDSN" yaml:"databaseDSN" env:"DATABASE_DSN"`
-	SaverCfg     saver.Config
+	// MaxBodySize предел тела запроса на приём метрик в байтах (после распаковки), 0 - без ограничения.
+	MaxBodySize int64 `json:"maxBodySize" yaml:"maxBodySize" env:"MAX_BODY_SIZE" default:"10485760"`
+	// MaxBatch предел числа метрик в одном запросе /updates/, 0 - без ограничения.
+	MaxBatch      int `json:"maxBatch" yaml:"maxBatch" env:"MAX_BATCH" default:"10000"`
+	MetricsCfg    metrics.Config
+	SaverCfg      saver.Config
+	StatsdCfg     statsd.Config
+	GraphiteCfg   graphite.Config
+	TenantsCfg    tenants.Config
+	RateLimitCfg  ratelimit.Config
+	AgentsCfg     agents.Config
+	AlertsCfg     alerts.Config
+	RecordingCfg  recording.Config
+	NotifyCfg     notify.Config
+	ForwardCfg    forward.Config
+	FederationCfg federation.Config
+	StreamCfg     stream.Config
+	HistoryCfg    history.Config
 }
 
 func (c *Config) ParseConfig() {
@@ -23,8 +53,8 @@ func (c *Config) ParseConfig() {
 	flag.StringVar(
 		&c.TemplatePath,
 		"templates",
-		"internal/server/templates/*.html",
-		"dir where html templates are stored",
+		"",
+		"dir or glob with html templates overriding the embedded ones",
 	)
 	flag.StringVar(
 		&c.DatabaseDSN,
@@ -50,6 +80,252 @@ func (c *Config) ParseConfig() {
 		true,
 		"should server restore old metrics or not",
 	)
+	flag.StringVar(
+		&c.StatsdCfg.Addr,
+		"statsd-addr",
+		"",
+		"ip:port for statsd udp listener, disabled if empty",
+	)
+	flag.IntVar(
+		&c.StatsdCfg.FlushInterval,
+		"statsd-flush",
+		10,
+		"statsd flush interval (seconds)",
+	)
+	flag.IntVar(
+		&c.StatsdCfg.Expiry,
+		"statsd-expiry",
+		300,
+		"seconds without data after which a statsd gauge is no longer flushed",
+	)
+	flag.StringVar(
+		&c.GraphiteCfg.Addr,
+		"graphite-addr",
+		"",
+		"ip:port for graphite plaintext tcp listener, disabled if empty",
+	)
+	flag.StringVar(
+		&c.GraphiteCfg.Template,
+		"graphite-template",
+		"",
+		"template to map graphite path to metric name and labels, e.g. host.measurement*",
+	)
+	flag.StringVar(
+		&c.MetricsCfg.HistogramBuckets,
+		"histogram-buckets",
+		"0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10",
+		"comma separated upper bounds of histogram buckets",
+	)
+	flag.IntVar(
+		&c.MetricsCfg.TTL,
+		"ttl",
+		0,
+		"minutes after which not updated metrics are deleted, 0 disables expiry",
+	)
+	flag.IntVar(
+		&c.MetricsCfg.SetInterval,
+		"set-interval",
+		60,
+		"seconds over which set metrics count unique values, 0 never resets them",
+	)
+	flag.IntVar(
+		&c.MetricsCfg.MaxNameLength,
+		"max-name-length",
+		200,
+		"max metric name length, 0 disables the limit",
+	)
+	flag.BoolVar(
+		&c.MetricsCfg.StrictNames,
+		"strict-names",
+		true,
+		"allow only letters, digits and _ . : - in metric names",
+	)
+	flag.StringVar(
+		&c.MetricsCfg.NamePattern,
+		"name-pattern",
+		"",
+		"regexp metric names must match, e.g. ^[a-z][a-z0-9_]*$",
+	)
+	flag.IntVar(
+		&c.MetricsCfg.MaxSeries,
+		"max-series",
+		0,
+		"max distinct series on the server, 0 disables the limit",
+	)
+	flag.IntVar(
+		&c.MetricsCfg.MaxSeriesPerTenant,
+		"max-series-per-tenant",
+		0,
+		"max distinct series per tenant, 0 disables the limit",
+	)
+	flag.StringVar(
+		&c.TenantsCfg.File,
+		"tenants-file",
+		"",
+		"yaml file with tenants and their api keys, multi-tenancy is off while there are no tenants",
+	)
+	flag.StringVar(
+		&c.TenantsCfg.AdminKey,
+		"admin-key",
+		"",
+		"api key for tenants admin api, admin api is disabled if empty",
+	)
+	flag.Int64Var(
+		&c.MaxBodySize,
+		"max-body-size",
+		10485760,
+		"max decompressed request body size in bytes for ingestion, 0 disables the limit",
+	)
+	flag.IntVar(
+		&c.MaxBatch,
+		"max-batch",
+		10000,
+		"max metrics in one /updates/ request, 0 disables the limit",
+	)
+	flag.Float64Var(
+		&c.RateLimitCfg.Rate,
+		"rate-limit",
+		0,
+		"ingestion requests per second per api key or client ip, 0 disables rate limiting",
+	)
+	flag.IntVar(
+		&c.RateLimitCfg.Burst,
+		"rate-burst",
+		20,
+		"how many ingestion requests a client can send at once above the rate limit",
+	)
+	flag.IntVar(
+		&c.AgentsCfg.StaleAfter,
+		"agent-stale-after",
+		60,
+		"seconds without requests after which an agent is marked as stale",
+	)
+	flag.IntVar(
+		&c.AgentsCfg.Retention,
+		"agent-retention",
+		86400,
+		"seconds without requests after which an agent is removed, 0 keeps agents forever",
+	)
+	flag.IntVar(
+		&c.AgentsCfg.MaxPerTenant,
+		"agent-max-per-tenant",
+		1000,
+		"max agents tracked per tenant, 0 disables the limit",
+	)
+	flag.StringVar(
+		&c.AlertsCfg.RulesFile,
+		"alert-rules",
+		"",
+		"yaml or json file with alerting rules, alerting is off if empty",
+	)
+	flag.IntVar(
+		&c.AlertsCfg.EvalInterval,
+		"alert-interval",
+		30,
+		"alerting rules evaluation interval (seconds)",
+	)
+	flag.StringVar(
+		&c.RecordingCfg.RulesFile,
+		"recording-rules",
+		"",
+		"yaml or json file with recording rules that store query results as metrics",
+	)
+	flag.IntVar(
+		&c.RecordingCfg.EvalInterval,
+		"recording-interval",
+		60,
+		"recording rules evaluation interval for rules without their own (seconds)",
+	)
+	flag.StringVar(
+		&c.NotifyCfg.ReceiversFile,
+		"notify-receivers",
+		"",
+		"yaml or json file with webhook receivers for alert notifications",
+	)
+	flag.StringVar(
+		&c.NotifyCfg.StateFile,
+		"notify-state",
+		"",
+		"file where notification queue and silences are kept between restarts",
+	)
+	flag.StringVar(
+		&c.NotifyCfg.GroupBy,
+		"notify-group-by",
+		"alertname",
+		"comma separated labels, alerts with equal values are sent in one notification",
+	)
+	flag.IntVar(
+		&c.NotifyCfg.RepeatInterval,
+		"notify-repeat",
+		14400,
+		"seconds after which a notification about unchanged firing alerts is repeated",
+	)
+	flag.IntVar(
+		&c.NotifyCfg.MaxRetry,
+		"notify-max-retry",
+		10,
+		"how many times to try delivering a notification",
+	)
+	flag.StringVar(
+		&c.ForwardCfg.SinksFile,
+		"forward-sinks",
+		"",
+		"yaml or json file with sinks every accepted update is forwarded to",
+	)
+	flag.StringVar(
+		&c.FederationCfg.ID,
+		"federation-id",
+		"",
+		"name of this server in the federation chain, hostname by default",
+	)
+	flag.StringVar(
+		&c.FederationCfg.SourcesFile,
+		"federation-sources",
+		"",
+		"yaml or json file with federation sources and aggregation rules",
+	)
+	flag.IntVar(
+		&c.FederationCfg.Interval,
+		"federation-interval",
+		30,
+		"how often to pull federation sources and refresh aggregates, in seconds",
+	)
+	flag.IntVar(
+		&c.FederationCfg.Staleness,
+		"federation-staleness",
+		300,
+		"seconds without data after which a source is left out of gauge aggregates",
+	)
+	flag.IntVar(
+		&c.StreamCfg.Buffer,
+		"stream-buffer",
+		256,
+		"updates buffered per live stream subscriber, slower subscribers are disconnected",
+	)
+	flag.IntVar(
+		&c.StreamCfg.MaxSubscribers,
+		"stream-max-subscribers",
+		1000,
+		"max concurrent live stream subscribers, 0 disables the limit",
+	)
+	flag.StringVar(
+		&c.StreamCfg.AllowedOrigins,
+		"stream-allowed-origins",
+		"",
+		"comma separated origins allowed to open the websocket stream from a browser, * allows any",
+	)
+	flag.IntVar(
+		&c.HistoryCfg.Points,
+		"history-points",
+		120,
+		"recent values kept in memory per series for dashboard charts, 0 disables history",
+	)
+	flag.IntVar(
+		&c.HistoryCfg.Resolution,
+		"history-resolution",
+		10,
+		"min seconds between history points of a series",
+	)
 	flag.Parse()
 }
 
@@ -57,7 +333,46 @@ func (c *Config) LoadEnv() {
 	if err := env.Parse(c); err != nil {
 		log.Fatalf("error setting config from env: %v", err)
 	}
+	if err := env.Parse(&c.MetricsCfg); err != nil {
+		log.Fatalf("error setting metrics config from env: %v", err)
+	}
 	if err := env.Parse(&c.SaverCfg); err != nil {
 		log.Fatalf("error setting saver config from env: %v", err)
 	}
+	if err := env.Parse(&c.StatsdCfg); err != nil {
+		log.Fatalf("error setting statsd config from env: %v", err)
+	}
+	if err := env.Parse(&c.GraphiteCfg); err != nil {
+		log.Fatalf("error setting graphite config from env: %v", err)
+	}
+	if err := env.Parse(&c.TenantsCfg); err != nil {
+		log.Fatalf("error setting tenants config from env: %v", err)
+	}
+	if err := env.Parse(&c.RateLimitCfg); err != nil {
+		log.Fatalf("error setting rate limit config from env: %v", err)
+	}
+	if err := env.Parse(&c.AgentsCfg); err != nil {
+		log.Fatalf("error setting agents config from env: %v", err)
+	}
+	if err := env.Parse(&c.AlertsCfg); err != nil {
+		log.Fatalf("error setting alerts config from env: %v", err)
+	}
+	if err := env.Parse(&c.RecordingCfg); err != nil {
+		log.Fatalf("error setting recording config from env: %v", err)
+	}
+	if err := env.Parse(&c.NotifyCfg); err != nil {
+		log.Fatalf("error setting notify config from env: %v", err)
+	}
+	if err := env.Parse(&c.ForwardCfg); err != nil {
+		log.Fatalf("error setting forward config from env: %v", err)
+	}
+	if err := env.Parse(&c.FederationCfg); err != nil {
+		log.Fatalf("error setting federation config from env: %v", err)
+	}
+	if err := env.Parse(&c.StreamCfg); err != nil {
+		log.Fatalf("error setting stream config from env: %v", err)
+	}
+	if err := env.Parse(&c.HistoryCfg); err != nil {
+		log.Fatalf("error setting history config from env: %v", err)
+	}
 }
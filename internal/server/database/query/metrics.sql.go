@@ -11,13 +11,50 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const delete = `-- name: Delete :many
+DELETE FROM metrics
+WHERE tenant = $1 AND series = ANY($2::text[]) AND updated_at < $3
+RETURNING series
+`
+
+type DeleteParams struct {
+	Tenant string
+	Series []string
+	Before pgtype.Timestamptz
+}
+
+func (q *Queries) Delete(ctx context.Context, arg DeleteParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, delete, arg.Tenant, arg.Series, arg.Before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var series string
+		if err := rows.Scan(&series); err != nil {
+			return nil, err
+		}
+		items = append(items, series)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const get = `-- name: Get :one
-SELECT id, name, type, delta, value, hash FROM metrics
-WHERE name = $1 LIMIT 1
+SELECT id, name, type, delta, value, hash, labels, series, data, updated_at, tenant FROM metrics
+WHERE tenant = $1 AND series = $2 LIMIT 1
 `
 
-func (q *Queries) Get(ctx context.Context, name string) (Metric, error) {
-	row := q.db.QueryRow(ctx, get, name)
+type GetParams struct {
+	Tenant string
+	Series string
+}
+
+func (q *Queries) Get(ctx context.Context, arg GetParams) (Metric, error) {
+	row := q.db.QueryRow(ctx, get, arg.Tenant, arg.Series)
 	var i Metric
 	err := row.Scan(
 		&i.ID,
@@ -26,17 +63,23 @@ func (q *Queries) Get(ctx context.Context, name string) (Metric, error) {
 		&i.Delta,
 		&i.Value,
 		&i.Hash,
+		&i.Labels,
+		&i.Series,
+		&i.Data,
+		&i.UpdatedAt,
+		&i.Tenant,
 	)
 	return i, err
 }
 
 const getAll = `-- name: GetAll :many
-SELECT id, name, type, delta, value, hash FROM metrics
+SELECT id, name, type, delta, value, hash, labels, series, data, updated_at, tenant FROM metrics
+WHERE tenant = $1
 ORDER BY id
 `
 
-func (q *Queries) GetAll(ctx context.Context) ([]Metric, error) {
-	rows, err := q.db.Query(ctx, getAll)
+func (q *Queries) GetAll(ctx context.Context, tenant string) ([]Metric, error) {
+	rows, err := q.db.Query(ctx, getAll, tenant)
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +94,11 @@ func (q *Queries) GetAll(ctx context.Context) ([]Metric, error) {
 			&i.Delta,
 			&i.Value,
 			&i.Hash,
+			&i.Labels,
+			&i.Series,
+			&i.Data,
+			&i.UpdatedAt,
+			&i.Tenant,
 		); err != nil {
 			return nil, err
 		}
@@ -62,36 +110,85 @@ func (q *Queries) GetAll(ctx context.Context) ([]Metric, error) {
 	return items, nil
 }
 
+const tenants = `-- name: Tenants :many
+SELECT DISTINCT tenant FROM metrics
+ORDER BY tenant
+`
+
+func (q *Queries) Tenants(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, tenants)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		items = append(items, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const update = `-- name: Update :exec
-UPDATE metrics SET (delta, value) = ($1, $2)
-WHERE name = $3
+UPDATE metrics SET (delta, value, data, updated_at) = ($1, $2, $3, $4)
+WHERE tenant = $5 AND series = $6
 `
 
 type UpdateParams struct {
-	Delta pgtype.Int8
-	Value pgtype.Float8
-	Name  string
+	Delta     pgtype.Int8
+	Value     pgtype.Float8
+	Data      []byte
+	UpdatedAt pgtype.Timestamptz
+	Tenant    string
+	Series    string
 }
 
 func (q *Queries) Update(ctx context.Context, arg UpdateParams) error {
-	_, err := q.db.Exec(ctx, update, arg.Delta, arg.Value, arg.Name)
+	_, err := q.db.Exec(ctx, update,
+		arg.Delta,
+		arg.Value,
+		arg.Data,
+		arg.UpdatedAt,
+		arg.Tenant,
+		arg.Series,
+	)
 	return err
 }
 
 const write = `-- name: Write :exec
 INSERT INTO metrics (
-  name, type, delta, value, hash
+  name, type, delta, value, hash, labels, series, data, updated_at, tenant
 ) VALUES (
-  $1,$2,$3,$4,$5
+  $1,$2,$3,$4,$5,$6,$7,$8,$9,$10
 )
+ON CONFLICT (tenant, series) DO UPDATE SET
+  name = EXCLUDED.name,
+  type = EXCLUDED.type,
+  delta = EXCLUDED.delta,
+  value = EXCLUDED.value,
+  hash = EXCLUDED.hash,
+  labels = EXCLUDED.labels,
+  data = EXCLUDED.data,
+  updated_at = EXCLUDED.updated_at
 `
 
 type WriteParams struct {
-	Name  string
-	Type  string
-	Delta pgtype.Int8
-	Value pgtype.Float8
-	Hash  pgtype.Text
+	Name      string
+	Type      string
+	Delta     pgtype.Int8
+	Value     pgtype.Float8
+	Hash      pgtype.Text
+	Labels    []byte
+	Series    string
+	Data      []byte
+	UpdatedAt pgtype.Timestamptz
+	Tenant    string
 }
 
 func (q *Queries) Write(ctx context.Context, arg WriteParams) error {
@@ -101,6 +198,11 @@ func (q *Queries) Write(ctx context.Context, arg WriteParams) error {
 		arg.Delta,
 		arg.Value,
 		arg.Hash,
+		arg.Labels,
+		arg.Series,
+		arg.Data,
+		arg.UpdatedAt,
+		arg.Tenant,
 	)
 	return err
 }
@@ -8,11 +8,25 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Metadatum struct {
+	Name        string
+	Type        string
+	Unit        string
+	Description string
+	Owner       string
+	Tenant      string
+}
+
 type Metric struct {
-	ID    int64
-	Name  string
-	Type  string
-	Delta pgtype.Int8
-	Value pgtype.Float8
-	Hash  pgtype.Text
+	ID        int64
+	Name      string
+	Type      string
+	Delta     pgtype.Int8
+	Value     pgtype.Float8
+	Hash      pgtype.Text
+	Labels    []byte
+	Series    string
+	Data      []byte
+	UpdatedAt pgtype.Timestamptz
+	Tenant    string
 }
@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/AA122AA/metring/internal/wire"
+)
+
+// Заголовки, которыми агент представляется серверу в каждом запросе.
+const (
+	HeaderAgentID         = wire.HeaderAgentID
+	HeaderAgentHostname   = wire.HeaderAgentHostname
+	HeaderAgentVersion    = wire.HeaderAgentVersion
+	HeaderAgentCollectors = wire.HeaderAgentCollectors
+)
+
+// Agent запись реестра агентов: кто присылал метрики и когда в последний раз.
+type Agent struct {
+	ID         string    `json:"id"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Collectors []string  `json:"collectors,omitempty"`
+	Addr       string    `json:"addr,omitempty"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+	// LastBatch число метрик в последнем запросе, MetricsTotal - за всё время
+	LastBatch    int    `json:"lastBatch"`
+	MetricsTotal uint64 `json:"metricsTotal"`
+	Stale        bool   `json:"stale"`
+}
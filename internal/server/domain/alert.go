@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+type AlertState string
+
+const (
+	// AlertPending условие выполняется, но ещё не дольше for правила.
+	AlertPending AlertState = "pending"
+	// AlertFiring условие выполняется дольше for правила.
+	AlertFiring AlertState = "firing"
+	// AlertResolved условие перестало выполняться после срабатывания.
+	AlertResolved AlertState = "resolved"
+)
+
+// Alert состояние правила по одной серии.
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Series      string            `json:"series"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	State       AlertState        `json:"state"`
+	Value       float64           `json:"value"`
+	ActiveAt    time.Time         `json:"activeAt"`
+	FiredAt     time.Time         `json:"firedAt,omitzero"`
+	ResolvedAt  time.Time         `json:"resolvedAt,omitzero"`
+}
@@ -0,0 +1,355 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/hll"
+	"github.com/AA122AA/metring/internal/tdigest"
+)
+
+// DefaultBuckets верхние границы бакетов гистограммы по умолчанию (секунды, как в Prometheus).
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultQuantiles квантили, которые отдаются для summary.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// HistogramData распределение по бакетам. Counts[i] - число наблюдений в (Bounds[i-1], Bounds[i]],
+// последний элемент Counts - бакет +Inf. Гистограммы с одинаковыми границами складываются.
+type HistogramData struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Count  uint64    `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+func NewHistogram(bounds []float64) *HistogramData {
+	return &HistogramData{
+		Bounds: slices.Clone(bounds),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe добавляет одно наблюдение.
+func (h *HistogramData) Observe(v float64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+	h.Count++
+	h.Sum += v
+}
+
+// Merge прибавляет o к h. Границы бакетов должны совпадать.
+func (h *HistogramData) Merge(o *HistogramData) error {
+	if o == nil {
+		return fmt.Errorf("no histogram to merge")
+	}
+	if !slices.Equal(h.Bounds, o.Bounds) {
+		return fmt.Errorf("histogram bounds mismatch: %v vs %v", h.Bounds, o.Bounds)
+	}
+	for i := range h.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+
+	return nil
+}
+
+// Validate проверяет согласованность границ и счётчиков.
+func (h *HistogramData) Validate() error {
+	for i := 1; i < len(h.Bounds); i++ {
+		if !(h.Bounds[i-1] < h.Bounds[i]) {
+			return fmt.Errorf("histogram bounds must be strictly increasing")
+		}
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram needs %d counts, got %d", len(h.Bounds)+1, len(h.Counts))
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("histogram count %d does not match buckets sum %d", h.Count, total)
+	}
+
+	return nil
+}
+
+// String выводит количество, сумму и кумулятивные бакеты: count=3 sum=1.5 le_0.5=1 le_+Inf=3
+func (h *HistogramData) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "count=%d sum=%g", h.Count, h.Sum)
+	var cum uint64
+	for i, c := range h.Counts {
+		cum += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, " le_%s=%d", le, cum)
+	}
+
+	return b.String()
+}
+
+// SummaryData потоковые квантили на основе t-digest. Summary с разных источников складываются.
+type SummaryData struct {
+	Count     uint64             `json:"count"`
+	Sum       float64            `json:"sum"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Centroids []tdigest.Centroid `json:"centroids"`
+}
+
+// summaryJSON добавляет к SummaryData посчитанные квантили при сериализации.
+type summaryJSON struct {
+	*summaryAlias
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+type summaryAlias SummaryData
+
+// Observe добавляет одно наблюдение.
+func (s *SummaryData) Observe(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+	s.Centroids = tdigest.Merge(tdigest.DefaultCompression, s.Centroids, []tdigest.Centroid{{Mean: v, Weight: 1}})
+}
+
+// Merge прибавляет o к s.
+func (s *SummaryData) Merge(o *SummaryData) error {
+	if o == nil {
+		return fmt.Errorf("no summary to merge")
+	}
+	if o.Count == 0 {
+		return nil
+	}
+	if s.Count == 0 || o.Min < s.Min {
+		s.Min = o.Min
+	}
+	if s.Count == 0 || o.Max > s.Max {
+		s.Max = o.Max
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Centroids = tdigest.Merge(tdigest.DefaultCompression, s.Centroids, o.Centroids)
+
+	return nil
+}
+
+// Validate проверяет, что вес центроидов совпадает с количеством наблюдений.
+func (s *SummaryData) Validate() error {
+	var total float64
+	for _, c := range s.Centroids {
+		if c.Weight <= 0 || math.IsNaN(c.Mean) {
+			return fmt.Errorf("bad summary centroid %v", c)
+		}
+		total += c.Weight
+	}
+	if uint64(math.Round(total)) != s.Count {
+		return fmt.Errorf("summary count %d does not match centroids weight %g", s.Count, total)
+	}
+
+	return nil
+}
+
+// Quantile оценивает квантиль q (0..1).
+func (s *SummaryData) Quantile(q float64) float64 {
+	switch {
+	case s.Count == 0:
+		return math.NaN()
+	case q <= 0:
+		return s.Min
+	case q >= 1:
+		return s.Max
+	}
+
+	return math.Min(math.Max(tdigest.Quantile(s.Centroids, q), s.Min), s.Max)
+}
+
+// Quantiles считает DefaultQuantiles, ключ - квантиль в виде строки ("0.99").
+func (s *SummaryData) Quantiles() map[string]float64 {
+	return s.quantiles(DefaultQuantiles)
+}
+
+func (s *SummaryData) quantiles(qs []float64) map[string]float64 {
+	if s.Count == 0 {
+		return nil
+	}
+	res := make(map[string]float64, len(qs))
+	for _, q := range qs {
+		res[strconv.FormatFloat(q, 'g', -1, 64)] = s.Quantile(q)
+	}
+
+	return res
+}
+
+func (s *SummaryData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(summaryJSON{
+		summaryAlias: (*summaryAlias)(s),
+		Quantiles:    s.Quantiles(),
+	})
+}
+
+// String выводит количество, сумму и квантили: count=3 sum=1.5 p50=0.5 p99=0.9
+func (s *SummaryData) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "count=%d sum=%g", s.Count, s.Sum)
+	if s.Count == 0 {
+		return b.String()
+	}
+	for _, q := range DefaultQuantiles {
+		fmt.Fprintf(&b, " p%s=%g", strconv.FormatFloat(q*100, 'g', -1, 64), s.Quantile(q))
+	}
+
+	return b.String()
+}
+
+// TimerQuantiles квантили, которые выводятся для timer.
+var TimerQuantiles = []float64{0.5, 0.95, 0.99}
+
+// TimerData длительности (в миллисекундах). Хранится как summary, выводит count/sum/min/max/p50/p95/p99.
+type TimerData struct {
+	SummaryData
+}
+
+// Merge прибавляет o к t.
+func (t *TimerData) Merge(o *TimerData) error {
+	if o == nil {
+		return fmt.Errorf("no timer to merge")
+	}
+
+	return t.SummaryData.Merge(&o.SummaryData)
+}
+
+// Quantiles считает TimerQuantiles, ключ - квантиль в виде строки ("0.95").
+func (t *TimerData) Quantiles() map[string]float64 {
+	return t.quantiles(TimerQuantiles)
+}
+
+// MarshalJSON сериализует таймер как summary, но с квантилями TimerQuantiles.
+func (t *TimerData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(summaryJSON{
+		summaryAlias: (*summaryAlias)(&t.SummaryData),
+		Quantiles:    t.Quantiles(),
+	})
+}
+
+// String выводит статистику таймера: count=3 sum=6 min=1 max=3 p50=2 p95=3 p99=3
+func (t *TimerData) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "count=%d sum=%g", t.Count, t.Sum)
+	if t.Count == 0 {
+		return b.String()
+	}
+	fmt.Fprintf(&b, " min=%g max=%g", t.Min, t.Max)
+	for _, q := range TimerQuantiles {
+		fmt.Fprintf(&b, " p%s=%g", strconv.FormatFloat(q*100, 'g', -1, 64), t.Quantile(q))
+	}
+
+	return b.String()
+}
+
+// SetData HyperLogLog скетч уникальных значений. Items - сырые значения от клиента,
+// сервер переносит их в Registers. Скетчи объединяются, поэтому set складывается с разных источников.
+type SetData struct {
+	Registers []byte   `json:"registers,omitempty"`
+	Items     []string `json:"items,omitempty"`
+}
+
+// setJSON добавляет к SetData оценку количества при сериализации.
+type setJSON struct {
+	*setAlias
+	Count uint64 `json:"count"`
+}
+
+type setAlias SetData
+
+// Add добавляет значение в скетч.
+func (s *SetData) Add(item string) {
+	if s.Registers == nil {
+		s.Registers = hll.New()
+	}
+	hll.Add(s.Registers, item)
+}
+
+// Compact переносит сырые значения в скетч.
+func (s *SetData) Compact() {
+	for _, item := range s.Items {
+		s.Add(item)
+	}
+	s.Items = nil
+	if s.Registers == nil {
+		s.Registers = hll.New()
+	}
+}
+
+// Merge объединяет o с s.
+func (s *SetData) Merge(o *SetData) error {
+	if o == nil {
+		return fmt.Errorf("no set to merge")
+	}
+	if len(o.Registers) != 0 && len(o.Registers) != hll.Size {
+		return fmt.Errorf("set sketch size mismatch: %d", len(o.Registers))
+	}
+	s.Compact()
+	for _, item := range o.Items {
+		s.Add(item)
+	}
+	if len(o.Registers) != 0 {
+		hll.Merge(s.Registers, o.Registers)
+	}
+
+	return nil
+}
+
+// Validate проверяет размер скетча.
+func (s *SetData) Validate() error {
+	if len(s.Registers) != 0 && len(s.Registers) != hll.Size {
+		return fmt.Errorf("set sketch must be %d bytes, got %d", hll.Size, len(s.Registers))
+	}
+
+	return nil
+}
+
+// Count оценивает число уникальных значений.
+func (s *SetData) Count() uint64 {
+	regs := s.Registers
+	if len(s.Items) > 0 {
+		regs = slices.Clone(regs)
+		if regs == nil {
+			regs = hll.New()
+		}
+		for _, item := range s.Items {
+			hll.Add(regs, item)
+		}
+	}
+	if regs == nil {
+		return 0
+	}
+
+	return hll.Count(regs)
+}
+
+func (s *SetData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(setJSON{
+		setAlias: (*setAlias)(s),
+		Count:    s.Count(),
+	})
+}
+
+// String выводит оценку количества уникальных значений: count=42
+func (s *SetData) String() string {
+	return fmt.Sprintf("count=%d", s.Count())
+}
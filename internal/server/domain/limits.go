@@ -0,0 +1,19 @@
+package domain
+
+import "errors"
+
+var (
+	// ErrBadName имя метрики не проходит правила именования.
+	ErrBadName = errors.New("bad metric name")
+	// ErrBadValue значение нельзя принять, например NaN или Inf в наблюдении распределения.
+	ErrBadValue = errors.New("bad metric value")
+	// ErrSeriesLimit новая серия превысила бы лимит числа серий.
+	ErrSeriesLimit = errors.New("series limit exceeded")
+)
+
+// Rejected ошибки приёма, которые не исчезнут при повторе того же обновления:
+// плохое имя или значение, конфликт типа, лимит серий.
+func Rejected(err error) bool {
+	return errors.Is(err, ErrBadName) || errors.Is(err, ErrBadValue) ||
+		errors.Is(err, ErrTypeConflict) || errors.Is(err, ErrSeriesLimit)
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"errors"
+
+	"github.com/AA122AA/metring/internal/server/database/query"
+)
+
+// ErrTypeConflict тип метрики не совпадает с закреплённым в реестре метаданных
+// или с типом уже записанной серии.
+var ErrTypeConflict = errors.New("metric type conflict")
+
+// Metadata описание метрики по имени. Непустой Type закрепляет тип:
+// обновления с другим типом отклоняются.
+type Metadata struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+}
+
+func DBToMetadata(m *query.Metadatum) *Metadata {
+	return &Metadata{
+		Name:        m.Name,
+		Type:        m.Type,
+		Unit:        m.Unit,
+		Description: m.Description,
+		Owner:       m.Owner,
+	}
+}
@@ -1,10 +1,21 @@
 package domain
 
-import "github.com/AA122AA/metring/internal/server/database/query"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/database/query"
+	"github.com/AA122AA/metring/internal/wire"
+)
 
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = wire.Counter
+	Gauge     = wire.Gauge
+	Histogram = "histogram"
+	Summary   = "summary"
+	Set       = "set"
+	Timer     = "timer"
 )
 
 // NOTE: Не усложняем пример, вводя иерархическую вложенность структур.
@@ -12,54 +23,177 @@ const (
 // Delta и Value объявлены через указатели,
 // что бы отличать значение "0", от не заданного значения
 // и соответственно не кодировать в структуру.
+// Серия метрики определяется именем и набором меток (Labels).
+// Для histogram, summary, set и timer значение хранится в одноимённом поле,
+// а Value во входящих данных означает одно наблюдение.
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Histogram *HistogramData    `json:"histogram,omitempty"`
+	Summary   *SummaryData      `json:"summary,omitempty"`
+	Set       *SetData          `json:"set,omitempty"`
+	Timer     *TimerData        `json:"timer,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt,omitzero"`
+	Tenant    string            `json:"tenant,omitempty"`
 }
 
 type MetricsJSON struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Histogram *HistogramData    `json:"histogram,omitempty"`
+	Summary   *SummaryData      `json:"summary,omitempty"`
+	Set       *SetData          `json:"set,omitempty"`
+	Timer     *TimerData        `json:"timer,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// IsValidType проверяет, что тип метрики поддерживается.
+func IsValidType(mType string) bool {
+	switch mType {
+	case Counter, Gauge, Histogram, Summary, Set, Timer:
+		return true
+	}
+
+	return false
+}
+
+// Key возвращает идентификатор серии метрики.
+func (m *Metrics) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// Key возвращает идентификатор серии метрики.
+func (m *MetricsJSON) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// LabelParam префикс query параметра, которым в URL API задаётся метка серии: ?label.host=a.
+const LabelParam = "label."
+
+// SeriesKey собирает идентификатор серии: имя и отсортированные метки в виде name{a="1",b="2"}.
+// Для метрики без меток идентификатор совпадает с именем.
+func SeriesKey(id string, labels map[string]string) string {
+	return wire.SeriesKey(id, labels)
+}
+
+// Number значение серии одним числом: gauge - значение, counter - накопленная сумма,
+// set - оценка числа уникальных, распределения - число наблюдений.
+func (m *Metrics) Number() (float64, bool) {
+	switch {
+	case m.Value != nil:
+		return *m.Value, true
+	case m.Delta != nil:
+		return float64(*m.Delta), true
+	case m.Histogram != nil:
+		return float64(m.Histogram.Count), true
+	case m.Summary != nil:
+		return float64(m.Summary.Count), true
+	case m.Timer != nil:
+		return float64(m.Timer.Count), true
+	case m.Set != nil:
+		return float64(m.Set.Count()), true
+	}
+
+	return 0, false
+}
+
+// MatchLabels проверяет, что у метрики есть все метки из matchers с теми же значениями.
+func MatchLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
 }
 
 func TransformFromJSON(data *MetricsJSON) *Metrics {
 	return &Metrics{
-		ID:    data.ID,
-		MType: data.MType,
-		Delta: data.Delta,
-		Value: data.Value,
+		ID:        data.ID,
+		MType:     data.MType,
+		Delta:     data.Delta,
+		Value:     data.Value,
+		Histogram: data.Histogram,
+		Summary:   data.Summary,
+		Set:       data.Set,
+		Timer:     data.Timer,
+		Labels:    data.Labels,
 	}
 }
 
 func TransformToJSON(data *Metrics) *MetricsJSON {
 	return &MetricsJSON{
-		ID:    data.ID,
-		MType: data.MType,
-		Delta: data.Delta,
-		Value: data.Value,
+		ID:        data.ID,
+		MType:     data.MType,
+		Delta:     data.Delta,
+		Value:     data.Value,
+		Histogram: data.Histogram,
+		Summary:   data.Summary,
+		Set:       data.Set,
+		Timer:     data.Timer,
+		Labels:    data.Labels,
 	}
 }
 
-func DBToDomain(metric *query.Metric) *Metrics {
+// DBToDomain переводит строку БД в метрику. Битые метки или данные распределения
+// возвращаются ошибкой, чтобы не подменить серию пустым значением.
+func DBToDomain(metric *query.Metric) (*Metrics, error) {
 	m := &Metrics{
 		ID:    metric.Name,
 		MType: metric.Type,
 		Hash:  metric.Hash.String,
 	}
+	if metric.UpdatedAt.Valid {
+		m.UpdatedAt = metric.UpdatedAt.Time
+	}
+
+	if len(metric.Labels) > 0 {
+		labels := make(map[string]string)
+		if err := json.Unmarshal(metric.Labels, &labels); err != nil {
+			return nil, fmt.Errorf("cannot decode labels of %v: %w", metric.Series, err)
+		}
+		if len(labels) > 0 {
+			m.Labels = labels
+		}
+	}
+
+	var data any
+	switch m.MType {
+	case Histogram:
+		m.Histogram = &HistogramData{}
+		data = m.Histogram
+	case Summary:
+		m.Summary = &SummaryData{}
+		data = m.Summary
+	case Set:
+		m.Set = &SetData{}
+		data = m.Set
+	case Timer:
+		m.Timer = &TimerData{}
+		data = m.Timer
+	}
+	if data != nil {
+		if err := json.Unmarshal(metric.Data, data); err != nil {
+			return nil, fmt.Errorf("cannot decode %v data of %v: %w", m.MType, metric.Series, err)
+		}
+		return m, nil
+	}
 
 	if metric.Delta.Valid {
 		m.Delta = &metric.Delta.Int64
 		m.Value = nil
-		return m
+		return m, nil
 	}
 
 	m.Value = &metric.Value.Float64
 	m.Delta = nil
 
-	return m
+	return m, nil
 }
@@ -0,0 +1,22 @@
+package domain
+
+import "context"
+
+// DefaultTenant тенант запросов без API ключа, пока тенанты не настроены.
+const DefaultTenant = ""
+
+type tenantKey struct{}
+
+// WithTenant кладёт тенант в контекст запроса, репозитории читают его оттуда.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext возвращает тенант из контекста или DefaultTenant.
+func TenantFromContext(ctx context.Context) string {
+	if t, ok := ctx.Value(tenantKey{}).(string); ok {
+		return t
+	}
+
+	return DefaultTenant
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Agents interface {
+	Seen(ctx context.Context, agent *domain.Agent, count int)
+	List(ctx context.Context) []*domain.Agent
+}
+
+type AgentsHandler struct {
+	srv Agents
+	lg  *zap.Logger
+}
+
+func NewAgentsHandler(ctx context.Context, srv Agents) *AgentsHandler {
+	return &AgentsHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("agents handler"),
+	}
+}
+
+// List отдаёт реестр агентов тенанта.
+func (h *AgentsHandler) List(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.List(r.Context()))
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// agentFromRequest читает идентификацию агента из заголовков, nil если агент не представился.
+func agentFromRequest(r *http.Request) *domain.Agent {
+	id := r.Header.Get(domain.HeaderAgentID)
+	if id == "" {
+		return nil
+	}
+
+	var collectors []string
+	for _, c := range strings.Split(r.Header.Get(domain.HeaderAgentCollectors), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			collectors = append(collectors, c)
+		}
+	}
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	return &domain.Agent{
+		ID:         id,
+		Hostname:   r.Header.Get(domain.HeaderAgentHostname),
+		Version:    r.Header.Get(domain.HeaderAgentVersion),
+		Collectors: collectors,
+		Addr:       addr,
+	}
+}
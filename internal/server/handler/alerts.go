@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Alerts interface {
+	Alerts(ctx context.Context, states ...domain.AlertState) []*domain.Alert
+}
+
+type AlertsHandler struct {
+	srv Alerts
+	lg  *zap.Logger
+}
+
+func NewAlertsHandler(ctx context.Context, srv Alerts) *AlertsHandler {
+	return &AlertsHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("alerts handler"),
+	}
+}
+
+// List отдаёт алерты тенанта. Без ?state= - активные (pending и firing),
+// состояния можно перечислить: ?state=firing&state=resolved.
+func (h *AlertsHandler) List(w http.ResponseWriter, r *http.Request) {
+	var states []domain.AlertState
+	for _, s := range r.URL.Query()["state"] {
+		switch st := domain.AlertState(s); st {
+		case domain.AlertPending, domain.AlertFiring, domain.AlertResolved:
+			states = append(states, st)
+		default:
+			http.Error(w, "bad state "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := json.Marshal(h.srv.Alerts(r.Context(), states...))
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
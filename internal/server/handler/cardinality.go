@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/AA122AA/metring/internal/server/service/cardinality"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// defaultTopLimit сколько префиксов отдаётся без ?limit=.
+const defaultTopLimit = 20
+
+type Cardinality interface {
+	Top(ctx context.Context, n, depth int) ([]*cardinality.PrefixStat, error)
+}
+
+type CardinalityHandler struct {
+	srv Cardinality
+	lg  *zap.Logger
+}
+
+func NewCardinalityHandler(ctx context.Context, srv Cardinality) *CardinalityHandler {
+	return &CardinalityHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("cardinality handler"),
+	}
+}
+
+// Top отдаёт префиксы имён с наибольшим числом серий: ?limit=20&depth=1.
+func (h *CardinalityHandler) Top(w http.ResponseWriter, r *http.Request) {
+	limit, depth := defaultTopLimit, 1
+	for name, v := range map[string]*int{"limit": &limit, "depth": &depth} {
+		q := r.URL.Query().Get(name)
+		if q == "" {
+			continue
+		}
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 {
+			http.Error(w, "bad "+name, http.StatusBadRequest)
+			return
+		}
+		*v = n
+	}
+
+	top, err := h.srv.Top(r.Context(), limit, depth)
+	if err != nil {
+		h.lg.Error("error while counting series", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(top)
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/service/export"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Exporter interface {
+	Export(ctx context.Context, w io.Writer, req *export.Request) error
+}
+
+var exportContentTypes = map[string]string{
+	export.FormatCSV:    "text/csv; charset=utf-8",
+	export.FormatNDJSON: "application/x-ndjson",
+}
+
+type ExportHandler struct {
+	srv Exporter
+	lg  *zap.Logger
+}
+
+func NewExportHandler(ctx context.Context, srv Exporter) *ExportHandler {
+	return &ExportHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("export handler"),
+	}
+}
+
+// Export выгружает серии тенанта: ?format=csv|ndjson (по умолчанию csv), селекторы в ?match=,
+// ?from= и ?to= - unix время или RFC3339. Без from и to - текущие значения, иначе история.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &export.Request{Format: q.Get("format"), Match: q["match"]}
+	if req.Format == "" {
+		req.Format = export.FormatCSV
+	}
+	for param, dst := range map[string]*time.Time{"from": &req.From, "to": &req.To} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			http.Error(w, "bad "+param+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		*dst = t
+	}
+	if _, err := export.Validate(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-type", exportContentTypes[req.Format])
+	w.Header().Set("Content-Disposition", `attachment; filename="metrics.`+req.Format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	// заголовки уже отправлены, ошибку можно только записать в лог
+	if err := h.srv.Export(r.Context(), w, req); err != nil && !errors.Is(err, context.Canceled) {
+		h.lg.Error("error while exporting", zap.Error(err))
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/middleware"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/export"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	require.NoError(t, srv.Update(ctx, gaugeJSON("Alloc", 1.5)))
+	h := middleware.Wrap(http.HandlerFunc(NewExportHandler(ctx, export.NewExporter(ctx, srv, nil)).Export), middleware.WithCompression())
+
+	tests := []struct {
+		name        string
+		target      string
+		gzip        bool
+		status      int
+		contentType string
+		want        string
+	}{
+		{
+			name:        "CSV by default",
+			target:      "/api/v1/export?match=Alloc",
+			status:      http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			want:        "name,type,labels,timestamp,value\nAlloc,gauge,,",
+		},
+		{
+			name:        "NDJSON with gzip",
+			target:      "/api/v1/export?format=ndjson",
+			gzip:        true,
+			status:      http.StatusOK,
+			contentType: "application/x-ndjson",
+			want:        `{"name":"Alloc","type":"gauge","timestamp":`,
+		},
+		{
+			name:   "Unknown format",
+			target: "/api/v1/export?format=xml",
+			status: http.StatusBadRequest,
+			want:   "format must be csv or ndjson",
+		},
+		{
+			name:   "Bad time",
+			target: "/api/v1/export?from=yesterday",
+			status: http.StatusBadRequest,
+			want:   "bad from",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.gzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			var body io.Reader = w.Body
+			if tt.gzip {
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				zr, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				body = zr
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Contains(t, string(data), tt.want)
+			if tt.contentType != "" {
+				require.Equal(t, tt.contentType, w.Header().Get("Content-type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/federation"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Federation interface {
+	Snapshot(ctx context.Context, via []string) ([]*domain.MetricsJSON, error)
+	Receive(ctx context.Context, source string, via []string, data []*domain.MetricsJSON) error
+	Sources() []*federation.Status
+}
+
+type FederationHandler struct {
+	srv   Federation
+	chain *federation.Chain
+	lg    *zap.Logger
+}
+
+func NewFederationHandler(ctx context.Context, srv Federation, chain *federation.Chain) *FederationHandler {
+	return &FederationHandler{
+		srv:   srv,
+		chain: chain,
+		lg:    zctx.From(ctx).Named("federation handler"),
+	}
+}
+
+// Snapshot отдаёт вышестоящему серверу gauge и counter тенанта в формате /updates/,
+// counter - итогами. Цепочка этого сервера возвращается в заголовке X-Metring-Via.
+func (h *FederationHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
+	data, err := h.srv.Snapshot(r.Context(), federation.ParseVia(r.Header.Get(federation.HeaderVia)))
+	if err != nil {
+		if errors.Is(err, federation.ErrLoop) {
+			h.lg.Warn("federation loop", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+			return
+		}
+		h.lg.Error("error while building snapshot", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(data)
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set(federation.HeaderVia, strings.Join(h.chain.List(), ","))
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Receive принимает обновления источника в формате /updates/, источник - в заголовке X-Metring-Source.
+func (h *FederationHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	source := r.Header.Get(federation.HeaderSource)
+	if source == "" {
+		http.Error(w, "missing "+federation.HeaderSource+" header", http.StatusBadRequest)
+		return
+	}
+
+	data := make([]*domain.MetricsJSON, 0, 20)
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "cannot decode body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.srv.Receive(r.Context(), source, federation.ParseVia(r.Header.Get(federation.HeaderVia)), data)
+	if err != nil {
+		switch {
+		case errors.Is(err, federation.ErrLoop):
+			h.lg.Warn("federation loop", zap.String("source", source), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+		case errors.Is(err, federation.ErrUnknownSource):
+			h.lg.Warn("federation source rejected", zap.String("source", source), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case rejectUpdate(w, h.lg, err):
+		default:
+			h.lg.Error("error while receiving federated metrics", zap.String("source", source), zap.Error(err))
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Sources отдаёт состояние источников федерации.
+func (h *FederationHandler) Sources(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.Sources())
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/federation"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederation(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	require.NoError(t, srv.Update(ctx, gaugeJSON("Alloc", 1.5)))
+	chain := federation.NewChain("mid")
+	settings := &federation.Settings{Sources: []*federation.Source{{Name: "dc1"}}}
+	require.NoError(t, settings.Compile())
+	h := NewFederationHandler(ctx, federation.NewFederator(ctx, federation.Config{}, srv, settings, chain), chain)
+
+	tests := []struct {
+		name    string
+		method  string
+		source  string
+		via     string
+		body    string
+		status  int
+		want    string
+		wantVia string
+	}{
+		{
+			name:   "Receive",
+			method: http.MethodPost,
+			source: "dc1",
+			via:    "dc1,rack1",
+			body:   `[{"id":"PollCount","type":"counter","delta":3}]`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Missing source",
+			method: http.MethodPost,
+			body:   `[]`,
+			status: http.StatusBadRequest,
+			want:   "missing X-Metring-Source header",
+		},
+		{
+			name:   "Unknown source",
+			method: http.MethodPost,
+			source: "dc2",
+			body:   `[]`,
+			status: http.StatusForbidden,
+			want:   "unknown federation source",
+		},
+		{
+			name:   "Receive loop",
+			method: http.MethodPost,
+			source: "dc1",
+			via:    "dc1,mid",
+			body:   `[]`,
+			status: http.StatusLoopDetected,
+			want:   "federation loop detected",
+		},
+		{
+			name:    "Snapshot",
+			method:  http.MethodGet,
+			via:     "root",
+			status:  http.StatusOK,
+			want:    `{"id":"PollCount","type":"counter","delta":3,"labels":{"source":"dc1"}}`,
+			wantVia: "mid,dc1,rack1",
+		},
+		{
+			name:   "Snapshot loop",
+			method: http.MethodGet,
+			via:    "rack1",
+			status: http.StatusLoopDetected,
+			want:   "federation loop detected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/federate", strings.NewReader(tt.body))
+			if tt.source != "" {
+				req.Header.Set(federation.HeaderSource, tt.source)
+			}
+			req.Header.Set(federation.HeaderVia, tt.via)
+			w := httptest.NewRecorder()
+			if tt.method == http.MethodGet {
+				h.Snapshot(w, req)
+			} else {
+				h.Receive(w, req)
+			}
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.status, res.StatusCode)
+			require.Contains(t, string(body), tt.want)
+			if tt.wantVia != "" {
+				require.Equal(t, tt.wantVia, res.Header.Get(federation.HeaderVia))
+			}
+		})
+	}
+}
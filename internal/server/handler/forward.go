@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/service/forward"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Forwarding interface {
+	Stats() []*forward.Stats
+}
+
+// ForwardingHandler админский API состояния пересылки.
+type ForwardingHandler struct {
+	srv Forwarding
+	lg  *zap.Logger
+}
+
+func NewForwardingHandler(ctx context.Context, srv Forwarding) *ForwardingHandler {
+	return &ForwardingHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("forwarding handler"),
+	}
+}
+
+// Stats отдаёт очереди приёмников пересылки со счётчиками доставленных и отброшенных обновлений.
+func (h *ForwardingHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.Stats())
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
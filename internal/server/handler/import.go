@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/service/importer"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Importer interface {
+	Import(ctx context.Context, r io.Reader, req *importer.Request) (*importer.Summary, error)
+}
+
+type ImportHandler struct {
+	srv Importer
+	lg  *zap.Logger
+}
+
+func NewImportHandler(ctx context.Context, srv Importer) *ImportHandler {
+	return &ImportHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("import handler"),
+	}
+}
+
+// Import загружает точки с временем: ?format=csv|ndjson, по умолчанию по Content-Type,
+// ?counters=absolute|delta - значения counter итоги (по умолчанию) или приросты.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &importer.Request{Format: q.Get("format"), Counters: q.Get("counters")}
+	if req.Format == "" {
+		ct := r.Header.Get("Content-Type")
+		switch {
+		case strings.Contains(ct, "text/csv"):
+			req.Format = importer.FormatCSV
+		case strings.Contains(ct, "ndjson"):
+			req.Format = importer.FormatNDJSON
+		}
+	}
+	if err := importer.Validate(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum, err := h.srv.Import(r.Context(), r.Body, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, importer.ErrBadRequest):
+			h.lg.Error("import rejected", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case rejectUpdate(w, h.lg, err):
+		default:
+			h.lg.Error("error while importing", zap.Error(err))
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sum)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/importer"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	require.NoError(t, srv.Update(ctx, gaugeJSON("Alloc", 1.5)))
+	h := NewImportHandler(ctx, importer.NewImporter(ctx, srv, nil))
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{
+			name:        "Format from Content-Type",
+			target:      "/api/v1/import",
+			contentType: "application/x-ndjson",
+			body:        `{"name":"Free","timestamp":1704164645,"value":1}`,
+			status:      http.StatusOK,
+			want:        `{"series":1,"points":1}`,
+		},
+		{
+			name:   "Format from query",
+			target: "/api/v1/import?format=csv",
+			body:   "name,type,timestamp,value\nPollCount,counter,1704164645,3\nPollCount,counter,1704164705,5",
+			status: http.StatusOK,
+			// без истории остаются только текущие значения
+			want: `{"series":1,"points":2,"dropped":1}`,
+		},
+		{
+			name:   "Unknown format",
+			target: "/api/v1/import",
+			status: http.StatusBadRequest,
+			want:   "format must be csv or ndjson",
+		},
+		{
+			name:   "Bad line",
+			target: "/api/v1/import?format=ndjson",
+			body:   `{"name":"Free","value":1}`,
+			status: http.StatusBadRequest,
+			want:   "line 1: missing timestamp",
+		},
+		{
+			name:   "Type conflict",
+			target: "/api/v1/import?format=ndjson",
+			body:   `{"name":"Alloc","type":"counter","timestamp":1704164645,"value":1}`,
+			status: http.StatusConflict,
+			want:   "metric type conflict",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.Import(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.status, res.StatusCode)
+			require.Contains(t, string(body), tt.want)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Metadata interface {
+	All(ctx context.Context) map[string]*domain.Metadata
+	Get(ctx context.Context, name string) (*domain.Metadata, error)
+	Put(ctx context.Context, meta *domain.Metadata) error
+	Populate(ctx context.Context, metas []*domain.Metadata) error
+}
+
+type MetadataHandler struct {
+	srv Metadata
+	lg  *zap.Logger
+}
+
+func NewMetadataHandler(ctx context.Context, srv Metadata) *MetadataHandler {
+	return &MetadataHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("metadata handler"),
+	}
+}
+
+// List отдаёт весь реестр метаданных, отсортированный по имени.
+func (h *MetadataHandler) List(w http.ResponseWriter, r *http.Request) {
+	all := h.srv.All(r.Context())
+	res := make([]*domain.Metadata, 0, len(all))
+	for _, name := range slices.Sorted(maps.Keys(all)) {
+		res = append(res, all[name])
+	}
+
+	h.writeJSON(w, res)
+}
+
+func (h *MetadataHandler) Get(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "mName")
+	meta, err := h.srv.Get(r.Context(), name)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if errors.Is(err, er) {
+			http.Error(w, "No metadata for this name", http.StatusNotFound)
+			return
+		}
+		h.lg.Error("error while getting metadata", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, meta)
+}
+
+// Put заменяет метаданные метрики целиком, имя берётся из пути.
+func (h *MetadataHandler) Put(w http.ResponseWriter, r *http.Request) {
+	meta := domain.Metadata{}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "bad metadata", http.StatusBadRequest)
+		return
+	}
+	meta.Name = chi.URLParam(r, "mName")
+
+	if err := h.srv.Put(r.Context(), &meta); err != nil {
+		h.lg.Error("error while saving metadata", zap.Error(err))
+		http.Error(w, "bad metadata", http.StatusBadRequest)
+		return
+	}
+
+	h.writeJSON(w, &meta)
+}
+
+// Populate дополняет реестр списком метаданных (так их присылает агент).
+// Расхождение с закреплённым типом - 409, остальные записи при этом сохраняются.
+func (h *MetadataHandler) Populate(w http.ResponseWriter, r *http.Request) {
+	metas := make([]*domain.Metadata, 0, 20)
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&metas); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "bad metadata", http.StatusBadRequest)
+		return
+	}
+
+	err := h.srv.Populate(r.Context(), metas)
+	if err != nil {
+		if errors.Is(err, domain.ErrTypeConflict) {
+			h.lg.Warn("metadata type conflict", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.lg.Error("error while saving metadata", zap.Error(err))
+		http.Error(w, "bad metadata", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *MetadataHandler) writeJSON(w http.ResponseWriter, v any) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
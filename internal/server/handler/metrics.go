@@ -1,17 +1,23 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"html/template"
+	"maps"
 	"net/http"
 	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/AA122AA/metring/internal/server/constants"
 	"github.com/AA122AA/metring/internal/server/domain"
 	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/exposition"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/templates"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-faster/sdk/zctx"
 	"go.uber.org/zap"
@@ -24,25 +30,70 @@ type Metrics interface {
 	Get(ctx context.Context, metric *domain.MetricsJSON) (string, error)
 	GetJSON(ctx context.Context, metric *domain.MetricsJSON) (*domain.MetricsJSON, error)
 	GetAll(ctx context.Context) (map[string]*domain.Metrics, error)
+	Find(ctx context.Context, name, mType string, labels map[string]string) (map[string]*domain.Metrics, error)
+	Metadata(ctx context.Context) map[string]*domain.Metadata
+	Delete(ctx context.Context, name, mType string, labels map[string]string) ([]string, error)
+	DeleteByPrefix(ctx context.Context, prefix string) ([]string, error)
 }
 
 type Saver interface {
-	WriteSync(data *domain.MetricsJSON) error
-	WriteSyncBatch(data []*domain.MetricsJSON) error
+	WriteSync(ctx context.Context, data *domain.MetricsJSON) error
+	WriteSyncBatch(ctx context.Context, data []*domain.MetricsJSON) error
+	Remove(ctx context.Context, keys []string) error
+}
+
+// History недавние значения серий для графиков на странице метрики.
+type History interface {
+	Points(ctx context.Context, key string) []history.Point
 }
 
 type MetricsHandler struct {
-	srv      Metrics
-	saver    Saver
-	lg       *zap.Logger
-	tmplPath string
+	srv   Metrics
+	saver Saver
+	lg    *zap.Logger
+	// tmpl шаблоны разбираются один раз при создании, tmplErr отдаётся страницами как 500
+	tmpl     *template.Template
+	tmplErr  error
+	maxBatch int
+	agents   Agents
+	history  History
+}
+
+type MetricsOption func(h *MetricsHandler)
+
+// WithMaxBatch ограничивает число метрик в одном запросе /updates/, 0 - без ограничения.
+func WithMaxBatch(n int) MetricsOption {
+	return func(h *MetricsHandler) {
+		h.maxBatch = n
+	}
+}
+
+// WithAgents включает учёт агентов, которые представились в заголовках запроса /updates/.
+func WithAgents(agents Agents) MetricsOption {
+	return func(h *MetricsHandler) {
+		h.agents = agents
+	}
 }
 
-func NewMetricsHandler(ctx context.Context, tPath string, srv Metrics, saver Saver) *MetricsHandler {
+// WithHistory включает графики на странице метрики.
+func WithHistory(history History) MetricsOption {
+	return func(h *MetricsHandler) {
+		h.history = history
+	}
+}
+
+// NewMetricsHandler tPath - каталог или glob с шаблонами, заменяющими встроенные, пусто - только встроенные.
+func NewMetricsHandler(ctx context.Context, tPath string, srv Metrics, saver Saver, opts ...MetricsOption) *MetricsHandler {
 	h := &MetricsHandler{
-		srv:      srv,
-		lg:       zctx.From(ctx).Named("metrics handler"),
-		tmplPath: tPath,
+		srv: srv,
+		lg:  zctx.From(ctx).Named("metrics handler"),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.tmpl, h.tmplErr = templates.Load(tPath)
+	if h.tmplErr != nil {
+		h.lg.Error("can not load templates", zap.String("path", tPath), zap.Error(h.tmplErr))
 	}
 
 	v := reflect.ValueOf(saver)
@@ -55,28 +106,115 @@ func NewMetricsHandler(ctx context.Context, tPath string, srv Metrics, saver Sav
 }
 
 func (h MetricsHandler) All(w http.ResponseWriter, r *http.Request) {
-	templates, err := template.ParseGlob(h.tmplPath)
-	if err != nil {
-		h.lg.Error("no templates within path", zap.String("path", h.tmplPath), zap.Error(err))
+	if h.tmplErr != nil {
 		http.Error(w, "no html templates", http.StatusInternalServerError)
 		return
 	}
 
-	metrics, err := h.srv.GetAll(r.Context())
+	name, mType, labels := filterFromQuery(r)
+	metrics, err := h.srv.Find(r.Context(), name, mType, labels)
 	if err != nil {
 		h.lg.Error("service returned no data", zap.Error(err))
 		http.Error(w, "no data", http.StatusNotFound)
 		return
 	}
 	data := struct {
-		Metrics map[string]*domain.Metrics
+		Metrics  map[string]*domain.Metrics
+		Metadata map[string]*domain.Metadata
+		Agents   []*domain.Agent
+		Type     string
+		Types    []string
 	}{
-		Metrics: metrics,
+		Metrics:  metrics,
+		Metadata: h.srv.Metadata(r.Context()),
+		Type:     mType,
+		Types:    []string{domain.Counter, domain.Gauge, domain.Histogram, domain.Summary, domain.Set, domain.Timer},
+	}
+	if h.agents != nil {
+		data.Agents = h.agents.List(r.Context())
 	}
 
-	w.Header().Set("Content-Type", "text/html")
+	h.render(w, "metrics.html", data)
+}
+
+// recentPoints сколько последних точек истории показывается таблицей на странице метрики.
+const recentPoints = 20
+
+// Detail страница одной серии с графиком недавних значений, метки задаются параметрами label.* как в /value/.
+func (h MetricsHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	if h.tmplErr != nil {
+		http.Error(w, "no html templates", http.StatusInternalServerError)
+		return
+	}
+
+	mName := chi.URLParam(r, "mName")
+	mType := chi.URLParam(r, "mType")
+	labels := labelsFromQuery(r)
+	key := domain.SeriesKey(mName, labels)
+
+	found, err := h.srv.Find(r.Context(), mName, mType, labels)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			h.lg.Error("error while searching metric", zap.Error(err))
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+			return
+		}
+	}
+	var metric *domain.Metrics
+	for _, m := range found {
+		if m.Key() == key {
+			metric = m
+			break
+		}
+	}
+	if metric == nil {
+		http.Error(w, "No metric with this name and labels", http.StatusNotFound)
+		return
+	}
+
+	data := struct {
+		Key            string
+		Metric         *domain.Metrics
+		Metadata       *domain.Metadata
+		Points         []history.Point
+		Recent         []history.Point
+		Values         []float64
+		Min, Max, Last float64
+	}{
+		Key:      key,
+		Metric:   metric,
+		Metadata: h.srv.Metadata(r.Context())[mName],
+	}
+	if h.history != nil {
+		data.Points = h.history.Points(r.Context(), key)
+	}
+	if len(data.Points) > 0 {
+		data.Values = make([]float64, 0, len(data.Points))
+		for _, p := range data.Points {
+			data.Values = append(data.Values, p.Value)
+		}
+		data.Min, data.Max = slices.Min(data.Values), slices.Max(data.Values)
+		data.Last = data.Values[len(data.Values)-1]
+		data.Recent = slices.Clone(data.Points[max(0, len(data.Points)-recentPoints):])
+		slices.Reverse(data.Recent)
+	}
+
+	h.render(w, "metric.html", data)
+}
+
+// render выполняет шаблон в буфер, чтобы при ошибке отдать 500, а не половину страницы.
+func (h MetricsHandler) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := h.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		h.lg.Error("error while rendering template", zap.String("template", name), zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	templates.ExecuteTemplate(w, "metrics.html", data)
+	w.Write(buf.Bytes())
 }
 
 func (h MetricsHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -91,6 +229,7 @@ func (h MetricsHandler) Get(w http.ResponseWriter, r *http.Request) {
 		h.lg.Error("what happend", zap.String("name", mName), zap.Error(err))
 		return
 	}
+	data.Labels = labelsFromQuery(r)
 
 	m, err := h.srv.Get(r.Context(), data)
 	if err != nil {
@@ -176,9 +315,13 @@ func (h MetricsHandler) Update(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "тип или значение некорректно", http.StatusBadRequest)
 		return
 	}
+	data.Labels = labelsFromQuery(r)
 
 	err = h.srv.Update(r.Context(), data)
 	if err != nil {
+		if rejectUpdate(w, h.lg, err) {
+			return
+		}
 		h.lg.Error("error while updating metric", zap.Error(err))
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
@@ -186,7 +329,7 @@ func (h MetricsHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	if h.saver != nil {
 		h.lg.Info("saver is not nil", zap.Any("h.saver", h.saver))
-		err = h.saver.WriteSync(data)
+		err = h.saver.WriteSync(r.Context(), data)
 		if err != nil {
 			h.lg.Error("error while writing to file", zap.Error(err))
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
@@ -211,13 +354,16 @@ func (h MetricsHandler) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 
 	err = h.srv.Update(r.Context(), &metric)
 	if err != nil {
+		if rejectUpdate(w, h.lg, err) {
+			return
+		}
 		h.lg.Error("metrics type or value is incorrect")
 		http.Error(w, "тип или значение некорректно", http.StatusBadRequest)
 		return
 	}
 
 	if h.saver != nil {
-		err = h.saver.WriteSync(&metric)
+		err = h.saver.WriteSync(r.Context(), &metric)
 		if err != nil {
 			h.lg.Error("error while writing to file", zap.Error(err))
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
@@ -233,26 +379,201 @@ func (h MetricsHandler) Updates(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	err := json.NewDecoder(r.Body).Decode(&metrics)
 	if err != nil {
-		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		http.Error(w, "не удалось разобрать метрики", http.StatusBadRequest)
 		h.lg.Error("error while decoding", zap.Error(err))
 		return
 	}
+	if h.maxBatch > 0 && len(metrics) > h.maxBatch {
+		h.lg.Warn("batch is too large", zap.Int("count", len(metrics)), zap.Int("max", h.maxBatch))
+		http.Error(w, "слишком много метрик в запросе", http.StatusRequestEntityTooLarge)
+		return
+	}
 
+	// отклонённые данные - 4xx, сбой хранилища - 500, чтобы агент повторил отправку
 	err = h.srv.Updates(r.Context(), metrics)
 	if err != nil {
-		h.lg.Error("metrics type or value is incorrect")
-		http.Error(w, "тип или значение некорректно", http.StatusBadRequest)
+		if rejectUpdate(w, h.lg, err) {
+			return
+		}
+		h.lg.Error("error while updating metrics", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
 		return
 	}
 
 	if h.saver != nil {
-		err = h.saver.WriteSyncBatch(metrics)
+		err = h.saver.WriteSyncBatch(r.Context(), metrics)
 		if err != nil {
 			h.lg.Error("error while writing to file", zap.Error(err))
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
 			return
 		}
 	}
+	if agent := agentFromRequest(r); agent != nil && h.agents != nil {
+		h.agents.Seen(r.Context(), agent, len(metrics))
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// List отдаёт серии в JSON, отфильтрованные по имени, типу и меткам из query.
+func (h MetricsHandler) List(w http.ResponseWriter, r *http.Request) {
+	name, mType, labels := filterFromQuery(r)
+	found, err := h.srv.Find(r.Context(), name, mType, labels)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+			h.lg.Error("got error in repo", zap.Error(err))
+			return
+		}
+	}
+
+	keys := slices.Sorted(maps.Keys(found))
+	metrics := make([]*domain.MetricsJSON, 0, len(keys))
+	for _, k := range keys {
+		metrics = append(metrics, domain.TransformToJSON(found[k]))
+	}
+
+	res, err := json.Marshal(metrics)
+	if err != nil {
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		h.lg.Error("error while marshaling", zap.Error(err))
+		return
+	}
 
+	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Delete удаляет метрику: все её серии или только серии с метками из query.
+func (h MetricsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "mName")
+	mType := chi.URLParam(r, "mType")
+
+	keys, err := h.srv.Delete(r.Context(), mName, mType, labelsFromQuery(r))
+	if err != nil {
+		h.lg.Error("error while deleting metric", zap.String("name", mName), zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		http.Error(w, "No metric with this name", http.StatusNotFound)
+		return
+	}
+
+	if h.removeSaved(w, r, keys) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteByPrefix удаляет все серии, имя которых начинается с ?prefix=, и отдаёт их количество.
+func (h MetricsHandler) DeleteByPrefix(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := h.srv.DeleteByPrefix(r.Context(), prefix)
+	if err != nil {
+		h.lg.Error("error while deleting metrics", zap.String("prefix", prefix), zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.removeSaved(w, r, keys) {
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Deleted int `json:"deleted"`
+	}{
+		Deleted: len(keys),
+	})
+}
+
+// rejectUpdate отвечает на нарушение правил приёма: конфликт типа, плохое имя или значение,
+// лимит серий. Общий для всех путей приёма, чтобы они отвечали одинаково.
+// Возвращает false, если ошибка другая.
+func rejectUpdate(w http.ResponseWriter, lg *zap.Logger, err error) bool {
+	switch {
+	case errors.Is(err, domain.ErrTypeConflict):
+		lg.Error("metric type conflicts with stored one", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, domain.ErrBadName):
+		lg.Error("bad metric name", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrBadValue):
+		lg.Error("bad metric value", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrSeriesLimit):
+		lg.Error("series limit exceeded", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// removeSaved убирает удалённые серии из файла сохранения, при ошибке отвечает 500.
+func (h MetricsHandler) removeSaved(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	if h.saver == nil {
+		return true
+	}
+	if err := h.saver.Remove(r.Context(), keys); err != nil {
+		h.lg.Error("error while removing from file", zap.Error(err))
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// Exposition отдаёт все метрики в Prometheus text format с HELP из реестра метаданных.
+func (h MetricsHandler) Exposition(w http.ResponseWriter, r *http.Request) {
+	all, err := h.srv.GetAll(r.Context())
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+			h.lg.Error("got error in repo", zap.Error(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", exposition.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if err := exposition.Write(w, all, h.srv.Metadata(r.Context())); err != nil {
+		h.lg.Error("error while writing exposition", zap.Error(err))
+	}
+}
+
+// labelsFromQuery собирает метки серии из query параметров с префиксом domain.LabelParam:
+// /value/gauge/Alloc?label.host=a. Остальные параметры метками не считаются.
+func labelsFromQuery(r *http.Request) map[string]string {
+	var labels map[string]string
+	for k, v := range r.URL.Query() {
+		name, ok := strings.CutPrefix(k, domain.LabelParam)
+		if !ok {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[name] = v[0]
+	}
+
+	return labels
+}
+
+// filterFromQuery разбирает фильтр для списков: имя и тип из name и type,
+// метки как в labelsFromQuery.
+func filterFromQuery(r *http.Request) (string, string, map[string]string) {
+	query := r.URL.Query()
+
+	return query.Get("name"), query.Get("type"), labelsFromQuery(r)
 }
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"io"
@@ -12,9 +13,15 @@ import (
 	"testing"
 
 	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/middleware"
 	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/agents"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/metadata"
 	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/ratelimit"
 	"github.com/AA122AA/metring/internal/server/service/saver"
+	"github.com/AA122AA/metring/internal/server/service/tenants"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 )
@@ -47,6 +54,13 @@ func TestGetAll(t *testing.T) {
 			repo:   repository.NewMockRepo(),
 			pass:   true,
 		},
+		{
+			name:   "Embedded templates",
+			url:    "/",
+			status: 200,
+			repo:   repository.NewMockRepo(),
+			pass:   true,
+		},
 		{
 			name:  "Negative tPath",
 			url:   "/",
@@ -457,3 +471,358 @@ func TestUpdateJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil)
+
+	v1, v2 := float64(1), float64(2)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "Alloc", MType: domain.Gauge, Value: &v1, Labels: map[string]string{"host": "a"}},
+		{ID: "Alloc", MType: domain.Gauge, Value: &v2, Labels: map[string]string{"host": "b"}},
+	}))
+
+	cases := []struct {
+		name string
+		url  string
+		want []float64
+	}{
+		{name: "All by name", url: "/api/v1/metrics?name=Alloc", want: []float64{1, 2}},
+		{name: "By label", url: "/api/v1/metrics?label.host=b", want: []float64{2}},
+		// параметры без префикса label. метками не считаются
+		{name: "Not a label", url: "/api/v1/metrics?name=Alloc&host=b&utm_source=x", want: []float64{1, 2}},
+		{name: "No match", url: "/api/v1/metrics?type=counter", want: []float64{}},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tCase.url, nil)
+			rec := httptest.NewRecorder()
+			h.List(rec, r)
+
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			var got []*domain.MetricsJSON
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+			values := make([]float64, 0, len(got))
+			for _, m := range got {
+				values = append(values, *m.Value)
+			}
+			require.Equal(t, tCase.want, values)
+		})
+	}
+
+	// Серия с метками ищется в URL API через query параметры
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("mName", "Alloc")
+	rctx.URLParams.Add("mType", domain.Gauge)
+	r := httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc?label.host=a", nil)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	h.Get(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "1", rec.Body.String())
+}
+
+func TestTypeConflict(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	reg := metadata.NewRegistry(ctx, repo)
+	require.NoError(t, reg.Put(ctx, &domain.Metadata{Name: "PollCount", Type: domain.Counter, Description: "Number of polls"}))
+
+	srv := metrics.NewMetrics(ctx, repo, metrics.WithMetadata(reg))
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil)
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "Locked type", body: `[{"id":"PollCount","type":"counter","delta":1}]`, status: http.StatusOK},
+		{name: "Conflict", body: `[{"id":"PollCount","type":"gauge","value":1}]`, status: http.StatusConflict},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tCase.body))
+			rec := httptest.NewRecorder()
+			h.Updates(rec, r)
+			require.Equal(t, tCase.status, rec.Code)
+		})
+	}
+
+	// HELP в Prometheus формате берётся из реестра
+	rec := httptest.NewRecorder()
+	h.Exposition(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "# HELP PollCount Number of polls\n# TYPE PollCount counter\nPollCount 1\n", rec.Body.String())
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil)
+
+	v := float64(1)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "Alloc", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "a"}},
+		{ID: "Alloc", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "b"}},
+		{ID: "host1.cpu", MType: domain.Gauge, Value: &v},
+		{ID: "host1.mem", MType: domain.Gauge, Value: &v},
+		{ID: "host2.cpu", MType: domain.Gauge, Value: &v},
+	}))
+
+	cases := []struct {
+		name   string
+		mType  string
+		url    string
+		status int
+		left   int
+	}{
+		{name: "One series", mType: domain.Gauge, url: "/value/gauge/Alloc?label.host=a", status: http.StatusOK, left: 4},
+		{name: "Wrong type", mType: domain.Counter, url: "/value/counter/Alloc", status: http.StatusNotFound, left: 4},
+		{name: "All series", mType: domain.Gauge, url: "/value/gauge/Alloc", status: http.StatusOK, left: 3},
+		{name: "Already deleted", mType: domain.Gauge, url: "/value/gauge/Alloc", status: http.StatusNotFound, left: 3},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("mName", "Alloc")
+			rctx.URLParams.Add("mType", tCase.mType)
+			r := httptest.NewRequest(http.MethodDelete, tCase.url, nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			rec := httptest.NewRecorder()
+			h.Delete(rec, r)
+
+			require.Equal(t, tCase.status, rec.Code)
+			require.Len(t, repo.Values, tCase.left)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.DeleteByPrefix(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/metrics?prefix=host1.", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"deleted":2}`, rec.Body.String())
+	require.Contains(t, repo.Values, "host2.cpu")
+
+	rec = httptest.NewRecorder()
+	h.DeleteByPrefix(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/metrics", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	reg, err := tenants.NewRegistry(ctx, tenants.Config{})
+	require.NoError(t, err)
+	require.NoError(t, reg.Put(&tenants.Tenant{ID: "team-a", Keys: []string{"key-a"}}))
+	require.NoError(t, reg.Put(&tenants.Tenant{ID: "team-b", Keys: []string{"key-b"}}))
+
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil)
+	updates := middleware.Wrap(http.HandlerFunc(h.Updates), middleware.WithTenant(reg))
+	list := middleware.Wrap(http.HandlerFunc(h.List), middleware.WithTenant(reg))
+
+	// обе команды пишут одно и то же имя
+	for _, tCase := range []struct {
+		key  string
+		body string
+	}{
+		{key: "key-a", body: `[{"id":"RandomValue","type":"gauge","value":1}]`},
+		{key: "key-b", body: `[{"id":"RandomValue","type":"gauge","value":2}]`},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tCase.body))
+		r.Header.Set("Authorization", "Bearer "+tCase.key)
+		rec := httptest.NewRecorder()
+		updates(rec, r)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	cases := []struct {
+		name   string
+		auth   string
+		status int
+		body   string
+	}{
+		{name: "Tenant A", auth: "Bearer key-a", status: http.StatusOK, body: `[{"id":"RandomValue","type":"gauge","value":1}]`},
+		{name: "Tenant B", auth: "Bearer key-b", status: http.StatusOK, body: `[{"id":"RandomValue","type":"gauge","value":2}]`},
+		{name: "Unknown key", auth: "Bearer key-c", status: http.StatusUnauthorized},
+		{name: "No key", status: http.StatusUnauthorized},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil)
+			if tCase.auth != "" {
+				r.Header.Set("Authorization", tCase.auth)
+			}
+			rec := httptest.NewRecorder()
+			list(rec, r)
+
+			require.Equal(t, tCase.status, rec.Code)
+			if tCase.body != "" {
+				require.JSONEq(t, tCase.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil, WithMaxBatch(2))
+	lim := ratelimit.NewLimiter(ctx, ratelimit.Config{Rate: 1, Burst: 3})
+	reg, err := tenants.NewRegistry(ctx, tenants.Config{})
+	require.NoError(t, err)
+	updates := middleware.Wrap(
+		middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(h.Updates), middleware.WithBodyLimit(200)),
+			middleware.WithCompression()),
+		middleware.WithRateLimit(lim, reg))
+
+	gzipped := func(body string) *bytes.Buffer {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(body))
+		w.Close()
+		return &buf
+	}
+	// 10 КБ пробелов сжимаются в пару десятков байт
+	bomb := `[{"id":"Alloc","type":"gauge","value":1}` + strings.Repeat(" ", 10000) + `]`
+
+	cases := []struct {
+		name   string
+		body   io.Reader
+		gzip   bool
+		auth   string
+		status int
+	}{
+		{name: "Ok", body: strings.NewReader(`[{"id":"Alloc","type":"gauge","value":1}]`), status: http.StatusOK},
+		{name: "Too many metrics", body: strings.NewReader(`[{"id":"A","type":"gauge","value":1},{"id":"B","type":"gauge","value":1},{"id":"C","type":"gauge","value":1}]`), status: http.StatusRequestEntityTooLarge},
+		{name: "Gzip bomb", body: gzipped(bomb), gzip: true, status: http.StatusRequestEntityTooLarge},
+		{name: "Rate limited", body: strings.NewReader(`[]`), status: http.StatusTooManyRequests},
+		// без тенантов ключ не проверяется, поэтому новый ключ не даёт нового запаса
+		{name: "Random key", body: strings.NewReader(`[]`), auth: "Bearer random", status: http.StatusTooManyRequests},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", tCase.body)
+			if tCase.gzip {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			if tCase.auth != "" {
+				r.Header.Set("Authorization", tCase.auth)
+			}
+			rec := httptest.NewRecorder()
+			updates(rec, r)
+
+			require.Equal(t, tCase.status, rec.Code)
+			if tCase.status == http.StatusTooManyRequests {
+				require.Equal(t, "1", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestAgents(t *testing.T) {
+	ctx := context.Background()
+	reg := agents.NewRegistry(ctx, agents.Config{StaleAfter: 60})
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil, WithAgents(reg))
+
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":1}]`))
+	r.Header.Set(domain.HeaderAgentID, "host-a")
+	r.Header.Set(domain.HeaderAgentVersion, "1.0")
+	r.Header.Set(domain.HeaderAgentCollectors, "runtime,scrape")
+	rec := httptest.NewRecorder()
+	h.Updates(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// запрос без идентификации в реестр не попадает
+	rec = httptest.NewRecorder()
+	h.Updates(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[]`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewAgentsHandler(ctx, reg).List(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list []*domain.Agent
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "host-a", list[0].ID)
+	require.Equal(t, []string{"runtime", "scrape"}, list[0].Collectors)
+	require.Equal(t, 2, list[0].LastBatch)
+	require.False(t, list[0].Stale)
+
+	rec = httptest.NewRecorder()
+	h.All(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "<td>host-a</td>")
+}
+
+func TestDetail(t *testing.T) {
+	ctx := context.Background()
+	hist := history.NewHistory(history.Config{Points: 10})
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithPublisher(hist))
+	h := NewMetricsHandler(ctx, "", srv, nil, WithHistory(hist))
+
+	for _, v := range []float64{1, 5, 3} {
+		require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "Alloc", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "a"}}))
+	}
+
+	cases := []struct {
+		name   string
+		url    string
+		mType  string
+		status int
+		want   string
+	}{
+		{name: "Series", url: "/metric/gauge/Alloc?label.host=a", mType: domain.Gauge, status: http.StatusOK, want: "<polyline"},
+		{name: "Other labels", url: "/metric/gauge/Alloc?label.host=b", mType: domain.Gauge, status: http.StatusNotFound},
+		{name: "Without labels", url: "/metric/gauge/Alloc", mType: domain.Gauge, status: http.StatusNotFound},
+		{name: "Other type", url: "/metric/counter/Alloc?label.host=a", mType: domain.Counter, status: http.StatusNotFound},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("mName", "Alloc")
+			rctx.URLParams.Add("mType", tCase.mType)
+			r := httptest.NewRequest(http.MethodGet, tCase.url, nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			rec := httptest.NewRecorder()
+
+			h.Detail(rec, r)
+
+			require.Equal(t, tCase.status, rec.Code)
+			if tCase.want != "" {
+				require.Contains(t, rec.Body.String(), tCase.want)
+				require.Contains(t, rec.Body.String(), "min 1, max 5, last 3")
+			}
+		})
+	}
+}
+
+func TestUpdatesStatus(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyUpdates{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage())}
+	h := NewMetricsHandler(ctx, "../templates/*.html", srv, nil)
+
+	send := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.Updates(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// плохие данные агент не повторяет
+	require.Equal(t, http.StatusBadRequest, send(`[{"id":"Alloc","type":"gauge","value":"x"}]`))
+	require.Equal(t, http.StatusBadRequest, send(`[{"id":"Alloc","type":"unknown","value":1}]`))
+
+	// сбой хранилища - 500, чтобы агент отправил пачку ещё раз
+	srv.fail = true
+	require.Equal(t, http.StatusInternalServerError, send(`[{"id":"Alloc","type":"gauge","value":1}]`))
+
+	srv.fail = false
+	require.Equal(t, http.StatusOK, send(`[{"id":"Alloc","type":"gauge","value":1}]`))
+}
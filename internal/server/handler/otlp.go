@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/go-faster/sdk/zctx"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+type OTLP interface {
+	Export(ctx context.Context, data *metricspb.MetricsData) error
+}
+
+type OTLPHandler struct {
+	srv OTLP
+	lg  *zap.Logger
+}
+
+func NewOTLPHandler(ctx context.Context, srv OTLP) *OTLPHandler {
+	return &OTLPHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("otlp handler"),
+	}
+}
+
+// Export принимает OTLP/HTTP запрос на /v1/metrics.
+// ExportMetricsServiceRequest совпадает по формату с MetricsData, поэтому декодируем в него.
+func (h *OTLPHandler) Export(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.lg.Error("error while reading body", zap.Error(err))
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = contentTypeProtobuf
+	}
+
+	data := &metricspb.MetricsData{}
+	switch contentType {
+	case contentTypeProtobuf, "application/protobuf":
+		err = proto.Unmarshal(body, data)
+	case contentTypeJSON:
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, data)
+	default:
+		h.lg.Error("unsupported content type", zap.String("content type", contentType))
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "cannot decode otlp payload", http.StatusBadRequest)
+		return
+	}
+
+	// OTLP клиенты повторяют только 429, 502, 503 и 504: отклонённые метрики получают 4xx,
+	// остальные ошибки записи - 503, чтобы клиент повторил запрос, а не потерял данные
+	err = h.srv.Export(r.Context(), data)
+	switch {
+	case err == nil:
+	case rejectUpdate(w, h.lg, err):
+		return
+	default:
+		h.lg.Error("error while writing metrics", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Пустой ExportMetricsServiceResponse означает полный успех
+	if contentType == contentTypeJSON {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.WriteHeader(http.StatusOK)
+}
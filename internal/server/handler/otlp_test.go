@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/otlp"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func cumulativeSum(name string, start uint64, v int64) *metricspb.MetricsData {
+	return &metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: name,
+					Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						IsMonotonic:            true,
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						DataPoints: []*metricspb.NumberDataPoint{{
+							StartTimeUnixNano: start,
+							Attributes: []*commonpb.KeyValue{{
+								Key:   "host",
+								Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}},
+							}},
+							Value: &metricspb.NumberDataPoint_AsInt{AsInt: v},
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	h := NewOTLPHandler(ctx, otlp.NewReceiver(ctx, srv))
+
+	send := func(contentType string, body []byte) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.Export(rec, r)
+		return rec.Result()
+	}
+
+	// Накопительный счётчик стартовал после metring: 10, затем 25 - в metring должно оказаться 25
+	start := uint64(time.Now().UnixNano())
+	for _, v := range []int64{10, 25} {
+		body, err := proto.Marshal(cumulativeSum("requests", start, v))
+		require.NoError(t, err)
+		res := send("application/x-protobuf", body)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "requests", MType: domain.Counter, Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), *m.Delta)
+
+	jsonBody := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[
+		{"name":"temperature","gauge":{"dataPoints":[{"asDouble":36.6}]}},
+		{"name":"latency","histogram":{"aggregationTemporality":2,"dataPoints":[
+			{"startTimeUnixNano":"` + strconv.FormatUint(start, 10) + `","count":"3","sum":0.6,"bucketCounts":["1","2"],"explicitBounds":[0.1]}
+		]}}
+	]}]}]}`
+	res := send("application/json", []byte(jsonBody))
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	m, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "temperature", MType: domain.Gauge})
+	require.NoError(t, err)
+	require.Equal(t, 36.6, *m.Value)
+
+	m, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "latency_count", MType: domain.Counter})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *m.Delta)
+
+	m, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "latency_bucket", MType: domain.Counter, Labels: map[string]string{"le": "0.1"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *m.Delta)
+
+	m, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "latency_bucket", MType: domain.Counter, Labels: map[string]string{"le": "+Inf"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *m.Delta)
+
+	res = send("text/plain", []byte(strings.Repeat("x", 3)))
+	res.Body.Close()
+	require.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+
+	res = send("application/x-protobuf", []byte("garbage"))
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// flakyUpdates сервис метрик, запись в который не удаётся, пока fail не сброшен.
+type flakyUpdates struct {
+	*metrics.Metrics
+	fail bool
+}
+
+func (f *flakyUpdates) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
+	if f.fail {
+		return errors.New("database is down")
+	}
+	return f.Metrics.Updates(ctx, data)
+}
+
+func TestOTLPExportErrors(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyUpdates{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage())}
+	h := NewOTLPHandler(ctx, otlp.NewReceiver(ctx, srv))
+
+	send := func(data *metricspb.MetricsData) int {
+		body, err := proto.Marshal(data)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		h.Export(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)))
+		return rec.Code
+	}
+
+	start := uint64(time.Now().UnixNano())
+	require.Equal(t, http.StatusOK, send(cumulativeSum("requests", start, 10)))
+
+	// ошибка хранилища повторяется клиентом, повтор не теряет прирост
+	srv.fail = true
+	require.Equal(t, http.StatusServiceUnavailable, send(cumulativeSum("requests", start, 25)))
+	srv.fail = false
+	require.Equal(t, http.StatusOK, send(cumulativeSum("requests", start, 25)))
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "requests", MType: domain.Counter, Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), *m.Delta)
+
+	// отклонённые метрики не повторяются
+	v := 1.0
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "load", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "a"}}))
+	require.Equal(t, http.StatusConflict, send(cumulativeSum("load", start, 1)))
+}
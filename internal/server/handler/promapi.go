@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Query interface {
+	Instant(ctx context.Context, q string, ts time.Time) (*promql.Result, error)
+	Range(ctx context.Context, q string, start, end time.Time, step time.Duration) (*promql.Result, error)
+	Series(ctx context.Context, matchers []string) ([]map[string]string, error)
+	LabelNames(ctx context.Context, matchers []string) ([]string, error)
+	LabelValues(ctx context.Context, name string, matchers []string) ([]string, error)
+}
+
+// PromAPIHandler часть Prometheus HTTP API, которой пользуется Grafana.
+// Ответы в конверте {"status": ..., "data": ...}, параметры принимаются в query и form.
+type PromAPIHandler struct {
+	srv Query
+	lg  *zap.Logger
+}
+
+func NewPromAPIHandler(ctx context.Context, srv Query) *PromAPIHandler {
+	return &PromAPIHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("prometheus api handler"),
+	}
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	Data      any    `json:"data,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type promData struct {
+	ResultType string `json:"resultType"`
+	Result     any    `json:"result"`
+}
+
+type promSeries struct {
+	Metric map[string]string `json:"metric"`
+	Value  *promSample       `json:"value,omitempty"`
+	Values []promSample      `json:"values,omitempty"`
+}
+
+// promSample точка в виде [unix время в секундах, "значение"].
+type promSample promql.Sample
+
+func (s promSample) MarshalJSON() ([]byte, error) {
+	ts := float64(s.T.UnixMilli()) / 1000
+	return json.Marshal([]any{ts, formatValue(s.V)})
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Query мгновенный запрос /api/v1/query: query и необязательный time.
+func (h *PromAPIHandler) Query(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.badData(w, err)
+		return
+	}
+	ts := time.Now()
+	if v := r.Form.Get("time"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			h.badData(w, err)
+			return
+		}
+		ts = t
+	}
+
+	res, err := h.srv.Instant(r.Context(), r.Form.Get("query"), ts)
+	if err != nil {
+		h.queryError(w, err)
+		return
+	}
+
+	h.success(w, resultData(res))
+}
+
+// QueryRange запрос по диапазону /api/v1/query_range: query, start, end и step.
+func (h *PromAPIHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.badData(w, err)
+		return
+	}
+	start, err := parseTime(r.Form.Get("start"))
+	if err != nil {
+		h.badData(w, errors.New("invalid parameter \"start\": "+err.Error()))
+		return
+	}
+	end, err := parseTime(r.Form.Get("end"))
+	if err != nil {
+		h.badData(w, errors.New("invalid parameter \"end\": "+err.Error()))
+		return
+	}
+	step, err := parseStep(r.Form.Get("step"))
+	if err != nil {
+		h.badData(w, errors.New("invalid parameter \"step\": "+err.Error()))
+		return
+	}
+
+	res, err := h.srv.Range(r.Context(), r.Form.Get("query"), start, end, step)
+	if err != nil {
+		h.queryError(w, err)
+		return
+	}
+
+	h.success(w, resultData(res))
+}
+
+// Series метки серий /api/v1/series, селекторы передаются в match[].
+func (h *PromAPIHandler) Series(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.badData(w, err)
+		return
+	}
+	matchers := r.Form["match[]"]
+	if len(matchers) == 0 {
+		h.badData(w, errors.New("no match[] parameter provided"))
+		return
+	}
+
+	res, err := h.srv.Series(r.Context(), matchers)
+	if err != nil {
+		h.queryError(w, err)
+		return
+	}
+
+	h.success(w, res)
+}
+
+// Labels имена меток /api/v1/labels.
+func (h *PromAPIHandler) Labels(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.badData(w, err)
+		return
+	}
+
+	res, err := h.srv.LabelNames(r.Context(), r.Form["match[]"])
+	if err != nil {
+		h.queryError(w, err)
+		return
+	}
+
+	h.success(w, res)
+}
+
+// LabelValues значения метки /api/v1/label/{name}/values.
+func (h *PromAPIHandler) LabelValues(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.badData(w, err)
+		return
+	}
+
+	res, err := h.srv.LabelValues(r.Context(), chi.URLParam(r, "name"), r.Form["match[]"])
+	if err != nil {
+		h.queryError(w, err)
+		return
+	}
+
+	h.success(w, res)
+}
+
+func resultData(res *promql.Result) *promData {
+	data := &promData{ResultType: res.Type}
+	switch res.Type {
+	case promql.ResultScalar:
+		data.Result = promSample(*res.Scalar)
+	case promql.ResultVector:
+		out := make([]promSeries, 0, len(res.Series))
+		for _, s := range res.Series {
+			v := promSample(s.Samples[len(s.Samples)-1])
+			out = append(out, promSeries{Metric: s.Labels, Value: &v})
+		}
+		data.Result = out
+	default:
+		out := make([]promSeries, 0, len(res.Series))
+		for _, s := range res.Series {
+			values := make([]promSample, 0, len(s.Samples))
+			for _, p := range s.Samples {
+				values = append(values, promSample(p))
+			}
+			out = append(out, promSeries{Metric: s.Labels, Values: values})
+		}
+		data.Result = out
+	}
+
+	return data
+}
+
+// parseTime время как unix секунды с дробной частью или RFC3339.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1000))*int64(time.Millisecond)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("cannot parse " + strconv.Quote(s) + " to a valid timestamp")
+}
+
+// parseStep шаг как секунды или длительность Prometheus: 15s, 1m.
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("missing step")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		d := time.Duration(f * float64(time.Second))
+		if d <= 0 {
+			return 0, errors.New("zero or negative step is not accepted")
+		}
+		return d, nil
+	}
+	return promql.ParseDuration(s)
+}
+
+func (h *PromAPIHandler) success(w http.ResponseWriter, data any) {
+	h.write(w, http.StatusOK, &promResponse{Status: "success", Data: data})
+}
+
+func (h *PromAPIHandler) badData(w http.ResponseWriter, err error) {
+	h.write(w, http.StatusBadRequest, &promResponse{Status: "error", ErrorType: "bad_data", Error: err.Error()})
+}
+
+func (h *PromAPIHandler) queryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, promql.ErrBadQuery) {
+		h.badData(w, err)
+		return
+	}
+	h.lg.Error("error while querying", zap.Error(err))
+	h.write(w, http.StatusInternalServerError, &promResponse{Status: "error", ErrorType: "internal", Error: "Что-то пошло не так"})
+}
+
+func (h *PromAPIHandler) write(w http.ResponseWriter, status int, resp *promResponse) {
+	res, err := json.Marshal(resp)
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
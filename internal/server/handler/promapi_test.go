@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromAPI(t *testing.T) {
+	ctx := context.Background()
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithPublisher(hist))
+	require.NoError(t, srv.Update(ctx, gaugeJSON("Alloc", 1.5)))
+	require.NoError(t, srv.Update(ctx, gaugeJSON("HeapAlloc", 2)))
+	h := NewPromAPIHandler(ctx, promql.NewEngine(ctx, srv, hist))
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/query", h.Query)
+	router.Post("/api/v1/query", h.Query)
+	router.Get("/api/v1/query_range", h.QueryRange)
+	router.Get("/api/v1/series", h.Series)
+	router.Get("/api/v1/labels", h.Labels)
+	router.Get("/api/v1/label/{name}/values", h.LabelValues)
+
+	now := float64(time.Now().Unix() + 1)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   url.Values
+		status int
+		want   string
+	}{
+		{
+			name:   "Instant query",
+			method: http.MethodGet,
+			target: "/api/v1/query?query=Alloc",
+			status: http.StatusOK,
+			want:   `"resultType":"vector","result":[{"metric":{"__name__":"Alloc"},"value":[`,
+		},
+		{
+			name:   "Instant query in form",
+			method: http.MethodPost,
+			target: "/api/v1/query",
+			body:   url.Values{"query": {`{__name__=~".*Alloc"}`}},
+			status: http.StatusOK,
+			want:   `"metric":{"__name__":"HeapAlloc"},"value":[`,
+		},
+		{
+			name:   "Range query",
+			method: http.MethodGet,
+			target: "/api/v1/query_range?query=Alloc&start=" + strconv.FormatFloat(now, 'f', -1, 64) + "&end=" + strconv.FormatFloat(now+30, 'f', -1, 64) + "&step=15s",
+			status: http.StatusOK,
+			want:   `"resultType":"matrix","result":[{"metric":{"__name__":"Alloc"},"values":[[`,
+		},
+		{
+			name:   "Expression",
+			method: http.MethodGet,
+			target: "/api/v1/query?query=" + url.QueryEscape("sum(HeapAlloc + Alloc * 2)"),
+			status: http.StatusOK,
+			want:   `"result":[{"metric":{},"value":[`,
+		},
+		{
+			name:   "Scalar",
+			method: http.MethodGet,
+			target: "/api/v1/query?query=" + url.QueryEscape("1 + 1") + "&time=1700000000.5",
+			status: http.StatusOK,
+			want:   `{"status":"success","data":{"resultType":"scalar","result":[1700000000.5,"2"]}}`,
+		},
+		{
+			name:   "Bad query",
+			method: http.MethodGet,
+			target: "/api/v1/query?query=Alloc{",
+			status: http.StatusBadRequest,
+			want:   `"status":"error","errorType":"bad_data"`,
+		},
+		{
+			name:   "Range without step",
+			method: http.MethodGet,
+			target: "/api/v1/query_range?query=Alloc&start=1&end=2",
+			status: http.StatusBadRequest,
+			want:   `invalid parameter \"step\"`,
+		},
+		{
+			name:   "Series",
+			method: http.MethodGet,
+			target: "/api/v1/series?match[]=HeapAlloc",
+			status: http.StatusOK,
+			want:   `{"status":"success","data":[{"__name__":"HeapAlloc"}]}`,
+		},
+		{
+			name:   "Series without match",
+			method: http.MethodGet,
+			target: "/api/v1/series",
+			status: http.StatusBadRequest,
+			want:   `no match[] parameter provided`,
+		},
+		{
+			name:   "Labels",
+			method: http.MethodGet,
+			target: "/api/v1/labels",
+			status: http.StatusOK,
+			want:   `{"status":"success","data":["__name__"]}`,
+		},
+		{
+			name:   "Label values",
+			method: http.MethodGet,
+			target: "/api/v1/label/__name__/values",
+			status: http.StatusOK,
+			want:   `{"status":"success","data":["Alloc","HeapAlloc"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.body != nil {
+				req = httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, tt.target, nil)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-type"))
+			require.True(t, json.Valid(w.Body.Bytes()))
+			require.Contains(t, w.Body.String(), tt.want)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/service/recording"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Recording interface {
+	Rules(ctx context.Context) []*recording.Status
+}
+
+type RecordingHandler struct {
+	srv Recording
+	lg  *zap.Logger
+}
+
+func NewRecordingHandler(ctx context.Context, srv Recording) *RecordingHandler {
+	return &RecordingHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("recording handler"),
+	}
+}
+
+// List отдаёт правила записи тенанта с результатом последнего вычисления.
+func (h *RecordingHandler) List(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.Rules(r.Context()))
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/service/remotewrite"
+	"github.com/go-faster/sdk/zctx"
+	"github.com/golang/snappy"
+	"go.uber.org/zap"
+)
+
+type RemoteWrite interface {
+	Write(ctx context.Context, req *remotewrite.WriteRequest) error
+}
+
+type RemoteWriteHandler struct {
+	srv RemoteWrite
+	// maxBody предел распакованного тела, 0 - без ограничения
+	maxBody int64
+	lg      *zap.Logger
+}
+
+func NewRemoteWriteHandler(ctx context.Context, srv RemoteWrite, maxBody int64) *RemoteWriteHandler {
+	return &RemoteWriteHandler{
+		srv:     srv,
+		maxBody: maxBody,
+		lg:      zctx.From(ctx).Named("remote write handler"),
+	}
+}
+
+// Write принимает Prometheus remote_write: сжатый snappy protobuf WriteRequest.
+// На ошибки разбора и отклонённые метрики отвечаем 4xx, чтобы Prometheus не повторял заведомо плохой запрос,
+// на ошибки записи - 500, такой запрос Prometheus повторит сам.
+func (h *RemoteWriteHandler) Write(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.lg.Error("error while reading body", zap.Error(err))
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+
+	// snappy хранит длину распакованных данных в заголовке, проверяем её до выделения памяти
+	if n, err := snappy.DecodedLen(compressed); err == nil && h.maxBody > 0 && int64(n) > h.maxBody {
+		h.lg.Warn("decompressed body is too large", zap.Int("size", n))
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		h.lg.Error("error while decompressing", zap.Error(err))
+		http.Error(w, "cannot decompress snappy body", http.StatusBadRequest)
+		return
+	}
+
+	req := &remotewrite.WriteRequest{}
+	if err = req.Unmarshal(body); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "cannot decode write request", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.srv.Write(r.Context(), req); err != nil {
+		// повтор не поможет, отвечаем 4xx, чтобы Prometheus отбросил запрос, а не блокировал шард
+		if rejectUpdate(w, h.lg, err) {
+			return
+		}
+		h.lg.Error("error while writing metrics", zap.Error(err))
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/remotewrite"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRequest(name string, v float64) []byte {
+	req := &remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{{
+		Labels:  []remotewrite.Label{{Name: "__name__", Value: name}},
+		Samples: []remotewrite.Sample{{Value: v, Timestamp: 1}},
+	}}}
+	return snappy.Encode(nil, req.Marshal())
+}
+
+func TestRemoteWrite(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyUpdates{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithStrictNames(true))}
+	h := NewRemoteWriteHandler(ctx, remotewrite.NewReceiver(ctx, srv), 1<<20)
+	v := 1.0
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "temperature_total", MType: domain.Gauge, Value: &v}))
+
+	tests := []struct {
+		name   string
+		body   []byte
+		fail   bool
+		status int
+	}{
+		{
+			name:   "Positive",
+			body:   writeRequest("temperature", 21),
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "Not snappy",
+			body:   []byte("garbage"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Not protobuf",
+			body:   snappy.Encode(nil, []byte{0x0a, 0xff}),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Bad name",
+			body:   writeRequest("bad name", 1),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Type conflict",
+			body:   writeRequest("temperature_total", 1),
+			status: http.StatusConflict,
+		},
+		{
+			name:   "Storage error is retried",
+			body:   writeRequest("temperature", 22),
+			fail:   true,
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.fail = tt.fail
+			rec := httptest.NewRecorder()
+			h.Write(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body)))
+			require.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+// fullLimiter лимит серий, который уже исчерпан.
+type fullLimiter struct{}
+
+func (fullLimiter) Admit(ctx context.Context, metrics []*domain.Metrics) error {
+	return fmt.Errorf("%w: no room for %d series", domain.ErrSeriesLimit, len(metrics))
+}
+
+func (fullLimiter) Forget(ctx context.Context, keys []string) {}
+
+func TestRemoteWriteSeriesLimit(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithSeriesLimiter(fullLimiter{}))
+
+	// лимит серий отклоняется так же, как на /updates/
+	rec := httptest.NewRecorder()
+	NewRemoteWriteHandler(ctx, remotewrite.NewReceiver(ctx, srv), 1<<20).
+		Write(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(writeRequest("temperature", 21))))
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewMetricsHandler(ctx, "../templates/*.html", srv, nil).
+		Updates(rec, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader([]byte(`[{"id":"temperature","type":"gauge","value":21}]`))))
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/service/notify"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Silences interface {
+	Silences(ctx context.Context) []*notify.Silence
+	AddSilence(ctx context.Context, s *notify.Silence) (*notify.Silence, error)
+	DeleteSilence(ctx context.Context, id string) error
+}
+
+// SilencesHandler API тишин, подавляющих уведомления по алертам тенанта.
+type SilencesHandler struct {
+	srv Silences
+	lg  *zap.Logger
+}
+
+func NewSilencesHandler(ctx context.Context, srv Silences) *SilencesHandler {
+	return &SilencesHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("silences handler"),
+	}
+}
+
+func (h *SilencesHandler) List(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.Silences(r.Context()))
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Create создаёт тишину и отдаёт её с присвоенным id.
+func (h *SilencesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	silence := notify.Silence{}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "bad silence", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.srv.AddSilence(r.Context(), &silence)
+	if err != nil {
+		if errors.Is(err, notify.ErrBadSilence) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.lg.Error("error while saving silence", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(created)
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+func (h *SilencesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := h.srv.DeleteSilence(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, notify.ErrSilenceNotFound) {
+			http.Error(w, "No silence with this id", http.StatusNotFound)
+			return
+		}
+		h.lg.Error("error while deleting silence", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/service/stream"
+	"github.com/AA122AA/metring/internal/server/websocket"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// keepAlive как часто слать пустое сообщение, чтобы прокси не закрывали простаивающий стрим.
+const keepAlive = 15 * time.Second
+
+type Stream interface {
+	Subscribe(ctx context.Context, match []string) (*stream.Subscription, error)
+	Unsubscribe(s *stream.Subscription)
+}
+
+// StreamHandler отдаёт принятые обновления метрик по SSE и WebSocket.
+// Стримы закрываются вместе с контекстом сервера.
+type StreamHandler struct {
+	srv Stream
+	// origins источники, с которых разрешён WebSocket кроме своего хоста
+	origins []string
+	done    <-chan struct{}
+	lg      *zap.Logger
+}
+
+func NewStreamHandler(ctx context.Context, srv Stream, origins []string) *StreamHandler {
+	return &StreamHandler{
+		srv:     srv,
+		origins: origins,
+		done:    ctx.Done(),
+		lg:      zctx.From(ctx).Named("stream handler"),
+	}
+}
+
+// allowOrigin защищает WebSocket от чужих страниц: браузер не применяет к нему CORS,
+// поэтому Origin проверяется здесь. Без Origin приходят не браузерные клиенты.
+func (h *StreamHandler) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range h.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscribe подписывает на имена из ?match=, при ошибке отвечает сам.
+func (h *StreamHandler) subscribe(w http.ResponseWriter, r *http.Request) (*stream.Subscription, bool) {
+	sub, err := h.srv.Subscribe(r.Context(), r.URL.Query()["match"])
+	if err != nil {
+		switch {
+		case errors.Is(err, stream.ErrBadMatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, stream.ErrTooManyStreams):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			h.lg.Error("error while subscribing", zap.Error(err))
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return sub, true
+}
+
+// SSE стрим обновлений в формате Server-Sent Events: каждое обновление - событие update
+// с JSON серии. Медленный клиент получает событие dropped и отключается.
+func (h *StreamHandler) SSE(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer h.srv.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.lg.Error("streaming is not supported", zap.Error(err))
+		return
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-h.done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case ev, ok := <-sub.Events():
+			if !ok {
+				if sub.Dropped() {
+					fmt.Fprint(w, "event: dropped\ndata: slow consumer\n\n")
+					_ = rc.Flush()
+				}
+				return
+			}
+			_, err = fmt.Fprintf(w, "event: update\ndata: %s\n\n", ev.Data)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			h.lg.Debug("stream client is gone", zap.Error(err))
+			return
+		}
+	}
+}
+
+// WebSocket тот же стрим по WebSocket: каждое обновление - текстовое сообщение с JSON серии.
+// Медленный клиент отключается с кодом 1008, страница с чужого Origin получает 403.
+func (h *StreamHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	if !h.allowOrigin(r) {
+		h.lg.Warn("websocket origin rejected", zap.String("origin", r.Header.Get("Origin")))
+		http.Error(w, "origin is not allowed", http.StatusForbidden)
+		return
+	}
+	sub, ok := h.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer h.srv.Unsubscribe(sub)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		h.lg.Debug("websocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.ReadLoop()
+	}()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			_ = conn.WriteClose(websocket.CloseGoingAway, "server shutdown")
+			return
+		case err := <-closed:
+			h.lg.Debug("websocket client is gone", zap.Error(err))
+			return
+		case <-ticker.C:
+			err = conn.Ping()
+		case ev, ok := <-sub.Events():
+			if !ok {
+				if sub.Dropped() {
+					_ = conn.WriteClose(websocket.ClosePolicy, "slow consumer")
+				}
+				return
+			}
+			err = conn.WriteText(ev.Data)
+		}
+		if err != nil {
+			h.lg.Debug("websocket write failed", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/stream"
+	"github.com/stretchr/testify/require"
+)
+
+func newStreamServer(t *testing.T) (*httptest.Server, *metrics.Metrics) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := stream.NewHub(ctx, stream.Config{Buffer: 10})
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithPublisher(hub))
+	h := NewStreamHandler(ctx, hub, []string{"https://dash.example.com"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/stream", h.SSE)
+	mux.HandleFunc("/api/v1/stream/ws", h.WebSocket)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+	})
+
+	return ts, srv
+}
+
+func gaugeJSON(name string, v float64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{ID: name, MType: domain.Gauge, Value: &v}
+}
+
+func TestStreamSSE(t *testing.T) {
+	ctx := context.Background()
+	ts, srv := newStreamServer(t)
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream?match=Heap*")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.NoError(t, srv.Update(ctx, gaugeJSON("Alloc", 1)))
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{gaugeJSON("HeapAlloc", 42)}))
+
+	rd := bufio.NewReader(resp.Body)
+	line, err := rd.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: update\n", line)
+	line, err = rd.ReadString('\n')
+	require.NoError(t, err)
+
+	var ev map[string]any
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+	require.Equal(t, "HeapAlloc", ev["id"])
+	require.Equal(t, 42.0, ev["value"])
+	require.NotEmpty(t, ev["timestamp"])
+
+	bad, err := http.Get(ts.URL + "/api/v1/stream?match=[a")
+	require.NoError(t, err)
+	bad.Body.Close()
+	require.Equal(t, http.StatusBadRequest, bad.StatusCode)
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	ctx := context.Background()
+	ts, srv := newStreamServer(t)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET /api/v1/stream/ws?match=PollCount HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	require.NoError(t, err)
+
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	for range 2 {
+		delta := int64(5)
+		require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "PollCount", MType: domain.Counter, Delta: &delta}))
+	}
+
+	for _, want := range []float64{5, 10} {
+		op, payload := readFrame(t, rd)
+		require.Equal(t, byte(0x1), op)
+		var ev map[string]any
+		require.NoError(t, json.Unmarshal(payload, &ev))
+		require.Equal(t, "PollCount", ev["id"])
+		require.Equal(t, want, ev["delta"])
+	}
+
+	// клиент закрывает соединение, сервер отвечает кадром закрытия
+	mask := []byte{1, 2, 3, 4}
+	code := binary.BigEndian.AppendUint16(nil, 1000)
+	for i := range code {
+		code[i] ^= mask[i%4]
+	}
+	_, err = conn.Write(append(append([]byte{0x88, 0x80 | 2}, mask...), code...))
+	require.NoError(t, err)
+	op, payload := readFrame(t, rd)
+	require.Equal(t, byte(0x8), op)
+	require.Equal(t, uint16(1000), binary.BigEndian.Uint16(payload))
+}
+
+func TestStreamOrigin(t *testing.T) {
+	ts, _ := newStreamServer(t)
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	cases := []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{name: "No origin", status: http.StatusSwitchingProtocols},
+		{name: "Same host", origin: "http://" + host, status: http.StatusSwitchingProtocols},
+		{name: "Allowed", origin: "https://dash.example.com", status: http.StatusSwitchingProtocols},
+		{name: "Foreign", origin: "https://evil.example.com", status: http.StatusForbidden},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", host)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			req := "GET /api/v1/stream/ws HTTP/1.1\r\n" +
+				"Host: " + host + "\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+			if tCase.origin != "" {
+				req += "Origin: " + tCase.origin + "\r\n"
+			}
+			_, err = io.WriteString(conn, req+"\r\n")
+			require.NoError(t, err)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tCase.status, resp.StatusCode)
+		})
+	}
+}
+
+// readFrame читает незамаскированный кадр сервера.
+func readFrame(t *testing.T, rd *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	head := make([]byte, 2)
+	_, err := io.ReadFull(rd, head)
+	require.NoError(t, err)
+	n := int(head[1] & 0x7F)
+	if n == 126 {
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(rd, ext)
+		require.NoError(t, err)
+		n = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(rd, payload)
+	require.NoError(t, err)
+
+	return head[0] & 0x0F, payload
+}
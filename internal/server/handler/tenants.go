@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/AA122AA/metring/internal/server/service/tenants"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+type Tenants interface {
+	List() []*tenants.Tenant
+	Put(t *tenants.Tenant) error
+	Delete(id string) error
+}
+
+// TenantsHandler админский API управления тенантами.
+type TenantsHandler struct {
+	srv Tenants
+	lg  *zap.Logger
+}
+
+func NewTenantsHandler(ctx context.Context, srv Tenants) *TenantsHandler {
+	return &TenantsHandler{
+		srv: srv,
+		lg:  zctx.From(ctx).Named("tenants handler"),
+	}
+}
+
+func (h *TenantsHandler) List(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.srv.List())
+	if err != nil {
+		h.lg.Error("error while marshaling", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Put создаёт тенанта или заменяет его ключи, id берётся из пути.
+func (h *TenantsHandler) Put(w http.ResponseWriter, r *http.Request) {
+	tenant := tenants.Tenant{}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+		h.lg.Error("error while decoding", zap.Error(err))
+		http.Error(w, "bad tenant", http.StatusBadRequest)
+		return
+	}
+	tenant.ID = chi.URLParam(r, "id")
+
+	if err := h.srv.Put(&tenant); err != nil {
+		switch {
+		case errors.Is(err, tenants.ErrInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, tenants.ErrKeyInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			h.lg.Error("error while saving tenant", zap.Error(err))
+			http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TenantsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := h.srv.Delete(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, tenants.ErrNotFound) {
+			http.Error(w, "No tenant with this id", http.StatusNotFound)
+			return
+		}
+		h.lg.Error("error while deleting tenant", zap.Error(err))
+		http.Error(w, "Что-то пошло не так", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
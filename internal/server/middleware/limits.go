@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+type Limiter interface {
+	Allow(client string) (bool, time.Duration)
+}
+
+// WithBodyLimit вычитывает тело не больше max байт, на большее отвечает 413.
+// Ставится ближе к хэндлеру, чем WithCompression, чтобы считать уже распакованные байты.
+func WithBodyLimit(max int64) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if max <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Header.Get("Content-Encoding") == "" && r.ContentLength > max {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "cannot read body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > max {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithRateLimit ограничивает частоту запросов клиента. Клиент - тенант из контекста,
+// если тенанты включены, иначе IP адрес: непроверенный ключ из заголовка ключом
+// лимита быть не может, иначе каждый новый ключ получал бы полный запас запросов.
+// На превышение отвечает 429 с Retry-After. Ставится после WithTenant.
+func WithRateLimit(l Limiter, tenants TenantResolver) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := l.Allow(client(r, tenants))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func client(r *http.Request, tenants TenantResolver) string {
+	if tenants.Enabled() {
+		return "tenant:" + domain.TenantFromContext(r.Context())
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
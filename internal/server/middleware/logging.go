@@ -28,6 +28,11 @@ func (lr *loggingResponseWriter) WriteHeader(statusCode int) {
 	lr.lResp.statusCode = statusCode
 }
 
+// Unwrap нужен http.ResponseController, чтобы добраться до Flush и Hijack исходного writer.
+func (lr *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lr.ResponseWriter
+}
+
 func WithLogger(lg *zap.Logger) Middleware {
 	return func(next http.Handler) http.HandlerFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -7,3 +7,10 @@ type Middleware func(next http.Handler) http.HandlerFunc
 func Wrap(h http.Handler, mw Middleware) http.HandlerFunc {
 	return mw(h)
 }
+
+// Handler приводит Middleware к виду, который принимают chi.Use и chi.With.
+func Handler(mw Middleware) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return mw(next)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+type TenantResolver interface {
+	Enabled() bool
+	Resolve(key string) (string, bool)
+}
+
+// WithTenant определяет тенанта по ключу из заголовка Authorization: Bearer <key>
+// и кладёт его в контекст запроса. Пока тенанты не настроены, запросы идут в тенант по умолчанию.
+func WithTenant(tenants TenantResolver) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tenants.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tenant, ok := tenants.Resolve(bearer(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unknown api key", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(domain.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// WithAdminKey пускает только запросы с админским ключом. Без ключа админский API выключен.
+func WithAdminKey(key string) Middleware {
+	return func(next http.Handler) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" {
+				http.Error(w, "admin api is disabled", http.StatusForbidden)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(bearer(r)), []byte(key)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "bad admin key", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bearer(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
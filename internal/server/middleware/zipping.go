@@ -24,7 +24,7 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
-	// проверяем, что контент ответа соответствует `application/json` и `text/html`.
+	// проверяем, что тип контента ответа из списка compressible.
 	if isCompressible(c.w) {
 		return c.zw.Write(p)
 	}
@@ -43,9 +43,17 @@ func (c *compressWriter) Close() error {
 	return c.zw.Close()
 }
 
+// compressible типы ответов, которые сжимаются.
+var compressible = []string{"application/json", "text/html", "text/csv", "application/x-ndjson"}
+
 func isCompressible(w http.ResponseWriter) bool {
 	dataType := w.Header().Get("Content-type")
-	return strings.Contains(dataType, "application/json") || strings.Contains(dataType, "text/html")
+	for _, t := range compressible {
+		if strings.Contains(dataType, t) {
+			return true
+		}
+	}
+	return false
 }
 
 // compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
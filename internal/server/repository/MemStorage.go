@@ -2,58 +2,186 @@ package repository
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/domain"
 )
 
+// MemStorage хранит метрики в памяти. Values и Metadata - данные тенанта по умолчанию,
+// остальные тенанты (domain.TenantFromContext) хранятся отдельно.
 type MemStorage struct {
-	Values map[string]*domain.Metrics
+	mu       sync.RWMutex
+	Values   map[string]*domain.Metrics
+	Metadata map[string]*domain.Metadata
+
+	tenantValues   map[string]map[string]*domain.Metrics
+	tenantMetadata map[string]map[string]*domain.Metadata
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		Values: make(map[string]*domain.Metrics),
+		Values:         make(map[string]*domain.Metrics),
+		Metadata:       make(map[string]*domain.Metadata),
+		tenantValues:   make(map[string]map[string]*domain.Metrics),
+		tenantMetadata: make(map[string]map[string]*domain.Metadata),
+	}
+}
+
+// values метрики тенанта из ctx, вызывается под ms.mu. Для записи создаёт недостающую map.
+func (ms *MemStorage) values(ctx context.Context, create bool) map[string]*domain.Metrics {
+	t := domain.TenantFromContext(ctx)
+	if t == domain.DefaultTenant {
+		return ms.Values
+	}
+
+	v, ok := ms.tenantValues[t]
+	if !ok && create {
+		v = make(map[string]*domain.Metrics)
+		ms.tenantValues[t] = v
+	}
+
+	return v
+}
+
+// metadata метаданные тенанта из ctx, вызывается под ms.mu.
+func (ms *MemStorage) metadata(ctx context.Context, create bool) map[string]*domain.Metadata {
+	t := domain.TenantFromContext(ctx)
+	if t == domain.DefaultTenant {
+		return ms.Metadata
+	}
+
+	v, ok := ms.tenantMetadata[t]
+	if !ok && create {
+		v = make(map[string]*domain.Metadata)
+		ms.tenantMetadata[t] = v
 	}
+
+	return v
 }
 
 func (ms *MemStorage) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
-	if len(ms.Values) != 0 {
-		return ms.Values, nil
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if values := ms.values(ctx, false); len(values) != 0 {
+		return maps.Clone(values), nil
 	}
 	// return nil, fmt.Errorf("no metrics")
 	return nil, NewEmptyRepoError(nil)
 }
 
-func (ms *MemStorage) Get(ctx context.Context, name string) (*domain.Metrics, error) {
-	if v, ok := ms.Values[name]; ok {
+func (ms *MemStorage) Get(ctx context.Context, key string) (*domain.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if v, ok := ms.values(ctx, false)[key]; ok {
 		return v, nil
 	}
 
 	return nil, NewEmptyRepoError(nil)
 }
 
-func (ms *MemStorage) Write(ctx context.Context, name string, value *domain.Metrics) error {
-	ms.Values[name] = value
+func (ms *MemStorage) Write(ctx context.Context, key string, value *domain.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.values(ctx, true)[key] = value
 	return nil
 }
 
 func (ms *MemStorage) WriteMetrics(ctx context.Context, values []*domain.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.values(ctx, true)
 	for _, v := range values {
-		ms.Values[v.ID] = v
+		stored[v.Key()] = v
 	}
 
 	return nil
 }
 
 func (ms *MemStorage) Update(ctx context.Context, value *domain.Metrics) error {
-	ms.Values[value.ID] = value
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.values(ctx, true)[value.Key()] = value
 	return nil
 }
 
 func (ms *MemStorage) UpdateMetrics(ctx context.Context, values []*domain.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.values(ctx, true)
 	for _, v := range values {
-		ms.Values[v.ID] = v
+		stored[v.Key()] = v
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) Delete(ctx context.Context, keys []string, before time.Time) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.values(ctx, false)
+	deleted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, ok := stored[k]
+		if !ok || !v.UpdatedAt.Before(before) {
+			continue
+		}
+		delete(stored, k)
+		deleted = append(deleted, k)
+	}
+
+	return deleted, nil
+}
+
+func (ms *MemStorage) Tenants(ctx context.Context) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	tenants := make([]string, 0, len(ms.tenantValues)+1)
+	if len(ms.Values) > 0 {
+		tenants = append(tenants, domain.DefaultTenant)
+	}
+	for t, v := range ms.tenantValues {
+		if len(v) > 0 {
+			tenants = append(tenants, t)
+		}
+	}
+	slices.Sort(tenants)
+
+	return tenants, nil
+}
+
+func (ms *MemStorage) GetAllMetadata(ctx context.Context) (map[string]*domain.Metadata, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return maps.Clone(ms.metadata(ctx, false)), nil
+}
+
+func (ms *MemStorage) GetMetadata(ctx context.Context, name string) (*domain.Metadata, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if v, ok := ms.metadata(ctx, false)[name]; ok {
+		return v, nil
 	}
 
+	return nil, NewEmptyRepoError(nil)
+}
+
+func (ms *MemStorage) WriteMetadata(ctx context.Context, meta *domain.Metadata) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.metadata(ctx, true)[meta.Name] = meta
 	return nil
 }
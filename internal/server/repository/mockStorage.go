@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/domain"
 )
@@ -82,3 +83,11 @@ func (mr *mockRepo) Update(ctx context.Context, value *domain.Metrics) error {
 func (mr *mockRepo) UpdateMetrics(ctx context.Context, value []*domain.Metrics) error {
 	return nil
 }
+
+func (mr *mockRepo) Delete(ctx context.Context, keys []string, before time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (mr *mockRepo) Tenants(ctx context.Context) ([]string, error) {
+	return []string{domain.DefaultTenant}, nil
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -42,7 +43,7 @@ func (ps *PSQLStorage) getAllWithRetry(ctx context.Context) ([]query.Metric, err
 		case <-ctx.Done():
 			return nil, nil
 		case <-timer.C:
-			metrics, err := ps.queries.GetAll(ctx)
+			metrics, err := ps.queries.GetAll(ctx, domain.TenantFromContext(ctx))
 			if err == nil {
 				return metrics, nil
 			}
@@ -70,7 +71,7 @@ func (ps *PSQLStorage) getAllWithRetry(ctx context.Context) ([]query.Metric, err
 
 func (ps *PSQLStorage) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
 	metrics, err := ps.getAllWithRetry(ctx)
-	// metrics, err := ps.queries.GetAll(ctx)
+	// metrics, err := ps.queries.GetAll(ctx, domain.TenantFromContext(ctx))
 	if err != nil {
 		ps.lg.Error("cannot get all metrics", zap.Error(err))
 		// return nil, fmt.Errorf("cannot get all metrics: %w", err)
@@ -79,23 +80,30 @@ func (ps *PSQLStorage) GetAll(ctx context.Context) (map[string]*domain.Metrics,
 	mm := make(map[string]*domain.Metrics)
 
 	for _, m := range metrics {
-		mm[m.Name] = domain.DBToDomain(&m)
+		metric, err := domain.DBToDomain(&m)
+		if err != nil {
+			return nil, err
+		}
+		mm[m.Series] = metric
 	}
 
 	return mm, nil
 }
 
-func (ps *PSQLStorage) Get(ctx context.Context, name string) (*domain.Metrics, error) {
-	metric, err := ps.queries.Get(ctx, name)
+func (ps *PSQLStorage) Get(ctx context.Context, key string) (*domain.Metrics, error) {
+	metric, err := ps.queries.Get(ctx, query.GetParams{
+		Tenant: domain.TenantFromContext(ctx),
+		Series: key,
+	})
 	if err != nil {
 		return nil, NewEmptyRepoError(err)
 	}
 
-	return domain.DBToDomain(&metric), nil
+	return domain.DBToDomain(&metric)
 }
 
 func (ps *PSQLStorage) Update(ctx context.Context, value *domain.Metrics) error {
-	err := ps.queries.Update(ctx, *parseUpdate(value))
+	err := ps.queries.Update(ctx, *parseUpdate(ctx, value))
 	if err != nil {
 		return fmt.Errorf("cannot update metric %v: %w", value.ID, err)
 	}
@@ -113,7 +121,7 @@ func (ps *PSQLStorage) UpdateMetrics(ctx context.Context, values []*domain.Metri
 
 	q := ps.queries.WithTx(tx)
 	for _, metric := range values {
-		err := q.Update(ctx, *parseUpdate(metric))
+		err := q.Update(ctx, *parseUpdate(ctx, metric))
 		if err != nil {
 			ps.lg.Error("cannot update metric", zap.String("metric name", metric.ID), zap.Error(err))
 			return fmt.Errorf("cannot update metric %v: %w", metric.ID, err)
@@ -123,8 +131,10 @@ func (ps *PSQLStorage) UpdateMetrics(ctx context.Context, values []*domain.Metri
 	return tx.Commit(ctx)
 }
 
+// Write добавляет серию. Если серию уже записал параллельный запрос,
+// она перезаписывается (ON CONFLICT по tenant и series), а не дублируется.
 func (ps *PSQLStorage) Write(ctx context.Context, name string, value *domain.Metrics) error {
-	err := ps.queries.Write(ctx, *parseWrite(value))
+	err := ps.queries.Write(ctx, *parseWrite(ctx, value))
 	if err != nil {
 		ps.lg.Error("cannot write metric", zap.String("metric name", name), zap.Error(err))
 		return fmt.Errorf("cannot write metric %v: %w", name, err)
@@ -143,7 +153,7 @@ func (ps *PSQLStorage) WriteMetrics(ctx context.Context, values []*domain.Metric
 
 	q := ps.queries.WithTx(tx)
 	for _, metric := range values {
-		err := q.Write(ctx, *parseWrite(metric))
+		err := q.Write(ctx, *parseWrite(ctx, metric))
 		if err != nil {
 			ps.lg.Error("cannot write metric", zap.String("metric name", metric.ID), zap.Error(err))
 			return fmt.Errorf("cannot write metric %v: %w", metric.ID, err)
@@ -153,9 +163,78 @@ func (ps *PSQLStorage) WriteMetrics(ctx context.Context, values []*domain.Metric
 	return tx.Commit(ctx)
 }
 
-func parseUpdate(value *domain.Metrics) *query.UpdateParams {
+func (ps *PSQLStorage) Delete(ctx context.Context, keys []string, before time.Time) ([]string, error) {
+	deleted, err := ps.queries.Delete(ctx, query.DeleteParams{
+		Tenant: domain.TenantFromContext(ctx),
+		Series: keys,
+		Before: pgtype.Timestamptz{Time: before, Valid: true},
+	})
+	if err != nil {
+		ps.lg.Error("cannot delete metrics", zap.Int("count", len(keys)), zap.Error(err))
+		return nil, fmt.Errorf("cannot delete metrics: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (ps *PSQLStorage) Tenants(ctx context.Context) ([]string, error) {
+	tenants, err := ps.queries.Tenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get tenants: %w", err)
+	}
+
+	return tenants, nil
+}
+
+func (ps *PSQLStorage) GetAllMetadata(ctx context.Context) (map[string]*domain.Metadata, error) {
+	rows, err := ps.queries.GetAllMetadata(ctx, domain.TenantFromContext(ctx))
+	if err != nil {
+		ps.lg.Error("cannot get metadata", zap.Error(err))
+		return nil, fmt.Errorf("cannot get metadata: %w", err)
+	}
+
+	res := make(map[string]*domain.Metadata, len(rows))
+	for _, m := range rows {
+		res[m.Name] = domain.DBToMetadata(&m)
+	}
+
+	return res, nil
+}
+
+func (ps *PSQLStorage) GetMetadata(ctx context.Context, name string) (*domain.Metadata, error) {
+	m, err := ps.queries.GetMetadata(ctx, query.GetMetadataParams{
+		Tenant: domain.TenantFromContext(ctx),
+		Name:   name,
+	})
+	if err != nil {
+		return nil, NewEmptyRepoError(err)
+	}
+
+	return domain.DBToMetadata(&m), nil
+}
+
+func (ps *PSQLStorage) WriteMetadata(ctx context.Context, meta *domain.Metadata) error {
+	err := ps.queries.WriteMetadata(ctx, query.WriteMetadataParams{
+		Name:        meta.Name,
+		Type:        meta.Type,
+		Unit:        meta.Unit,
+		Description: meta.Description,
+		Owner:       meta.Owner,
+		Tenant:      domain.TenantFromContext(ctx),
+	})
+	if err != nil {
+		ps.lg.Error("cannot write metadata", zap.String("metric name", meta.Name), zap.Error(err))
+		return fmt.Errorf("cannot write metadata %v: %w", meta.Name, err)
+	}
+
+	return nil
+}
+
+func parseUpdate(ctx context.Context, value *domain.Metrics) *query.UpdateParams {
 	arg := &query.UpdateParams{
-		Name: value.ID,
+		Tenant:    domain.TenantFromContext(ctx),
+		Series:    value.Key(),
+		UpdatedAt: updatedAtToDB(value.UpdatedAt),
 	}
 	switch value.MType {
 	case domain.Counter:
@@ -168,15 +247,21 @@ func parseUpdate(value *domain.Metrics) *query.UpdateParams {
 			Float64: *value.Value,
 			Valid:   true,
 		}
+	case domain.Histogram, domain.Summary, domain.Set, domain.Timer:
+		arg.Data = dataToDB(value)
 	}
 
 	return arg
 }
 
-func parseWrite(value *domain.Metrics) *query.WriteParams {
+func parseWrite(ctx context.Context, value *domain.Metrics) *query.WriteParams {
 	arg := &query.WriteParams{
-		Name: value.ID,
-		Type: value.MType,
+		Tenant:    domain.TenantFromContext(ctx),
+		Name:      value.ID,
+		Type:      value.MType,
+		Labels:    labelsToDB(value.Labels),
+		Series:    value.Key(),
+		UpdatedAt: updatedAtToDB(value.UpdatedAt),
 	}
 	switch value.MType {
 	case domain.Counter:
@@ -189,7 +274,51 @@ func parseWrite(value *domain.Metrics) *query.WriteParams {
 			Float64: *value.Value,
 			Valid:   true,
 		}
+	case domain.Histogram, domain.Summary, domain.Set, domain.Timer:
+		arg.Data = dataToDB(value)
 	}
 
 	return arg
 }
+
+// updatedAtToDB время обновления серии, незаданное считается текущим.
+func updatedAtToDB(t time.Time) pgtype.Timestamptz {
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+func labelsToDB(labels map[string]string) []byte {
+	if len(labels) == 0 {
+		return []byte("{}")
+	}
+	// map[string]string всегда сериализуется без ошибок
+	b, _ := json.Marshal(labels)
+
+	return b
+}
+
+// dataToDB сериализует значение histogram, summary, set или timer в колонку data.
+func dataToDB(value *domain.Metrics) []byte {
+	var (
+		b   []byte
+		err error
+	)
+	switch value.MType {
+	case domain.Histogram:
+		b, err = json.Marshal(value.Histogram)
+	case domain.Summary:
+		b, err = json.Marshal(value.Summary)
+	case domain.Set:
+		b, err = json.Marshal(value.Set)
+	case domain.Timer:
+		b, err = json.Marshal(value.Timer)
+	}
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
@@ -2,15 +2,31 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/domain"
 )
 
+// MetricsRepository хранилище метрик.
+// Метрики хранятся по ключу серии (domain.SeriesKey): имя и отсортированные метки.
+// Все методы работают в пространстве тенанта из контекста (domain.TenantFromContext).
 type MetricsRepository interface {
 	GetAll(ctx context.Context) (map[string]*domain.Metrics, error)
-	Get(ctx context.Context, name string) (*domain.Metrics, error)
-	Write(ctx context.Context, name string, value *domain.Metrics) error
+	Get(ctx context.Context, key string) (*domain.Metrics, error)
+	Write(ctx context.Context, key string, value *domain.Metrics) error
 	WriteMetrics(ctx context.Context, values []*domain.Metrics) error
 	Update(ctx context.Context, value *domain.Metrics) error
 	UpdateMetrics(ctx context.Context, values []*domain.Metrics) error
+	// Delete удаляет серии из keys, не обновлявшиеся с момента before, и возвращает удалённые.
+	// Серия, обновлённая после выборки кандидатов, так не удаляется.
+	Delete(ctx context.Context, keys []string, before time.Time) ([]string, error)
+	// Tenants возвращает тенантов, у которых есть метрики.
+	Tenants(ctx context.Context) ([]string, error)
+}
+
+// MetadataRepository хранилище метаданных метрик по имени в пространстве тенанта из контекста.
+type MetadataRepository interface {
+	GetAllMetadata(ctx context.Context) (map[string]*domain.Metadata, error)
+	GetMetadata(ctx context.Context, name string) (*domain.Metadata, error)
+	WriteMetadata(ctx context.Context, meta *domain.Metadata) error
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/config"
 	"github.com/AA122AA/metring/internal/server/middleware"
@@ -16,17 +17,98 @@ import (
 
 type metricsHandler interface {
 	All(w http.ResponseWriter, r *http.Request)
+	Detail(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
 	GetJSON(w http.ResponseWriter, r *http.Request)
 	Update(w http.ResponseWriter, r *http.Request)
 	UpdateJSON(w http.ResponseWriter, r *http.Request)
 	Updates(w http.ResponseWriter, r *http.Request)
+	List(w http.ResponseWriter, r *http.Request)
+	Exposition(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	DeleteByPrefix(w http.ResponseWriter, r *http.Request)
+}
+
+type metadataHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	Put(w http.ResponseWriter, r *http.Request)
+	Populate(w http.ResponseWriter, r *http.Request)
+}
+
+type tenantsHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Put(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+type alertsHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+}
+
+type exportHandler interface {
+	Export(w http.ResponseWriter, r *http.Request)
+}
+
+type importHandler interface {
+	Import(w http.ResponseWriter, r *http.Request)
+}
+
+type recordingHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+}
+
+type silencesHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+type streamHandler interface {
+	SSE(w http.ResponseWriter, r *http.Request)
+	WebSocket(w http.ResponseWriter, r *http.Request)
+}
+
+type promAPIHandler interface {
+	Query(w http.ResponseWriter, r *http.Request)
+	QueryRange(w http.ResponseWriter, r *http.Request)
+	Series(w http.ResponseWriter, r *http.Request)
+	Labels(w http.ResponseWriter, r *http.Request)
+	LabelValues(w http.ResponseWriter, r *http.Request)
+}
+
+type agentsHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+}
+
+type forwardingHandler interface {
+	Stats(w http.ResponseWriter, r *http.Request)
+}
+
+type federationHandler interface {
+	Snapshot(w http.ResponseWriter, r *http.Request)
+	Receive(w http.ResponseWriter, r *http.Request)
+	Sources(w http.ResponseWriter, r *http.Request)
+}
+
+type cardinalityHandler interface {
+	Top(w http.ResponseWriter, r *http.Request)
 }
 
 type pingHandler interface {
 	Ping(w http.ResponseWriter, r *http.Request)
 }
 
+type otlpHandler interface {
+	Export(w http.ResponseWriter, r *http.Request)
+}
+
+type remoteWriteHandler interface {
+	Write(w http.ResponseWriter, r *http.Request)
+}
+
+const shutdownTimeout = 5 * time.Second
+
 type Server struct {
 	srv *http.Server
 	lg  *zap.Logger
@@ -46,7 +128,10 @@ func (s *Server) OnShutDown(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 	<-ctx.Done()
 	if s.srv != nil {
-		if err := s.srv.Shutdown(ctx); err != nil {
+		// ctx уже отменён, даём открытым запросам и стримам время завершиться
+		sCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+		if err := s.srv.Shutdown(sCtx); err != nil {
 			s.lg.Fatal("failed to shutdown http server", zap.Error(err))
 		}
 		s.lg.Info("shutdown http server")
@@ -66,55 +151,281 @@ func (s *Server) Run(ctx context.Context) error {
 	return s.srv.Serve(listener)
 }
 
-func NewRouter(ctx context.Context, h metricsHandler, p pingHandler) *chi.Mux {
+// Handlers обработчики, которые NewRouter развешивает по маршрутам.
+type Handlers struct {
+	Metrics     metricsHandler
+	Ping        pingHandler
+	OTLP        otlpHandler
+	RemoteWrite remoteWriteHandler
+	Metadata    metadataHandler
+	Agents      agentsHandler
+	Alerts      alertsHandler
+	Recording   recordingHandler
+	Silences    silencesHandler
+	Stream      streamHandler
+	PromAPI     promAPIHandler
+	Export      exportHandler
+	Import      importHandler
+	Tenants     tenantsHandler
+	Cardinality cardinalityHandler
+	Forwarding  forwardingHandler
+	Federation  federationHandler
+}
+
+// RouterOptions доступ и лимиты, общие для маршрутов.
+type RouterOptions struct {
+	// Tenants определяет тенанта запроса по API ключу
+	Tenants middleware.TenantResolver
+	// AdminKey ключ админского API
+	AdminKey string
+	// Limiter ограничивает частоту приёма метрик для каждого клиента
+	Limiter middleware.Limiter
+	// MaxBody предел размера тела запросов на приём
+	MaxBody int64
+}
+
+func NewRouter(ctx context.Context, hs Handlers, opts RouterOptions) *chi.Mux {
 	router := chi.NewRouter()
-	router.Get("/", middleware.Wrap(
+	// всё, кроме ping и админского API, работает в пространстве тенанта из API ключа
+	tenant := router.With(middleware.Handler(middleware.WithTenant(opts.Tenants)))
+	// приём метрик ограничен по частоте для каждого клиента
+	ingest := tenant.With(middleware.Handler(middleware.WithRateLimit(opts.Limiter, opts.Tenants)))
+	bodyLimit := middleware.WithBodyLimit(opts.MaxBody)
+	tenant.Get("/", middleware.Wrap(
 		middleware.Wrap(
-			http.HandlerFunc(h.All),
+			http.HandlerFunc(hs.Metrics.All),
 			middleware.WithLogger(zctx.From(ctx).Named("GetAll"))),
 		middleware.WithCompression()),
 	)
+	tenant.Get("/metric/{mType}/{mName}", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Metrics.Detail),
+			middleware.WithLogger(zctx.From(ctx).Named("MetricDetail"))),
+		middleware.WithCompression()),
+	)
 	router.Get("/ping", middleware.Wrap(
-		http.HandlerFunc(p.Ping),
+		http.HandlerFunc(hs.Ping.Ping),
 		middleware.WithLogger(zctx.From(ctx).Named("Ping"))),
 	)
-	router.Route("/value/", func(r chi.Router) {
+	tenant.Route("/value/", func(r chi.Router) {
 		r.Post("/", middleware.Wrap(
 			middleware.Wrap(
-				http.HandlerFunc(h.GetJSON),
+				http.HandlerFunc(hs.Metrics.GetJSON),
 				middleware.WithLogger(zctx.From(ctx).Named("GetValueJSON"))),
 			middleware.WithCompression()),
 		)
 		r.Get("/{mType}/{mName}", middleware.Wrap(
 			middleware.Wrap(
-				http.HandlerFunc(h.Get),
+				http.HandlerFunc(hs.Metrics.Get),
 				middleware.WithLogger(zctx.From(ctx).Named("GetValue"))),
 			middleware.WithCompression()),
 		)
+		r.Delete("/{mType}/{mName}", middleware.Wrap(
+			http.HandlerFunc(hs.Metrics.Delete),
+			middleware.WithLogger(zctx.From(ctx).Named("DeleteValue"))),
+		)
 	})
-	router.Route("/update", func(r chi.Router) {
+	ingest.Route("/update", func(r chi.Router) {
 		r.Post("/", middleware.Wrap(
 			middleware.Wrap(
-				http.HandlerFunc(h.UpdateJSON),
+				middleware.Wrap(http.HandlerFunc(hs.Metrics.UpdateJSON), bodyLimit),
 				middleware.WithLogger(zctx.From(ctx).Named("UpdateValueJSON"))),
 			middleware.WithCompression()),
 		)
 		r.Post("/{mType}/{mName}/{value}", middleware.Wrap(
 			middleware.Wrap(
-				http.HandlerFunc(h.Update),
+				http.HandlerFunc(hs.Metrics.Update),
 				middleware.WithLogger(zctx.From(ctx).Named("UpdateValue"))),
 			middleware.WithCompression()),
 		)
 	})
 
-	router.Route("/updates", func(r chi.Router) {
+	ingest.Route("/updates", func(r chi.Router) {
 		r.Post("/", middleware.Wrap(
 			middleware.Wrap(
-				http.HandlerFunc(h.Updates),
+				middleware.Wrap(http.HandlerFunc(hs.Metrics.Updates), bodyLimit),
 				middleware.WithLogger(zctx.From(ctx).Named("Updates"))),
 			middleware.WithCompression()),
 		)
 	})
 
+	tenant.Get("/api/v1/metrics", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Metrics.List),
+			middleware.WithLogger(zctx.From(ctx).Named("List"))),
+		middleware.WithCompression()),
+	)
+	tenant.Delete("/api/v1/metrics", middleware.Wrap(
+		http.HandlerFunc(hs.Metrics.DeleteByPrefix),
+		middleware.WithLogger(zctx.From(ctx).Named("DeleteByPrefix"))),
+	)
+	ingest.Post("/v1/metrics", middleware.Wrap(
+		middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(hs.OTLP.Export), bodyLimit),
+			middleware.WithLogger(zctx.From(ctx).Named("OTLPExport"))),
+		middleware.WithCompression()),
+	)
+
+	ingest.Post("/api/v1/write", middleware.Wrap(
+		middleware.Wrap(http.HandlerFunc(hs.RemoteWrite.Write), bodyLimit),
+		middleware.WithLogger(zctx.From(ctx).Named("RemoteWrite"))),
+	)
+
+	tenant.Get("/metrics", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Metrics.Exposition),
+			middleware.WithLogger(zctx.From(ctx).Named("Exposition"))),
+		middleware.WithCompression()),
+	)
+
+	tenant.Route("/api/v1/metadata", func(r chi.Router) {
+		r.Get("/", middleware.Wrap(
+			http.HandlerFunc(hs.Metadata.List),
+			middleware.WithLogger(zctx.From(ctx).Named("MetadataList"))),
+		)
+		r.Post("/", middleware.Wrap(
+			middleware.Wrap(
+				middleware.Wrap(http.HandlerFunc(hs.Metadata.Populate), bodyLimit),
+				middleware.WithLogger(zctx.From(ctx).Named("MetadataPopulate"))),
+			middleware.WithCompression()),
+		)
+		r.Get("/{mName}", middleware.Wrap(
+			http.HandlerFunc(hs.Metadata.Get),
+			middleware.WithLogger(zctx.From(ctx).Named("MetadataGet"))),
+		)
+		r.Put("/{mName}", middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(hs.Metadata.Put), bodyLimit),
+			middleware.WithLogger(zctx.From(ctx).Named("MetadataPut"))),
+		)
+	})
+
+	tenant.Get("/api/v1/agents", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Agents.List),
+			middleware.WithLogger(zctx.From(ctx).Named("AgentsList"))),
+		middleware.WithCompression()),
+	)
+
+	tenant.Get("/api/v1/alerts", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Alerts.List),
+			middleware.WithLogger(zctx.From(ctx).Named("AlertsList"))),
+		middleware.WithCompression()),
+	)
+
+	tenant.Get("/api/v1/export", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Export.Export),
+			middleware.WithLogger(zctx.From(ctx).Named("Export"))),
+		middleware.WithCompression()),
+	)
+
+	ingest.Post("/api/v1/import", middleware.Wrap(
+		middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(hs.Import.Import), bodyLimit),
+			middleware.WithLogger(zctx.From(ctx).Named("Import"))),
+		middleware.WithCompression()),
+	)
+
+	tenant.Get("/api/v1/federate", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Federation.Snapshot),
+			middleware.WithLogger(zctx.From(ctx).Named("FederationSnapshot"))),
+		middleware.WithCompression()),
+	)
+	ingest.Post("/api/v1/federate", middleware.Wrap(
+		middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(hs.Federation.Receive), bodyLimit),
+			middleware.WithLogger(zctx.From(ctx).Named("FederationReceive"))),
+		middleware.WithCompression()),
+	)
+
+	tenant.Get("/api/v1/recording-rules", middleware.Wrap(
+		middleware.Wrap(
+			http.HandlerFunc(hs.Recording.List),
+			middleware.WithLogger(zctx.From(ctx).Named("RecordingRulesList"))),
+		middleware.WithCompression()),
+	)
+
+	// стримы без сжатия: ответ должен уходить клиенту сразу
+	tenant.Get("/api/v1/stream", middleware.Wrap(
+		http.HandlerFunc(hs.Stream.SSE),
+		middleware.WithLogger(zctx.From(ctx).Named("StreamSSE"))),
+	)
+	tenant.Get("/api/v1/stream/ws", middleware.Wrap(
+		http.HandlerFunc(hs.Stream.WebSocket),
+		middleware.WithLogger(zctx.From(ctx).Named("StreamWebSocket"))),
+	)
+
+	// подмножество Prometheus HTTP API для Grafana, запросы принимаются в GET и POST
+	promAPI := []struct {
+		path    string
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"/api/v1/query", "PromQuery", hs.PromAPI.Query},
+		{"/api/v1/query_range", "PromQueryRange", hs.PromAPI.QueryRange},
+		{"/api/v1/series", "PromSeries", hs.PromAPI.Series},
+		{"/api/v1/labels", "PromLabels", hs.PromAPI.Labels},
+		{"/api/v1/label/{name}/values", "PromLabelValues", hs.PromAPI.LabelValues},
+	}
+	for _, api := range promAPI {
+		tenant.Get(api.path, middleware.Wrap(
+			middleware.Wrap(
+				api.handler,
+				middleware.WithLogger(zctx.From(ctx).Named(api.name))),
+			middleware.WithCompression()),
+		)
+		tenant.Post(api.path, middleware.Wrap(
+			middleware.Wrap(
+				middleware.Wrap(api.handler, bodyLimit),
+				middleware.WithLogger(zctx.From(ctx).Named(api.name))),
+			middleware.WithCompression()),
+		)
+	}
+
+	tenant.Route("/api/v1/silences", func(r chi.Router) {
+		r.Get("/", middleware.Wrap(
+			http.HandlerFunc(hs.Silences.List),
+			middleware.WithLogger(zctx.From(ctx).Named("SilencesList"))),
+		)
+		r.Post("/", middleware.Wrap(
+			middleware.Wrap(http.HandlerFunc(hs.Silences.Create), bodyLimit),
+			middleware.WithLogger(zctx.From(ctx).Named("SilencesCreate"))),
+		)
+		r.Delete("/{id}", middleware.Wrap(
+			http.HandlerFunc(hs.Silences.Delete),
+			middleware.WithLogger(zctx.From(ctx).Named("SilencesDelete"))),
+		)
+	})
+
+	router.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(middleware.Handler(middleware.WithAdminKey(opts.AdminKey)))
+		r.Get("/tenants", middleware.Wrap(
+			http.HandlerFunc(hs.Tenants.List),
+			middleware.WithLogger(zctx.From(ctx).Named("TenantsList"))),
+		)
+		r.Put("/tenants/{id}", middleware.Wrap(
+			http.HandlerFunc(hs.Tenants.Put),
+			middleware.WithLogger(zctx.From(ctx).Named("TenantsPut"))),
+		)
+		r.Delete("/tenants/{id}", middleware.Wrap(
+			http.HandlerFunc(hs.Tenants.Delete),
+			middleware.WithLogger(zctx.From(ctx).Named("TenantsDelete"))),
+		)
+		r.Get("/cardinality", middleware.Wrap(
+			http.HandlerFunc(hs.Cardinality.Top),
+			middleware.WithLogger(zctx.From(ctx).Named("CardinalityTop"))),
+		)
+		r.Get("/forwarding", middleware.Wrap(
+			http.HandlerFunc(hs.Forwarding.Stats),
+			middleware.WithLogger(zctx.From(ctx).Named("ForwardingStats"))),
+		)
+		r.Get("/federation", middleware.Wrap(
+			http.HandlerFunc(hs.Federation.Sources),
+			middleware.WithLogger(zctx.From(ctx).Named("FederationSources"))),
+		)
+	})
+
 	return router
 }
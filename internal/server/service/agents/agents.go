@@ -0,0 +1,144 @@
+package agents
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// sweepEvery как часто Seen удаляет агентов, не приходивших дольше retention.
+const sweepEvery = time.Minute
+
+// Registry агенты по тенантам. Хранится в памяти: после перезапуска
+// агенты появляются снова с первым же запросом. Агенты без запросов дольше retention
+// удаляются, у тенанта хранится не больше maxPerTenant агентов.
+type Registry struct {
+	staleAfter   time.Duration
+	retention    time.Duration
+	maxPerTenant int
+	swept        time.Time
+
+	mu     sync.RWMutex
+	agents map[string]map[string]*domain.Agent
+	now    func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewRegistry(ctx context.Context, cfg Config) *Registry {
+	return &Registry{
+		staleAfter:   time.Duration(cfg.StaleAfter) * time.Second,
+		retention:    time.Duration(cfg.Retention) * time.Second,
+		maxPerTenant: cfg.MaxPerTenant,
+		agents:       make(map[string]map[string]*domain.Agent),
+		now:          time.Now,
+		lg:           zctx.From(ctx).Named("agents registry"),
+	}
+}
+
+// Seen отмечает запрос агента с count метриками.
+func (r *Registry) Seen(ctx context.Context, agent *domain.Agent, count int) {
+	tenant := domain.TenantFromContext(ctx)
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.swept) >= sweepEvery {
+		r.sweep(now)
+	}
+
+	byID, ok := r.agents[tenant]
+	if !ok {
+		byID = make(map[string]*domain.Agent)
+		r.agents[tenant] = byID
+	}
+	a, ok := byID[agent.ID]
+	if !ok && !r.makeRoom(byID, now) {
+		r.lg.Debug("too many agents, ignoring new one", zap.String("tenant", tenant), zap.String("id", agent.ID))
+		return
+	}
+	if !ok {
+		a = &domain.Agent{ID: agent.ID, FirstSeen: now}
+		byID[agent.ID] = a
+		r.lg.Info("new agent", zap.String("tenant", tenant), zap.String("id", agent.ID), zap.String("version", agent.Version))
+	} else if r.stale(a, now) {
+		r.lg.Info("agent is back", zap.String("tenant", tenant), zap.String("id", agent.ID))
+	}
+
+	a.Hostname = agent.Hostname
+	a.Version = agent.Version
+	a.Collectors = slices.Clone(agent.Collectors)
+	a.Addr = agent.Addr
+	a.LastSeen = now
+	a.LastBatch = count
+	a.MetricsTotal += uint64(count)
+}
+
+// List возвращает агентов тенанта, отсортированных по id, с отметкой о пропавших.
+func (r *Registry) List(ctx context.Context) []*domain.Agent {
+	now := r.now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byID := r.agents[domain.TenantFromContext(ctx)]
+	res := make([]*domain.Agent, 0, len(byID))
+	for _, id := range slices.Sorted(maps.Keys(byID)) {
+		a := *byID[id]
+		a.Collectors = slices.Clone(a.Collectors)
+		a.Stale = r.stale(&a, now)
+		res = append(res, &a)
+	}
+
+	return res
+}
+
+// sweep удаляет агентов, не приходивших дольше retention, и опустевших тенантов.
+func (r *Registry) sweep(now time.Time) {
+	r.swept = now
+	if r.retention <= 0 {
+		return
+	}
+	for tenant, byID := range r.agents {
+		for id, a := range byID {
+			if now.Sub(a.LastSeen) > r.retention {
+				delete(byID, id)
+			}
+		}
+		if len(byID) == 0 {
+			delete(r.agents, tenant)
+		}
+	}
+}
+
+// makeRoom проверяет, можно ли добавить тенанту агента. Если лимит достигнут,
+// место освобождается за счёт давно пропавшего агента, активные не вытесняются.
+func (r *Registry) makeRoom(byID map[string]*domain.Agent, now time.Time) bool {
+	if r.maxPerTenant <= 0 || len(byID) < r.maxPerTenant {
+		return true
+	}
+
+	var oldest *domain.Agent
+	for _, a := range byID {
+		if oldest == nil || a.LastSeen.Before(oldest.LastSeen) {
+			oldest = a
+		}
+	}
+	if !r.stale(oldest, now) {
+		return false
+	}
+	delete(byID, oldest.ID)
+
+	return true
+}
+
+func (r *Registry) stale(a *domain.Agent, now time.Time) bool {
+	return r.staleAfter > 0 && now.Sub(a.LastSeen) > r.staleAfter
+}
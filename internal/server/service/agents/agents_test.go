@@ -0,0 +1,72 @@
+package agents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := NewRegistry(ctx, Config{StaleAfter: 60})
+	r.now = func() time.Time { return now }
+
+	r.Seen(ctx, &domain.Agent{ID: "host-a", Version: "1.0", Collectors: []string{"runtime"}}, 30)
+	r.Seen(ctx, &domain.Agent{ID: "host-b", Version: "1.0"}, 10)
+	// агенты другого тенанта не видны
+	r.Seen(domain.WithTenant(ctx, "team-a"), &domain.Agent{ID: "host-c"}, 5)
+
+	now = now.Add(45 * time.Second)
+	r.Seen(ctx, &domain.Agent{ID: "host-a", Version: "1.1", Collectors: []string{"runtime", "scrape"}}, 40)
+
+	now = now.Add(30 * time.Second)
+	list := r.List(ctx)
+	require.Len(t, list, 2)
+
+	require.Equal(t, "host-a", list[0].ID)
+	require.Equal(t, "1.1", list[0].Version)
+	require.Equal(t, []string{"runtime", "scrape"}, list[0].Collectors)
+	require.Equal(t, 40, list[0].LastBatch)
+	require.Equal(t, uint64(70), list[0].MetricsTotal)
+	require.False(t, list[0].Stale)
+
+	require.Equal(t, "host-b", list[1].ID)
+	require.True(t, list[1].Stale)
+}
+
+func TestRegistryLimits(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := NewRegistry(ctx, Config{StaleAfter: 60, Retention: 3600, MaxPerTenant: 2})
+	r.now = func() time.Time { return now }
+
+	r.Seen(ctx, &domain.Agent{ID: "host-a"}, 1)
+	r.Seen(ctx, &domain.Agent{ID: "host-b"}, 1)
+	// лимит достигнут, а пропавших агентов нет - новый не добавляется
+	r.Seen(ctx, &domain.Agent{ID: "host-c"}, 1)
+	require.Len(t, r.List(ctx), 2)
+	// у другого тенанта свой лимит
+	r.Seen(domain.WithTenant(ctx, "team-a"), &domain.Agent{ID: "host-c"}, 1)
+	require.Len(t, r.List(domain.WithTenant(ctx, "team-a")), 1)
+
+	// host-a пропал и уступает место новому агенту
+	now = now.Add(2 * time.Minute)
+	r.Seen(ctx, &domain.Agent{ID: "host-b"}, 1)
+	r.Seen(ctx, &domain.Agent{ID: "host-c"}, 1)
+	list := r.List(ctx)
+	require.Len(t, list, 2)
+	require.Equal(t, "host-b", list[0].ID)
+	require.Equal(t, "host-c", list[1].ID)
+
+	// через retention без запросов агенты удаляются
+	now = now.Add(2 * time.Hour)
+	r.Seen(ctx, &domain.Agent{ID: "host-d"}, 1)
+	list = r.List(ctx)
+	require.Len(t, list, 1)
+	require.Equal(t, "host-d", list[0].ID)
+	require.Empty(t, r.List(domain.WithTenant(ctx, "team-a")))
+}
@@ -0,0 +1,10 @@
+package agents
+
+type Config struct {
+	// StaleAfter через сколько секунд без запросов агент считается пропавшим.
+	StaleAfter int `json:"agentStaleAfter" yaml:"agentStaleAfter" env:"AGENT_STALE_AFTER" default:"60"`
+	// Retention через сколько секунд без запросов агент удаляется из реестра, 0 - не удалять.
+	Retention int `json:"agentRetention" yaml:"agentRetention" env:"AGENT_RETENTION" default:"86400"`
+	// MaxPerTenant предел агентов одного тенанта, 0 - без ограничения.
+	MaxPerTenant int `json:"agentMaxPerTenant" yaml:"agentMaxPerTenant" env:"AGENT_MAX_PER_TENANT" default:"1000"`
+}
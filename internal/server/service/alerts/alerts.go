@@ -0,0 +1,353 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// resolvedRetention сколько разрешённые алерты остаются в списке.
+const resolvedRetention = 15 * time.Minute
+
+type Metrics interface {
+	Find(ctx context.Context, name, mType string, labels map[string]string) (map[string]*domain.Metrics, error)
+}
+
+// Notifier получает алерты для отправки уведомлений.
+type Notifier interface {
+	Notify(ctx context.Context, alerts []*domain.Alert)
+}
+
+// Querier вычисляет выражения языка запросов для правил с Query.
+type Querier interface {
+	Instant(ctx context.Context, q string, ts time.Time) (*promql.Result, error)
+}
+
+type Option func(*Engine)
+
+// WithQuerier подключает язык запросов для правил с Query.
+func WithQuerier(q Querier) Option {
+	return func(e *Engine) {
+		e.querier = q
+	}
+}
+
+// WithNotifier после каждого вычисления передаёт notifier все firing алерты
+// и только что разрешённые.
+func WithNotifier(n Notifier) Option {
+	return func(e *Engine) {
+		e.notifier = n
+	}
+}
+
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+// Engine периодически вычисляет правила и ведёт состояние алертов:
+// pending -> firing -> resolved. Состояние хранится в памяти.
+type Engine struct {
+	srv      Metrics
+	rules    []*Rule
+	interval time.Duration
+	notifier Notifier
+	querier  Querier
+
+	mu     sync.RWMutex
+	alerts map[string]*domain.Alert
+	// prev прошлые значения серий для rate(), по правилу и серии
+	prev map[string]sample
+	now  func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewEngine(ctx context.Context, cfg Config, srv Metrics, rules []*Rule, opts ...Option) *Engine {
+	e := &Engine{
+		srv:      srv,
+		rules:    rules,
+		interval: time.Duration(cfg.EvalInterval) * time.Second,
+		alerts:   make(map[string]*domain.Alert),
+		prev:     make(map[string]sample),
+		now:      time.Now,
+		lg:       zctx.From(ctx).Named("alerts engine"),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+func (e *Engine) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(e.rules) == 0 || e.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			e.Eval(ctx)
+		}
+	}
+}
+
+// Eval вычисляет все правила один раз.
+func (e *Engine) Eval(ctx context.Context) {
+	now := e.now()
+	for _, r := range e.rules {
+		active, err := e.evalRule(domain.WithTenant(ctx, r.Tenant), r, now)
+		if err != nil {
+			e.lg.Error("error while evaluating rule", zap.String("rule", r.Name), zap.Error(err))
+			continue
+		}
+		e.update(r, active, now)
+	}
+	e.prune()
+	if e.notifier != nil {
+		e.notifier.Notify(ctx, e.notifiable(now))
+	}
+}
+
+// notifiable копии firing алертов всех тенантов и разрешённых в этом вычислении.
+func (e *Engine) notifiable(now time.Time) []*domain.Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make([]*domain.Alert, 0)
+	for _, k := range slices.Sorted(maps.Keys(e.alerts)) {
+		a := e.alerts[k]
+		if a.State == domain.AlertFiring || (a.State == domain.AlertResolved && a.ResolvedAt.Equal(now)) {
+			c := *a
+			res = append(res, &c)
+		}
+	}
+
+	return res
+}
+
+// Alerts возвращает алерты тенанта в указанных состояниях, по умолчанию pending и firing.
+func (e *Engine) Alerts(ctx context.Context, states ...domain.AlertState) []*domain.Alert {
+	if len(states) == 0 {
+		states = []domain.AlertState{domain.AlertPending, domain.AlertFiring}
+	}
+	tenant := domain.TenantFromContext(ctx)
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make([]*domain.Alert, 0)
+	for _, k := range slices.Sorted(maps.Keys(e.alerts)) {
+		a := e.alerts[k]
+		if a.Tenant == tenant && slices.Contains(states, a.State) {
+			c := *a
+			res = append(res, &c)
+		}
+	}
+
+	return res
+}
+
+// evalRule возвращает серии, для которых выполняется условие правила, с их значением.
+func (e *Engine) evalRule(ctx context.Context, r *Rule, now time.Time) (map[string]*domain.Alert, error) {
+	if r.Query != "" {
+		return e.evalQuery(ctx, r, now)
+	}
+
+	found, err := e.srv.Find(ctx, r.metric, r.mType, r.matchers)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			return nil, err
+		}
+		found = nil
+	}
+
+	active := make(map[string]*domain.Alert)
+	if r.fn == fnAbsent {
+		for key, m := range found {
+			if !m.UpdatedAt.IsZero() && now.Sub(m.UpdatedAt) > r.window {
+				active[key] = r.alert(key, m.Labels, 1)
+			}
+		}
+		if len(found) == 0 {
+			series := domain.SeriesKey(r.metric, r.matchers)
+			active[series] = r.alert(series, r.matchers, 1)
+		}
+		return active, nil
+	}
+	if r.fn == fnRate {
+		e.forget(r, found)
+	}
+
+	for key, m := range found {
+		v, ok := m.Number()
+		if !ok {
+			continue
+		}
+		if r.fn == fnRate {
+			if v, ok = e.rate(r, key, v, now); !ok {
+				continue
+			}
+		}
+		if r.compare(v) {
+			active[key] = r.alert(key, m.Labels, v)
+		}
+	}
+
+	return active, nil
+}
+
+// evalQuery заводит алерт на каждую серию результата Query.
+func (e *Engine) evalQuery(ctx context.Context, r *Rule, now time.Time) (map[string]*domain.Alert, error) {
+	if e.querier == nil {
+		return nil, errors.New("query rules need a query engine")
+	}
+	res, err := e.querier.Instant(ctx, r.Query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]*domain.Alert)
+	for _, s := range res.Series {
+		labels := maps.Clone(s.Labels)
+		name := labels[promql.NameLabel]
+		delete(labels, promql.NameLabel)
+		series := domain.SeriesKey(name, labels)
+		active[series] = r.alert(series, labels, s.Samples[len(s.Samples)-1].V)
+	}
+
+	return active, nil
+}
+
+// rate прирост серии в секунду с прошлого вычисления. При сбросе счётчика
+// прирост считается от нуля. Для первого вычисления значения нет.
+func (e *Engine) rate(r *Rule, series string, v float64, now time.Time) (float64, bool) {
+	key := r.Tenant + "/" + r.Name + "/" + series
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev, ok := e.prev[key]
+	e.prev[key] = sample{value: v, at: now}
+	dt := now.Sub(prev.at).Seconds()
+	if !ok || dt <= 0 {
+		return 0, false
+	}
+	inc := v - prev.value
+	if inc < 0 {
+		inc = v
+	}
+
+	return inc / dt, true
+}
+
+// forget удаляет прошлые значения серий правила, которых больше нет.
+func (e *Engine) forget(r *Rule, found map[string]*domain.Metrics) {
+	prefix := r.Tenant + "/" + r.Name + "/"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key := range e.prev {
+		if series, ok := strings.CutPrefix(key, prefix); ok && found[series] == nil {
+			delete(e.prev, key)
+		}
+	}
+}
+
+// prune удаляет прошлые значения и алерты правил, которых больше нет.
+func (e *Engine) prune() {
+	prefixes := make([]string, 0, len(e.rules))
+	for _, r := range e.rules {
+		prefixes = append(prefixes, r.Tenant+"/"+r.Name+"/")
+	}
+	known := func(key string) bool {
+		return slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(key, p) })
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	maps.DeleteFunc(e.prev, func(key string, _ sample) bool { return !known(key) })
+	maps.DeleteFunc(e.alerts, func(key string, _ *domain.Alert) bool { return !known(key) })
+}
+
+func (r *Rule) alert(series string, labels map[string]string, v float64) *domain.Alert {
+	all := make(map[string]string, len(labels)+len(r.Labels)+1)
+	maps.Copy(all, labels)
+	maps.Copy(all, r.Labels)
+	all["alertname"] = r.Name
+
+	return &domain.Alert{
+		Rule:        r.Name,
+		Tenant:      r.Tenant,
+		Series:      series,
+		Labels:      all,
+		Annotations: r.Annotations,
+		Value:       v,
+	}
+}
+
+// update переводит алерты правила по состояниям.
+func (e *Engine) update(r *Rule, active map[string]*domain.Alert, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prefix := r.Tenant + "/" + r.Name + "/"
+	for series, a := range active {
+		key := prefix + series
+		old, ok := e.alerts[key]
+		if !ok || old.State == domain.AlertResolved {
+			a.State = domain.AlertPending
+			a.ActiveAt = now
+			old = a
+			e.alerts[key] = a
+		}
+		old.Value = a.Value
+		if old.State == domain.AlertPending && now.Sub(old.ActiveAt) >= r.forDur {
+			old.State = domain.AlertFiring
+			old.FiredAt = now
+			e.lg.Warn("alert is firing", zap.String("rule", r.Name), zap.String("series", series), zap.Float64("value", a.Value))
+		}
+	}
+
+	for key, a := range e.alerts {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, ok := active[strings.TrimPrefix(key, prefix)]; ok {
+			continue
+		}
+		switch a.State {
+		case domain.AlertPending:
+			delete(e.alerts, key)
+		case domain.AlertFiring:
+			a.State = domain.AlertResolved
+			a.ResolvedAt = now
+			e.lg.Info("alert is resolved", zap.String("rule", r.Name), zap.String("series", a.Series))
+		case domain.AlertResolved:
+			if now.Sub(a.ResolvedAt) > resolvedRetention {
+				delete(e.alerts, key)
+			}
+		}
+	}
+}
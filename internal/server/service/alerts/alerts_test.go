@@ -0,0 +1,284 @@
+package alerts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		name      string
+		expr      string
+		fn        string
+		mType     string
+		metric    string
+		op        string
+		threshold float64
+		forDur    time.Duration
+		pass      bool
+	}{
+		{name: "Threshold", expr: "gauge HeapAlloc > 500MB for 5m", mType: domain.Gauge, metric: "HeapAlloc", op: ">", threshold: 500 << 20, forDur: 5 * time.Minute, pass: true},
+		{name: "Rate", expr: `rate(PollCount{host="a"}) == 0 for 2m`, fn: fnRate, metric: "PollCount", op: "==", forDur: 2 * time.Minute, pass: true},
+		{name: "Absent", expr: "absent(PollCount) for 10m", fn: fnAbsent, metric: "PollCount", forDur: 10 * time.Minute, pass: true},
+		{name: "No comparison", expr: "gauge HeapAlloc", pass: false},
+		{name: "Bad type", expr: "meter HeapAlloc > 1", pass: false},
+		{name: "Bad threshold", expr: "HeapAlloc > lots", pass: false},
+		{name: "Absent with comparison", expr: "absent(PollCount) > 1", pass: false},
+		{name: "Bad absent window", expr: "absent(PollCount[soon])", pass: false},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := &Rule{Name: "test", Expr: tCase.expr}
+			err := r.Compile()
+			if !tCase.pass {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tCase.fn, r.fn)
+			require.Equal(t, tCase.mType, r.mType)
+			require.Equal(t, tCase.metric, r.metric)
+			require.Equal(t, tCase.op, r.op)
+			require.Equal(t, tCase.threshold, r.threshold)
+			require.Equal(t, tCase.forDur, r.forDur)
+		})
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	cases := []struct {
+		name   string
+		rule   *Rule
+		forDur time.Duration
+		pass   bool
+	}{
+		{name: "Query", rule: &Rule{Query: "sum by (host) (rate(PollCount[5m])) < 1", For: "5m"}, forDur: 5 * time.Minute, pass: true},
+		{name: "Bad query", rule: &Rule{Query: "rate(PollCount)"}},
+		{name: "Scalar query", rule: &Rule{Query: "1 + 1"}},
+		{name: "Both expr and query", rule: &Rule{Expr: "absent(PollCount)", Query: "PollCount"}},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tCase.rule.Name = "test"
+			err := tCase.rule.Compile()
+			if !tCase.pass {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tCase.forDur, tCase.rule.forDur)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: HeapTooBig
+    expr: gauge HeapAlloc > 500MB
+    for: 5m
+    labels: {severity: page}
+  - name: AgentDown
+    expr: absent(PollCount) for 10m
+`), 0o600))
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, 5*time.Minute, rules[0].forDur)
+	require.Equal(t, fnAbsent, rules[1].fn)
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+
+	rules := []*Rule{
+		{Name: "HeapTooBig", Expr: "gauge HeapAlloc > 100 for 1m"},
+		{Name: "NoPolls", Expr: "rate(PollCount) == 0"},
+		{Name: "NoRequests", Expr: "absent(requests[1h])"},
+	}
+	for _, r := range rules {
+		require.NoError(t, r.Compile())
+	}
+
+	now := time.Now()
+	e := NewEngine(ctx, Config{EvalInterval: 30}, srv, rules)
+	e.now = func() time.Time { return now }
+
+	update := func(heap float64, polls int64) {
+		require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+			{ID: "HeapAlloc", MType: domain.Gauge, Value: &heap},
+			{ID: "PollCount", MType: domain.Counter, Delta: &polls},
+		}))
+	}
+	states := func() map[string]domain.AlertState {
+		res := make(map[string]domain.AlertState)
+		for _, a := range e.Alerts(ctx, domain.AlertPending, domain.AlertFiring, domain.AlertResolved) {
+			res[a.Rule] = a.State
+		}
+		return res
+	}
+
+	update(200, 1)
+	e.Eval(ctx)
+	// rate ещё не из чего считать, absent срабатывает сразу
+	require.Equal(t, map[string]domain.AlertState{
+		"HeapTooBig": domain.AlertPending,
+		"NoRequests": domain.AlertFiring,
+	}, states())
+
+	now = now.Add(time.Minute)
+	update(200, 0)
+	e.Eval(ctx)
+	require.Equal(t, map[string]domain.AlertState{
+		"HeapTooBig": domain.AlertFiring,
+		"NoPolls":    domain.AlertFiring,
+		"NoRequests": domain.AlertFiring,
+	}, states())
+
+	now = now.Add(time.Minute)
+	update(-150, 5)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{{ID: "requests", MType: domain.Counter, Delta: new(int64)}}))
+	e.Eval(ctx)
+	require.Equal(t, map[string]domain.AlertState{
+		"HeapTooBig": domain.AlertResolved,
+		"NoPolls":    domain.AlertResolved,
+		"NoRequests": domain.AlertResolved,
+	}, states())
+	require.Empty(t, e.Alerts(ctx))
+
+	// разрешённые алерты со временем пропадают
+	now = now.Add(resolvedRetention + time.Minute)
+	update(-150, 5)
+	e.Eval(ctx)
+	require.Empty(t, states())
+}
+
+func TestEngineAbsentStale(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+	rules := []*Rule{
+		{Name: "AgentDown", Expr: "absent(PollCount[2m])"},
+		{Name: "NoPolls", Expr: "rate(PollCount) == 0"},
+	}
+	for _, r := range rules {
+		require.NoError(t, r.Compile())
+	}
+	require.Equal(t, 2*time.Minute, rules[0].window)
+
+	now := time.Now()
+	e := NewEngine(ctx, Config{EvalInterval: 30}, srv, rules)
+	e.now = func() time.Time { return now }
+
+	// время обновления задаётся явно, чтобы не зависеть от часов
+	polls := int64(1)
+	write := func(host string) {
+		m := &domain.Metrics{ID: "PollCount", MType: domain.Counter, Delta: &polls, Labels: map[string]string{"host": host}, UpdatedAt: now}
+		require.NoError(t, repo.Write(ctx, m.Key(), m))
+		polls++
+	}
+	write("a")
+	write("b")
+	e.Eval(ctx)
+	require.Empty(t, e.Alerts(ctx))
+	require.Len(t, e.prev, 2)
+
+	// b перестал присылать данные: серия есть, но не обновлялась дольше окна
+	now = now.Add(3 * time.Minute)
+	write("a")
+	e.Eval(ctx)
+	alerts := e.Alerts(ctx)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "AgentDown", alerts[0].Rule)
+	require.Equal(t, `PollCount{host="b"}`, alerts[0].Series)
+
+	// прошлые значения удалённых серий и правил не копятся
+	_, err := srv.Delete(ctx, "PollCount", domain.Counter, map[string]string{"host": "b"})
+	require.NoError(t, err)
+	e.Eval(ctx)
+	require.Len(t, e.prev, 1)
+	e.rules = rules[:1]
+	e.Eval(ctx)
+	require.Empty(t, e.prev)
+}
+
+func TestEngineQuery(t *testing.T) {
+	ctx := context.Background()
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithPublisher(hist))
+	rule := &Rule{Name: "HeapShare", Query: "HeapAlloc / HeapSys > 0.5", Labels: map[string]string{"severity": "page"}}
+	require.NoError(t, rule.Compile())
+
+	e := NewEngine(ctx, Config{EvalInterval: 30}, srv, []*Rule{rule})
+	// без языка запросов правило не вычисляется
+	active, err := e.evalRule(ctx, rule, time.Now())
+	require.Error(t, err)
+	require.Nil(t, active)
+
+	e = NewEngine(ctx, Config{EvalInterval: 30}, srv, []*Rule{rule}, WithQuerier(promql.NewEngine(ctx, srv, hist)))
+	update := func(host string, heap, sys float64) {
+		labels := map[string]string{"host": host}
+		require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+			{ID: "HeapAlloc", MType: domain.Gauge, Value: &heap, Labels: labels},
+			{ID: "HeapSys", MType: domain.Gauge, Value: &sys, Labels: labels},
+		}))
+	}
+	update("a", 80, 100)
+	update("b", 10, 100)
+	e.Eval(ctx)
+
+	alerts := e.Alerts(ctx)
+	require.Len(t, alerts, 1)
+	require.Equal(t, domain.AlertFiring, alerts[0].State)
+	require.Equal(t, `{host="a"}`, alerts[0].Series)
+	require.Equal(t, 0.8, alerts[0].Value)
+	require.Equal(t, map[string]string{"alertname": "HeapShare", "host": "a", "severity": "page"}, alerts[0].Labels)
+}
+
+type fakeNotifier struct {
+	got [][]*domain.Alert
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, alerts []*domain.Alert) {
+	f.got = append(f.got, alerts)
+}
+
+func TestEngineNotify(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	rule := &Rule{Name: "NoRequests", Expr: "absent(requests)"}
+	require.NoError(t, rule.Compile())
+
+	n := &fakeNotifier{}
+	now := time.Now()
+	e := NewEngine(ctx, Config{EvalInterval: 30}, srv, []*Rule{rule}, WithNotifier(n))
+	e.now = func() time.Time { return now }
+
+	e.Eval(ctx)
+	now = now.Add(time.Minute)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{{ID: "requests", MType: domain.Counter, Delta: new(int64)}}))
+	e.Eval(ctx)
+	now = now.Add(time.Minute)
+	e.Eval(ctx)
+
+	require.Len(t, n.got, 3)
+	require.Len(t, n.got[0], 1)
+	require.Equal(t, domain.AlertFiring, n.got[0][0].State)
+	// разрешённый алерт передаётся один раз
+	require.Len(t, n.got[1], 1)
+	require.Equal(t, domain.AlertResolved, n.got[1][0].State)
+	require.Empty(t, n.got[2])
+}
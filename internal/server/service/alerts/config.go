@@ -0,0 +1,7 @@
+package alerts
+
+type Config struct {
+	RulesFile string `json:"alertRules" yaml:"alertRules" env:"ALERT_RULES"`
+	// EvalInterval как часто вычисляются правила (секунды).
+	EvalInterval int `json:"alertEvalInterval" yaml:"alertEvalInterval" env:"ALERT_EVAL_INTERVAL" default:"30"`
+}
@@ -0,0 +1,275 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fnValue  = ""
+	fnRate   = "rate"
+	fnAbsent = "absent"
+
+	// defaultAbsentWindow сколько серия может не обновляться, пока absent() не сработал.
+	defaultAbsentWindow = 5 * time.Minute
+)
+
+var (
+	forRe  = regexp.MustCompile(`\s+for\s+(\S+)$`)
+	condRe = regexp.MustCompile(`^(.+?)\s*(>=|<=|==|!=|>|<)\s*(\S+)$`)
+	callRe = regexp.MustCompile(`^(rate|absent)\s*\((.*)\)$`)
+	// windowRe окно absent(): absent(PollCount[10m])
+	windowRe = regexp.MustCompile(`^(.+?)\s*\[(\S+)\]$`)
+	nameRe   = regexp.MustCompile(`^[a-zA-Z0-9_.:\-]+$`)
+)
+
+// byteUnits суффиксы размеров, степени 1024: 500MB = 500 * 1024 * 1024.
+var byteUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{suffix: "TB", mult: 1 << 40},
+	{suffix: "GB", mult: 1 << 30},
+	{suffix: "MB", mult: 1 << 20},
+	{suffix: "KB", mult: 1 << 10},
+	{suffix: "B", mult: 1},
+}
+
+// Rule правило алерта. Expr - условие в одном из видов:
+//
+//	gauge HeapAlloc > 500MB
+//	rate(PollCount{host="a"}) == 0
+//	absent(PollCount[10m])
+//
+// Тип перед именем необязателен. Окончание "for 5m" в Expr задаёт For. absent()
+// срабатывает на серии, не обновлявшиеся дольше окна в скобках (по умолчанию 5m),
+// и на сам селектор, если серий нет.
+//
+// Вместо Expr можно задать Query - выражение языка запросов, например
+// sum by (host) (rate(PollCount[5m])) < 1. Алерт заводится на каждую серию результата.
+type Rule struct {
+	Name        string            `json:"name" yaml:"name"`
+	Expr        string            `json:"expr,omitempty" yaml:"expr"`
+	Query       string            `json:"query,omitempty" yaml:"query"`
+	For         string            `json:"for,omitempty" yaml:"for"`
+	Tenant      string            `json:"tenant,omitempty" yaml:"tenant"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations"`
+
+	fn        string
+	mType     string
+	metric    string
+	matchers  map[string]string
+	op        string
+	threshold float64
+	forDur    time.Duration
+	window    time.Duration
+}
+
+type rulesFile struct {
+	Rules []*Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadRules читает правила из YAML или JSON файла.
+func LoadRules(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read rules file: %w", err)
+	}
+
+	var f rulesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("can not parse rules file: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(f.Rules))
+	var errs []error
+	for _, r := range f.Rules {
+		if err := r.Compile(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := seen[r.Tenant+"/"+r.Name]; ok {
+			errs = append(errs, fmt.Errorf("duplicate rule %q", r.Name))
+		}
+		seen[r.Tenant+"/"+r.Name] = struct{}{}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return f.Rules, nil
+}
+
+// Compile разбирает Expr и For.
+func (r *Rule) Compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name: %q", r.Expr+r.Query)
+	}
+	if r.Query != "" {
+		return r.compileQuery()
+	}
+
+	expr := strings.TrimSpace(r.Expr)
+	if m := forRe.FindStringSubmatch(expr); m != nil {
+		if r.For == "" {
+			r.For = m[1]
+		}
+		expr = strings.TrimSpace(strings.TrimSuffix(expr, m[0]))
+	}
+	if err := r.compileFor(); err != nil {
+		return err
+	}
+
+	selector := expr
+	r.fn = fnValue
+	if m := condRe.FindStringSubmatch(expr); m != nil {
+		selector, r.op = strings.TrimSpace(m[1]), m[2]
+		v, err := parseValue(m[3])
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.threshold = v
+	}
+	if m := callRe.FindStringSubmatch(selector); m != nil {
+		r.fn, selector = m[1], strings.TrimSpace(m[2])
+	}
+	if r.fn == fnAbsent {
+		r.window = defaultAbsentWindow
+		if m := windowRe.FindStringSubmatch(selector); m != nil {
+			d, err := time.ParseDuration(m[2])
+			if err != nil || d <= 0 {
+				return fmt.Errorf("rule %s: bad absent window %q", r.Name, m[2])
+			}
+			r.window, selector = d, m[1]
+		}
+	}
+
+	switch {
+	case r.fn == fnAbsent && r.op != "":
+		return fmt.Errorf("rule %s: absent() takes no comparison", r.Name)
+	case r.fn != fnAbsent && r.op == "":
+		return fmt.Errorf("rule %s: no comparison in %q", r.Name, r.Expr)
+	}
+
+	return r.parseSelector(selector)
+}
+
+// compileQuery проверяет Query: результат должен быть вектором серий.
+func (r *Rule) compileQuery() error {
+	if r.Expr != "" {
+		return fmt.Errorf("rule %s: both expr and query are set", r.Name)
+	}
+	expr, err := promql.ParseExpr(r.Query)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+	if expr.Type() != promql.ResultVector {
+		return fmt.Errorf("rule %s: query must return an instant vector, got %s", r.Name, expr.Type())
+	}
+
+	return r.compileFor()
+}
+
+func (r *Rule) compileFor() error {
+	if r.For == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(r.For)
+	if err != nil || d < 0 {
+		return fmt.Errorf("rule %s: bad for %q", r.Name, r.For)
+	}
+	r.forDur = d
+
+	return nil
+}
+
+// parseSelector разбирает [тип] имя[{метка="значение",...}].
+func (r *Rule) parseSelector(s string) error {
+	if i := strings.Index(s, "{"); i >= 0 {
+		if !strings.HasSuffix(s, "}") {
+			return fmt.Errorf("rule %s: unclosed labels in %q", r.Name, s)
+		}
+		matchers, err := parseMatchers(s[i+1 : len(s)-1])
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.matchers = matchers
+		s = strings.TrimSpace(s[:i])
+	}
+
+	if mType, name, ok := strings.Cut(s, " "); ok {
+		if !domain.IsValidType(mType) {
+			return fmt.Errorf("rule %s: unknown type %q", r.Name, mType)
+		}
+		r.mType, s = mType, strings.TrimSpace(name)
+	}
+	if !nameRe.MatchString(s) {
+		return fmt.Errorf("rule %s: bad metric name %q", r.Name, s)
+	}
+	r.metric = s
+
+	return nil
+}
+
+func parseMatchers(s string) (map[string]string, error) {
+	matchers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("bad label matcher %q", pair)
+		}
+		matchers[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+
+	return matchers, nil
+}
+
+func parseValue(s string) (float64, error) {
+	mult := float64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, fmt.Errorf("bad threshold %q", s)
+	}
+
+	return v * mult, nil
+}
+
+func (r *Rule) compare(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	case "!=":
+		return v != r.threshold
+	}
+
+	return false
+}
@@ -0,0 +1,209 @@
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// nameSeparators разделители частей имени, по ним считаются префиксы.
+const nameSeparators = "._:-"
+
+// PrefixStat число серий с одинаковым префиксом имени.
+type PrefixStat struct {
+	Tenant string `json:"tenant"`
+	Prefix string `json:"prefix"`
+	Names  int    `json:"names"`
+	Series int    `json:"series"`
+}
+
+// Limiter считает серии по тенантам и не даёт создавать новые сверх лимитов.
+// Известные серии читаются из хранилища при первом обращении, дальше
+// счётчики поддерживаются через Admit и Forget.
+type Limiter struct {
+	repo         repository.MetricsRepository
+	maxSeries    int
+	maxPerTenant int
+
+	mu     sync.Mutex
+	loaded bool
+	// series имя метрики по тенанту и ключу серии
+	series map[string]map[string]string
+	total  int
+
+	lg *zap.Logger
+}
+
+// NewLimiter создаёт счётчик серий. Нулевой лимит означает отсутствие ограничения.
+func NewLimiter(ctx context.Context, repo repository.MetricsRepository, maxSeries, maxPerTenant int) *Limiter {
+	return &Limiter{
+		repo:         repo,
+		maxSeries:    maxSeries,
+		maxPerTenant: maxPerTenant,
+		series:       make(map[string]map[string]string),
+		lg:           zctx.From(ctx).Named("cardinality limiter"),
+	}
+}
+
+// Admit запоминает серии тенанта из ctx. Если новые серии не влезают
+// в лимиты, не запоминается ни одна и возвращается ErrSeriesLimit.
+func (l *Limiter) Admit(ctx context.Context, metrics []*domain.Metrics) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(ctx); err != nil {
+		return err
+	}
+
+	tenant := domain.TenantFromContext(ctx)
+	known := l.series[tenant]
+	fresh := make(map[string]string)
+	for _, m := range metrics {
+		if _, ok := known[m.Key()]; !ok {
+			fresh[m.Key()] = m.ID
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	if l.maxPerTenant > 0 && len(known)+len(fresh) > l.maxPerTenant {
+		l.lg.Warn("tenant series limit exceeded", zap.String("tenant", tenant), zap.Int("new", len(fresh)))
+		return fmt.Errorf("%w: tenant already has %d series, limit is %d", domain.ErrSeriesLimit, len(known), l.maxPerTenant)
+	}
+	if l.maxSeries > 0 && l.total+len(fresh) > l.maxSeries {
+		l.lg.Warn("global series limit exceeded", zap.String("tenant", tenant), zap.Int("new", len(fresh)))
+		return fmt.Errorf("%w: server already has %d series, limit is %d", domain.ErrSeriesLimit, l.total, l.maxSeries)
+	}
+
+	if known == nil {
+		known = make(map[string]string, len(fresh))
+		l.series[tenant] = known
+	}
+	maps.Copy(known, fresh)
+	l.total += len(fresh)
+
+	return nil
+}
+
+// Forget убирает удалённые серии тенанта из ctx.
+func (l *Limiter) Forget(ctx context.Context, keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	known := l.series[domain.TenantFromContext(ctx)]
+	for _, k := range keys {
+		if _, ok := known[k]; ok {
+			delete(known, k)
+			l.total--
+		}
+	}
+}
+
+// Top возвращает n префиксов имён с наибольшим числом серий по всем тенантам.
+// depth - сколько частей имени (через . _ : -) входит в префикс.
+func (l *Limiter) Top(ctx context.Context, n, depth int) ([]*PrefixStat, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(ctx); err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		tenant, prefix string
+	}
+	stats := make(map[group]*PrefixStat)
+	names := make(map[group]map[string]struct{})
+	for tenant, known := range l.series {
+		for _, name := range known {
+			g := group{tenant: tenant, prefix: Prefix(name, depth)}
+			st, ok := stats[g]
+			if !ok {
+				st = &PrefixStat{Tenant: tenant, Prefix: g.prefix}
+				stats[g] = st
+				names[g] = make(map[string]struct{})
+			}
+			st.Series++
+			names[g][name] = struct{}{}
+		}
+	}
+
+	res := slices.Collect(maps.Values(stats))
+	for _, st := range res {
+		st.Names = len(names[group{tenant: st.Tenant, prefix: st.Prefix}])
+	}
+	slices.SortFunc(res, func(a, b *PrefixStat) int {
+		if a.Series != b.Series {
+			return b.Series - a.Series
+		}
+		if a.Tenant != b.Tenant {
+			return strings.Compare(a.Tenant, b.Tenant)
+		}
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+
+	return res, nil
+}
+
+// Prefix первые depth частей имени: Prefix("go_memstats_alloc", 2) = "go_memstats".
+func Prefix(name string, depth int) string {
+	if depth < 1 {
+		depth = 1
+	}
+	for i, r := range name {
+		if strings.ContainsRune(nameSeparators, r) {
+			depth--
+			if depth == 0 {
+				return name[:i]
+			}
+		}
+	}
+
+	return name
+}
+
+// load читает все серии из хранилища, вызывается под блокировкой.
+func (l *Limiter) load(ctx context.Context) error {
+	if l.loaded {
+		return nil
+	}
+
+	tenants, err := l.repo.Tenants(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot load tenants: %w", err)
+	}
+	for _, tenant := range tenants {
+		all, err := l.repo.GetAll(domain.WithTenant(ctx, tenant))
+		if err != nil {
+			var er *repository.EmptyRepoError
+			if errors.Is(err, er) {
+				continue
+			}
+			return fmt.Errorf("cannot load series: %w", err)
+		}
+
+		known := make(map[string]string, len(all))
+		for k, v := range all {
+			known[k] = v.ID
+		}
+		l.series[tenant] = known
+		l.total += len(known)
+	}
+	l.loaded = true
+	l.lg.Info("loaded series", zap.Int("count", l.total))
+
+	return nil
+}
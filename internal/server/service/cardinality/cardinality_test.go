@@ -0,0 +1,64 @@
+package cardinality
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func gauges(names ...string) []*domain.Metrics {
+	res := make([]*domain.Metrics, 0, len(names))
+	for _, name := range names {
+		v := float64(1)
+		res = append(res, &domain.Metrics{ID: name, MType: domain.Gauge, Value: &v})
+	}
+	return res
+}
+
+func TestAdmit(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	// серия, которая уже была в хранилище до запуска
+	require.NoError(t, repo.Write(ctx, "Alloc", gauges("Alloc")[0]))
+
+	l := NewLimiter(ctx, repo, 5, 3)
+	teamA := domain.WithTenant(ctx, "team-a")
+
+	require.NoError(t, l.Admit(ctx, gauges("Alloc", "GCSys", "HeapSys")))
+	// уже известные серии лимит не тратят
+	require.NoError(t, l.Admit(ctx, gauges("Alloc", "HeapSys")))
+	require.ErrorIs(t, l.Admit(ctx, gauges("NumGC")), domain.ErrSeriesLimit)
+
+	require.NoError(t, l.Admit(teamA, gauges("Alloc", "GCSys")))
+	// у тенанта место есть, но на сервере уже 5 серий
+	require.ErrorIs(t, l.Admit(teamA, gauges("HeapSys")), domain.ErrSeriesLimit)
+
+	l.Forget(ctx, []string{"GCSys"})
+	require.NoError(t, l.Admit(teamA, gauges("HeapSys")))
+}
+
+func TestTop(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(ctx, repository.NewMemStorage(), 0, 0)
+
+	names := []string{"go_memstats_alloc", "go_memstats_sys", "go_goroutines"}
+	for i := 0; i < 5; i++ {
+		names = append(names, "req_"+strconv.Itoa(i))
+	}
+	require.NoError(t, l.Admit(ctx, gauges(names...)))
+
+	top, err := l.Top(ctx, 2, 1)
+	require.NoError(t, err)
+	require.Equal(t, []*PrefixStat{
+		{Prefix: "req", Names: 5, Series: 5},
+		{Prefix: "go", Names: 3, Series: 3},
+	}, top)
+
+	top, err = l.Top(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []*PrefixStat{{Prefix: "go_memstats", Names: 2, Series: 2}}, top)
+}
@@ -0,0 +1,86 @@
+package expiry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// checkInterval как часто ищутся устаревшие серии, TTL задаётся в минутах.
+const checkInterval = time.Minute
+
+type Metrics interface {
+	Expire(ctx context.Context, before time.Time) ([]string, error)
+	Tenants(ctx context.Context) ([]string, error)
+}
+
+// Remover убирает удалённые серии из файла сохранения.
+type Remover interface {
+	Remove(ctx context.Context, keys []string) error
+}
+
+// Expirer периодически удаляет серии, которые не обновлялись дольше TTL.
+type Expirer struct {
+	srv     Metrics
+	remover Remover
+	ttl     time.Duration
+	lg      *zap.Logger
+}
+
+func NewExpirer(ctx context.Context, ttl time.Duration, srv Metrics, remover Remover) *Expirer {
+	return &Expirer{
+		srv:     srv,
+		remover: remover,
+		ttl:     ttl,
+		lg:      zctx.From(ctx).Named("expiry service"),
+	}
+}
+
+func (e *Expirer) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		e.expire(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			e.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire удаляет устаревшие серии каждого тенанта.
+func (e *Expirer) expire(ctx context.Context, now time.Time) {
+	tenants, err := e.srv.Tenants(ctx)
+	if err != nil {
+		e.lg.Error("error while getting tenants", zap.Error(err))
+		return
+	}
+
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		keys, err := e.srv.Expire(tCtx, now.Add(-e.ttl))
+		if err != nil {
+			e.lg.Error("error while expiring metrics", zap.String("tenant", tenant), zap.Error(err))
+			continue
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		e.lg.Info("expired stale metrics", zap.String("tenant", tenant), zap.Int("count", len(keys)))
+
+		if e.remover != nil {
+			if err := e.remover.Remove(tCtx, keys); err != nil {
+				e.lg.Error("error while removing expired metrics from file", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package expiry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+type remover struct {
+	keys []string
+}
+
+func (r *remover) Remove(ctx context.Context, keys []string) error {
+	r.keys = append(r.keys, keys...)
+	return nil
+}
+
+func TestExpire(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+
+	v := float64(1)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "Alloc", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "old"}},
+		{ID: "Alloc", MType: domain.Gauge, Value: &v, Labels: map[string]string{"host": "new"}},
+	}))
+	// старый хост перестал присылать метрики час назад
+	repo.Values[`Alloc{host="old"}`].UpdatedAt = time.Now().Add(-time.Hour)
+
+	rm := &remover{}
+	e := NewExpirer(ctx, 30*time.Minute, srv, rm)
+	e.expire(ctx, time.Now())
+
+	require.Equal(t, []string{`Alloc{host="old"}`}, rm.keys)
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Contains(t, all, `Alloc{host="new"}`)
+}
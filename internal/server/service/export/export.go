@@ -0,0 +1,244 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// Форматы выгрузки.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrBadRequest = errors.New("bad export request")
+
+type Metrics interface {
+	GetAll(ctx context.Context) (map[string]*domain.Metrics, error)
+}
+
+type History interface {
+	Points(ctx context.Context, key string) []history.Point
+}
+
+// Request что выгружать. Без From и To - текущие значения, иначе точки истории
+// в интервале [From, To], нулевая граница интервал не ограничивает.
+type Request struct {
+	Format string
+	// Match селекторы серий, серия выгружается, если подходит хотя бы под один.
+	Match []string
+	From  time.Time
+	To    time.Time
+}
+
+func (r *Request) history() bool {
+	return !r.From.IsZero() || !r.To.IsZero()
+}
+
+// row строка выгрузки: одно значение одной серии.
+type row struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+	Value     *float64          `json:"value"`
+}
+
+type rowWriter interface {
+	write(r *row) error
+	flush() error
+}
+
+// Exporter выгружает серии тенанта построчно, не собирая выгрузку в памяти.
+type Exporter struct {
+	srv     Metrics
+	history History
+	lg      *zap.Logger
+}
+
+func NewExporter(ctx context.Context, srv Metrics, history History) *Exporter {
+	return &Exporter{
+		srv:     srv,
+		history: history,
+		lg:      zctx.From(ctx).Named("exporter"),
+	}
+}
+
+// Validate проверяет запрос до начала выгрузки, чтобы ошибку можно было вернуть статусом.
+func Validate(req *Request) ([]*promql.Selector, error) {
+	if req.Format != FormatCSV && req.Format != FormatNDJSON {
+		return nil, fmt.Errorf("%w: format must be %s or %s, got %q", ErrBadRequest, FormatCSV, FormatNDJSON, req.Format)
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && req.To.Before(req.From) {
+		return nil, fmt.Errorf("%w: to is before from", ErrBadRequest)
+	}
+
+	sels := make([]*promql.Selector, 0, len(req.Match))
+	for _, m := range req.Match {
+		sel, err := promql.ParseSelector(m)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
+		}
+		sels = append(sels, sel)
+	}
+
+	return sels, nil
+}
+
+// Export пишет подходящие серии в w в порядке ключей.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, req *Request) error {
+	sels, err := Validate(req)
+	if err != nil {
+		return err
+	}
+
+	all, err := e.srv.GetAll(ctx)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			return err
+		}
+		all = nil
+	}
+
+	var rw rowWriter
+	if req.Format == FormatCSV {
+		rw, err = newCSVWriter(w)
+		if err != nil {
+			return err
+		}
+	} else {
+		rw = &ndjsonWriter{enc: json.NewEncoder(w)}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(all)) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m := all[key]
+		if !matches(m, sels) {
+			continue
+		}
+		if err := e.exportSeries(ctx, rw, m, req); err != nil {
+			return err
+		}
+	}
+
+	return rw.flush()
+}
+
+func matches(m *domain.Metrics, sels []*promql.Selector) bool {
+	if len(sels) == 0 {
+		return true
+	}
+	labels := make(map[string]string, len(m.Labels)+1)
+	maps.Copy(labels, m.Labels)
+	labels[promql.NameLabel] = m.ID
+	for _, sel := range sels {
+		if sel.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Exporter) exportSeries(ctx context.Context, rw rowWriter, m *domain.Metrics, req *Request) error {
+	if !req.history() {
+		r := &row{Name: m.ID, Type: m.MType, Labels: m.Labels}
+		if !m.UpdatedAt.IsZero() {
+			at := m.UpdatedAt
+			r.Timestamp = &at
+		}
+		if v, ok := m.Number(); ok {
+			r.Value = &v
+		}
+		return rw.write(r)
+	}
+
+	if e.history == nil {
+		return nil
+	}
+	for _, p := range e.history.Points(ctx, m.Key()) {
+		if (!req.From.IsZero() && p.At.Before(req.From)) || (!req.To.IsZero() && p.At.After(req.To)) {
+			continue
+		}
+		at, v := p.At, p.Value
+		if err := rw.write(&row{Name: m.ID, Type: m.MType, Labels: m.Labels, Timestamp: &at, Value: &v}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// csvWriter колонки name,type,labels,timestamp,value. Метки - JSON объект,
+// время - RFC3339, пустое если неизвестно.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write([]string{"name", "type", "labels", "timestamp", "value"}); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) write(r *row) error {
+	labels := ""
+	if len(r.Labels) > 0 {
+		data, err := json.Marshal(r.Labels)
+		if err != nil {
+			return err
+		}
+		labels = string(data)
+	}
+	ts := ""
+	if r.Timestamp != nil {
+		ts = r.Timestamp.Format(time.RFC3339Nano)
+	}
+	value := ""
+	if r.Value != nil {
+		value = strconv.FormatFloat(*r.Value, 'g', -1, 64)
+	}
+
+	return c.w.Write([]string{r.Name, r.Type, labels, ts, value})
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter JSON объект на строку. NaN и Inf в JSON не представимы и пишутся как null.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) write(r *row) error {
+	if r.Value != nil && (math.IsNaN(*r.Value) || math.IsInf(*r.Value, 0)) {
+		r.Value = nil
+	}
+	return n.enc.Encode(r)
+}
+
+func (n *ndjsonWriter) flush() error {
+	return nil
+}
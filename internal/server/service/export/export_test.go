@@ -0,0 +1,108 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMetrics map[string]*domain.Metrics
+
+func (f fakeMetrics) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
+	return f, nil
+}
+
+func gauge(name string, v float64, at time.Time, labels map[string]string) *domain.Metrics {
+	return &domain.Metrics{ID: name, MType: domain.Gauge, Value: &v, Labels: labels, UpdatedAt: at}
+}
+
+func newTestExporter(start time.Time) *Exporter {
+	ctx := context.Background()
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	srv := fakeMetrics{}
+	for i := range 3 {
+		m := gauge("Alloc", float64(i), start.Add(time.Duration(i)*time.Minute), map[string]string{"host": "a"})
+		hist.Publish(ctx, []*domain.Metrics{m})
+		srv[m.Key()] = m
+	}
+	delta := int64(7)
+	srv["PollCount"] = &domain.Metrics{ID: "PollCount", MType: domain.Counter, Delta: &delta}
+	nan := gauge("Broken", math.NaN(), start, nil)
+	srv[nan.Key()] = nan
+
+	return NewExporter(ctx, srv, hist)
+}
+
+func TestExportCSV(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := newTestExporter(start)
+
+	var buf bytes.Buffer
+	require.NoError(t, e.Export(context.Background(), &buf, &Request{Format: FormatCSV, Match: []string{"Alloc", "Poll*"}}))
+	require.Equal(t, strings.Join([]string{
+		"name,type,labels,timestamp,value",
+		`Alloc,gauge,"{""host"":""a""}",2024-01-02T03:06:05Z,2`,
+		"PollCount,counter,,,7",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestExportNDJSONHistory(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := newTestExporter(start)
+
+	var buf bytes.Buffer
+	req := &Request{Format: FormatNDJSON, From: start.Add(30 * time.Second)}
+	require.NoError(t, e.Export(context.Background(), &buf, req))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var r struct {
+		Name      string            `json:"name"`
+		Labels    map[string]string `json:"labels"`
+		Timestamp time.Time         `json:"timestamp"`
+		Value     float64           `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &r))
+	require.Equal(t, "Alloc", r.Name)
+	require.Equal(t, map[string]string{"host": "a"}, r.Labels)
+	require.True(t, start.Add(time.Minute).Equal(r.Timestamp))
+	require.Equal(t, 1.0, r.Value)
+
+	// NaN в JSON пишется как null
+	buf.Reset()
+	require.NoError(t, e.Export(context.Background(), &buf, &Request{Format: FormatNDJSON, Match: []string{"Broken"}}))
+	require.Contains(t, buf.String(), `"value":null`)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name string
+		req  *Request
+		pass bool
+	}{
+		{name: "CSV", req: &Request{Format: FormatCSV}, pass: true},
+		{name: "Unknown format", req: &Request{Format: "parquet"}},
+		{name: "Bad match", req: &Request{Format: FormatCSV, Match: []string{"Alloc{"}}},
+		{name: "To before from", req: &Request{Format: FormatCSV, From: now, To: now.Add(-time.Second)}},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			_, err := Validate(tCase.req)
+			if tCase.pass {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrBadRequest)
+		})
+	}
+}
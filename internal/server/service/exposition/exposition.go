@@ -0,0 +1,179 @@
+// Package exposition выводит метрики в Prometheus text exposition format (0.0.4).
+package exposition
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Write выводит метрики, сгруппированные по имени. Для каждого имени пишется TYPE,
+// а HELP - если в метаданных есть описание или единица измерения.
+func Write(w io.Writer, metrics map[string]*domain.Metrics, meta map[string]*domain.Metadata) error {
+	families := make(map[string][]*domain.Metrics)
+	for _, key := range slices.Sorted(maps.Keys(metrics)) {
+		m := metrics[key]
+		families[m.ID] = append(families[m.ID], m)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, id := range slices.Sorted(maps.Keys(families)) {
+		series := families[id]
+		name := sanitizeName(id)
+		mType := series[0].MType
+
+		if help := helpText(meta[id]); help != "" {
+			bw.WriteString("# HELP " + name + " " + help + "\n")
+		}
+		bw.WriteString("# TYPE " + name + " " + promType(mType) + "\n")
+
+		for _, m := range series {
+			// серии другого типа под тем же именем Prometheus не примет
+			if m.MType != mType {
+				continue
+			}
+			writeSeries(bw, name, m)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeSeries(w *bufio.Writer, name string, m *domain.Metrics) {
+	switch m.MType {
+	case domain.Counter:
+		if m.Delta != nil {
+			writeSample(w, name, m.Labels, "", "", strconv.FormatInt(*m.Delta, 10))
+		}
+	case domain.Gauge:
+		if m.Value != nil {
+			writeSample(w, name, m.Labels, "", "", formatFloat(*m.Value))
+		}
+	case domain.Set:
+		if m.Set != nil {
+			writeSample(w, name, m.Labels, "", "", strconv.FormatUint(m.Set.Count(), 10))
+		}
+	case domain.Histogram:
+		if m.Histogram == nil {
+			return
+		}
+		h := m.Histogram
+		var cum uint64
+		for i, c := range h.Counts {
+			cum += c
+			le := "+Inf"
+			if i < len(h.Bounds) {
+				le = formatFloat(h.Bounds[i])
+			}
+			writeSample(w, name+"_bucket", m.Labels, "le", le, strconv.FormatUint(cum, 10))
+		}
+		writeSample(w, name+"_sum", m.Labels, "", "", formatFloat(h.Sum))
+		writeSample(w, name+"_count", m.Labels, "", "", strconv.FormatUint(h.Count, 10))
+	case domain.Summary:
+		if m.Summary != nil {
+			writeSummary(w, name, m.Labels, m.Summary, domain.DefaultQuantiles)
+		}
+	case domain.Timer:
+		if m.Timer != nil {
+			writeSummary(w, name, m.Labels, &m.Timer.SummaryData, domain.TimerQuantiles)
+		}
+	}
+}
+
+func writeSummary(w *bufio.Writer, name string, labels map[string]string, s *domain.SummaryData, quantiles []float64) {
+	if s.Count > 0 {
+		for _, q := range quantiles {
+			writeSample(w, name, labels, "quantile", formatFloat(q), formatFloat(s.Quantile(q)))
+		}
+	}
+	writeSample(w, name+"_sum", labels, "", "", formatFloat(s.Sum))
+	writeSample(w, name+"_count", labels, "", "", strconv.FormatUint(s.Count, 10))
+}
+
+// writeSample пишет строку name{labels,extra="value"} value.
+func writeSample(w *bufio.Writer, name string, labels map[string]string, extra, extraValue, value string) {
+	w.WriteString(name)
+	keys := slices.Sorted(maps.Keys(labels))
+	if len(keys) > 0 || extra != "" {
+		w.WriteString("{")
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			writeLabel(w, sanitizeName(k), labels[k])
+		}
+		if extra != "" {
+			if len(keys) > 0 {
+				w.WriteString(",")
+			}
+			writeLabel(w, extra, extraValue)
+		}
+		w.WriteString("}")
+	}
+	w.WriteString(" " + value + "\n")
+}
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name + `="`)
+	w.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+	w.WriteString(`"`)
+}
+
+// helpText собирает HELP из описания и единицы измерения: "Heap bytes allocated (bytes)".
+func helpText(meta *domain.Metadata) string {
+	if meta == nil {
+		return ""
+	}
+
+	help := meta.Description
+	if meta.Unit != "" {
+		help = strings.TrimSpace(help + " (" + meta.Unit + ")")
+	}
+
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func promType(mType string) string {
+	switch mType {
+	case domain.Counter, domain.Gauge, domain.Histogram, domain.Summary:
+		return mType
+	case domain.Timer:
+		return domain.Summary
+	default:
+		return "gauge"
+	}
+}
+
+// sanitizeName заменяет символы, недопустимые в имени Prometheus, на "_".
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		ok := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !ok {
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,46 @@
+package exposition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	alloc := float64(1024)
+	polls := int64(5)
+	h := domain.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	metrics := map[string]*domain.Metrics{
+		`HeapAlloc{host="a"}`: {ID: "HeapAlloc", MType: domain.Gauge, Value: &alloc, Labels: map[string]string{"host": "a"}},
+		"PollCount":           {ID: "PollCount", MType: domain.Counter, Delta: &polls},
+		"req.latency":         {ID: "req.latency", MType: domain.Histogram, Histogram: h},
+	}
+	meta := map[string]*domain.Metadata{
+		"HeapAlloc": {Name: "HeapAlloc", Unit: "bytes", Description: "Heap bytes allocated"},
+		"PollCount": {Name: "PollCount", Description: "Number of polls"},
+	}
+
+	var b strings.Builder
+	require.NoError(t, Write(&b, metrics, meta))
+
+	want := `# HELP HeapAlloc Heap bytes allocated (bytes)
+# TYPE HeapAlloc gauge
+HeapAlloc{host="a"} 1024
+# HELP PollCount Number of polls
+# TYPE PollCount counter
+PollCount 5
+# TYPE req_latency histogram
+req_latency_bucket{le="0.1"} 1
+req_latency_bucket{le="1"} 2
+req_latency_bucket{le="+Inf"} 3
+req_latency_sum 3.55
+req_latency_count 3
+`
+	require.Equal(t, want, b.String())
+}
@@ -0,0 +1,12 @@
+package federation
+
+type Config struct {
+	// ID имя сервера в цепочке федерации для защиты от петель, по умолчанию имя хоста.
+	ID string `json:"federationID" yaml:"federationID" env:"FEDERATION_ID"`
+	// SourcesFile YAML или JSON файл с источниками федерации и правилами агрегации.
+	SourcesFile string `json:"federationSources" yaml:"federationSources" env:"FEDERATION_SOURCES"`
+	// Interval как часто опрашивать источники и пересчитывать агрегаты, в секундах.
+	Interval int `json:"federationInterval" yaml:"federationInterval" env:"FEDERATION_INTERVAL" default:"30"`
+	// Staleness через сколько секунд без данных источник перестаёт участвовать в агрегатах gauge.
+	Staleness int `json:"federationStaleness" yaml:"federationStaleness" env:"FEDERATION_STALENESS" default:"300"`
+}
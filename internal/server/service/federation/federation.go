@@ -0,0 +1,596 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderSource имя источника в присланных обновлениях.
+	HeaderSource = "X-Metring-Source"
+	// HeaderVia серверы через запятую, через которые прошли данные, первым - отправитель.
+	HeaderVia = "X-Metring-Via"
+
+	// maxHops предел длины цепочки, длиннее считается петлёй.
+	maxHops     = 16
+	pullTimeout = 10 * time.Second
+)
+
+var (
+	ErrLoop          = errors.New("federation loop detected")
+	ErrUnknownSource = errors.New("unknown federation source")
+)
+
+type Metrics interface {
+	GetAll(ctx context.Context) (map[string]*domain.Metrics, error)
+	Updates(ctx context.Context, data []*domain.MetricsJSON) error
+}
+
+// Chain этот сервер и все серверы, от которых он прямо или через посредников
+// получает данные. Передаётся в HeaderVia, чтобы данные не вернулись туда, откуда пришли.
+type Chain struct {
+	id string
+
+	mu  sync.RWMutex
+	via map[string][]string
+}
+
+func NewChain(id string) *Chain {
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	return &Chain{id: id, via: make(map[string][]string)}
+}
+
+func (c *Chain) ID() string {
+	return c.id
+}
+
+// List возвращает цепочку: этот сервер первым, остальные по алфавиту.
+func (c *Chain) List() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, via := range c.via {
+		for _, id := range via {
+			if id != c.id {
+				seen[id] = true
+			}
+		}
+	}
+
+	return append([]string{c.id}, slices.Sorted(maps.Keys(seen))...)
+}
+
+func (c *Chain) set(source string, via []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.via[source] = via
+}
+
+// loops проверяет, прошли ли данные уже через этот сервер.
+func (c *Chain) loops(via []string) bool {
+	return slices.Contains(via, c.id) || len(via) > maxHops
+}
+
+// ParseVia разбирает заголовок HeaderVia.
+func ParseVia(h string) []string {
+	res := make([]string, 0)
+	for _, id := range strings.Split(h, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// series последнее значение серии источника без префикса.
+type series struct {
+	name   string
+	mType  string
+	labels map[string]string
+	value  float64
+	total  int64
+	// raw последний итог, полученный опросом, pulled - он известен
+	raw    int64
+	pulled bool
+}
+
+// pull переносит в total итог источника, полученный опросом. Итог меньше
+// предыдущего - сброс счётчика у источника, он прибавляется целиком. После рестарта
+// предыдущий итог неизвестен: меньший итог не уменьшает сохранённую сумму.
+func (s *series) pull(total int64) {
+	switch {
+	case !s.pulled:
+		s.total = max(s.total, total)
+	case total < s.raw:
+		s.total += total
+	default:
+		s.total += total - s.raw
+	}
+	s.raw, s.pulled = total, true
+}
+
+type sourceState struct {
+	lastSeen  time.Time
+	lastError string
+	via       []string
+	series    map[string]*series
+}
+
+// Status состояние источника.
+type Status struct {
+	Name string `json:"name"`
+	// Mode pull - опрашивается, push - присылает сам.
+	Mode      string    `json:"mode"`
+	Tenant    string    `json:"tenant,omitempty"`
+	LastSeen  time.Time `json:"lastSeen,omitzero"`
+	Stale     bool      `json:"stale"`
+	Series    int       `json:"series"`
+	Via       []string  `json:"via,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Federator собирает gauge и counter нижестоящих серверов. Каждая серия источника
+// пишется с его префиксом, а рядом - агрегат по всем источникам тенанта с префиксом
+// Settings.Aggregate: counter суммируются, gauge объединяются настроенной агрегацией.
+// Gauge устаревшего источника не участвуют в агрегате, его counter остаются в сумме,
+// чтобы сумма не уменьшалась.
+type Federator struct {
+	srv       Metrics
+	settings  *Settings
+	chain     *Chain
+	interval  time.Duration
+	staleness time.Duration
+	client    *http.Client
+
+	// wmu сериализует чтение сохранённых серий, расчёт и запись: иначе два источника
+	// посчитают прирост агрегата от одного и того же итога и он задвоится
+	wmu sync.Mutex
+
+	mu    sync.Mutex
+	state map[string]*sourceState
+	// seeded тенанты, counter источников которых восстановлены из хранилища
+	seeded map[string]bool
+	now    func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewFederator(ctx context.Context, cfg Config, srv Metrics, settings *Settings, chain *Chain) *Federator {
+	if settings == nil {
+		settings = &Settings{}
+		_ = settings.Compile()
+	}
+	f := &Federator{
+		srv:       srv,
+		settings:  settings,
+		chain:     chain,
+		interval:  time.Duration(cfg.Interval) * time.Second,
+		staleness: time.Duration(cfg.Staleness) * time.Second,
+		client:    &http.Client{Timeout: pullTimeout},
+		state:     make(map[string]*sourceState, len(settings.Sources)),
+		seeded:    make(map[string]bool),
+		now:       time.Now,
+		lg:        zctx.From(ctx).Named("federator"),
+	}
+	for _, src := range settings.Sources {
+		f.state[src.Name] = &sourceState{series: make(map[string]*series)}
+	}
+
+	return f
+}
+
+func (f *Federator) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(f.settings.Sources) == 0 || f.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			f.Pull(ctx)
+			f.Refresh(ctx)
+		}
+	}
+}
+
+// Pull опрашивает источники с URL.
+func (f *Federator) Pull(ctx context.Context) {
+	for _, src := range f.settings.Sources {
+		if !src.pull() {
+			continue
+		}
+		data, via, err := f.fetch(ctx, src)
+		if err == nil {
+			err = f.ingest(ctx, src, via, data, true)
+		}
+		if err != nil {
+			f.fail(src, err)
+		}
+	}
+}
+
+// Receive принимает обновления источника в формате /updates/: counter - приростами.
+func (f *Federator) Receive(ctx context.Context, source string, via []string, data []*domain.MetricsJSON) error {
+	idx := slices.IndexFunc(f.settings.Sources, func(s *Source) bool { return s.Name == source })
+	if idx < 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownSource, source)
+	}
+	src := f.settings.Sources[idx]
+	if src.pull() || src.Tenant != domain.TenantFromContext(ctx) {
+		return fmt.Errorf("%w: %q does not push to this tenant", ErrUnknownSource, source)
+	}
+	if len(via) == 0 {
+		via = []string{source}
+	}
+
+	if err := f.ingest(ctx, src, via, data, false); err != nil {
+		f.fail(src, err)
+		return err
+	}
+	return nil
+}
+
+// Snapshot текущие gauge и counter тенанта для вышестоящего сервера, counter - итогами.
+// via - цепочка запрашивающего, если он сам получает данные от этого сервера, это петля.
+func (f *Federator) Snapshot(ctx context.Context, via []string) ([]*domain.MetricsJSON, error) {
+	if len(via) > 0 && slices.Contains(f.chain.List(), via[0]) {
+		return nil, fmt.Errorf("%w: %s already sends data to %s", ErrLoop, via[0], f.chain.ID())
+	}
+
+	all, err := f.stored(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*domain.MetricsJSON, 0, len(all))
+	for _, key := range slices.Sorted(maps.Keys(all)) {
+		m := all[key]
+		d := &domain.MetricsJSON{ID: m.ID, MType: m.MType, Labels: m.Labels}
+		switch {
+		case m.MType == domain.Counter && m.Delta != nil:
+			total := *m.Delta
+			d.Delta = &total
+		case m.MType == domain.Gauge && m.Value != nil && !math.IsNaN(*m.Value) && !math.IsInf(*m.Value, 0):
+			v := *m.Value
+			d.Value = &v
+		default:
+			continue
+		}
+		res = append(res, d)
+	}
+
+	return res, nil
+}
+
+// Refresh пересчитывает агрегаты gauge, чтобы устаревшие источники выпали из них.
+func (f *Federator) Refresh(ctx context.Context) {
+	tenants := make(map[string]bool)
+	for _, src := range f.settings.Sources {
+		tenants[src.Tenant] = true
+	}
+
+	for tenant := range tenants {
+		f.refresh(domain.WithTenant(ctx, tenant), tenant)
+	}
+}
+
+// refresh пересчитывает агрегаты gauge одного тенанта.
+func (f *Federator) refresh(ctx context.Context, tenant string) {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	current, err := f.stored(ctx)
+	if err != nil {
+		f.lg.Error("error while reading metrics", zap.Error(err))
+		return
+	}
+
+	now := f.now()
+	targets := make(map[string]*series)
+	f.mu.Lock()
+	f.seed(tenant, current)
+	for _, src := range f.settings.Sources {
+		if src.Tenant != tenant {
+			continue
+		}
+		for key, s := range f.state[src.Name].series {
+			if s.mType != domain.Gauge {
+				continue
+			}
+			if agg := f.aggregate(tenant, key, now); agg != nil {
+				targets[domain.SeriesKey(agg.name, agg.labels)] = agg
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if err := f.write(ctx, current, targets); err != nil {
+		f.lg.Error("error while writing federated aggregates", zap.String("tenant", tenant), zap.Error(err))
+	}
+}
+
+// Sources возвращает состояние источников в порядке файла.
+func (f *Federator) Sources() []*Status {
+	now := f.now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := make([]*Status, 0, len(f.settings.Sources))
+	for _, src := range f.settings.Sources {
+		st := f.state[src.Name]
+		s := &Status{
+			Name:      src.Name,
+			Mode:      "push",
+			Tenant:    src.Tenant,
+			LastSeen:  st.lastSeen,
+			Stale:     !f.fresh(st, now),
+			Series:    len(st.series),
+			Via:       st.via,
+			LastError: st.lastError,
+		}
+		if src.pull() {
+			s.Mode = "pull"
+		}
+		res = append(res, s)
+	}
+
+	return res
+}
+
+// ingest обновляет серии источника и агрегаты по затронутым сериям. absolute - counter
+// пришли итогами (опрос), иначе приростами (push).
+func (f *Federator) ingest(ctx context.Context, src *Source, via []string, data []*domain.MetricsJSON, absolute bool) error {
+	if f.chain.loops(via) {
+		return fmt.Errorf("%w: %s is already in %s", ErrLoop, f.chain.ID(), strings.Join(via, ","))
+	}
+	ctx = domain.WithTenant(ctx, src.Tenant)
+
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	current, err := f.stored(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := f.now()
+	targets := make(map[string]*series)
+	touched := make(map[string]bool)
+
+	f.mu.Lock()
+	f.seed(src.Tenant, current)
+	st := f.state[src.Name]
+	for _, d := range data {
+		if (d.MType != domain.Gauge || d.Value == nil) && (d.MType != domain.Counter || d.Delta == nil) {
+			continue
+		}
+		key := domain.SeriesKey(d.ID, d.Labels)
+		name, labels := f.settings.prefixed(src.Name, d.ID, d.Labels)
+		pkey := domain.SeriesKey(name, labels)
+
+		s, ok := st.series[key]
+		if ok && s.mType != d.MType {
+			continue
+		}
+		if !ok {
+			s = &series{name: d.ID, mType: d.MType, labels: d.Labels}
+			st.series[key] = s
+		}
+		switch {
+		case d.MType == domain.Gauge:
+			s.value = *d.Value
+		case absolute:
+			s.pull(*d.Delta)
+		default:
+			s.total += *d.Delta
+		}
+
+		c := *s
+		c.name, c.labels = name, labels
+		targets[pkey] = &c
+		touched[key] = true
+	}
+	st.lastSeen = now
+	st.lastError = ""
+	st.via = via
+	for key := range touched {
+		if agg := f.aggregate(src.Tenant, key, now); agg != nil {
+			targets[domain.SeriesKey(agg.name, agg.labels)] = agg
+		}
+	}
+	f.mu.Unlock()
+	f.chain.set(src.Name, via)
+
+	return f.write(ctx, current, targets)
+}
+
+// seed восстанавливает counter источников тенанта из сохранённых серий, один раз
+// после старта: иначе агрегат посчитается только по приславшим и уменьшится.
+// Вызывается под блокировкой.
+func (f *Federator) seed(tenant string, current map[string]*domain.Metrics) {
+	if f.seeded[tenant] {
+		return
+	}
+	f.seeded[tenant] = true
+
+	for _, m := range current {
+		if m.MType != domain.Counter || m.Delta == nil {
+			continue
+		}
+		for _, src := range f.settings.Sources {
+			if src.Tenant != tenant {
+				continue
+			}
+			name, labels, ok := f.settings.unprefixed(src.Name, m.ID, m.Labels)
+			if !ok {
+				continue
+			}
+			key := domain.SeriesKey(name, labels)
+			if _, ok := f.state[src.Name].series[key]; !ok {
+				f.state[src.Name].series[key] = &series{name: name, mType: m.MType, labels: labels, total: *m.Delta}
+			}
+		}
+	}
+}
+
+// aggregate серия key по всем источникам тенанта, вызывается под блокировкой.
+func (f *Federator) aggregate(tenant, key string, now time.Time) *series {
+	var (
+		res    *series
+		values []float64
+	)
+	for _, src := range f.settings.Sources {
+		if src.Tenant != tenant {
+			continue
+		}
+		st := f.state[src.Name]
+		s, ok := st.series[key]
+		if !ok {
+			continue
+		}
+		if res == nil {
+			res = &series{name: s.name, mType: s.mType, labels: s.labels}
+		}
+		if s.mType != res.mType {
+			continue
+		}
+		if s.mType == domain.Counter {
+			res.total += s.total
+		} else if f.fresh(st, now) {
+			values = append(values, s.value)
+		}
+	}
+	if res == nil || (res.mType == domain.Gauge && len(values) == 0) {
+		return nil
+	}
+	if res.mType == domain.Gauge {
+		res.value = aggregations[f.settings.gaugeAggregation(res.name)](values)
+	}
+	res.name, res.labels = f.settings.prefixed(f.settings.Aggregate, res.name, res.labels)
+
+	return res
+}
+
+func (f *Federator) fresh(st *sourceState, now time.Time) bool {
+	return !st.lastSeen.IsZero() && (f.staleness <= 0 || now.Sub(st.lastSeen) <= f.staleness)
+}
+
+// write записывает серии с целевыми значениями, counter - приростом до целевого итога.
+// Серии, тип которых не совпадает с сохранённым, пропускаются.
+func (f *Federator) write(ctx context.Context, current map[string]*domain.Metrics, targets map[string]*series) error {
+	data := make([]*domain.MetricsJSON, 0, len(targets))
+	for _, key := range slices.Sorted(maps.Keys(targets)) {
+		t := targets[key]
+		cur, ok := current[key]
+		if ok && cur.MType != t.mType {
+			f.lg.Warn("federated series type conflicts with stored one", zap.String("series", key))
+			continue
+		}
+		d := &domain.MetricsJSON{ID: t.name, MType: t.mType, Labels: t.labels}
+		if t.mType == domain.Counter {
+			delta := t.total
+			if ok && cur.Delta != nil {
+				delta -= *cur.Delta
+			}
+			if ok && delta == 0 {
+				continue
+			}
+			d.Delta = &delta
+		} else {
+			v := t.value
+			d.Value = &v
+		}
+		data = append(data, d)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	return f.srv.Updates(ctx, data)
+}
+
+func (f *Federator) stored(ctx context.Context) (map[string]*domain.Metrics, error) {
+	all, err := f.srv.GetAll(ctx)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if errors.Is(err, er) {
+			return map[string]*domain.Metrics{}, nil
+		}
+		return nil, err
+	}
+	return all, nil
+}
+
+// fetch запрашивает снимок источника и его цепочку.
+func (f *Federator) fetch(ctx context.Context, src *Source) ([]*domain.MetricsJSON, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pullTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(src.URL, "/")+"/api/v1/federate", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range src.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(HeaderVia, strings.Join(f.chain.List(), ","))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		if resp.StatusCode == http.StatusLoopDetected {
+			return nil, nil, fmt.Errorf("%w: source %s gets data from this server", ErrLoop, src.Name)
+		}
+		return nil, nil, fmt.Errorf("source %s responded with %s", src.Name, resp.Status)
+	}
+
+	var data []*domain.MetricsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, nil, fmt.Errorf("can not decode snapshot of %s: %w", src.Name, err)
+	}
+
+	via := ParseVia(resp.Header.Get(HeaderVia))
+	if len(via) == 0 {
+		via = []string{src.Name}
+	}
+	return data, via, nil
+}
+
+func (f *Federator) fail(src *Source, err error) {
+	f.mu.Lock()
+	f.state[src.Name].lastError = err.Error()
+	f.mu.Unlock()
+
+	f.lg.Error("federation failed", zap.String("source", src.Name), zap.Error(err))
+}
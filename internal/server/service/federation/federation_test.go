@@ -0,0 +1,335 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{ID: id, MType: domain.Gauge, Value: &v}
+}
+
+func counter(id string, d int64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{ID: id, MType: domain.Counter, Delta: &d}
+}
+
+func settings(t *testing.T, s *Settings) *Settings {
+	t.Helper()
+	require.NoError(t, s.Compile())
+	return s
+}
+
+// values значения серий тенанта по ключу.
+func values(t *testing.T, ctx context.Context, srv *metrics.Metrics) map[string]float64 {
+	t.Helper()
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	res := make(map[string]float64, len(all))
+	for key, m := range all {
+		v, _ := m.Number()
+		res[key] = v
+	}
+	return res
+}
+
+func TestReceive(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	f := NewFederator(ctx, Config{Staleness: 300}, srv, settings(t, &Settings{
+		GaugeOverrides: map[string]string{"connections": AggSum},
+		Sources:        []*Source{{Name: "dc1"}, {Name: "dc2"}, {Name: "dc3", URL: "http://dc3:8080"}},
+	}), NewChain("root"))
+
+	require.NoError(t, f.Receive(ctx, "dc1", nil, []*domain.MetricsJSON{counter("requests", 3), gauge("load", 1), gauge("connections", 10)}))
+	require.NoError(t, f.Receive(ctx, "dc2", nil, []*domain.MetricsJSON{counter("requests", 5), gauge("load", 3), gauge("connections", 20)}))
+	require.NoError(t, f.Receive(ctx, "dc1", nil, []*domain.MetricsJSON{counter("requests", 2)}))
+
+	require.Equal(t, map[string]float64{
+		`requests{source="dc1"}`:    5,
+		`requests{source="dc2"}`:    5,
+		`requests{source="all"}`:    10,
+		`load{source="dc1"}`:        1,
+		`load{source="dc2"}`:        3,
+		`load{source="all"}`:        2,
+		`connections{source="dc1"}`: 10,
+		`connections{source="dc2"}`: 20,
+		`connections{source="all"}`: 30,
+	}, values(t, ctx, srv))
+
+	// опрашиваемый и неизвестный источники присылать не могут
+	require.ErrorIs(t, f.Receive(ctx, "dc3", nil, nil), ErrUnknownSource)
+	require.ErrorIs(t, f.Receive(ctx, "dc4", nil, nil), ErrUnknownSource)
+	require.ErrorIs(t, f.Receive(domain.WithTenant(ctx, "acme"), "dc1", nil, nil), ErrUnknownSource)
+}
+
+// slowMetrics сервис метрик с медленным чтением копий, как из базы,
+// чтобы параллельные записи пересекались.
+type slowMetrics struct {
+	*metrics.Metrics
+}
+
+func (s slowMetrics) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
+	all, err := s.Metrics.GetAll(ctx)
+	res := make(map[string]*domain.Metrics, len(all))
+	for k, m := range all {
+		c := *m
+		if m.Delta != nil {
+			d := *m.Delta
+			c.Delta = &d
+		}
+		res[k] = &c
+	}
+	time.Sleep(time.Millisecond)
+	return res, err
+}
+
+func TestReceiveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	// локальная серия под исходным именем не смешивается с агрегатом
+	require.NoError(t, srv.Update(ctx, counter("requests", 100)))
+	f := NewFederator(ctx, Config{Staleness: 300}, slowMetrics{srv}, settings(t, &Settings{
+		Sources: []*Source{{Name: "dc1"}, {Name: "dc2"}},
+	}), NewChain("root"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for range 50 {
+		for _, src := range []string{"dc1", "dc2"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- f.Receive(ctx, src, nil, []*domain.MetricsJSON{counter("requests", 1)})
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got := values(t, ctx, srv)
+	require.Equal(t, 50.0, got[`requests{source="dc1"}`])
+	require.Equal(t, 50.0, got[`requests{source="dc2"}`])
+	require.Equal(t, 100.0, got[`requests{source="all"}`])
+	require.Equal(t, 100.0, got[`requests`])
+}
+
+func TestPrefix(t *testing.T) {
+	s := settings(t, &Settings{})
+	name, labels := s.prefixed("dc1", "requests", map[string]string{"code": "200"})
+	require.Equal(t, "requests", name)
+	require.Equal(t, map[string]string{"code": "200", "source": "dc1"}, labels)
+
+	// метка уже проставлена нижестоящей федерацией
+	_, labels = s.prefixed("dc1", "requests", map[string]string{"source": "rack1"})
+	require.Equal(t, map[string]string{"source": "dc1/rack1"}, labels)
+
+	name, labels, ok := s.unprefixed("dc1", "requests", map[string]string{"code": "200", "source": "dc1"})
+	require.True(t, ok)
+	require.Equal(t, "requests", name)
+	require.Equal(t, map[string]string{"code": "200"}, labels)
+	_, labels, ok = s.unprefixed("dc1", "requests", map[string]string{"source": "dc1/rack1"})
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"source": "rack1"}, labels)
+	_, _, ok = s.unprefixed("dc1", "requests", map[string]string{"source": "dc10"})
+	require.False(t, ok)
+
+	s = settings(t, &Settings{Prefix: PrefixName})
+	name, labels = s.prefixed("dc1", "requests", nil)
+	require.Equal(t, "dc1:requests", name)
+	require.Nil(t, labels)
+	name, _, ok = s.unprefixed("dc1", "dc1:requests", nil)
+	require.True(t, ok)
+	require.Equal(t, "requests", name)
+}
+
+func TestRefreshStaleness(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	f := NewFederator(ctx, Config{Staleness: 60}, srv, settings(t, &Settings{
+		Gauges:  AggMax,
+		Sources: []*Source{{Name: "dc1"}, {Name: "dc2"}},
+	}), NewChain("root"))
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	require.NoError(t, f.Receive(ctx, "dc1", nil, []*domain.MetricsJSON{gauge("load", 5), counter("requests", 1)}))
+	now = now.Add(50 * time.Second)
+	require.NoError(t, f.Receive(ctx, "dc2", nil, []*domain.MetricsJSON{gauge("load", 2), counter("requests", 2)}))
+	require.Equal(t, 5.0, values(t, ctx, srv)[`load{source="all"}`])
+
+	// dc1 устарел: его gauge выпадает из агрегата, counter остаётся в сумме
+	now = now.Add(20 * time.Second)
+	f.Refresh(ctx)
+	require.Equal(t, 2.0, values(t, ctx, srv)[`load{source="all"}`])
+	require.Equal(t, 3.0, values(t, ctx, srv)[`requests{source="all"}`])
+
+	stale := make(map[string]bool)
+	for _, s := range f.Sources() {
+		stale[s.Name] = s.Stale
+	}
+	require.Equal(t, map[string]bool{"dc1": true, "dc2": false}, stale)
+}
+
+func TestPull(t *testing.T) {
+	ctx := context.Background()
+
+	// нижестоящий сервер со своим федератором
+	leafSrv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	require.NoError(t, leafSrv.Updates(ctx, []*domain.MetricsJSON{counter("requests", 7), gauge("load", 0.5)}))
+	leaf := NewFederator(ctx, Config{}, leafSrv, nil, NewChain("leaf"))
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/federate", r.URL.Path)
+		data, err := leaf.Snapshot(r.Context(), ParseVia(r.Header.Get(HeaderVia)))
+		if err != nil {
+			w.WriteHeader(http.StatusLoopDetected)
+			return
+		}
+		w.Header().Set(HeaderVia, "leaf")
+		require.NoError(t, json.NewEncoder(w).Encode(data))
+	}))
+	defer source.Close()
+
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	chain := NewChain("root")
+	f := NewFederator(ctx, Config{}, srv, settings(t, &Settings{
+		Prefix:  PrefixName,
+		Sources: []*Source{{Name: "dc1", URL: source.URL}},
+	}), chain)
+
+	// опрос итогами не удваивает counter
+	f.Pull(ctx)
+	f.Pull(ctx)
+	require.Equal(t, map[string]float64{
+		"dc1:requests": 7,
+		"dc1:load":     0.5,
+		"all:requests": 7,
+		"all:load":     0.5,
+	}, values(t, ctx, srv))
+	require.Equal(t, []string{"root", "leaf"}, chain.List())
+	require.Equal(t, []string{"leaf"}, f.Sources()[0].Via)
+	require.Empty(t, f.Sources()[0].LastError)
+
+	// leaf сам начинает получать данные от root: петля
+	leaf.chain.set("up", []string{"root"})
+	f.Pull(ctx)
+	require.Contains(t, f.Sources()[0].LastError, ErrLoop.Error())
+}
+
+func TestRestart(t *testing.T) {
+	ctx := context.Background()
+
+	// итог опрашиваемого источника, сбрасывается при его рестарте
+	var total int64
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode([]*domain.MetricsJSON{counter("requests", total)}))
+	}))
+	defer source.Close()
+
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	set := settings(t, &Settings{
+		Sources: []*Source{{Name: "dc1"}, {Name: "dc2", URL: source.URL}},
+	})
+	f := NewFederator(ctx, Config{}, srv, set, NewChain("root"))
+	require.NoError(t, f.Receive(ctx, "dc1", nil, []*domain.MetricsJSON{counter("requests", 5)}))
+	total = 10
+	f.Pull(ctx)
+	require.Equal(t, 15.0, values(t, ctx, srv)[`requests{source="all"}`])
+
+	// после рестарта первым присылает dc1: сумма не теряет сохранённый итог dc2
+	f = NewFederator(ctx, Config{}, srv, set, NewChain("root"))
+	require.NoError(t, f.Receive(ctx, "dc1", nil, []*domain.MetricsJSON{counter("requests", 1)}))
+	require.Equal(t, map[string]float64{
+		`requests{source="dc1"}`: 6,
+		`requests{source="dc2"}`: 10,
+		`requests{source="all"}`: 16,
+	}, values(t, ctx, srv))
+
+	// dc2 продолжил счёт
+	total = 12
+	f.Pull(ctx)
+	require.Equal(t, 18.0, values(t, ctx, srv)[`requests{source="all"}`])
+
+	// dc2 перезапустился: меньший итог прибавляется, а не уменьшает сумму
+	total = 3
+	f.Pull(ctx)
+	total = 4
+	f.Pull(ctx)
+	require.Equal(t, map[string]float64{
+		`requests{source="dc1"}`: 6,
+		`requests{source="dc2"}`: 16,
+		`requests{source="all"}`: 22,
+	}, values(t, ctx, srv))
+}
+
+func TestLoops(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	chain := NewChain("mid")
+	f := NewFederator(ctx, Config{}, srv, settings(t, &Settings{Sources: []*Source{{Name: "dc1"}}}), chain)
+
+	// данные уже проходили через этот сервер
+	require.ErrorIs(t, f.Receive(ctx, "dc1", []string{"dc1", "mid"}, []*domain.MetricsJSON{gauge("load", 1)}), ErrLoop)
+
+	require.NoError(t, f.Receive(ctx, "dc1", []string{"dc1", "rack1"}, []*domain.MetricsJSON{gauge("load", 1)}))
+	require.Equal(t, []string{"mid", "dc1", "rack1"}, chain.List())
+
+	// снимок не отдаётся серверу, от которого сюда идут данные
+	_, err := f.Snapshot(ctx, []string{"rack1"})
+	require.ErrorIs(t, err, ErrLoop)
+	data, err := f.Snapshot(ctx, []string{"root"})
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+}
+
+func TestLoadSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "federation.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+gauges: max
+gaugeOverrides:
+  connections: sum
+sources:
+  - name: dc1
+    url: http://metring-dc1:8080
+    headers:
+      X-API-Key: secret
+  - name: dc2
+    tenant: acme
+`), 0o600))
+
+	s, err := LoadSettings(path)
+	require.NoError(t, err)
+	require.Equal(t, PrefixLabel, s.Prefix)
+	require.Equal(t, "source", s.Label)
+	require.Equal(t, AggMax, s.gaugeAggregation("load"))
+	require.Equal(t, AggSum, s.gaugeAggregation("connections"))
+	require.True(t, s.Sources[0].pull())
+	require.Equal(t, "acme", s.Sources[1].Tenant)
+
+	for _, s := range []*Settings{
+		{Prefix: "tag"},
+		{Gauges: "median"},
+		{GaugeOverrides: map[string]string{"load": "p99"}},
+		{Sources: []*Source{{Name: "dc 1"}}},
+		{Sources: []*Source{{Name: "dc1"}, {Name: "dc1"}}},
+		{Sources: []*Source{{Name: "dc1", URL: "metring:8080"}}},
+		{Sources: []*Source{{Name: "all"}}},
+		{Aggregate: "a b"},
+	} {
+		require.Error(t, s.Compile())
+	}
+}
@@ -0,0 +1,224 @@
+package federation
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Как помечаются серии источника.
+const (
+	// PrefixLabel метка источника, уже существующая метка получает префикс "источник/".
+	PrefixLabel = "label"
+	// PrefixName имя серии с префиксом "источник:".
+	PrefixName = "name"
+)
+
+// Агрегации gauge по источникам.
+const (
+	AggSum = "sum"
+	AggAvg = "avg"
+	AggMin = "min"
+	AggMax = "max"
+)
+
+const (
+	defaultLabel     = "source"
+	defaultAggregate = "all"
+)
+
+var sourceName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Source нижестоящий сервер. С URL он опрашивается через /api/v1/federate,
+// без URL сам присылает обновления на /api/v1/federate.
+type Source struct {
+	Name    string            `json:"name" yaml:"name"`
+	URL     string            `json:"url,omitempty" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	// Tenant тенант, в который пишутся серии источника. Присылать обновления
+	// источник может только с ключом этого тенанта.
+	Tenant string `json:"tenant,omitempty" yaml:"tenant"`
+}
+
+func (s *Source) pull() bool {
+	return s.URL != ""
+}
+
+// Settings источники и правила объединения их серий:
+//
+//	prefix: label
+//	aggregate: all
+//	gauges: avg
+//	gaugeOverrides:
+//	  connections: sum
+//	sources:
+//	  - name: dc1
+//	    url: http://metring-dc1:8080
+//	  - name: dc2
+type Settings struct {
+	// Prefix label (по умолчанию) или name.
+	Prefix string `json:"prefix,omitempty" yaml:"prefix"`
+	// Label имя метки источника, по умолчанию source.
+	Label string `json:"label,omitempty" yaml:"label"`
+	// Aggregate под каким источником пишутся агрегаты, по умолчанию all: requests{source="all"}
+	// или all:requests. Так агрегат не смешивается с локальной серией под исходным именем.
+	Aggregate string `json:"aggregate,omitempty" yaml:"aggregate"`
+	// Gauges агрегация gauge: sum, avg (по умолчанию), min или max. Counter всегда суммируются.
+	Gauges string `json:"gauges,omitempty" yaml:"gauges"`
+	// GaugeOverrides агрегация gauge по имени метрики.
+	GaugeOverrides map[string]string `json:"gaugeOverrides,omitempty" yaml:"gaugeOverrides"`
+	Sources        []*Source         `json:"sources" yaml:"sources"`
+}
+
+// LoadSettings читает источники из YAML или JSON файла.
+func LoadSettings(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read federation sources file: %w", err)
+	}
+
+	var s Settings
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("can not parse federation sources file: %w", err)
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Compile проверяет настройки и подставляет значения по умолчанию.
+func (s *Settings) Compile() error {
+	switch s.Prefix {
+	case "":
+		s.Prefix = PrefixLabel
+	case PrefixLabel, PrefixName:
+	default:
+		return fmt.Errorf("federation: prefix must be label or name, got %q", s.Prefix)
+	}
+	if s.Label == "" {
+		s.Label = defaultLabel
+	}
+	if s.Aggregate == "" {
+		s.Aggregate = defaultAggregate
+	}
+	if !sourceName.MatchString(s.Aggregate) {
+		return fmt.Errorf("federation: bad aggregate name %q", s.Aggregate)
+	}
+	if s.Gauges == "" {
+		s.Gauges = AggAvg
+	}
+	for _, agg := range append([]string{s.Gauges}, slices.Collect(maps.Values(s.GaugeOverrides))...) {
+		if _, ok := aggregations[agg]; !ok {
+			return fmt.Errorf("federation: unknown gauge aggregation %q", agg)
+		}
+	}
+
+	names := make(map[string]bool, len(s.Sources))
+	for _, src := range s.Sources {
+		if !sourceName.MatchString(src.Name) {
+			return fmt.Errorf("federation: bad source name %q", src.Name)
+		}
+		if names[src.Name] {
+			return fmt.Errorf("federation: duplicate source %q", src.Name)
+		}
+		if src.Name == s.Aggregate {
+			return fmt.Errorf("federation: source %q is named like aggregates", src.Name)
+		}
+		names[src.Name] = true
+		if src.URL != "" {
+			u, err := url.Parse(src.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("federation: source %s: bad url %q", src.Name, src.URL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// prefixed имя и метки серии источника.
+func (s *Settings) prefixed(source, name string, labels map[string]string) (string, map[string]string) {
+	if s.Prefix == PrefixName {
+		return source + ":" + name, labels
+	}
+	res := make(map[string]string, len(labels)+1)
+	maps.Copy(res, labels)
+	if v, ok := res[s.Label]; ok {
+		res[s.Label] = source + "/" + v
+	} else {
+		res[s.Label] = source
+	}
+	return name, res
+}
+
+// unprefixed имя и метки серии источника без его префикса, false - серия не его.
+func (s *Settings) unprefixed(source, name string, labels map[string]string) (string, map[string]string, bool) {
+	if s.Prefix == PrefixName {
+		name, ok := strings.CutPrefix(name, source+":")
+		return name, labels, ok
+	}
+	v, ok := labels[s.Label]
+	if !ok {
+		return "", nil, false
+	}
+	res := maps.Clone(labels)
+	switch rest, cut := strings.CutPrefix(v, source+"/"); {
+	case v == source:
+		delete(res, s.Label)
+	case cut:
+		res[s.Label] = rest
+	default:
+		return "", nil, false
+	}
+	if len(res) == 0 {
+		res = nil
+	}
+	return name, res, true
+}
+
+func (s *Settings) gaugeAggregation(name string) string {
+	if agg, ok := s.GaugeOverrides[name]; ok {
+		return agg
+	}
+	return s.Gauges
+}
+
+var aggregations = map[string]func(values []float64) float64{
+	AggSum: func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	AggAvg: func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	AggMin: func(values []float64) float64 {
+		res := math.Inf(1)
+		for _, v := range values {
+			res = math.Min(res, v)
+		}
+		return res
+	},
+	AggMax: func(values []float64) float64 {
+		res := math.Inf(-1)
+		for _, v := range values {
+			res = math.Max(res, v)
+		}
+		return res
+	},
+}
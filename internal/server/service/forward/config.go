@@ -0,0 +1,6 @@
+package forward
+
+type Config struct {
+	// SinksFile YAML или JSON файл с приёмниками, в которые пересылаются принятые обновления.
+	SinksFile string `json:"forwardSinks" yaml:"forwardSinks" env:"FORWARD_SINKS"`
+}
@@ -0,0 +1,306 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/federation"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// maxBackoff предел задержки между повторами.
+const maxBackoff = time.Minute
+
+// Stats состояние очереди приёмника с момента запуска.
+type Stats struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Queued int    `json:"queued"`
+	// Sent сколько обновлений доставлено
+	Sent uint64 `json:"sent"`
+	// Dropped сколько обновлений отброшено из-за полной очереди
+	Dropped uint64 `json:"dropped"`
+	// Failed сколько обновлений не доставлено после всех попыток
+	Failed      uint64    `json:"failed"`
+	Retries     uint64    `json:"retries"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+}
+
+// queue очередь одного приёмника, разбирается своей горутиной.
+type queue struct {
+	sink   *Sink
+	ch     chan *item
+	client *http.Client
+	via    func() []string
+
+	mu    sync.Mutex
+	stats Stats
+
+	lg *zap.Logger
+}
+
+// Forwarder пересылает принятые обновления в приёмники. Подключается к сервису метрик
+// подписчиком, поэтому видит только успешно записанные обновления. Очереди в памяти
+// и при рестарте теряются.
+type Forwarder struct {
+	queues []*queue
+	lg     *zap.Logger
+}
+
+type Option func(f *Forwarder)
+
+// WithVia задаёт цепочку федерации, которая отправляется приёмникам с Source
+// для защиты от петель.
+func WithVia(via func() []string) Option {
+	return func(f *Forwarder) {
+		for _, q := range f.queues {
+			q.via = via
+		}
+	}
+}
+
+func NewForwarder(ctx context.Context, sinks []*Sink, opts ...Option) *Forwarder {
+	lg := zctx.From(ctx).Named("forwarder")
+	f := &Forwarder{lg: lg, queues: make([]*queue, 0, len(sinks))}
+	for _, s := range sinks {
+		f.queues = append(f.queues, &queue{
+			sink:   s,
+			ch:     make(chan *item, s.QueueSize),
+			client: &http.Client{Timeout: s.timeout},
+			stats:  Stats{Name: s.Name, Type: s.Type},
+			lg:     lg.With(zap.String("sink", s.Name)),
+		})
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Run разбирает очереди приёмников, пока не отменён ctx. Остаток очереди
+// при остановке отправляется одной попыткой.
+func (f *Forwarder) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var qwg sync.WaitGroup
+	for _, q := range f.queues {
+		qwg.Add(1)
+		go q.run(ctx, &qwg)
+	}
+	qwg.Wait()
+	f.lg.Info("got cancellation, returning")
+}
+
+// Publish пересылает обновления без приростов counter, в metring они не попадут.
+func (f *Forwarder) Publish(ctx context.Context, metrics []*domain.Metrics) {
+	f.PublishIncrements(ctx, metrics, nil)
+}
+
+// PublishIncrements ставит обновления в очереди приёмников. Не блокируется:
+// если очередь полна, обновление отбрасывается и учитывается в Dropped.
+func (f *Forwarder) PublishIncrements(ctx context.Context, metrics []*domain.Metrics, increments map[string]int64) {
+	if len(f.queues) == 0 {
+		return
+	}
+	tenant := domain.TenantFromContext(ctx)
+	for _, m := range metrics {
+		it := newItem(tenant, m, increments)
+		for _, q := range f.queues {
+			if q.sink.accepts(it) {
+				q.push(it)
+			}
+		}
+	}
+}
+
+// Stats возвращает состояние очередей в порядке приёмников в файле.
+func (f *Forwarder) Stats() []*Stats {
+	res := make([]*Stats, 0, len(f.queues))
+	for _, q := range f.queues {
+		q.mu.Lock()
+		s := q.stats
+		q.mu.Unlock()
+		s.Queued = len(q.ch)
+		res = append(res, &s)
+	}
+
+	return res
+}
+
+func (q *queue) push(it *item) {
+	select {
+	case q.ch <- it:
+	default:
+		q.mu.Lock()
+		q.stats.Dropped++
+		q.mu.Unlock()
+	}
+}
+
+func (q *queue) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(q.sink.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*item, 0, q.sink.BatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			q.drain(batch)
+			return
+		case it := <-q.ch:
+			batch = append(batch, it)
+			if len(batch) >= q.sink.BatchSize {
+				q.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// drain отправляет остаток очереди одной попыткой на пачку.
+func (q *queue) drain(batch []*item) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.sink.timeout)
+	defer cancel()
+
+	for {
+		select {
+		case it := <-q.ch:
+			batch = append(batch, it)
+			if len(batch) < q.sink.BatchSize {
+				continue
+			}
+		default:
+		}
+		if len(batch) == 0 {
+			return
+		}
+		_, err := q.send(ctx, batch)
+		q.report(len(batch), err, true)
+		batch = batch[:0]
+		if err != nil {
+			q.lg.Error("forwarding on shutdown failed", zap.Int("dropped", len(q.ch)), zap.Error(err))
+			return
+		}
+	}
+}
+
+// flush отправляет пачку. Ошибки сети, 5xx и 429 повторяются с удвоением задержки,
+// остальные ответы не повторяются.
+func (q *queue) flush(ctx context.Context, batch []*item) {
+	wait := q.sink.retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := q.send(ctx, batch)
+		last := err == nil || !retry || attempt >= q.sink.MaxRetry
+		q.report(len(batch), err, last)
+		if last {
+			return
+		}
+
+		q.lg.Warn("forwarding failed, will retry", zap.Int("attempt", attempt), zap.Duration("wait", wait), zap.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// пачка уйдёт при остановке вместе с остатком очереди
+			q.drain(batch)
+			return
+		case <-timer.C:
+		}
+		wait = min(wait*2, maxBackoff)
+	}
+}
+
+// report учитывает результат попытки, final - больше попыток не будет.
+func (q *queue) report(n int, err error, final bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch {
+	case err == nil:
+		q.stats.Sent += uint64(n)
+		q.stats.LastSuccess = time.Now()
+	case final:
+		q.stats.Failed += uint64(n)
+		q.stats.LastError = err.Error()
+		q.lg.Error("forwarding failed, batch dropped", zap.Int("size", n), zap.Error(err))
+	default:
+		q.stats.Retries++
+		q.stats.LastError = err.Error()
+	}
+}
+
+// send возвращает ошибку и стоит ли повторять отправку.
+func (q *queue) send(ctx context.Context, batch []*item) (bool, error) {
+	req, err := q.sink.encode(batch)
+	if err != nil {
+		return false, fmt.Errorf("can not encode batch: %w", err)
+	}
+	if q.sink.Type == TypeFile {
+		return true, appendFile(q.sink.Path, req.body)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, q.sink.timeout)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, q.sink.URL, bytes.NewReader(req.body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range req.headers {
+		hreq.Header.Set(k, v)
+	}
+	for k, v := range q.sink.Headers {
+		hreq.Header.Set(k, v)
+	}
+	if q.sink.Source != "" {
+		hreq.Header.Set(federation.HeaderSource, q.sink.Source)
+		if q.via != nil {
+			hreq.Header.Set(federation.HeaderVia, strings.Join(q.via(), ","))
+		}
+	}
+
+	resp, err := q.client.Do(hreq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+
+	return retry, fmt.Errorf("sink %s responded with %s", q.sink.Name, resp.Status)
+}
+
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
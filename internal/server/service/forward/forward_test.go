@@ -0,0 +1,280 @@
+package forward
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/remotewrite"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+)
+
+func sink(t *testing.T, s *Sink) *Sink {
+	t.Helper()
+	if s.FlushInterval == "" {
+		s.FlushInterval = "10ms"
+	}
+	if s.RetryBackoff == "" {
+		s.RetryBackoff = "1ms"
+	}
+	require.NoError(t, s.Compile())
+	return s
+}
+
+// start запускает пересылку и возвращает функцию остановки.
+func start(f *Forwarder) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.Run(ctx, &wg)
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func gauge(id string, v float64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{ID: id, MType: domain.Gauge, Value: &v}
+}
+
+func counter(id string, d int64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{ID: id, MType: domain.Counter, Delta: &d}
+}
+
+func TestForwardMetring(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []*domain.MetricsJSON
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/updates/", r.URL.Path)
+		require.Equal(t, "secret", r.Header.Get("X-API-Key"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var data []*domain.MetricsJSON
+		require.NoError(t, json.NewDecoder(zr).Decode(&data))
+
+		mu.Lock()
+		received = append(received, data...)
+		mu.Unlock()
+	}))
+	defer target.Close()
+
+	ctx := context.Background()
+	f := NewForwarder(ctx, []*Sink{sink(t, &Sink{Name: "new", Type: TypeMetring, URL: target.URL, Headers: map[string]string{"X-API-Key": "secret"}})})
+	stop := start(f)
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage(), metrics.WithPublisher(f))
+
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{counter("PollCount", 3), gauge("Alloc", 1.5)}))
+	require.NoError(t, srv.Update(ctx, counter("PollCount", 2)))
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "latency", MType: domain.Histogram, Value: new(float64)}))
+
+	require.Eventually(t, func() bool {
+		return f.Stats()[0].Sent == 3
+	}, time.Second, 10*time.Millisecond)
+	stop()
+
+	// counter повторяется приростами, а не накопленным итогом, распределения не пересылаются
+	mu.Lock()
+	defer mu.Unlock()
+	deltas := make([]int64, 0)
+	for _, m := range received {
+		switch m.MType {
+		case domain.Counter:
+			deltas = append(deltas, *m.Delta)
+		case domain.Gauge:
+			require.Equal(t, 1.5, *m.Value)
+		default:
+			t.Fatalf("unexpected %s %s", m.MType, m.ID)
+		}
+	}
+	require.Equal(t, []int64{3, 2}, deltas)
+}
+
+func TestForwardFederation(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/federate", r.URL.Path)
+		headers <- r.Header
+	}))
+	defer target.Close()
+
+	f := NewForwarder(context.Background(),
+		[]*Sink{sink(t, &Sink{Name: "root", Type: TypeMetring, URL: target.URL, Source: "dc1"})},
+		WithVia(func() []string { return []string{"dc1", "rack1"} }),
+	)
+	stop := start(f)
+	defer stop()
+
+	f.Publish(context.Background(), []*domain.Metrics{{ID: "Alloc", MType: domain.Gauge, Value: new(float64)}})
+
+	h := <-headers
+	require.Equal(t, "dc1", h.Get("X-Metring-Source"))
+	require.Equal(t, "dc1,rack1", h.Get("X-Metring-Via"))
+}
+
+func TestForwardRemoteWrite(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+
+	f := NewForwarder(context.Background(), []*Sink{sink(t, &Sink{Name: "prom", Type: TypeRemoteWrite, URL: target.URL})})
+	stop := start(f)
+	defer stop()
+
+	at := time.UnixMilli(1704164645000)
+	v := int64(42)
+	ctx := domain.WithTenant(context.Background(), "acme")
+	f.Publish(ctx, []*domain.Metrics{{ID: "http.requests", MType: domain.Counter, Delta: &v, Labels: map[string]string{"code": "200"}, UpdatedAt: at}})
+
+	req := &remotewrite.WriteRequest{}
+	require.NoError(t, req.Unmarshal(<-bodies))
+	require.Equal(t, []remotewrite.TimeSeries{{
+		Labels: []remotewrite.Label{
+			{Name: "__name__", Value: "http_requests"},
+			{Name: "code", Value: "200"},
+			{Name: "tenant", Value: "acme"},
+		},
+		Samples: []remotewrite.Sample{{Value: 42, Timestamp: at.UnixMilli()}},
+	}}, req.Timeseries)
+	require.Equal(t, remotewrite.MetricTypeCounter, req.Metadata[0].Type)
+}
+
+func TestEncodeInflux(t *testing.T) {
+	at := time.Unix(1704164645, 0)
+	req := encodeInflux([]*item{
+		{tenant: domain.DefaultTenant, name: "cpu load", mType: domain.Gauge, labels: map[string]string{"host": "a,b"}, value: 0.5, at: at},
+		{tenant: domain.DefaultTenant, name: "PollCount", mType: domain.Counter, value: 7, at: at},
+		{tenant: domain.DefaultTenant, name: "latency", mType: domain.Histogram, value: 3, at: at},
+	})
+	require.Equal(t, strings.Join([]string{
+		`cpu\ load,host=a\,b value=0.5 1704164645000000000`,
+		`PollCount value=7i 1704164645000000000`,
+		`latency count=3i 1704164645000000000`,
+		"",
+	}, "\n"), string(req.body))
+}
+
+func TestForwardFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forward.ndjson")
+	f := NewForwarder(context.Background(), []*Sink{sink(t, &Sink{Name: "file", Type: TypeFile, Path: path, BatchSize: 100, FlushInterval: "1h"})})
+	stop := start(f)
+
+	v := 1.5
+	f.Publish(context.Background(), []*domain.Metrics{{ID: "Alloc", MType: domain.Gauge, Value: &v, UpdatedAt: time.Unix(1704164645, 0).UTC()}})
+	// при остановке неполная пачка дописывается
+	stop()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"Alloc","type":"gauge","timestamp":"2024-01-02T03:04:05Z","value":1.5}`+"\n", string(data))
+	require.Equal(t, uint64(1), f.Stats()[0].Sent)
+}
+
+func TestForwardRetries(t *testing.T) {
+	var calls atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer target.Close()
+
+	f := NewForwarder(context.Background(), []*Sink{sink(t, &Sink{Name: "influx", Type: TypeInflux, URL: target.URL, BatchSize: 1})})
+	stop := start(f)
+	defer stop()
+
+	v := 1.0
+	m := []*domain.Metrics{{ID: "Alloc", MType: domain.Gauge, Value: &v}}
+	// 503 повторяется
+	f.Publish(context.Background(), m)
+	require.Eventually(t, func() bool { return f.Stats()[0].Sent == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, uint64(1), f.Stats()[0].Retries)
+
+	// 400 не повторяется
+	f.Publish(context.Background(), m)
+	require.Eventually(t, func() bool { return f.Stats()[0].Failed == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, int32(3), calls.Load())
+	require.Contains(t, f.Stats()[0].LastError, "400")
+}
+
+func TestForwardQueueFull(t *testing.T) {
+	// без запущенного Run очередь не разбирается
+	f := NewForwarder(context.Background(), []*Sink{
+		sink(t, &Sink{Name: "small", Type: TypeFile, Path: filepath.Join(t.TempDir(), "f"), QueueSize: 2}),
+		sink(t, &Sink{Name: "other", Type: TypeFile, Path: filepath.Join(t.TempDir(), "f"), Tenants: []string{"acme"}}),
+	})
+
+	v := 1.0
+	for range 5 {
+		f.Publish(context.Background(), []*domain.Metrics{{ID: "Alloc", MType: domain.Gauge, Value: &v}})
+	}
+
+	stats := f.Stats()
+	require.Equal(t, 2, stats[0].Queued)
+	require.Equal(t, uint64(3), stats[0].Dropped)
+	// чужой тенант не пересылается
+	require.Equal(t, 0, stats[1].Queued)
+	require.Equal(t, uint64(0), stats[1].Dropped)
+}
+
+func TestLoadSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sinks.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+sinks:
+  - name: old
+    type: metring
+    url: http://old:8080
+  - name: influx
+    type: influx
+    url: http://influx:8086/api/v2/write?bucket=metrics
+    batchSize: 100
+    flushInterval: 1s
+`), 0o600))
+
+	sinks, err := LoadSinks(path)
+	require.NoError(t, err)
+	require.Len(t, sinks, 2)
+	require.Equal(t, "http://old:8080/updates/", sinks[0].URL)
+	require.Equal(t, defaultBatchSize, sinks[0].BatchSize)
+	require.Equal(t, 100, sinks[1].BatchSize)
+	require.Equal(t, time.Second, sinks[1].flushInterval)
+
+	for _, s := range []*Sink{
+		{Name: "a", Type: "kafka", URL: "http://x"},
+		{Name: "a", Type: TypeInflux, URL: "influx:8086"},
+		{Name: "a", Type: TypeFile},
+		{Name: "a", Type: TypeFile, Path: "f", Timeout: "soon"},
+		{Type: TypeFile, Path: "f"},
+		{Name: "a", Type: TypeInflux, URL: "http://influx:8086", Source: "dc1"},
+	} {
+		require.Error(t, s.Compile())
+	}
+}
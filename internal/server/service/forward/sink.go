@@ -0,0 +1,415 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/remotewrite"
+	"github.com/golang/snappy"
+	"gopkg.in/yaml.v3"
+)
+
+// Типы приёмников.
+const (
+	// TypeMetring другой сервер metring, обновления повторяются через /updates/.
+	TypeMetring = "metring"
+	// TypeRemoteWrite Prometheus remote_write.
+	TypeRemoteWrite = "remote_write"
+	// TypeInflux InfluxDB line protocol.
+	TypeInflux = "influx"
+	// TypeFile NDJSON файл, строки дописываются в конец.
+	TypeFile = "file"
+)
+
+// Значения по умолчанию для настроек приёмника.
+const (
+	defaultBatchSize     = 500
+	defaultQueueSize     = 10000
+	defaultMaxRetry      = 5
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+	defaultRetryBackoff  = time.Second
+)
+
+// Sink приёмник пересылки. У каждого своя очередь, пачки и повторы:
+//
+//	name: new-backend
+//	type: remote_write
+//	url: http://victoria:8428/api/v1/write
+//
+// Для metring url - адрес сервера, обновления уходят на /updates/, а с Source -
+// на /api/v1/federate вышестоящего сервера федерации.
+type Sink struct {
+	Name string `json:"name" yaml:"name"`
+	// Type metring, remote_write, influx или file.
+	Type string `json:"type" yaml:"type"`
+	URL  string `json:"url,omitempty" yaml:"url"`
+	// Path файл для type: file.
+	Path    string            `json:"path,omitempty" yaml:"path"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	// Source имя этого сервера в источниках федерации вышестоящего, только для metring.
+	Source string `json:"source,omitempty" yaml:"source"`
+	// Tenants тенанты, чьи обновления пересылаются, пусто - все.
+	Tenants []string `json:"tenants,omitempty" yaml:"tenants"`
+	// BatchSize сколько обновлений отправлять одним запросом.
+	BatchSize int `json:"batchSize,omitempty" yaml:"batchSize"`
+	// FlushInterval как долго неполная пачка ждёт отправки.
+	FlushInterval string `json:"flushInterval,omitempty" yaml:"flushInterval"`
+	// QueueSize сколько обновлений ждёт в очереди, остальные отбрасываются.
+	QueueSize int `json:"queueSize,omitempty" yaml:"queueSize"`
+	// MaxRetry сколько раз пытаться отправить пачку.
+	MaxRetry int `json:"maxRetry,omitempty" yaml:"maxRetry"`
+	// RetryBackoff задержка перед первым повтором, дальше удваивается.
+	RetryBackoff string `json:"retryBackoff,omitempty" yaml:"retryBackoff"`
+	Timeout      string `json:"timeout,omitempty" yaml:"timeout"`
+
+	flushInterval time.Duration
+	retryBackoff  time.Duration
+	timeout       time.Duration
+}
+
+type sinksFile struct {
+	Sinks []*Sink `json:"sinks" yaml:"sinks"`
+}
+
+// LoadSinks читает приёмники из YAML или JSON файла.
+func LoadSinks(path string) ([]*Sink, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read forward sinks file: %w", err)
+	}
+
+	var f sinksFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("can not parse forward sinks file: %w", err)
+	}
+
+	names := make(map[string]bool, len(f.Sinks))
+	for _, s := range f.Sinks {
+		if err := s.Compile(); err != nil {
+			return nil, err
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate forward sink %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return f.Sinks, nil
+}
+
+// Compile проверяет приёмник и подставляет значения по умолчанию.
+func (s *Sink) Compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("forward sink without name")
+	}
+	switch s.Type {
+	case TypeMetring, TypeRemoteWrite, TypeInflux:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("forward sink %s: bad url %q", s.Name, s.URL)
+		}
+		switch {
+		case s.Type == TypeMetring && s.Source != "":
+			s.URL = u.JoinPath("api/v1/federate").String()
+		case s.Type == TypeMetring:
+			s.URL = u.JoinPath("updates/").String()
+		case s.Source != "":
+			return fmt.Errorf("forward sink %s: source is only for metring sinks", s.Name)
+		}
+	case TypeFile:
+		if s.Path == "" {
+			return fmt.Errorf("forward sink %s: file sink without path", s.Name)
+		}
+	default:
+		return fmt.Errorf("forward sink %s: unknown type %q", s.Name, s.Type)
+	}
+
+	for _, v := range []*int{&s.BatchSize, &s.QueueSize, &s.MaxRetry} {
+		if *v < 0 {
+			return fmt.Errorf("forward sink %s: negative batchSize, queueSize or maxRetry", s.Name)
+		}
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = defaultBatchSize
+	}
+	if s.QueueSize == 0 {
+		s.QueueSize = defaultQueueSize
+	}
+	if s.MaxRetry == 0 {
+		s.MaxRetry = defaultMaxRetry
+	}
+
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+		def   time.Duration
+	}{
+		{"flushInterval", s.FlushInterval, &s.flushInterval, defaultFlushInterval},
+		{"retryBackoff", s.RetryBackoff, &s.retryBackoff, defaultRetryBackoff},
+		{"timeout", s.Timeout, &s.timeout, defaultTimeout},
+	} {
+		*d.dst = d.def
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("forward sink %s: bad %s %q", s.Name, d.name, d.value)
+		}
+		*d.dst = v
+	}
+
+	return nil
+}
+
+// accepts решает, пересылать ли обновление в приёмник. В metring уходят только gauge
+// и counter с известным приростом: распределения хранятся накопленными, и повторить
+// их обновление без двойного счёта нельзя. NaN и Inf в JSON не представимы.
+func (s *Sink) accepts(it *item) bool {
+	if len(s.Tenants) > 0 && !slices.Contains(s.Tenants, it.tenant) {
+		return false
+	}
+	if s.Type != TypeMetring {
+		return true
+	}
+	switch it.mType {
+	case domain.Gauge:
+		return !math.IsNaN(it.value) && !math.IsInf(it.value, 0)
+	case domain.Counter:
+		return it.increment != nil
+	default:
+		return false
+	}
+}
+
+// item обновление в очереди: значение серии на момент приёма.
+type item struct {
+	tenant string
+	name   string
+	mType  string
+	labels map[string]string
+	// value значение gauge, итог counter или число наблюдений распределения
+	value float64
+	// increment прирост counter из принятого обновления
+	increment *int64
+	at        time.Time
+}
+
+func newItem(tenant string, m *domain.Metrics, increments map[string]int64) *item {
+	v, _ := m.Number()
+	it := &item{tenant: tenant, name: m.ID, mType: m.MType, labels: m.Labels, value: v, at: m.UpdatedAt}
+	if inc, ok := increments[m.Key()]; ok && m.MType == domain.Counter {
+		it.increment = &inc
+	}
+	return it
+}
+
+func isDistribution(mType string) bool {
+	return mType != domain.Gauge && mType != domain.Counter
+}
+
+// request тело запроса в формате приёмника.
+type request struct {
+	body    []byte
+	headers map[string]string
+}
+
+func (s *Sink) encode(batch []*item) (*request, error) {
+	switch s.Type {
+	case TypeMetring:
+		return encodeMetring(batch)
+	case TypeRemoteWrite:
+		return encodeRemoteWrite(batch), nil
+	case TypeInflux:
+		return encodeInflux(batch), nil
+	default:
+		return encodeFile(batch)
+	}
+}
+
+// encodeMetring JSON массив как у агента, сжатый gzip.
+func encodeMetring(batch []*item) (*request, error) {
+	data := make([]*domain.MetricsJSON, 0, len(batch))
+	for _, it := range batch {
+		m := &domain.MetricsJSON{ID: it.name, MType: it.mType, Labels: it.labels}
+		if it.mType == domain.Counter {
+			m.Delta = it.increment
+		} else {
+			v := it.value
+			m.Value = &v
+		}
+		data = append(data, m)
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &request{body: buf.Bytes(), headers: map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+	}}, nil
+}
+
+// encodeRemoteWrite серия на обновление, распределения уходят как <name>_count.
+// Тенант, кроме тенанта по умолчанию, добавляется меткой tenant.
+func encodeRemoteWrite(batch []*item) *request {
+	wr := &remotewrite.WriteRequest{}
+	families := make(map[string]remotewrite.MetricType)
+	for _, it := range batch {
+		name := sanitizeName(it.name)
+		mType := remotewrite.MetricTypeGauge
+		switch {
+		case it.mType == domain.Counter:
+			mType = remotewrite.MetricTypeCounter
+		case isDistribution(it.mType):
+			name += "_count"
+			mType = remotewrite.MetricTypeCounter
+		}
+		families[name] = mType
+
+		labels := []remotewrite.Label{{Name: "__name__", Value: name}}
+		for k, v := range tagsOf(it) {
+			labels = append(labels, remotewrite.Label{Name: sanitizeName(k), Value: v})
+		}
+		slices.SortFunc(labels, func(a, b remotewrite.Label) int { return strings.Compare(a.Name, b.Name) })
+
+		wr.Timeseries = append(wr.Timeseries, remotewrite.TimeSeries{
+			Labels:  labels,
+			Samples: []remotewrite.Sample{{Value: it.value, Timestamp: it.at.UnixMilli()}},
+		})
+	}
+	for _, name := range slices.Sorted(maps.Keys(families)) {
+		wr.Metadata = append(wr.Metadata, remotewrite.MetricMetadata{Type: families[name], MetricFamilyName: name})
+	}
+
+	return &request{body: snappy.Encode(nil, wr.Marshal()), headers: map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}}
+}
+
+// encodeInflux строка на обновление: gauge - поле value, counter - целое value,
+// распределения - целое count. Время в наносекундах.
+func encodeInflux(batch []*item) *request {
+	var buf bytes.Buffer
+	for _, it := range batch {
+		buf.WriteString(influxEscape(it.name, ", "))
+		tags := tagsOf(it)
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			buf.WriteByte(',')
+			buf.WriteString(influxEscape(k, ",= "))
+			buf.WriteByte('=')
+			buf.WriteString(influxEscape(tags[k], ",= "))
+		}
+		switch {
+		case it.mType == domain.Counter:
+			buf.WriteString(" value=" + strconv.FormatInt(int64(it.value), 10) + "i")
+		case isDistribution(it.mType):
+			buf.WriteString(" count=" + strconv.FormatInt(int64(it.value), 10) + "i")
+		default:
+			buf.WriteString(" value=" + strconv.FormatFloat(it.value, 'g', -1, 64))
+		}
+		buf.WriteString(" " + strconv.FormatInt(it.at.UnixNano(), 10) + "\n")
+	}
+
+	return &request{body: buf.Bytes(), headers: map[string]string{"Content-Type": "text/plain; charset=utf-8"}}
+}
+
+// fileRow строка файла пересылки.
+type fileRow struct {
+	Tenant    string            `json:"tenant,omitempty"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Value     *float64          `json:"value"`
+	Increment *int64            `json:"increment,omitempty"`
+}
+
+// encodeFile NDJSON с полями выгрузки, NaN и Inf пишутся как null.
+func encodeFile(batch []*item) (*request, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	enc := json.NewEncoder(w)
+	for _, it := range batch {
+		r := &fileRow{Name: it.name, Type: it.mType, Labels: it.labels, Timestamp: it.at, Increment: it.increment}
+		if it.tenant != domain.DefaultTenant {
+			r.Tenant = it.tenant
+		}
+		if v := it.value; !math.IsNaN(v) && !math.IsInf(v, 0) {
+			r.Value = &v
+		}
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &request{body: buf.Bytes()}, nil
+}
+
+// tagsOf метки серии и тенант, если он не по умолчанию.
+func tagsOf(it *item) map[string]string {
+	if it.tenant == domain.DefaultTenant {
+		return it.labels
+	}
+	tags := make(map[string]string, len(it.labels)+1)
+	maps.Copy(tags, it.labels)
+	tags["tenant"] = it.tenant
+	return tags
+}
+
+// sanitizeName заменяет символы, недопустимые в имени Prometheus, на "_".
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		ok := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !ok {
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+// influxEscape экранирует обратной косой чертой символы chars.
+func influxEscape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,8 @@
+package graphite
+
+type Config struct {
+	Addr      string `json:"graphiteAddr" yaml:"graphiteAddr" env:"GRAPHITE_ADDR"`
+	Template  string `json:"graphiteTemplate" yaml:"graphiteTemplate" env:"GRAPHITE_TEMPLATE"`
+	BatchSize int    `json:"graphiteBatchSize" yaml:"graphiteBatchSize" env:"GRAPHITE_BATCH_SIZE" default:"500"`
+	QueueSize int    `json:"graphiteQueueSize" yaml:"graphiteQueueSize" env:"GRAPHITE_QUEUE_SIZE" default:"10000"`
+}
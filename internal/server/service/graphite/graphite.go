@@ -0,0 +1,259 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+const flushInterval = time.Second
+
+type Metrics interface {
+	Updates(ctx context.Context, metrics []*domain.MetricsJSON) error
+}
+
+// Listener принимает метрики по графитовому plaintext протоколу (path value timestamp).
+// Соединения пишут в ограниченную очередь, если сервис не успевает её разбирать,
+// чтение из сокетов останавливается и клиенты упираются в TCP backpressure.
+type Listener struct {
+	addr      string
+	template  *Template
+	batchSize int
+
+	queue chan *domain.MetricsJSON
+	conns sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[net.Conn]struct{}
+
+	srv Metrics
+	lg  *zap.Logger
+}
+
+func NewListener(ctx context.Context, cfg Config, srv Metrics) (*Listener, error) {
+	tmpl, err := NewTemplate(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create graphite listener: %w", err)
+	}
+
+	return &Listener{
+		addr:      cfg.Addr,
+		template:  tmpl,
+		batchSize: max(cfg.BatchSize, 1),
+		queue:     make(chan *domain.MetricsJSON, max(cfg.QueueSize, 1)),
+		active:    make(map[net.Conn]struct{}),
+		srv:       srv,
+		lg:        zctx.From(ctx).Named("graphite listener"),
+	}, nil
+}
+
+func (l *Listener) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		l.lg.Error("failed to listen tcp", zap.String("addr", l.addr), zap.Error(err))
+		return
+	}
+	l.lg.Info("Start graphite listener on", zap.String("addr", ln.Addr().String()))
+
+	go l.accept(ctx, ln)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.write(context.WithoutCancel(ctx))
+	}()
+
+	<-ctx.Done()
+	l.lg.Info("got cancellation, returning")
+	ln.Close()
+	l.closeConns()
+	l.conns.Wait()
+	close(l.queue)
+	<-done
+}
+
+func (l *Listener) accept(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.lg.Warn("error while accepting connection", zap.Error(err))
+			continue
+		}
+
+		l.mu.Lock()
+		l.active[conn] = struct{}{}
+		l.mu.Unlock()
+
+		l.conns.Add(1)
+		go l.serve(ctx, conn)
+	}
+}
+
+func (l *Listener) closeConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for conn := range l.active {
+		conn.Close()
+	}
+}
+
+func (l *Listener) serve(ctx context.Context, conn net.Conn) {
+	defer l.conns.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.active, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	lg := l.lg.With(zap.String("remote", conn.RemoteAddr().String()))
+	lg.Debug("new connection")
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		metric, err := l.parseLine(line)
+		if err != nil {
+			lg.Debug("skip bad graphite line", zap.Error(err))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case l.queue <- metric:
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		lg.Warn("error while reading connection", zap.Error(err))
+	}
+}
+
+// write разбирает очередь и отправляет метрики в сервис пачками
+// по batchSize или раз в flushInterval. Метрики, которые не удалось записать,
+// уходят со следующей пачкой, их не больше размера очереди.
+func (l *Listener) write(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*domain.MetricsJSON, 0, l.batchSize)
+	var pending []*domain.MetricsJSON
+	flush := func() {
+		if len(batch) == 0 && len(pending) == 0 {
+			return
+		}
+		pending = l.send(ctx, append(pending, batch...))
+		if n := len(pending) - cap(l.queue); n > 0 {
+			l.lg.Error("graphite metrics dropped, too many are waiting for retry", zap.Int("count", n))
+			pending = pending[n:]
+		}
+		batch = make([]*domain.MetricsJSON, 0, l.batchSize)
+	}
+
+	for {
+		select {
+		case metric, ok := <-l.queue:
+			if !ok {
+				flush()
+				if len(pending) > 0 {
+					l.lg.Error("graphite metrics lost on shutdown", zap.Int("count", len(pending)))
+				}
+				return
+			}
+			batch = append(batch, metric)
+			if len(batch) >= l.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send пишет пачку и возвращает метрики, запись которых стоит повторить. Если пачку
+// отклонили из-за отдельных строк (плохое имя, конфликт типа, лимит серий), она пишется
+// по одной, чтобы остальные строки не потерялись, а отклонённые строки отбрасываются.
+func (l *Listener) send(ctx context.Context, batch []*domain.MetricsJSON) []*domain.MetricsJSON {
+	err := l.srv.Updates(ctx, batch)
+	switch {
+	case err == nil:
+		return nil
+	case !domain.Rejected(err):
+		l.lg.Error("error while writing graphite metrics, will retry", zap.Int("count", len(batch)), zap.Error(err))
+		return batch
+	}
+
+	failed := make([]*domain.MetricsJSON, 0)
+	for _, m := range batch {
+		err := l.srv.Updates(ctx, []*domain.MetricsJSON{m})
+		switch {
+		case err == nil:
+		case domain.Rejected(err):
+			l.lg.Warn("graphite metric rejected", zap.String("name", m.ID), zap.Error(err))
+		default:
+			failed = append(failed, m)
+		}
+	}
+	if len(failed) > 0 {
+		l.lg.Error("error while writing graphite metrics, will retry", zap.Int("count", len(failed)))
+	}
+
+	return failed
+}
+
+func (l *Listener) parseLine(line string) (*domain.MetricsJSON, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("bad line %q: want path value [timestamp]", line)
+	}
+
+	name, labels, err := l.template.Apply(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad line %q: %w", line, err)
+	}
+
+	v, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad line %q: bad value: %w", line, err)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("bad line %q: value is not finite", line)
+	}
+
+	if len(fields) == 3 {
+		// Время точки проверяется, но отбрасывается: серия хранит только текущее значение
+		// со временем приёма, как и при записи через /update. Для догрузки истории
+		// с временем точек есть /api/v1/import.
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, fmt.Errorf("bad line %q: bad timestamp: %w", line, err)
+		}
+	}
+
+	return &domain.MetricsJSON{
+		ID:     name,
+		MType:  domain.Gauge,
+		Value:  &v,
+		Labels: labels,
+	}, nil
+}
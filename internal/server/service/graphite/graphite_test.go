@@ -0,0 +1,219 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		path     string
+		want     string
+		labels   map[string]string
+		pass     bool
+	}{
+		{
+			name: "Positive empty template",
+			path: "host1.cpu.load",
+			want: "host1.cpu.load",
+			pass: true,
+		},
+		{
+			name:     "Positive greedy",
+			template: "_.measurement*",
+			path:     "host1.cpu.load",
+			want:     "cpu.load",
+			pass:     true,
+		},
+		{
+			name:     "Positive exact",
+			template: "_.measurement._",
+			path:     "host1.cpu.load",
+			want:     "cpu",
+			pass:     true,
+		},
+		{
+			name:     "Positive labels",
+			template: "env.host.measurement*",
+			path:     "prod.host1.cpu.load",
+			want:     "cpu.load",
+			labels:   map[string]string{"env": "prod", "host": "host1"},
+			pass:     true,
+		},
+		{
+			name:     "Negative too long path",
+			template: "_.measurement",
+			path:     "host1.cpu.load",
+			pass:     false,
+		},
+		{
+			name:     "Negative too short path",
+			template: "_._.measurement",
+			path:     "host1.cpu",
+			pass:     false,
+		},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tmpl, err := NewTemplate(tCase.template)
+			require.NoError(t, err)
+
+			name, labels, err := tmpl.Apply(tCase.path)
+			if tCase.pass {
+				require.NoError(t, err)
+				require.Equal(t, tCase.want, name)
+				require.Equal(t, tCase.labels, labels)
+				return
+			}
+			require.Error(t, err)
+		})
+	}
+
+	_, err := NewTemplate("measurement*._")
+	require.Error(t, err)
+	_, err = NewTemplate("host..measurement")
+	require.Error(t, err)
+}
+
+func TestListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+
+	cfg := Config{
+		Addr:      "127.0.0.1:0",
+		Template:  "host.measurement*",
+		BatchSize: 2,
+		QueueSize: 1,
+	}
+	l, err := NewListener(ctx, cfg, srv)
+	require.NoError(t, err)
+
+	// Слушаем сами, чтобы узнать порт
+	ln, err := net.Listen("tcp", cfg.Addr)
+	require.NoError(t, err)
+	l.addr = ln.Addr().String()
+	ln.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go l.Run(ctx, &wg)
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", l.addr)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = fmt.Fprint(conn, "host1.cpu.load 1.5 1700000000\nbroken line\nhost1.mem.used abc 1\nhost1.mem.used 42 1700000000\n")
+	require.NoError(t, err)
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "mem.used", MType: domain.Gauge, Labels: map[string]string{"host": "host1"}})
+		return err == nil && *m.Value == 42
+	}, 3*time.Second, 10*time.Millisecond)
+
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "cpu.load", MType: domain.Gauge, Labels: map[string]string{"host": "host1"}})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *m.Value)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestSendRejected(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+
+	l, err := NewListener(ctx, Config{Addr: "127.0.0.1:0", BatchSize: 3, QueueSize: 1}, srv)
+	require.NoError(t, err)
+
+	// requests уже хранится счётчиком, gauge с тем же именем будет отклонён
+	delta := int64(1)
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "requests", MType: domain.Counter, Delta: &delta}))
+
+	value := func(v float64) *float64 { return &v }
+	l.send(ctx, []*domain.MetricsJSON{
+		{ID: "load", MType: domain.Gauge, Value: value(1)},
+		{ID: "requests", MType: domain.Gauge, Value: value(2)},
+		{ID: "mem", MType: domain.Gauge, Value: value(3)},
+	})
+
+	for name, want := range map[string]float64{"load": 1, "mem": 3} {
+		m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: name, MType: domain.Gauge})
+		require.NoError(t, err)
+		require.Equal(t, want, *m.Value)
+	}
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "requests", MType: domain.Counter})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *m.Delta)
+}
+
+// flakyMetrics сервис метрик, запись в который не удаётся, пока fail выставлен.
+// О каждой попытке записи сообщает в calls.
+type flakyMetrics struct {
+	*metrics.Metrics
+	fail  atomic.Bool
+	calls chan struct{}
+}
+
+func (f *flakyMetrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
+	defer func() { f.calls <- struct{}{} }()
+	if f.fail.Load() {
+		return errors.New("database is down")
+	}
+	return f.Metrics.Updates(ctx, data)
+}
+
+func TestWriteRetry(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyMetrics{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage()), calls: make(chan struct{})}
+	srv.fail.Store(true)
+
+	l, err := NewListener(ctx, Config{Addr: "127.0.0.1:0", BatchSize: 1, QueueSize: 2}, srv)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.write(ctx)
+	}()
+
+	value := func(v float64) *float64 { return &v }
+	push := func(name string, v float64) {
+		l.queue <- &domain.MetricsJSON{ID: name, MType: domain.Gauge, Value: value(v)}
+		<-srv.calls
+	}
+
+	// хранилище недоступно: пачки копятся, но не больше размера очереди
+	push("a", 1)
+	push("b", 2)
+	push("c", 3)
+	srv.fail.Store(false)
+	push("d", 4)
+	close(l.queue)
+	<-done
+
+	for name, want := range map[string]float64{"b": 2, "c": 3, "d": 4} {
+		m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: name, MType: domain.Gauge})
+		require.NoError(t, err)
+		require.Equal(t, want, *m.Value)
+	}
+	_, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "a", MType: domain.Gauge})
+	require.Error(t, err)
+}
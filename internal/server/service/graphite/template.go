@@ -0,0 +1,86 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	tokenMeasurement = "measurement"
+	tokenSkip        = "_"
+)
+
+// Template описывает, как из графитового пути path.to.metric получить имя и метки метрики.
+// Шаблон состоит из частей, разделённых точкой:
+//   - measurement - часть пути попадает в имя метрики;
+//   - _ - часть пути отбрасывается;
+//   - любое другое слово - имя метки, часть пути становится её значением.
+//
+// Последняя часть может заканчиваться на *, тогда она применяется ко всем оставшимся частям пути.
+// Пустой шаблон оставляет путь без изменений.
+// Например, шаблон "host.measurement*" превращает "host1.cpu.load" в "cpu.load" с меткой host=host1.
+type Template struct {
+	tokens []string
+	greedy bool
+}
+
+func NewTemplate(s string) (*Template, error) {
+	t := &Template{}
+	if s == "" {
+		return t, nil
+	}
+
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		if strings.HasSuffix(p, "*") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("bad template %q: * allowed only in the last part", s)
+			}
+			t.greedy = true
+			p = strings.TrimSuffix(p, "*")
+		}
+		if p == "" || strings.ContainsAny(p, "{}=,\"") {
+			return nil, fmt.Errorf("bad template %q: bad part %q", s, p)
+		}
+		t.tokens = append(t.tokens, p)
+	}
+
+	return t, nil
+}
+
+func (t *Template) Apply(path string) (string, map[string]string, error) {
+	if len(t.tokens) == 0 {
+		return path, nil, nil
+	}
+
+	parts := strings.Split(path, ".")
+	if len(parts) < len(t.tokens) || (!t.greedy && len(parts) > len(t.tokens)) {
+		return "", nil, fmt.Errorf("path %q does not match template", path)
+	}
+
+	name := make([]string, 0, len(parts))
+	tags := make(map[string][]string)
+	for i, p := range parts {
+		token := t.tokens[min(i, len(t.tokens)-1)]
+		switch token {
+		case tokenMeasurement:
+			name = append(name, p)
+		case tokenSkip:
+		default:
+			tags[token] = append(tags[token], p)
+		}
+	}
+	if len(name) == 0 {
+		return "", nil, fmt.Errorf("path %q gives empty name", path)
+	}
+
+	var labels map[string]string
+	if len(tags) > 0 {
+		labels = make(map[string]string, len(tags))
+		for k, v := range tags {
+			labels[k] = strings.Join(v, ".")
+		}
+	}
+
+	return strings.Join(name, "."), labels, nil
+}
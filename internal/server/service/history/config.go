@@ -0,0 +1,8 @@
+package history
+
+type Config struct {
+	// Points сколько последних значений хранится для каждой серии.
+	Points int `json:"historyPoints" yaml:"historyPoints" env:"HISTORY_POINTS" default:"120"`
+	// Resolution не чаще раза в столько секунд добавляется новая точка, более частые обновления заменяют последнюю.
+	Resolution int `json:"historyResolution" yaml:"historyResolution" env:"HISTORY_RESOLUTION" default:"10"`
+}
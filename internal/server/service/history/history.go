@@ -0,0 +1,164 @@
+package history
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+type Point struct {
+	At    time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// ring последние точки серии, старые перезаписываются по кругу.
+type ring struct {
+	points []Point
+	next   int
+	full   bool
+}
+
+func (r *ring) add(p Point, resolution time.Duration) {
+	if last, ok := r.last(); ok && p.At.Sub(last.At) < resolution {
+		r.points[(r.next-1+len(r.points))%len(r.points)].Value = p.Value
+		return
+	}
+	r.points[r.next] = p
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) last() (Point, bool) {
+	if r.next == 0 && !r.full {
+		return Point{}, false
+	}
+	return r.points[(r.next-1+len(r.points))%len(r.points)], true
+}
+
+// list точки от старых к новым.
+func (r *ring) list() []Point {
+	if !r.full {
+		return append([]Point(nil), r.points[:r.next]...)
+	}
+	res := make([]Point, 0, len(r.points))
+	res = append(res, r.points[r.next:]...)
+	return append(res, r.points[:r.next]...)
+}
+
+// History хранит в памяти недавние значения серий для графиков. Заполняется
+// из принятых обновлений и не переживает рестарт.
+type History struct {
+	size       int
+	resolution time.Duration
+
+	mu     sync.RWMutex
+	series map[string]*ring
+}
+
+func NewHistory(cfg Config) *History {
+	return &History{
+		size:       cfg.Points,
+		resolution: time.Duration(cfg.Resolution) * time.Second,
+		series:     make(map[string]*ring),
+	}
+}
+
+// Publish добавляет новые значения серий тенанта из контекста.
+func (h *History) Publish(ctx context.Context, metrics []*domain.Metrics) {
+	if h.size <= 0 {
+		return
+	}
+	tenant := domain.TenantFromContext(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, m := range metrics {
+		v, ok := m.Number()
+		if !ok {
+			continue
+		}
+		key := tenant + "/" + m.Key()
+		r, ok := h.series[key]
+		if !ok {
+			r = &ring{points: make([]Point, h.size)}
+			h.series[key] = r
+		}
+		r.add(Point{At: m.UpdatedAt, Value: v}, h.resolution)
+	}
+}
+
+// Import добавляет точки серии тенанта из контекста в любом порядке. Точки сортируются
+// по времени, точка с тем же временем заменяет старую, остаются только последние Points точек.
+// Возвращает, сколько из points не поместилось.
+func (h *History) Import(ctx context.Context, key string, points []Point) int {
+	if h.size <= 0 || len(points) == 0 {
+		return len(points)
+	}
+	key = domain.TenantFromContext(ctx) + "/" + key
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var all []Point
+	if r, ok := h.series[key]; ok {
+		all = r.list()
+	}
+	all = append(all, points...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].At.Before(all[j].At) })
+
+	merged := all[:0]
+	for _, p := range all {
+		if n := len(merged); n > 0 && merged[n-1].At.Equal(p.At) {
+			merged[n-1] = p
+			continue
+		}
+		merged = append(merged, p)
+	}
+	dropped := 0
+	if len(merged) > h.size {
+		merged = merged[len(merged)-h.size:]
+		for _, p := range points {
+			if p.At.Before(merged[0].At) {
+				dropped++
+			}
+		}
+	}
+
+	r := &ring{points: make([]Point, h.size)}
+	copy(r.points, merged)
+	r.next = len(merged) % h.size
+	r.full = len(merged) == h.size
+	h.series[key] = r
+
+	return dropped
+}
+
+// Points возвращает точки серии тенанта от старых к новым.
+func (h *History) Points(ctx context.Context, key string) []Point {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.series[domain.TenantFromContext(ctx)+"/"+key]
+	if !ok {
+		return nil
+	}
+	return r.list()
+}
+
+// Forget удаляет историю удалённых серий.
+func (h *History) Forget(ctx context.Context, keys []string) {
+	tenant := domain.TenantFromContext(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, k := range keys {
+		delete(h.series, tenant+"/"+k)
+	}
+}
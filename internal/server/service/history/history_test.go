@@ -0,0 +1,75 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(v float64, at time.Time) *domain.Metrics {
+	return &domain.Metrics{ID: "Alloc", MType: domain.Gauge, Value: &v, UpdatedAt: at}
+}
+
+func values(points []Point) []float64 {
+	res := make([]float64, 0, len(points))
+	for _, p := range points {
+		res = append(res, p.Value)
+	}
+	return res
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistory(Config{Points: 3, Resolution: 10})
+	start := time.Now()
+
+	require.Nil(t, h.Points(ctx, "Alloc"))
+
+	h.Publish(ctx, []*domain.Metrics{gauge(1, start)})
+	// чаще Resolution точка заменяется
+	h.Publish(ctx, []*domain.Metrics{gauge(2, start.Add(5*time.Second))})
+	require.Equal(t, []float64{2}, values(h.Points(ctx, "Alloc")))
+
+	for i := 1; i <= 3; i++ {
+		h.Publish(ctx, []*domain.Metrics{gauge(float64(2+i), start.Add(time.Duration(i)*time.Minute))})
+	}
+	// старые точки вытесняются по кругу
+	require.Equal(t, []float64{3, 4, 5}, values(h.Points(ctx, "Alloc")))
+
+	acme := domain.WithTenant(ctx, "acme")
+	require.Nil(t, h.Points(acme, "Alloc"))
+
+	h.Forget(ctx, []string{"Alloc"})
+	require.Nil(t, h.Points(ctx, "Alloc"))
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistory(Config{Points: 4, Resolution: 10})
+	start := time.Now()
+
+	h.Publish(ctx, []*domain.Metrics{gauge(10, start)})
+	dropped := h.Import(ctx, "Alloc", []Point{
+		{At: start.Add(-time.Minute), Value: 2},
+		{At: start.Add(-3 * time.Minute), Value: 1},
+		{At: start, Value: 11},
+	})
+	require.Zero(t, dropped)
+	// точки встают по времени, точка с тем же временем заменяется
+	require.Equal(t, []float64{1, 2, 11}, values(h.Points(ctx, "Alloc")))
+
+	dropped = h.Import(ctx, "Alloc", []Point{
+		{At: start.Add(-5 * time.Minute), Value: 0},
+		{At: start.Add(-2 * time.Minute), Value: 1.5},
+	})
+	// лишние старые точки отбрасываются и попадают в отчёт
+	require.Equal(t, []float64{1, 1.5, 2, 11}, values(h.Points(ctx, "Alloc")))
+	require.Equal(t, 1, dropped)
+
+	// после загрузки кольцо продолжает принимать обновления
+	h.Publish(ctx, []*domain.Metrics{gauge(12, start.Add(time.Minute))})
+	require.Equal(t, []float64{1.5, 2, 11, 12}, values(h.Points(ctx, "Alloc")))
+}
@@ -0,0 +1,360 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// Форматы загрузки, колонки те же, что у выгрузки.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Как читать значения counter.
+const (
+	// CountersAbsolute значение - накопленный итог.
+	CountersAbsolute = "absolute"
+	// CountersDelta значение - прирост с предыдущей точки, итог считается по порядку времени.
+	CountersDelta = "delta"
+)
+
+// maxSkew насколько время точки может опережать часы сервера.
+const maxSkew = 5 * time.Minute
+
+var ErrBadRequest = errors.New("bad import request")
+
+type Metrics interface {
+	Import(ctx context.Context, metrics []*domain.Metrics) error
+}
+
+type History interface {
+	Import(ctx context.Context, key string, points []history.Point) int
+}
+
+type Request struct {
+	Format   string
+	Counters string
+}
+
+// Summary сколько серий и точек загружено.
+type Summary struct {
+	Series int `json:"series"`
+	Points int `json:"points"`
+	// Dropped точки, не попавшие в историю: она в памяти и хранит только
+	// последние точки серии, без истории остаются лишь текущие значения.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// row строка загрузки: одно значение одной серии в момент Timestamp.
+type row struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp json.RawMessage   `json:"timestamp"`
+	Value     *float64          `json:"value"`
+}
+
+type series struct {
+	name   string
+	mType  string
+	labels map[string]string
+	points []history.Point
+}
+
+// Importer загружает точки с явным временем в любом порядке, например чтобы перенести
+// историю из прежней системы. Вход разбирается и проверяется целиком до записи.
+type Importer struct {
+	srv     Metrics
+	history History
+	now     func() time.Time
+	lg      *zap.Logger
+}
+
+func NewImporter(ctx context.Context, srv Metrics, history History) *Importer {
+	return &Importer{
+		srv:     srv,
+		history: history,
+		now:     time.Now,
+		lg:      zctx.From(ctx).Named("importer"),
+	}
+}
+
+// Validate проверяет параметры запроса и подставляет значения по умолчанию.
+func Validate(req *Request) error {
+	if req.Format != FormatCSV && req.Format != FormatNDJSON {
+		return fmt.Errorf("%w: format must be %s or %s, got %q", ErrBadRequest, FormatCSV, FormatNDJSON, req.Format)
+	}
+	switch req.Counters {
+	case "":
+		req.Counters = CountersAbsolute
+	case CountersAbsolute, CountersDelta:
+	default:
+		return fmt.Errorf("%w: counters must be %s or %s, got %q", ErrBadRequest, CountersAbsolute, CountersDelta, req.Counters)
+	}
+
+	return nil
+}
+
+// Import читает строки из r, текущим значением серии становится её последняя точка,
+// если она новее сохранённой. Остальные точки попадают в историю.
+func (i *Importer) Import(ctx context.Context, r io.Reader, req *Request) (*Summary, error) {
+	if err := Validate(req); err != nil {
+		return nil, err
+	}
+
+	var (
+		all map[string]*series
+		err error
+	)
+	if req.Format == FormatCSV {
+		all, err = i.readCSV(r)
+	} else {
+		all, err = i.readNDJSON(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sum := &Summary{Series: len(keys)}
+	current := make([]*domain.Metrics, 0, len(keys))
+	for _, k := range keys {
+		s := all[k]
+		s.points = merge(s.points)
+		if s.mType == domain.Counter && req.Counters == CountersDelta {
+			var total float64
+			for j := range s.points {
+				total += s.points[j].Value
+				s.points[j].Value = total
+			}
+		}
+		sum.Points += len(s.points)
+		current = append(current, s.current())
+	}
+	if len(current) == 0 {
+		return sum, nil
+	}
+
+	if err := i.srv.Import(ctx, current); err != nil {
+		return nil, err
+	}
+	if i.history != nil {
+		for _, k := range keys {
+			sum.Dropped += i.history.Import(ctx, k, all[k].points)
+		}
+	} else {
+		sum.Dropped = sum.Points - sum.Series
+	}
+	if sum.Dropped > 0 {
+		i.lg.Warn("imported points do not fit into history", zap.Int("dropped", sum.Dropped))
+	}
+	i.lg.Info("imported metrics", zap.Int("series", sum.Series), zap.Int("points", sum.Points))
+
+	return sum, nil
+}
+
+// merge сортирует точки по времени, из точек с одинаковым временем остаётся последняя.
+func merge(points []history.Point) []history.Point {
+	sort.SliceStable(points, func(a, b int) bool { return points[a].At.Before(points[b].At) })
+	res := points[:0]
+	for _, p := range points {
+		if n := len(res); n > 0 && res[n-1].At.Equal(p.At) {
+			res[n-1] = p
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+func (s *series) current() *domain.Metrics {
+	last := s.points[len(s.points)-1]
+	m := &domain.Metrics{ID: s.name, MType: s.mType, Labels: s.labels, UpdatedAt: last.At}
+	if s.mType == domain.Counter {
+		d := int64(math.Round(last.Value))
+		m.Delta = &d
+	} else {
+		v := last.Value
+		m.Value = &v
+	}
+	return m
+}
+
+// add проверяет строку и добавляет её точку к серии.
+func (i *Importer) add(all map[string]*series, line int, r *row, at time.Time) error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: line %d: empty name", ErrBadRequest, line)
+	}
+	switch r.Type {
+	case "":
+		r.Type = domain.Gauge
+	case domain.Gauge, domain.Counter:
+	default:
+		return fmt.Errorf("%w: line %d: type must be gauge or counter, got %q", ErrBadRequest, line, r.Type)
+	}
+	if at.IsZero() {
+		return fmt.Errorf("%w: line %d: missing timestamp", ErrBadRequest, line)
+	}
+	if at.After(i.now().Add(maxSkew)) {
+		return fmt.Errorf("%w: line %d: timestamp %s is in the future", ErrBadRequest, line, at.Format(time.RFC3339))
+	}
+	if r.Value == nil {
+		return fmt.Errorf("%w: line %d: missing value", ErrBadRequest, line)
+	}
+	v := *r.Value
+	if r.Type == domain.Counter && (math.IsNaN(v) || math.IsInf(v, 0) || v < 0) {
+		return fmt.Errorf("%w: line %d: counter value must be a finite non-negative number, got %v", ErrBadRequest, line, v)
+	}
+	if len(r.Labels) == 0 {
+		r.Labels = nil
+	}
+
+	key := domain.SeriesKey(r.Name, r.Labels)
+	s, ok := all[key]
+	if !ok {
+		s = &series{name: r.Name, mType: r.Type, labels: r.Labels}
+		all[key] = s
+	}
+	if s.mType != r.Type {
+		return fmt.Errorf("%w: line %d: %s is %s in earlier lines, got %s", ErrBadRequest, line, key, s.mType, r.Type)
+	}
+	s.points = append(s.points, history.Point{At: at, Value: v})
+
+	return nil
+}
+
+// readCSV первая строка - заголовок с колонками name,type,labels,timestamp,value в любом порядке,
+// обязательны name, timestamp и value.
+func (i *Importer) readCSV(r io.Reader) (map[string]*series, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]*series{}, nil
+		}
+		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+	cols := make(map[string]int, len(header))
+	for j, h := range header {
+		cols[strings.TrimSpace(h)] = j
+	}
+	for _, c := range []string{"name", "timestamp", "value"} {
+		if _, ok := cols[c]; !ok {
+			return nil, fmt.Errorf("%w: no %s column in the header", ErrBadRequest, c)
+		}
+	}
+	field := func(rec []string, name string) string {
+		j, ok := cols[name]
+		if !ok || j >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[j])
+	}
+
+	all := make(map[string]*series)
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		rw := &row{Name: field(rec, "name"), Type: field(rec, "type")}
+		if l := field(rec, "labels"); l != "" {
+			if err := json.Unmarshal([]byte(l), &rw.Labels); err != nil {
+				return nil, fmt.Errorf("%w: line %d: bad labels: %w", ErrBadRequest, line, err)
+			}
+		}
+		if v := field(rec, "value"); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: bad value %q", ErrBadRequest, line, v)
+			}
+			rw.Value = &f
+		}
+		at, err := parseTime(field(rec, "timestamp"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrBadRequest, line, err)
+		}
+		if err := i.add(all, line, rw, at); err != nil {
+			return nil, err
+		}
+	}
+
+	return all, nil
+}
+
+// readNDJSON JSON объект на строку, время - unix секунды числом или строка RFC3339.
+func (i *Importer) readNDJSON(r io.Reader) (map[string]*series, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	all := make(map[string]*series)
+	for line := 1; sc.Scan(); line++ {
+		data := strings.TrimSpace(sc.Text())
+		if data == "" {
+			continue
+		}
+		rw := &row{}
+		if err := json.Unmarshal([]byte(data), rw); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrBadRequest, line, err)
+		}
+		ts := strings.Trim(string(rw.Timestamp), `"`)
+		if ts == "null" {
+			ts = ""
+		}
+		at, err := parseTime(ts)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrBadRequest, line, err)
+		}
+		if err := i.add(all, line, rw, at); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+
+	return all, nil
+}
+
+// parseTime unix секунды (можно дробные) или RFC3339, пустая строка - нулевое время.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
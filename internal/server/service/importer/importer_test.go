@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestImporter() (*Importer, *metrics.Metrics, *history.History) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	return NewImporter(ctx, srv, hist), srv, hist
+}
+
+func values(points []history.Point) []float64 {
+	res := make([]float64, 0, len(points))
+	for _, p := range points {
+		res = append(res, p.Value)
+	}
+	return res
+}
+
+func TestImportCSV(t *testing.T) {
+	ctx := context.Background()
+	i, srv, hist := newTestImporter()
+	start := time.Now()
+
+	body := strings.Join([]string{
+		"name,type,labels,timestamp,value",
+		`Alloc,gauge,"{""host"":""a""}",2024-01-02T03:06:05Z,2`,
+		`Alloc,gauge,"{""host"":""a""}",2024-01-02T03:04:05Z,1`,
+		"PollCount,counter,,1704164645,10",
+		"PollCount,counter,,1704164705,25",
+	}, "\n")
+	sum, err := i.Import(ctx, strings.NewReader(body), &Request{Format: FormatCSV})
+	require.NoError(t, err)
+	require.Equal(t, &Summary{Series: 2, Points: 4}, sum)
+
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	alloc := all[`Alloc{host="a"}`]
+	require.Equal(t, 2.0, *alloc.Value)
+	// TTL отсчитывается от загрузки, а не от времени последней точки
+	require.False(t, alloc.UpdatedAt.Before(start))
+	require.Equal(t, int64(25), *all["PollCount"].Delta)
+
+	require.Equal(t, []float64{1, 2}, values(hist.Points(ctx, `Alloc{host="a"}`)))
+	require.Equal(t, []float64{10, 25}, values(hist.Points(ctx, "PollCount")))
+}
+
+func TestImportNDJSONDeltas(t *testing.T) {
+	ctx := context.Background()
+	i, srv, hist := newTestImporter()
+
+	body := strings.Join([]string{
+		`{"name":"PollCount","type":"counter","timestamp":"2024-01-02T03:05:05Z","value":5}`,
+		``,
+		`{"name":"PollCount","type":"counter","timestamp":1704164645,"value":3}`,
+		`{"name":"PollCount","type":"counter","timestamp":1704164765.5,"value":2}`,
+	}, "\n")
+	sum, err := i.Import(ctx, strings.NewReader(body), &Request{Format: FormatNDJSON, Counters: CountersDelta})
+	require.NoError(t, err)
+	require.Equal(t, &Summary{Series: 1, Points: 3}, sum)
+
+	// приросты складываются в порядке времени
+	require.Equal(t, []float64{3, 8, 10}, values(hist.Points(ctx, "PollCount")))
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *all["PollCount"].Delta)
+}
+
+func TestImportRejects(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	cases := []struct {
+		name string
+		req  *Request
+		body string
+		err  string
+	}{
+		{
+			name: "bad format",
+			req:  &Request{Format: "xml"},
+			err:  "format must be",
+		},
+		{
+			name: "bad counters mode",
+			req:  &Request{Format: FormatCSV, Counters: "rate"},
+			err:  "counters must be",
+		},
+		{
+			name: "no timestamp column",
+			req:  &Request{Format: FormatCSV},
+			body: "name,value\nAlloc,1",
+			err:  "no timestamp column",
+		},
+		{
+			name: "missing timestamp",
+			req:  &Request{Format: FormatNDJSON},
+			body: `{"name":"Alloc","value":1}`,
+			err:  "line 1: missing timestamp",
+		},
+		{
+			name: "timestamp in the future",
+			req:  &Request{Format: FormatNDJSON},
+			body: `{"name":"Alloc","timestamp":"` + future + `","value":1}`,
+			err:  "in the future",
+		},
+		{
+			name: "unsupported type",
+			req:  &Request{Format: FormatCSV},
+			body: "name,type,timestamp,value\nAlloc,histogram,1704164645,1",
+			err:  "line 2: type must be gauge or counter",
+		},
+		{
+			name: "negative counter",
+			req:  &Request{Format: FormatCSV},
+			body: "name,type,timestamp,value\nPollCount,counter,1704164645,-1",
+			err:  "non-negative",
+		},
+		{
+			name: "type changes between lines",
+			req:  &Request{Format: FormatNDJSON},
+			body: `{"name":"Alloc","timestamp":1704164645,"value":1}` + "\n" +
+				`{"name":"Alloc","type":"counter","timestamp":1704164705,"value":1}`,
+			err: "line 2: Alloc is gauge",
+		},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			i, srv, _ := newTestImporter()
+
+			_, err := i.Import(ctx, strings.NewReader(tCase.body), tCase.req)
+			require.ErrorIs(t, err, ErrBadRequest)
+			require.ErrorContains(t, err, tCase.err)
+
+			// при ошибке ничего не записывается
+			_, err = srv.GetAll(ctx)
+			var er *repository.EmptyRepoError
+			require.ErrorIs(t, err, er)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// Registry реестр метаданных метрик. Держит копию реестра каждого тенанта в памяти,
+// чтобы проверка типа на каждом обновлении не ходила в хранилище.
+type Registry struct {
+	repo repository.MetadataRepository
+	mu   sync.RWMutex
+	// cache метаданные по тенанту и имени, тенант подгружается из хранилища при первом обращении
+	cache map[string]map[string]*domain.Metadata
+	lg    *zap.Logger
+}
+
+func NewRegistry(ctx context.Context, repo repository.MetadataRepository) *Registry {
+	return &Registry{
+		repo:  repo,
+		cache: make(map[string]map[string]*domain.Metadata),
+		lg:    zctx.From(ctx).Named("metadata registry"),
+	}
+}
+
+// All возвращает весь реестр тенанта по имени метрики.
+func (r *Registry) All(ctx context.Context) map[string]*domain.Metadata {
+	r.load(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return maps.Clone(r.cache[domain.TenantFromContext(ctx)])
+}
+
+func (r *Registry) Get(ctx context.Context, name string) (*domain.Metadata, error) {
+	r.load(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if m, ok := r.cache[domain.TenantFromContext(ctx)][name]; ok {
+		return m, nil
+	}
+
+	return nil, repository.NewEmptyRepoError(fmt.Errorf("no metadata for %v", name))
+}
+
+// Put сохраняет метаданные целиком, в том числе меняет закреплённый тип.
+func (r *Registry) Put(ctx context.Context, meta *domain.Metadata) error {
+	if err := validate(meta); err != nil {
+		return err
+	}
+
+	r.load(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.write(ctx, meta)
+}
+
+// Populate дополняет реестр метаданными от агентов: заполняет только пустые поля
+// и не меняет закреплённый тип. Расхождение типа возвращает domain.ErrTypeConflict,
+// остальные записи при этом сохраняются.
+func (r *Registry) Populate(ctx context.Context, metas []*domain.Metadata) error {
+	for _, meta := range metas {
+		if err := validate(meta); err != nil {
+			return err
+		}
+	}
+
+	r.load(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cache := r.cache[domain.TenantFromContext(ctx)]
+	var conflict error
+	for _, meta := range metas {
+		old, ok := cache[meta.Name]
+		if !ok {
+			if err := r.write(ctx, meta); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if old.Type != "" && meta.Type != "" && old.Type != meta.Type {
+			conflict = fmt.Errorf("%w: %v is %v, got %v", domain.ErrTypeConflict, meta.Name, old.Type, meta.Type)
+			continue
+		}
+
+		merged := *old
+		fill(&merged.Type, meta.Type)
+		fill(&merged.Unit, meta.Unit)
+		fill(&merged.Description, meta.Description)
+		fill(&merged.Owner, meta.Owner)
+		if merged == *old {
+			continue
+		}
+		if err := r.write(ctx, &merged); err != nil {
+			return err
+		}
+	}
+
+	return conflict
+}
+
+// CheckType проверяет тип метрики по закреплённому в реестре.
+func (r *Registry) CheckType(ctx context.Context, name, mType string) error {
+	r.load(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.cache[domain.TenantFromContext(ctx)][name]
+	if !ok || m.Type == "" || m.Type == mType {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v is %v, got %v", domain.ErrTypeConflict, name, m.Type, mType)
+}
+
+// write сохраняет запись в хранилище и кэше, вызывается под r.mu.
+func (r *Registry) write(ctx context.Context, meta *domain.Metadata) error {
+	if err := r.repo.WriteMetadata(ctx, meta); err != nil {
+		return fmt.Errorf("cannot write metadata: %w", err)
+	}
+	r.cache[domain.TenantFromContext(ctx)][meta.Name] = meta
+
+	return nil
+}
+
+// load подгружает реестр тенанта из хранилища, если его ещё нет в памяти.
+func (r *Registry) load(ctx context.Context) {
+	tenant := domain.TenantFromContext(ctx)
+	r.mu.RLock()
+	_, ok := r.cache[tenant]
+	r.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cache[tenant]; ok {
+		return
+	}
+
+	cache := make(map[string]*domain.Metadata)
+	all, err := r.repo.GetAllMetadata(ctx)
+	if err != nil {
+		r.lg.Error("cannot load metadata, starting with empty registry", zap.String("tenant", tenant), zap.Error(err))
+	}
+	maps.Copy(cache, all)
+	r.cache[tenant] = cache
+}
+
+func fill(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+func validate(meta *domain.Metadata) error {
+	if meta.Name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if meta.Type != "" && !domain.IsValidType(meta.Type) {
+		return fmt.Errorf("wrong type %v", meta.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	reg := NewRegistry(ctx, repo)
+
+	require.NoError(t, reg.Put(ctx, &domain.Metadata{Name: "HeapAlloc", Type: domain.Gauge, Owner: "platform"}))
+	require.Error(t, reg.Put(ctx, &domain.Metadata{Name: "bad", Type: "lol"}))
+
+	// Агент дополняет пустые поля, но не переписывает заданные
+	err := reg.Populate(ctx, []*domain.Metadata{
+		{Name: "HeapAlloc", Type: domain.Gauge, Unit: "bytes", Owner: "agent"},
+		{Name: "PollCount", Type: domain.Counter},
+	})
+	require.NoError(t, err)
+
+	got, err := reg.Get(ctx, "HeapAlloc")
+	require.NoError(t, err)
+	require.Equal(t, &domain.Metadata{Name: "HeapAlloc", Type: domain.Gauge, Unit: "bytes", Owner: "platform"}, got)
+
+	// Реестр переживает перезапуск через хранилище
+	reg = NewRegistry(ctx, repo)
+	require.Len(t, reg.All(ctx), 2)
+
+	cases := []struct {
+		name  string
+		mName string
+		mType string
+		pass  bool
+	}{
+		{name: "Locked type", mName: "PollCount", mType: domain.Counter, pass: true},
+		{name: "Conflict", mName: "PollCount", mType: domain.Gauge, pass: false},
+		{name: "Unknown name", mName: "Other", mType: domain.Gauge, pass: true},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := reg.CheckType(ctx, tCase.mName, tCase.mType)
+			if tCase.pass {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, domain.ErrTypeConflict)
+		})
+	}
+
+	// Конфликт в одной записи не мешает сохранить остальные
+	err = reg.Populate(ctx, []*domain.Metadata{
+		{Name: "PollCount", Type: domain.Gauge},
+		{Name: "RandomValue", Description: "random"},
+	})
+	require.ErrorIs(t, err, domain.ErrTypeConflict)
+	_, err = reg.Get(ctx, "RandomValue")
+	require.NoError(t, err)
+}
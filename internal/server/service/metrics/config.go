@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	HistogramBuckets string `json:"histogramBuckets" yaml:"histogramBuckets" env:"HISTOGRAM_BUCKETS" default:"0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10"`
+	// TTL в минутах, серии без обновлений дольше TTL удаляются. 0 - не удалять.
+	TTL int `json:"ttl" yaml:"ttl" env:"METRICS_TTL"`
+	// SetInterval длина интервала в секундах, за который set считает уникальные значения.
+	// 0 - скетч не сбрасывается.
+	SetInterval int `json:"setInterval" yaml:"setInterval" env:"METRICS_SET_INTERVAL" default:"60"`
+	// MaxNameLength предел длины имени метрики, 0 - без ограничения.
+	MaxNameLength int `json:"maxNameLength" yaml:"maxNameLength" env:"METRICS_MAX_NAME_LENGTH" default:"200"`
+	// StrictNames разрешает в имени только буквы, цифры и _ . : - (по умолчанию включено).
+	StrictNames bool `json:"strictNames" yaml:"strictNames" env:"METRICS_STRICT_NAMES" default:"true"`
+	// NamePattern регулярное выражение, которому должно соответствовать имя метрики.
+	NamePattern string `json:"namePattern" yaml:"namePattern" env:"METRICS_NAME_PATTERN"`
+	// MaxSeries и MaxSeriesPerTenant лимиты числа серий на сервер и на тенанта, 0 - без ограничения.
+	MaxSeries          int `json:"maxSeries" yaml:"maxSeries" env:"METRICS_MAX_SERIES"`
+	MaxSeriesPerTenant int `json:"maxSeriesPerTenant" yaml:"maxSeriesPerTenant" env:"METRICS_MAX_SERIES_PER_TENANT"`
+}
+
+// ParseBuckets разбирает границы бакетов гистограммы: "0.1,0.5,1".
+func ParseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		b, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad histogram bucket %q: %w", part, err)
+		}
+		buckets = append(buckets, b)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no histogram buckets")
+	}
+
+	slices.Sort(buckets)
+	return slices.Compact(buckets), nil
+}
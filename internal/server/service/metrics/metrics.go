@@ -4,7 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/constants"
 	"github.com/AA122AA/metring/internal/server/domain"
@@ -13,27 +19,205 @@ import (
 	"go.uber.org/zap"
 )
 
+// labelForbiddenChars символы, которые нельзя использовать в имени метки,
+// иначе ключ серии (domain.SeriesKey) станет неоднозначным.
+const labelForbiddenChars = "{}=,\""
+
+// nameChars допустимые символы имени метрики, проверяются при WithStrictNames.
+var nameChars = regexp.MustCompile(`^[a-zA-Z0-9_.:\-]+$`)
+
+// MetadataRegistry реестр метаданных, по которому проверяется закреплённый тип метрики.
+type MetadataRegistry interface {
+	All(ctx context.Context) map[string]*domain.Metadata
+	CheckType(ctx context.Context, name, mType string) error
+}
+
+// SeriesLimiter следит за числом серий и отклоняет новые сверх лимита.
+type SeriesLimiter interface {
+	Admit(ctx context.Context, metrics []*domain.Metrics) error
+	Forget(ctx context.Context, keys []string)
+}
+
+// Publisher получает серии после успешной записи, например для живого стрима.
+type Publisher interface {
+	Publish(ctx context.Context, metrics []*domain.Metrics)
+}
+
+// incrementPublisher подписчик, которому кроме новых значений нужны приросты counter
+// из принятого обновления, например чтобы повторить его на другом сервере.
+// Вызывается вместо Publish.
+type incrementPublisher interface {
+	PublishIncrements(ctx context.Context, metrics []*domain.Metrics, increments map[string]int64)
+}
+
+// forgetter подписчик, который хранит что-то по сериям и должен узнать об их удалении.
+type forgetter interface {
+	Forget(ctx context.Context, keys []string)
+}
+
 type Metrics struct {
-	repo repository.MetricsRepository
-	lg   *zap.Logger
+	repo     repository.MetricsRepository
+	buckets  []float64
+	metadata MetadataRegistry
+	series   SeriesLimiter
+	pubs     []Publisher
+	// setInterval интервал, за который set считает уникальные значения, 0 - без сброса
+	setInterval time.Duration
+	// maxName предел длины имени, 0 - без ограничения
+	maxName     int
+	strictNames bool
+	namePattern *regexp.Regexp
+	lg          *zap.Logger
+}
+
+type Option func(m *Metrics)
+
+// WithMetadata включает проверку типа по реестру метаданных при обновлении.
+func WithMetadata(reg MetadataRegistry) Option {
+	return func(m *Metrics) {
+		m.metadata = reg
+	}
+}
+
+// WithBuckets задаёт границы бакетов, по которым раскладываются наблюдения histogram.
+func WithBuckets(buckets []float64) Option {
+	return func(m *Metrics) {
+		m.buckets = buckets
+	}
+}
+
+// WithSetInterval задаёт интервал, по истечении которого set начинает считать заново.
+func WithSetInterval(d time.Duration) Option {
+	return func(m *Metrics) {
+		m.setInterval = d
+	}
+}
+
+// WithSeriesLimiter включает лимиты на число серий.
+func WithSeriesLimiter(l SeriesLimiter) Option {
+	return func(m *Metrics) {
+		m.series = l
+	}
+}
+
+// WithNameRules задаёт предел длины имени и регулярное выражение, которому имя должно соответствовать.
+func WithNameRules(maxLen int, pattern *regexp.Regexp) Option {
+	return func(m *Metrics) {
+		m.maxName = maxLen
+		m.namePattern = pattern
+	}
+}
+
+// WithStrictNames ограничивает символы имени набором nameChars.
+func WithStrictNames(strict bool) Option {
+	return func(m *Metrics) {
+		m.strictNames = strict
+	}
+}
+
+// WithPublisher передаёт каждое принятое обновление с новым значением серии.
+// Можно указать несколько раз.
+func WithPublisher(p Publisher) Option {
+	return func(m *Metrics) {
+		m.pubs = append(m.pubs, p)
+	}
 }
 
-func NewMetrics(ctx context.Context, r repository.MetricsRepository) *Metrics {
-	return &Metrics{
-		repo: r,
-		lg:   zctx.From(ctx).Named("metrics service"),
+func NewMetrics(ctx context.Context, r repository.MetricsRepository, opts ...Option) *Metrics {
+	m := &Metrics{
+		repo:    r,
+		buckets: domain.DefaultBuckets,
+		lg:      zctx.From(ctx).Named("metrics service"),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
 }
 
 func (m *Metrics) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
 	return m.repo.GetAll(ctx)
 }
 
+// Tenants возвращает тенантов, у которых есть метрики.
+func (m *Metrics) Tenants(ctx context.Context) ([]string, error) {
+	return m.repo.Tenants(ctx)
+}
+
+// Metadata возвращает реестр метаданных по имени метрики, nil если реестр не подключен.
+func (m *Metrics) Metadata(ctx context.Context) map[string]*domain.Metadata {
+	if m.metadata == nil {
+		return nil
+	}
+
+	return m.metadata.All(ctx)
+}
+
+// checkType проверяет тип по реестру метаданных, если он подключен. Тип уже записанной
+// серии сверяется отдельно при слиянии со значением из репозитория.
+func (m *Metrics) checkType(ctx context.Context, data *domain.MetricsJSON) error {
+	if m.metadata == nil {
+		return nil
+	}
+
+	return m.metadata.CheckType(ctx, data.ID, data.MType)
+}
+
+// checkName проверяет имя по правилам именования.
+func (m *Metrics) checkName(name string) error {
+	if m.maxName > 0 && len(name) > m.maxName {
+		return fmt.Errorf("%w: %d characters, limit is %d", domain.ErrBadName, len(name), m.maxName)
+	}
+	if m.strictNames && !nameChars.MatchString(name) {
+		return fmt.Errorf("%w: %q, allowed characters are letters, digits and _ . : -", domain.ErrBadName, name)
+	}
+	if m.namePattern != nil && !m.namePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q does not match %s", domain.ErrBadName, name, m.namePattern)
+	}
+
+	return nil
+}
+
+// admit проверяет лимит серий, если он подключен.
+func (m *Metrics) admit(ctx context.Context, metrics []*domain.Metrics) error {
+	if m.series == nil {
+		return nil
+	}
+
+	return m.series.Admit(ctx, metrics)
+}
+
+// Find возвращает серии с указанным именем и типом (пустые значения не фильтруют),
+// у которых есть все метки из labels. Ключи как в репозитории.
+func (m *Metrics) Find(ctx context.Context, name, mType string, labels map[string]string) (map[string]*domain.Metrics, error) {
+	all, err := m.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]*domain.Metrics, len(all))
+	for k, v := range all {
+		if name != "" && v.ID != name {
+			continue
+		}
+		if mType != "" && v.MType != mType {
+			continue
+		}
+		if !domain.MatchLabels(v.Labels, labels) {
+			continue
+		}
+		res[k] = v
+	}
+
+	return res, nil
+}
+
 func (m *Metrics) get(ctx context.Context, data *domain.MetricsJSON) (*domain.Metrics, error) {
 	if err := validate(data, constants.Get); err != nil {
 		return nil, err
 	}
-	metric, err := m.repo.Get(ctx, data.ID)
+	metric, err := m.repo.Get(ctx, data.Key())
 	if err != nil {
 		return nil, fmt.Errorf("err from repo: %w", err)
 	}
@@ -57,6 +241,14 @@ func (m *Metrics) Get(ctx context.Context, data *domain.MetricsJSON) (string, er
 		res = fmt.Sprintf("%g", *metric.Value)
 	case domain.Counter:
 		res = fmt.Sprintf("%d", *metric.Delta)
+	case domain.Histogram:
+		res = metric.Histogram.String()
+	case domain.Summary:
+		res = metric.Summary.String()
+	case domain.Set:
+		res = metric.Set.String()
+	case domain.Timer:
+		res = metric.Timer.String()
 	}
 
 	return res, nil
@@ -75,36 +267,82 @@ func (m *Metrics) Update(ctx context.Context, data *domain.MetricsJSON) error {
 	if err := validate(data, constants.Update); err != nil {
 		return err
 	}
+	if err := m.checkName(data.ID); err != nil {
+		return err
+	}
+	if err := m.checkType(ctx, data); err != nil {
+		return err
+	}
+	m.observe(data)
 	metric := domain.TransformFromJSON(data)
+	metric.UpdatedAt = time.Now()
+	if err := m.admit(ctx, []*domain.Metrics{metric}); err != nil {
+		return err
+	}
+
+	var increments map[string]int64
+	if metric.MType == domain.Counter {
+		increments = map[string]int64{metric.Key(): *metric.Delta}
+	}
 
-	v, err := m.repo.Get(ctx, metric.ID)
+	v, err := m.repo.Get(ctx, metric.Key())
 	if err != nil {
 		var er *repository.EmptyRepoError
 		if errors.Is(err, er) {
-			return m.repo.Write(ctx, data.ID, metric)
+			if err := m.repo.Write(ctx, metric.Key(), metric); err != nil {
+				return err
+			}
+			m.publish(ctx, increments, metric)
+			return nil
 		}
 		return fmt.Errorf("%w", err)
 	}
+	if err := conflicts(metric, v); err != nil {
+		return err
+	}
 
-	// Увеличиваем значение, если это Counter
-	if data.MType == domain.Counter {
+	// Увеличиваем значение, если это Counter, и складываем распределения
+	switch data.MType {
+	case domain.Counter:
 		*metric.Delta += *v.Delta
+	case domain.Histogram, domain.Summary, domain.Set, domain.Timer:
+		if err := m.merge(metric, v); err != nil {
+			return err
+		}
+	}
+
+	if err := m.repo.Update(ctx, metric); err != nil {
+		return err
 	}
+	m.publish(ctx, increments, metric)
 
-	return m.repo.Update(ctx, metric)
+	return nil
 }
 
 func (m *Metrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
-	mm, err := trim(data)
+	for _, d := range data {
+		if err := m.checkType(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	mm, err := m.trim(data)
 	if err != nil {
 		return err
 	}
+	if err := m.admit(ctx, slices.Collect(maps.Values(mm))); err != nil {
+		return err
+	}
 
 	toUpdate := make([]*domain.Metrics, 0, len(mm))
 	toInsert := make([]*domain.Metrics, 0, len(mm))
+	increments := make(map[string]int64)
 
-	for name, metric := range mm {
-		fromRepo, err := m.repo.Get(ctx, name)
+	for key, metric := range mm {
+		if metric.MType == domain.Counter {
+			increments[key] = *metric.Delta
+		}
+		fromRepo, err := m.repo.Get(ctx, key)
 		if err != nil {
 			var er *repository.EmptyRepoError
 			if errors.Is(err, er) {
@@ -113,8 +351,16 @@ func (m *Metrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error
 			}
 			return err
 		}
+		if err := conflicts(metric, fromRepo); err != nil {
+			return err
+		}
 
-		if fromRepo.MType == domain.Counter {
+		switch {
+		case isDistribution(metric.MType):
+			if err := m.merge(metric, fromRepo); err != nil {
+				return err
+			}
+		case fromRepo.MType == domain.Counter:
 			*metric.Delta += *fromRepo.Delta
 		}
 
@@ -134,34 +380,197 @@ func (m *Metrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error
 			return err
 		}
 	}
+	m.publish(ctx, increments, append(toInsert, toUpdate...)...)
 
 	return nil
 }
 
-func trim(data []*domain.MetricsJSON) (map[string]*domain.Metrics, error) {
+// Import записывает gauge и counter, если их время новее времени обновления сохранённой
+// серии, так догрузка прошлых данных не затирает свежие. Временем обновления записанной
+// серии становится время загрузки, чтобы TTL не удалил её сразу. Counter задаётся полным
+// значением. Подписчики не уведомляются.
+func (m *Metrics) Import(ctx context.Context, metrics []*domain.Metrics) error {
+	for _, metric := range metrics {
+		d := domain.TransformToJSON(metric)
+		if metric.MType != domain.Gauge && metric.MType != domain.Counter {
+			return fmt.Errorf("only gauge and counter can be imported, got %q", metric.MType)
+		}
+		if err := validate(d, constants.Update); err != nil {
+			return err
+		}
+		if err := m.checkName(d.ID); err != nil {
+			return err
+		}
+		if err := m.checkType(ctx, d); err != nil {
+			return err
+		}
+	}
+	if err := m.admit(ctx, metrics); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	toUpdate := make([]*domain.Metrics, 0, len(metrics))
+	toInsert := make([]*domain.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		c := *metric
+		c.UpdatedAt = now
+		fromRepo, err := m.repo.Get(ctx, metric.Key())
+		if err != nil {
+			var er *repository.EmptyRepoError
+			if errors.Is(err, er) {
+				toInsert = append(toInsert, &c)
+				continue
+			}
+			return err
+		}
+		if err := conflicts(metric, fromRepo); err != nil {
+			return err
+		}
+		if metric.UpdatedAt.After(fromRepo.UpdatedAt) {
+			toUpdate = append(toUpdate, &c)
+		}
+	}
+
+	if len(toInsert) > 0 {
+		if err := m.repo.WriteMetrics(ctx, toInsert); err != nil {
+			return err
+		}
+	}
+	if len(toUpdate) > 0 {
+		if err := m.repo.UpdateMetrics(ctx, toUpdate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish increments приросты counter из обновления по ключам серий.
+func (m *Metrics) publish(ctx context.Context, increments map[string]int64, metrics ...*domain.Metrics) {
+	if len(metrics) == 0 {
+		return
+	}
+	for _, p := range m.pubs {
+		if ip, ok := p.(incrementPublisher); ok {
+			ip.PublishIncrements(ctx, metrics, increments)
+			continue
+		}
+		p.Publish(ctx, metrics)
+	}
+}
+
+func (m *Metrics) trim(data []*domain.MetricsJSON) (map[string]*domain.Metrics, error) {
 	mm := make(map[string]*domain.Metrics, len(data))
+	now := time.Now()
 	for _, d := range data {
 		err := validate(d, constants.Update)
 		if err != nil {
 			return nil, err
 		}
+		if err := m.checkName(d.ID); err != nil {
+			return nil, err
+		}
+		m.observe(d)
 
 		metric := domain.TransformFromJSON(d)
+		metric.UpdatedAt = now
 
-		if v, ok := mm[metric.ID]; ok {
-			if v.MType == domain.Counter {
+		if v, ok := mm[metric.Key()]; ok {
+			// одна серия в пачке дважды, но с разными типами
+			if err := conflicts(metric, v); err != nil {
+				return nil, err
+			}
+			switch v.MType {
+			case domain.Counter:
 				*v.Delta += *metric.Delta
-			} else {
+			case domain.Histogram, domain.Summary, domain.Set, domain.Timer:
+				if err := mergeDistribution(v, metric); err != nil {
+					return nil, err
+				}
+			default:
 				*v.Value = *metric.Value
 			}
 		} else {
-			mm[metric.ID] = metric
+			mm[metric.Key()] = metric
 		}
 	}
 
 	return mm, nil
 }
 
+// Delete удаляет серии с именем и типом, у которых есть все метки из labels.
+// Возвращает ключи удалённых серий.
+func (m *Metrics) Delete(ctx context.Context, name, mType string, labels map[string]string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty name")
+	}
+
+	return m.deleteWhere(ctx, time.Now(), func(v *domain.Metrics) bool {
+		return v.ID == name && v.MType == mType && domain.MatchLabels(v.Labels, labels)
+	})
+}
+
+// DeleteByPrefix удаляет все серии, имя которых начинается с prefix.
+func (m *Metrics) DeleteByPrefix(ctx context.Context, prefix string) ([]string, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("empty prefix")
+	}
+
+	return m.deleteWhere(ctx, time.Now(), func(v *domain.Metrics) bool {
+		return strings.HasPrefix(v.ID, prefix)
+	})
+}
+
+// Expire удаляет серии, которые не обновлялись с момента before.
+func (m *Metrics) Expire(ctx context.Context, before time.Time) ([]string, error) {
+	return m.deleteWhere(ctx, before, func(v *domain.Metrics) bool {
+		return !v.UpdatedAt.IsZero() && v.UpdatedAt.Before(before)
+	})
+}
+
+// deleteWhere удаляет серии, подходящие под match. Репозиторий удаляет только те,
+// что не обновлялись с before, поэтому обновление между выборкой и удалением не теряется.
+func (m *Metrics) deleteWhere(ctx context.Context, before time.Time, match func(v *domain.Metrics) bool) ([]string, error) {
+	all, err := m.repo.GetAll(ctx)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if errors.Is(err, er) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for k, v := range all {
+		if match(v) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	keys, err = m.repo.Delete(ctx, keys, before)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if m.series != nil {
+		m.series.Forget(ctx, keys)
+	}
+	for _, p := range m.pubs {
+		if f, ok := p.(forgetter); ok {
+			f.Forget(ctx, keys)
+		}
+	}
+	m.lg.Debug("deleted metrics", zap.Int("count", len(keys)))
+
+	return keys, nil
+}
+
 func (m *Metrics) Parse(mType, mName, value, handler string) (*domain.MetricsJSON, error) {
 	data := &domain.MetricsJSON{
 		ID:    mName,
@@ -194,24 +603,200 @@ func (m *Metrics) Parse(mType, mName, value, handler string) (*domain.MetricsJSO
 
 		data.Value = &f
 		return data, nil
+	case domain.Histogram, domain.Summary:
+		// значение в URL - одно наблюдение
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad input value - %w", err)
+		}
+		if err := finite(f); err != nil {
+			return nil, err
+		}
+
+		data.Value = &f
+		return data, nil
+	case domain.Timer:
+		// число в миллисекундах или длительность в формате Go: 150ms, 1.5s
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			d, errDur := time.ParseDuration(value)
+			if errDur != nil {
+				return nil, fmt.Errorf("bad input value - %w", err)
+			}
+			f = float64(d) / float64(time.Millisecond)
+		}
+		if err := finite(f); err != nil {
+			return nil, err
+		}
+
+		data.Value = &f
+		return data, nil
+	case domain.Set:
+		// значение в URL - элемент множества, любая непустая строка
+		if value == "" {
+			return nil, fmt.Errorf("empty set item")
+		}
+
+		data.Set = &domain.SetData{Items: []string{value}}
+		return data, nil
 	default:
 		return nil, fmt.Errorf("wrong type")
 	}
 }
 
+// validate проверяет обновление. Ошибки имени и меток оборачивают domain.ErrBadName,
+// ошибки значения - domain.ErrBadValue, чтобы вызывающий мог отличить их от сбоя записи.
 func validate(data *domain.MetricsJSON, handler string) error {
 	if data.ID == "" {
-		return fmt.Errorf("empty name")
+		return fmt.Errorf("%w: empty name", domain.ErrBadName)
+	}
+	if data.Value == nil && data.Delta == nil && data.Histogram == nil && data.Summary == nil &&
+		data.Set == nil && data.Timer == nil && handler == constants.Update {
+		return fmt.Errorf("%w: empty Value or Delta", domain.ErrBadValue)
+	}
+	// метки входят в имя серии, поэтому плохие метки - плохое имя
+	for k := range data.Labels {
+		if k == "" || strings.ContainsAny(k, labelForbiddenChars) {
+			return fmt.Errorf("%w: bad label name %q", domain.ErrBadName, k)
+		}
 	}
-	if data.Value == nil && data.Delta == nil && handler == constants.Update {
-		return fmt.Errorf("empty Value or Delta")
+	if data.Value != nil && isDistribution(data.MType) {
+		if err := finite(*data.Value); err != nil {
+			return err
+		}
 	}
 	switch data.MType {
 	case domain.Counter:
 		return nil
 	case domain.Gauge:
 		return nil
+	case domain.Histogram:
+		if data.Histogram != nil {
+			return badValue(data.Histogram.Validate())
+		}
+		return nil
+	case domain.Summary:
+		if data.Summary != nil {
+			return badValue(data.Summary.Validate())
+		}
+		return nil
+	case domain.Set:
+		if data.Set != nil {
+			return badValue(data.Set.Validate())
+		}
+		return nil
+	case domain.Timer:
+		if data.Timer != nil {
+			return badValue(data.Timer.Validate())
+		}
+		return nil
 	default:
-		return fmt.Errorf("wrong type")
+		return fmt.Errorf("%w: wrong type %q", domain.ErrBadValue, data.MType)
+	}
+}
+
+// badValue помечает ошибку проверки распределения как domain.ErrBadValue.
+func badValue(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", domain.ErrBadValue, err)
+}
+
+// finite проверяет наблюдение распределения: NaN и Inf испортили бы сумму и квантили.
+func finite(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%w: %g", domain.ErrBadValue, v)
+	}
+
+	return nil
+}
+
+// observe превращает наблюдение из Value в распределение для histogram, summary, set и timer.
+func (m *Metrics) observe(data *domain.MetricsJSON) {
+	if data.MType == domain.Set && data.Set != nil {
+		data.Set.Compact()
+	}
+	if data.Value == nil {
+		return
 	}
+
+	switch data.MType {
+	case domain.Histogram:
+		if data.Histogram == nil {
+			data.Histogram = domain.NewHistogram(m.buckets)
+		}
+		data.Histogram.Observe(*data.Value)
+	case domain.Summary:
+		if data.Summary == nil {
+			data.Summary = &domain.SummaryData{}
+		}
+		data.Summary.Observe(*data.Value)
+	case domain.Timer:
+		if data.Timer == nil {
+			data.Timer = &domain.TimerData{}
+		}
+		data.Timer.Observe(*data.Value)
+	case domain.Set:
+		if data.Set == nil {
+			data.Set = &domain.SetData{}
+		}
+		data.Set.Add(strconv.FormatFloat(*data.Value, 'g', -1, 64))
+	default:
+		return
+	}
+	data.Value = nil
+}
+
+// conflicts проверяет, что тип обновления совпадает с типом уже записанной серии.
+// Без реестра метаданных это единственная защита от смешения gauge и counter в одной серии.
+func conflicts(metric, old *domain.Metrics) error {
+	if metric.MType == old.MType {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s is %s, got %s", domain.ErrTypeConflict, metric.Key(), old.MType, metric.MType)
+}
+
+// mergeDistribution прибавляет к metric распределение из old. Типы должны совпадать.
+func mergeDistribution(metric, old *domain.Metrics) error {
+	if err := conflicts(metric, old); err != nil {
+		return err
+	}
+
+	// распределения, которые нельзя сложить (разные бакеты, размер скетча), - плохое значение
+	switch metric.MType {
+	case domain.Histogram:
+		return badValue(metric.Histogram.Merge(old.Histogram))
+	case domain.Summary:
+		return badValue(metric.Summary.Merge(old.Summary))
+	case domain.Set:
+		return badValue(metric.Set.Merge(old.Set))
+	case domain.Timer:
+		return badValue(metric.Timer.Merge(old.Timer))
+	}
+
+	return nil
+}
+
+// merge складывает распределение с сохранённым. Set считает уникальные значения
+// за интервал setInterval: скетч из прошлого интервала не учитывается.
+func (m *Metrics) merge(metric, old *domain.Metrics) error {
+	if metric.MType == domain.Set && m.setInterval > 0 &&
+		!old.UpdatedAt.Truncate(m.setInterval).Equal(metric.UpdatedAt.Truncate(m.setInterval)) {
+		return conflicts(metric, old)
+	}
+
+	return mergeDistribution(metric, old)
+}
+
+// isDistribution типы, значения которых складываются через mergeDistribution.
+func isDistribution(mType string) bool {
+	switch mType {
+	case domain.Histogram, domain.Summary, domain.Set, domain.Timer:
+		return true
+	}
+
+	return false
 }
@@ -2,7 +2,13 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/AA122AA/metring/internal/server/constants"
 	"github.com/AA122AA/metring/internal/server/domain"
@@ -177,6 +183,42 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestTypeConflict(t *testing.T) {
+	v := 1.5
+	d := int64(2)
+	gauge := func() *domain.MetricsJSON { return &domain.MetricsJSON{ID: "load", MType: domain.Gauge, Value: &v} }
+	counter := func() *domain.MetricsJSON { return &domain.MetricsJSON{ID: "load", MType: domain.Counter, Delta: &d} }
+	ctx := context.Background()
+
+	cases := []struct {
+		name   string
+		stored *domain.MetricsJSON
+		update *domain.MetricsJSON
+	}{
+		{name: "Gauge to counter", stored: gauge(), update: counter()},
+		{name: "Counter to gauge", stored: counter(), update: gauge()},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name+" Update", func(t *testing.T) {
+			m := NewMetrics(ctx, repository.NewMemStorage())
+			require.NoError(t, m.Update(ctx, tCase.stored))
+			require.ErrorIs(t, m.Update(ctx, tCase.update), domain.ErrTypeConflict)
+		})
+		t.Run(tCase.name+" Updates", func(t *testing.T) {
+			m := NewMetrics(ctx, repository.NewMemStorage())
+			require.NoError(t, m.Updates(ctx, []*domain.MetricsJSON{tCase.stored}))
+			require.ErrorIs(t, m.Updates(ctx, []*domain.MetricsJSON{tCase.update}), domain.ErrTypeConflict)
+		})
+		t.Run(tCase.name+" in one batch", func(t *testing.T) {
+			m := NewMetrics(ctx, repository.NewMemStorage())
+			require.ErrorIs(t, m.Updates(ctx, []*domain.MetricsJSON{tCase.stored, tCase.update}), domain.ErrTypeConflict)
+			_, err := m.GetAll(ctx)
+			var er *repository.EmptyRepoError
+			require.ErrorIs(t, err, er)
+		})
+	}
+}
+
 func TestGet(t *testing.T) {
 	ctx := context.Background()
 	cases := []struct {
@@ -237,3 +279,298 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestDistributions(t *testing.T) {
+	ctx := context.Background()
+	srv := NewMetrics(ctx, repository.NewMemStorage(), WithBuckets([]float64{0.1, 0.5, 1}))
+
+	// Наблюдения через URL API раскладываются по бакетам
+	for _, v := range []string{"0.05", "0.3", "0.7", "2"} {
+		data, err := srv.Parse(domain.Histogram, "latency", v, constants.Update)
+		require.NoError(t, err)
+		require.NoError(t, srv.Update(ctx, data))
+	}
+
+	// Готовая гистограмма с теми же границами складывается
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{{
+		ID:    "latency",
+		MType: domain.Histogram,
+		Histogram: &domain.HistogramData{
+			Bounds: []float64{0.1, 0.5, 1},
+			Counts: []uint64{1, 0, 0, 1},
+			Count:  2,
+			Sum:    5.05,
+		},
+	}}))
+
+	got, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "latency", MType: domain.Histogram})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 1, 1, 2}, got.Histogram.Counts)
+	require.Equal(t, uint64(6), got.Histogram.Count)
+	require.InDelta(t, 8.1, got.Histogram.Sum, 1e-9)
+
+	s, err := srv.Get(ctx, &domain.MetricsJSON{ID: "latency", MType: domain.Histogram})
+	require.NoError(t, err)
+	require.Equal(t, "count=6 sum=8.1 le_0.1=2 le_0.5=3 le_1=4 le_+Inf=6", s)
+
+	// Другие границы не складываются
+	err = srv.Update(ctx, &domain.MetricsJSON{
+		ID:        "latency",
+		MType:     domain.Histogram,
+		Histogram: &domain.HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 0}, Count: 1, Sum: 1},
+	})
+	require.Error(t, err)
+
+	// Summary считает квантили по всем наблюдениям
+	batch := make([]*domain.MetricsJSON, 0, 100)
+	for i := 1; i <= 100; i++ {
+		v := float64(i)
+		batch = append(batch, &domain.MetricsJSON{ID: "rt", MType: domain.Summary, Value: &v})
+	}
+	require.NoError(t, srv.Updates(ctx, batch[:50]))
+	require.NoError(t, srv.Updates(ctx, batch[50:]))
+
+	got, err = srv.GetJSON(ctx, &domain.MetricsJSON{ID: "rt", MType: domain.Summary})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), got.Summary.Count)
+	require.Equal(t, float64(1), got.Summary.Min)
+	require.Equal(t, float64(100), got.Summary.Max)
+	require.InDelta(t, 50.5, got.Summary.Quantile(0.5), 1)
+	require.InDelta(t, 99, got.Summary.Quantile(0.99), 1)
+
+	// Неверная гистограмма отклоняется
+	err = srv.Update(ctx, &domain.MetricsJSON{
+		ID:        "bad",
+		MType:     domain.Histogram,
+		Histogram: &domain.HistogramData{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1},
+	})
+	require.Error(t, err)
+}
+
+func TestSetAndTimer(t *testing.T) {
+	ctx := context.Background()
+	srv := NewMetrics(ctx, repository.NewMemStorage())
+
+	// Повторяющиеся значения set считаются один раз
+	for _, user := range []string{"alice", "bob", "alice", "carol"} {
+		data, err := srv.Parse(domain.Set, "users", user, constants.Update)
+		require.NoError(t, err)
+		require.NoError(t, srv.Update(ctx, data))
+	}
+
+	// Скетч с другого агента объединяется с сохранённым
+	other := &domain.SetData{}
+	other.Add("bob")
+	other.Add("dave")
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "users", MType: domain.Set, Set: other},
+		{ID: "users", MType: domain.Set, Set: &domain.SetData{Items: []string{"erin"}}},
+	}))
+
+	s, err := srv.Get(ctx, &domain.MetricsJSON{ID: "users", MType: domain.Set})
+	require.NoError(t, err)
+	require.Equal(t, "count=5", s)
+
+	// Set считает уникальные значения за интервал: скетч прошлого интервала сбрасывается
+	repo := repository.NewMemStorage()
+	srv = NewMetrics(ctx, repo, WithSetInterval(time.Hour))
+	for _, user := range []string{"alice", "bob"} {
+		data, err := srv.Parse(domain.Set, "users", user, constants.Update)
+		require.NoError(t, err)
+		require.NoError(t, srv.Update(ctx, data))
+	}
+	stored, err := repo.Get(ctx, "users")
+	require.NoError(t, err)
+	stored.UpdatedAt = stored.UpdatedAt.Add(-2 * time.Hour)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "users", MType: domain.Set, Set: &domain.SetData{Items: []string{"bob", "carol"}}},
+	}))
+	s, err = srv.Get(ctx, &domain.MetricsJSON{ID: "users", MType: domain.Set})
+	require.NoError(t, err)
+	require.Equal(t, "count=2", s)
+
+	cases := []struct {
+		value string
+		pass  bool
+	}{
+		{value: "10", pass: true},
+		{value: "20ms", pass: true},
+		{value: "0.03s", pass: true},
+		{value: "fast", pass: false},
+	}
+	for _, tCase := range cases {
+		data, err := srv.Parse(domain.Timer, "request", tCase.value, constants.Update)
+		if !tCase.pass {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, srv.Update(ctx, data))
+	}
+
+	got, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "request", MType: domain.Timer})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), got.Timer.Count)
+	require.Equal(t, float64(60), got.Timer.Sum)
+	require.Equal(t, float64(10), got.Timer.Min)
+	require.Equal(t, float64(30), got.Timer.Max)
+
+	s, err = srv.Get(ctx, &domain.MetricsJSON{ID: "request", MType: domain.Timer})
+	require.NoError(t, err)
+	require.Equal(t, "count=3 sum=60 min=10 max=30 p50=20 p95=30 p99=30", s)
+
+	// У таймера в JSON свои квантили, а не квантили summary
+	res, err := json.Marshal(got.Timer)
+	require.NoError(t, err)
+	var timer struct {
+		Quantiles map[string]float64 `json:"quantiles"`
+	}
+	require.NoError(t, json.Unmarshal(res, &timer))
+	require.Equal(t, []string{"0.5", "0.95", "0.99"}, slices.Sorted(maps.Keys(timer.Quantiles)))
+}
+
+func TestBadValue(t *testing.T) {
+	ctx := context.Background()
+	srv := NewMetrics(ctx, repository.NewMemStorage())
+
+	for _, mType := range []string{domain.Histogram, domain.Summary, domain.Timer} {
+		for _, value := range []string{"NaN", "+Inf", "-Inf"} {
+			_, err := srv.Parse(mType, "latency", value, constants.Update)
+			require.ErrorIs(t, err, domain.ErrBadValue, mType+" "+value)
+		}
+
+		nan := math.NaN()
+		err := srv.Updates(ctx, []*domain.MetricsJSON{{ID: "latency", MType: mType, Value: &nan}})
+		require.ErrorIs(t, err, domain.ErrBadValue, mType)
+		require.True(t, domain.Rejected(err))
+	}
+
+	// Для gauge NaN - допустимое значение
+	data, err := srv.Parse(domain.Gauge, "temperature", "NaN", constants.Update)
+	require.NoError(t, err)
+	require.NoError(t, srv.Update(ctx, data))
+}
+
+func TestNameRules(t *testing.T) {
+	ctx := context.Background()
+	srv := NewMetrics(ctx, repository.NewMemStorage(),
+		WithNameRules(16, regexp.MustCompile(`^[a-z]`)),
+		WithStrictNames(true),
+	)
+
+	cases := []struct {
+		name string
+		pass bool
+	}{
+		{name: "alloc", pass: true},
+		{name: "http.requests_total", pass: false},
+		{name: "req 42", pass: false},
+		{name: "Alloc", pass: false},
+		{name: "host1.cpu", pass: true},
+	}
+	v := float64(1)
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := srv.Updates(ctx, []*domain.MetricsJSON{{ID: tCase.name, MType: domain.Gauge, Value: &v}})
+			if tCase.pass {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, domain.ErrBadName)
+			}
+		})
+	}
+
+	// Без WithStrictNames набор символов не ограничен, длина и шаблон проверяются
+	lax := NewMetrics(ctx, repository.NewMemStorage(), WithNameRules(16, regexp.MustCompile(`^[a-z]`)))
+	require.NoError(t, lax.Updates(ctx, []*domain.MetricsJSON{{ID: "req 42", MType: domain.Gauge, Value: &v}}))
+	err := lax.Updates(ctx, []*domain.MetricsJSON{{ID: "Alloc", MType: domain.Gauge, Value: &v}})
+	require.ErrorIs(t, err, domain.ErrBadName)
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	srv := NewMetrics(ctx, repository.NewMemStorage())
+	now := time.Now()
+
+	v, total := float64(5), int64(3)
+	require.NoError(t, srv.Updates(ctx, []*domain.MetricsJSON{
+		{ID: "Alloc", MType: domain.Gauge, Value: &v},
+		{ID: "PollCount", MType: domain.Counter, Delta: &total},
+	}))
+
+	old, fresh, imported := float64(1), float64(2), int64(100)
+	require.NoError(t, srv.Import(ctx, []*domain.Metrics{
+		// старое значение не затирает текущее
+		{ID: "Alloc", MType: domain.Gauge, Value: &old, UpdatedAt: now.Add(-time.Hour)},
+		{ID: "Free", MType: domain.Gauge, Value: &fresh, UpdatedAt: now.Add(-time.Hour)},
+		// counter задаётся итогом, а не приростом
+		{ID: "PollCount", MType: domain.Counter, Delta: &imported, UpdatedAt: now.Add(time.Minute)},
+	}))
+
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5.0, *all["Alloc"].Value)
+	require.Equal(t, 2.0, *all["Free"].Value)
+	// время обновления - время загрузки, TTL не удаляет догруженную серию
+	require.False(t, all["Free"].UpdatedAt.Before(now))
+	expired, err := srv.Expire(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, expired)
+	require.Equal(t, int64(100), *all["PollCount"].Delta)
+
+	err = srv.Import(ctx, []*domain.Metrics{{ID: "Alloc", MType: domain.Counter, Delta: &imported, UpdatedAt: now}})
+	require.ErrorIs(t, err, domain.ErrTypeConflict)
+	err = srv.Import(ctx, []*domain.Metrics{{ID: "Free", MType: domain.Gauge, Value: &v, Labels: map[string]string{"a=b": "c"}, UpdatedAt: now}})
+	require.ErrorIs(t, err, domain.ErrBadName)
+	// ошибка значения не выдаётся за ошибку имени
+	err = srv.Import(ctx, []*domain.Metrics{{ID: "Free", MType: domain.Gauge, UpdatedAt: now}})
+	require.ErrorIs(t, err, domain.ErrBadValue)
+	require.NotErrorIs(t, err, domain.ErrBadName)
+}
+
+// racyRepo отдаёт копию хранилища и сразу после выборки выполняет update,
+// как параллельный запрос между GetAll и Delete.
+type racyRepo struct {
+	*repository.MemStorage
+	update func()
+}
+
+func (r *racyRepo) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
+	all, err := r.MemStorage.GetAll(ctx)
+	snapshot := make(map[string]*domain.Metrics, len(all))
+	for k, v := range all {
+		c := *v
+		snapshot[k] = &c
+	}
+	if r.update != nil {
+		r.update()
+		r.update = nil
+	}
+
+	return snapshot, err
+}
+
+func TestExpireRace(t *testing.T) {
+	ctx := context.Background()
+	repo := &racyRepo{MemStorage: repository.NewMemStorage()}
+	srv := NewMetrics(ctx, repo)
+
+	v, fresh := float64(1), float64(2)
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "Alloc", MType: domain.Gauge, Value: &v}))
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "Free", MType: domain.Gauge, Value: &v}))
+	for _, k := range []string{"Alloc", "Free"} {
+		repo.Values[k].UpdatedAt = time.Now().Add(-time.Hour)
+	}
+
+	// Alloc обновили после выборки кандидатов - он не удаляется
+	repo.update = func() {
+		require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "Alloc", MType: domain.Gauge, Value: &fresh}))
+	}
+	keys, err := srv.Expire(ctx, time.Now().Add(-30*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Free"}, keys)
+
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "Alloc", MType: domain.Gauge})
+	require.NoError(t, err)
+	require.Equal(t, fresh, *m.Value)
+}
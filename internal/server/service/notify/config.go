@@ -0,0 +1,14 @@
+package notify
+
+type Config struct {
+	// ReceiversFile YAML или JSON файл с получателями уведомлений.
+	ReceiversFile string `json:"notifyReceivers" yaml:"notifyReceivers" env:"NOTIFY_RECEIVERS"`
+	// StateFile файл с очередью уведомлений и тишинами, без него они теряются при рестарте.
+	StateFile string `json:"notifyState" yaml:"notifyState" env:"NOTIFY_STATE"`
+	// GroupBy метки через запятую, алерты с одинаковыми значениями уходят одним уведомлением.
+	GroupBy string `json:"notifyGroupBy" yaml:"notifyGroupBy" env:"NOTIFY_GROUP_BY" default:"alertname"`
+	// RepeatInterval через сколько секунд повторить уведомление о неизменной группе.
+	RepeatInterval int `json:"notifyRepeatInterval" yaml:"notifyRepeatInterval" env:"NOTIFY_REPEAT_INTERVAL" default:"14400"`
+	// MaxRetry сколько раз пытаться доставить уведомление.
+	MaxRetry int `json:"notifyMaxRetry" yaml:"notifyMaxRetry" env:"NOTIFY_MAX_RETRY" default:"10"`
+}
@@ -0,0 +1,401 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+const (
+	// tick как часто проверяется очередь на доставку.
+	tick        = time.Second
+	maxBackoff  = 5 * time.Minute
+	sendTimeout = 10 * time.Second
+)
+
+// Notification уведомление в очереди на доставку. Тело собирается при постановке
+// в очередь, чтобы после рестарта отправить ровно то же самое.
+type Notification struct {
+	ID          string          `json:"id"`
+	Receiver    string          `json:"receiver"`
+	GroupKey    string          `json:"groupKey"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+type state struct {
+	Queue    []*Notification `json:"queue"`
+	Silences []*Silence      `json:"silences"`
+}
+
+// sent что и когда последний раз ушло получателю по группе.
+type sent struct {
+	firing string
+	at     time.Time
+}
+
+// Notifier группирует алерты, убирает повторы и доставляет уведомления на вебхуки
+// с повторными попытками. Очередь и тишины сохраняются в файл состояния.
+type Notifier struct {
+	receivers []*Receiver
+	groupBy   []string
+	repeat    time.Duration
+	maxRetry  int
+	path      string
+	client    *http.Client
+
+	mu       sync.Mutex
+	queue    []*Notification
+	silences map[string]*Silence
+	sent     map[string]sent
+	now      func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewNotifier(ctx context.Context, cfg Config, receivers []*Receiver) (*Notifier, error) {
+	n := &Notifier{
+		receivers: receivers,
+		repeat:    time.Duration(cfg.RepeatInterval) * time.Second,
+		maxRetry:  cfg.MaxRetry,
+		path:      cfg.StateFile,
+		client:    &http.Client{Timeout: sendTimeout},
+		queue:     make([]*Notification, 0),
+		silences:  make(map[string]*Silence),
+		sent:      make(map[string]sent),
+		now:       time.Now,
+		lg:        zctx.From(ctx).Named("notifier"),
+	}
+	for _, l := range strings.Split(cfg.GroupBy, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			n.groupBy = append(n.groupBy, l)
+		}
+	}
+	if n.path == "" {
+		return n, nil
+	}
+
+	data, err := os.ReadFile(n.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return n, nil
+		}
+		return nil, fmt.Errorf("can not read notifier state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("can not parse notifier state: %w", err)
+	}
+	n.queue = append(n.queue, st.Queue...)
+	for _, s := range st.Silences {
+		n.silences[s.ID] = s
+	}
+	n.lg.Info("loaded notifier state", zap.Int("queued", len(n.queue)), zap.Int("silences", len(n.silences)))
+
+	return n, nil
+}
+
+func (n *Notifier) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			n.deliver(ctx)
+		}
+	}
+}
+
+// Notify принимает все firing алерты и только что разрешённые после очередного
+// вычисления правил и ставит в очередь уведомления по изменившимся группам.
+// Неизменная группа повторяется раз в RepeatInterval.
+func (n *Notifier) Notify(ctx context.Context, alerts []*domain.Alert) {
+	if len(n.receivers) == 0 {
+		return
+	}
+	now := n.now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seen := make(map[string]bool)
+	added := false
+	for _, g := range n.group(alerts, now) {
+		firing, resolved := g.fingerprint()
+		for _, r := range n.receivers {
+			if !r.accepts(g.tenant) {
+				continue
+			}
+			key := r.Name + "/" + g.key
+			seen[key] = true
+
+			last, ok := n.sent[key]
+			if firing == "" && !ok {
+				continue
+			}
+			if ok && !resolved && last.firing == firing && now.Sub(last.at) < n.repeat {
+				continue
+			}
+
+			body, err := r.payload(g)
+			if err != nil {
+				n.lg.Error("error while building payload", zap.String("receiver", r.Name), zap.Error(err))
+				continue
+			}
+			n.queue = append(n.queue, &Notification{
+				ID:          newID(),
+				Receiver:    r.Name,
+				GroupKey:    g.key,
+				Body:        body,
+				NextAttempt: now,
+				CreatedAt:   now,
+			})
+			added = true
+
+			if firing == "" {
+				delete(n.sent, key)
+			} else {
+				n.sent[key] = sent{firing: firing, at: now}
+			}
+		}
+	}
+	// группы без firing алертов больше не отслеживаются, при новом срабатывании уведомим сразу
+	for key := range n.sent {
+		if !seen[key] {
+			delete(n.sent, key)
+		}
+	}
+
+	if added {
+		if err := n.save(); err != nil {
+			n.lg.Error("error while saving notifier state", zap.Error(err))
+		}
+	}
+}
+
+// Queue возвращает уведомления, ожидающие доставки.
+func (n *Notifier) Queue() []*Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	res := make([]*Notification, 0, len(n.queue))
+	for _, q := range n.queue {
+		c := *q
+		res = append(res, &c)
+	}
+
+	return res
+}
+
+// group раскладывает незаглушённые алерты по группам, вызывается под блокировкой.
+func (n *Notifier) group(alerts []*domain.Alert, now time.Time) []*group {
+	groups := make(map[string]*group)
+	for _, a := range alerts {
+		if n.silenced(a, now) {
+			continue
+		}
+
+		labels := make(map[string]string, len(n.groupBy))
+		for _, l := range n.groupBy {
+			if v, ok := a.Labels[l]; ok {
+				labels[l] = v
+			}
+		}
+		key := a.Tenant + domain.SeriesKey("", labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{key: key, tenant: a.Tenant, labels: labels}
+			groups[key] = g
+		}
+		g.alerts = append(g.alerts, a)
+	}
+
+	res := make([]*group, 0, len(groups))
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[key]
+		slices.SortFunc(g.alerts, func(a, b *domain.Alert) int {
+			return strings.Compare(a.Rule+"/"+a.Series, b.Rule+"/"+b.Series)
+		})
+		res = append(res, g)
+	}
+
+	return res
+}
+
+// fingerprint firing алерты группы и есть ли среди алертов разрешённые.
+func (g *group) fingerprint() (string, bool) {
+	firing := make([]string, 0, len(g.alerts))
+	resolved := false
+	for _, a := range g.alerts {
+		switch a.State {
+		case domain.AlertFiring:
+			firing = append(firing, a.Rule+"/"+a.Series)
+		case domain.AlertResolved:
+			resolved = true
+		}
+	}
+
+	return strings.Join(firing, "\n"), resolved
+}
+
+// deliver отправляет уведомления, у которых подошло время. Ошибки сети и 5xx
+// повторяются с экспоненциальной задержкой, остальные ответы не повторяются.
+func (n *Notifier) deliver(ctx context.Context) {
+	now := n.now()
+
+	n.mu.Lock()
+	due := make([]*Notification, 0)
+	for _, q := range n.queue {
+		if !q.NextAttempt.After(now) {
+			due = append(due, q)
+		}
+	}
+	n.mu.Unlock()
+
+	changed := false
+	for _, q := range due {
+		retry, err := n.send(ctx, q)
+		if ctx.Err() != nil {
+			// не доставленное отправится после рестарта
+			return
+		}
+
+		n.mu.Lock()
+		q.Attempts++
+		switch {
+		case err == nil:
+			n.remove(q.ID)
+			n.lg.Debug("notification delivered", zap.String("receiver", q.Receiver), zap.String("group", q.GroupKey))
+		case retry && q.Attempts < n.maxRetry:
+			q.NextAttempt = now.Add(backoff(q.Attempts))
+			n.lg.Warn("notification delivery failed, will retry", zap.String("receiver", q.Receiver),
+				zap.Int("attempt", q.Attempts), zap.Time("next", q.NextAttempt), zap.Error(err))
+		default:
+			n.remove(q.ID)
+			n.lg.Error("notification dropped", zap.String("receiver", q.Receiver),
+				zap.String("group", q.GroupKey), zap.Int("attempts", q.Attempts), zap.Error(err))
+		}
+		n.mu.Unlock()
+		changed = true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for id, s := range n.silences {
+		if !now.Before(s.EndsAt) {
+			delete(n.silences, id)
+			changed = true
+		}
+	}
+	if changed {
+		if err := n.save(); err != nil {
+			n.lg.Error("error while saving notifier state", zap.Error(err))
+		}
+	}
+}
+
+// send возвращает ошибку и стоит ли повторять отправку.
+func (n *Notifier) send(ctx context.Context, q *Notification) (bool, error) {
+	idx := slices.IndexFunc(n.receivers, func(r *Receiver) bool { return r.Name == q.Receiver })
+	if idx < 0 {
+		return false, fmt.Errorf("receiver %s is not configured", q.Receiver)
+	}
+	r := n.receivers[idx]
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(q.Body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+
+	return retry, fmt.Errorf("receiver %s responded with %s", r.Name, resp.Status)
+}
+
+// remove вызывается под блокировкой.
+func (n *Notifier) remove(id string) {
+	n.queue = slices.DeleteFunc(n.queue, func(q *Notification) bool { return q.ID == id })
+}
+
+// save записывает очередь и тишины в файл состояния, вызывается под блокировкой.
+func (n *Notifier) save() error {
+	if n.path == "" {
+		return nil
+	}
+
+	st := state{Queue: n.queue, Silences: make([]*Silence, 0, len(n.silences))}
+	for _, id := range slices.Sorted(maps.Keys(n.silences)) {
+		st.Silences = append(st.Silences, n.silences[id])
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("can not marshal notifier state: %w", err)
+	}
+	// через временный файл, чтобы не оставить половину очереди при падении
+	tmp := n.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("can not write notifier state: %w", err)
+	}
+	if err := os.Rename(tmp, n.path); err != nil {
+		return fmt.Errorf("can not write notifier state: %w", err)
+	}
+
+	return nil
+}
+
+// backoff задержка перед попыткой после attempts неудачных: 1s, 2s, 4s... до maxBackoff.
+func backoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return maxBackoff
+	}
+	return min(time.Second<<(attempts-1), maxBackoff)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,259 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+)
+
+type webhook struct {
+	mu     sync.Mutex
+	bodies [][]byte
+	codes  []int
+}
+
+func (wh *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	code := http.StatusOK
+	if len(wh.codes) > 0 {
+		code, wh.codes = wh.codes[0], wh.codes[1:]
+	}
+	if code == http.StatusOK {
+		wh.bodies = append(wh.bodies, body)
+	}
+	w.WriteHeader(code)
+}
+
+func firing(rule, series string, labels map[string]string) *domain.Alert {
+	all := map[string]string{"alertname": rule}
+	for k, v := range labels {
+		all[k] = v
+	}
+	return &domain.Alert{Rule: rule, Series: series, Labels: all, State: domain.AlertFiring, Value: 1}
+}
+
+func newTestNotifier(t *testing.T, cfg Config, receivers ...*Receiver) (*Notifier, *time.Time) {
+	t.Helper()
+	if cfg.MaxRetry == 0 {
+		cfg.MaxRetry = 3
+	}
+	if cfg.RepeatInterval == 0 {
+		cfg.RepeatInterval = 3600
+	}
+	if cfg.GroupBy == "" {
+		cfg.GroupBy = "alertname"
+	}
+	n, err := NewNotifier(context.Background(), cfg, receivers)
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	return n, &now
+}
+
+func TestNotifyGrouping(t *testing.T) {
+	ctx := context.Background()
+	wh := &webhook{}
+	srv := httptest.NewServer(wh)
+	defer srv.Close()
+
+	n, now := newTestNotifier(t, Config{}, &Receiver{Name: "oncall", URL: srv.URL, Type: TypeJSON})
+	a := firing("HeapTooBig", "HeapAlloc{host=a}", map[string]string{"host": "a"})
+	b := firing("HeapTooBig", "HeapAlloc{host=b}", map[string]string{"host": "b"})
+	c := firing("NoPolls", "PollCount", nil)
+
+	n.Notify(ctx, []*domain.Alert{a, b, c})
+	// две группы по alertname
+	require.Len(t, n.Queue(), 2)
+
+	// повтор без изменений не уходит
+	n.Notify(ctx, []*domain.Alert{a, b, c})
+	require.Len(t, n.Queue(), 2)
+
+	n.deliver(ctx)
+	require.Empty(t, n.Queue())
+	require.Len(t, wh.bodies, 2)
+
+	var p jsonPayload
+	require.NoError(t, json.Unmarshal(wh.bodies[0], &p))
+	require.Equal(t, "firing", p.Status)
+	require.Equal(t, map[string]string{"alertname": "HeapTooBig"}, p.GroupLabels)
+	require.Len(t, p.Alerts, 2)
+
+	// разрешение алерта меняет группу
+	resolved := *b
+	resolved.State = domain.AlertResolved
+	n.Notify(ctx, []*domain.Alert{a, &resolved, c})
+	require.Len(t, n.Queue(), 1)
+	n.Notify(ctx, []*domain.Alert{a, c})
+	require.Len(t, n.Queue(), 1)
+
+	// неизменные группы повторяются через RepeatInterval
+	*now = now.Add(time.Hour)
+	n.Notify(ctx, []*domain.Alert{a, c})
+	require.Len(t, n.Queue(), 3)
+}
+
+func TestNotifyTenants(t *testing.T) {
+	ctx := context.Background()
+	n, _ := newTestNotifier(t, Config{},
+		&Receiver{Name: "all", URL: "http://localhost", Type: TypeJSON},
+		&Receiver{Name: "acme", URL: "http://localhost", Type: TypeJSON, Tenants: []string{"acme"}},
+	)
+
+	a := firing("HeapTooBig", "HeapAlloc", nil)
+	b := firing("HeapTooBig", "HeapAlloc", nil)
+	b.Tenant = "acme"
+	n.Notify(ctx, []*domain.Alert{a, b})
+
+	got := make(map[string]int)
+	for _, q := range n.Queue() {
+		got[q.Receiver]++
+	}
+	require.Equal(t, map[string]int{"all": 2, "acme": 1}, got)
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		codes    []int
+		ticks    []time.Duration
+		queued   int
+		attempts int
+		bodies   int
+	}{
+		{name: "Retry on 5xx", codes: []int{500, 502}, ticks: []time.Duration{0, time.Second, 2 * time.Second}, queued: 0, bodies: 1},
+		{name: "Backoff not passed", codes: []int{500, 500}, ticks: []time.Duration{0, time.Second, time.Second}, queued: 1, attempts: 2, bodies: 0},
+		{name: "Drop on 4xx", codes: []int{400}, ticks: []time.Duration{0}, queued: 0, bodies: 0},
+		{name: "Drop after max retry", codes: []int{500, 500, 500}, ticks: []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}, queued: 0, bodies: 0},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			wh := &webhook{codes: tCase.codes}
+			srv := httptest.NewServer(wh)
+			defer srv.Close()
+
+			n, now := newTestNotifier(t, Config{MaxRetry: 3}, &Receiver{Name: "oncall", URL: srv.URL, Type: TypeJSON})
+			n.Notify(ctx, []*domain.Alert{firing("NoPolls", "PollCount", nil)})
+
+			for _, d := range tCase.ticks {
+				*now = now.Add(d)
+				n.deliver(ctx)
+			}
+
+			q := n.Queue()
+			require.Len(t, q, tCase.queued)
+			if tCase.queued > 0 {
+				require.Equal(t, tCase.attempts, q[0].Attempts)
+			}
+			require.Len(t, wh.bodies, tCase.bodies)
+		})
+	}
+}
+
+func TestSilences(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+	n, now := newTestNotifier(t, Config{StateFile: path}, &Receiver{Name: "oncall", URL: "http://localhost", Type: TypeJSON})
+
+	_, err := n.AddSilence(ctx, &Silence{EndsAt: now.Add(time.Hour)})
+	require.ErrorIs(t, err, ErrBadSilence)
+	_, err = n.AddSilence(ctx, &Silence{Matchers: map[string]string{"host": "a"}, EndsAt: now.Add(-time.Hour)})
+	require.ErrorIs(t, err, ErrBadSilence)
+
+	s, err := n.AddSilence(ctx, &Silence{Matchers: map[string]string{"host": "a"}, EndsAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	require.NotEmpty(t, s.ID)
+
+	// чужой тенант тишину не видит и не удаляет
+	acme := domain.WithTenant(ctx, "acme")
+	require.Empty(t, n.Silences(acme))
+	require.ErrorIs(t, n.DeleteSilence(acme, s.ID), ErrSilenceNotFound)
+
+	a := firing("HeapTooBig", "HeapAlloc{host=a}", map[string]string{"host": "a"})
+	n.Notify(ctx, []*domain.Alert{a})
+	require.Empty(t, n.Queue())
+
+	b := firing("HeapTooBig", "HeapAlloc{host=b}", map[string]string{"host": "b"})
+	n.Notify(ctx, []*domain.Alert{a, b})
+	require.Len(t, n.Queue(), 1)
+
+	// очередь и тишины переживают рестарт
+	restarted, _ := newTestNotifier(t, Config{StateFile: path}, &Receiver{Name: "oncall", URL: "http://localhost", Type: TypeJSON})
+	require.Len(t, restarted.Queue(), 1)
+	require.Len(t, restarted.Silences(ctx), 1)
+
+	// истёкшие тишины удаляются
+	*now = now.Add(2 * time.Hour)
+	n.queue = n.queue[:0]
+	n.deliver(ctx)
+	require.Empty(t, n.Silences(ctx))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"queue":[],"silences":[]}`, string(data))
+}
+
+func TestPayloads(t *testing.T) {
+	a := firing("HeapTooBig", "HeapAlloc{host=a}", map[string]string{"host": "a", "severity": "page"})
+	a.Annotations = map[string]string{"summary": "heap is too big"}
+	b := firing("HeapTooBig", "HeapAlloc{host=b}", map[string]string{"host": "b", "severity": "page"})
+	b.State = domain.AlertResolved
+	g := &group{key: "{alertname=HeapTooBig}", labels: map[string]string{"alertname": "HeapTooBig"}, alerts: []*domain.Alert{a, b}}
+
+	body, err := (&Receiver{Name: "slack", Type: TypeSlack}).payload(g)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"text":"[FIRING:1] alertname=HeapTooBig\n• firing HeapTooBig HeapAlloc{host=a} = 1: heap is too big\n• resolved HeapTooBig HeapAlloc{host=b} = 1"}`, string(body))
+
+	body, err = (&Receiver{Name: "am", Type: TypeAlertmanager}).payload(g)
+	require.NoError(t, err)
+	var p amPayload
+	require.NoError(t, json.Unmarshal(body, &p))
+	require.Equal(t, "4", p.Version)
+	require.Equal(t, "firing", p.Status)
+	require.Equal(t, map[string]string{"alertname": "HeapTooBig", "severity": "page"}, p.CommonLabels)
+	require.Len(t, p.Alerts, 2)
+	require.Equal(t, "resolved", p.Alerts[1].Status)
+	require.NotEqual(t, p.Alerts[0].Fingerprint, p.Alerts[1].Fingerprint)
+}
+
+func TestLoadReceivers(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		pass bool
+	}{
+		{name: "Valid", data: "receivers:\n  - name: oncall\n    url: https://example.com/hook\n  - name: slack\n    url: https://hooks.slack.com/x\n    type: slack\n", pass: true},
+		{name: "Bad url", data: "receivers:\n  - name: oncall\n    url: example.com\n", pass: false},
+		{name: "Bad type", data: "receivers:\n  - name: oncall\n    url: http://example.com\n    type: pager\n", pass: false},
+		{name: "Duplicate", data: "receivers:\n  - name: a\n    url: http://example.com\n  - name: a\n    url: http://example.com\n", pass: false},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "receivers.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tCase.data), 0o600))
+
+			rs, err := LoadReceivers(path)
+			if !tCase.pass {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, TypeJSON, rs[0].Type)
+		})
+	}
+}
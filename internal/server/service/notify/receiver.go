@@ -0,0 +1,251 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"gopkg.in/yaml.v3"
+)
+
+// Форматы тела уведомления.
+const (
+	TypeJSON         = "json"
+	TypeSlack        = "slack"
+	TypeAlertmanager = "alertmanager"
+)
+
+// Receiver вебхук, на который отправляются уведомления.
+type Receiver struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+	// Type формат тела: json (по умолчанию), slack или alertmanager.
+	Type string `json:"type,omitempty" yaml:"type"`
+	// Tenants тенанты, чьи алерты получает вебхук, пусто - все.
+	Tenants []string          `json:"tenants,omitempty" yaml:"tenants"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+}
+
+type receiversFile struct {
+	Receivers []*Receiver `json:"receivers" yaml:"receivers"`
+}
+
+// LoadReceivers читает получателей из YAML или JSON файла.
+func LoadReceivers(path string) ([]*Receiver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read receivers file: %w", err)
+	}
+
+	var f receiversFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("can not parse receivers file: %w", err)
+	}
+
+	names := make(map[string]bool, len(f.Receivers))
+	for _, r := range f.Receivers {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate receiver %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	return f.Receivers, nil
+}
+
+func (r *Receiver) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("receiver without name")
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("receiver %s: bad url %q", r.Name, r.URL)
+	}
+	switch r.Type {
+	case "":
+		r.Type = TypeJSON
+	case TypeJSON, TypeSlack, TypeAlertmanager:
+	default:
+		return fmt.Errorf("receiver %s: unknown type %q", r.Name, r.Type)
+	}
+
+	return nil
+}
+
+func (r *Receiver) accepts(tenant string) bool {
+	return len(r.Tenants) == 0 || slices.Contains(r.Tenants, tenant)
+}
+
+// group алерты одного тенанта с одинаковыми значениями меток группировки.
+type group struct {
+	key    string
+	tenant string
+	labels map[string]string
+	alerts []*domain.Alert
+}
+
+func (g *group) status() string {
+	for _, a := range g.alerts {
+		if a.State == domain.AlertFiring {
+			return string(domain.AlertFiring)
+		}
+	}
+	return string(domain.AlertResolved)
+}
+
+// payload тело уведомления в формате получателя.
+func (r *Receiver) payload(g *group) ([]byte, error) {
+	switch r.Type {
+	case TypeSlack:
+		return json.Marshal(slackPayload{Text: slackText(g)})
+	case TypeAlertmanager:
+		return json.Marshal(amWebhook(r.Name, g))
+	default:
+		return json.Marshal(jsonPayload{
+			Receiver:    r.Name,
+			Status:      g.status(),
+			Tenant:      g.tenant,
+			GroupKey:    g.key,
+			GroupLabels: g.labels,
+			Alerts:      g.alerts,
+		})
+	}
+}
+
+type jsonPayload struct {
+	Receiver    string            `json:"receiver"`
+	Status      string            `json:"status"`
+	Tenant      string            `json:"tenant,omitempty"`
+	GroupKey    string            `json:"groupKey"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	Alerts      []*domain.Alert   `json:"alerts"`
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func slackText(g *group) string {
+	firing := 0
+	for _, a := range g.alerts {
+		if a.State == domain.AlertFiring {
+			firing++
+		}
+	}
+
+	var b strings.Builder
+	if firing > 0 {
+		fmt.Fprintf(&b, "[FIRING:%d]", firing)
+	} else {
+		b.WriteString("[RESOLVED]")
+	}
+	for _, k := range slices.Sorted(maps.Keys(g.labels)) {
+		fmt.Fprintf(&b, " %s=%s", k, g.labels[k])
+	}
+	if g.tenant != "" {
+		fmt.Fprintf(&b, " (tenant %s)", g.tenant)
+	}
+	for _, a := range g.alerts {
+		fmt.Fprintf(&b, "\n• %s %s %s = %s", a.State, a.Rule, a.Series, strconv.FormatFloat(a.Value, 'g', -1, 64))
+		if s := a.Annotations["summary"]; s != "" {
+			fmt.Fprintf(&b, ": %s", s)
+		}
+	}
+
+	return b.String()
+}
+
+// amPayload тело вебхука в формате Alertmanager (version 4).
+type amPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []amAlert         `json:"alerts"`
+}
+
+type amAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+func amWebhook(receiver string, g *group) amPayload {
+	p := amPayload{
+		Version:           "4",
+		GroupKey:          g.key,
+		Status:            g.status(),
+		Receiver:          receiver,
+		GroupLabels:       g.labels,
+		CommonLabels:      commonLabels(g.alerts, func(a *domain.Alert) map[string]string { return a.Labels }),
+		CommonAnnotations: commonLabels(g.alerts, func(a *domain.Alert) map[string]string { return a.Annotations }),
+		Alerts:            make([]amAlert, 0, len(g.alerts)),
+	}
+	for _, a := range g.alerts {
+		annotations := a.Annotations
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		p.Alerts = append(p.Alerts, amAlert{
+			Status:      string(a.State),
+			Labels:      a.Labels,
+			Annotations: annotations,
+			StartsAt:    a.FiredAt,
+			EndsAt:      a.ResolvedAt,
+			Fingerprint: fingerprint(a.Labels),
+		})
+	}
+
+	return p
+}
+
+// commonLabels пары, одинаковые у всех алертов группы.
+func commonLabels(alerts []*domain.Alert, get func(*domain.Alert) map[string]string) map[string]string {
+	res := make(map[string]string)
+	if len(alerts) == 0 {
+		return res
+	}
+	maps.Copy(res, get(alerts[0]))
+	for _, a := range alerts[1:] {
+		m := get(a)
+		for k, v := range res {
+			if m[k] != v {
+				delete(res, k)
+			}
+		}
+	}
+
+	return res
+}
+
+func fingerprint(labels map[string]string) string {
+	h := fnv.New64a()
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		h.Write([]byte(k))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[k]))
+		h.Write([]byte{0xff})
+	}
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
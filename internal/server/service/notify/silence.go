@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+var (
+	ErrSilenceNotFound = errors.New("silence not found")
+	ErrBadSilence      = errors.New("invalid silence")
+)
+
+// Silence подавляет уведомления по алертам, у которых совпадают все метки Matchers.
+type Silence struct {
+	ID        string            `json:"id"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Matchers  map[string]string `json:"matchers"`
+	StartsAt  time.Time         `json:"startsAt"`
+	EndsAt    time.Time         `json:"endsAt"`
+	CreatedBy string            `json:"createdBy,omitempty"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+func (s *Silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s *Silence) matches(a *domain.Alert) bool {
+	if s.Tenant != a.Tenant {
+		return false
+	}
+	for k, v := range s.Matchers {
+		if a.Labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Silences возвращает действующие и будущие тишины тенанта.
+func (n *Notifier) Silences(ctx context.Context) []*Silence {
+	tenant := domain.TenantFromContext(ctx)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	res := make([]*Silence, 0)
+	for _, id := range slices.Sorted(maps.Keys(n.silences)) {
+		if s := n.silences[id]; s.Tenant == tenant {
+			c := *s
+			res = append(res, &c)
+		}
+	}
+
+	return res
+}
+
+// AddSilence создаёт тишину в тенанте из контекста. Без StartsAt тишина действует сразу.
+func (n *Notifier) AddSilence(ctx context.Context, s *Silence) (*Silence, error) {
+	now := n.now()
+	if len(s.Matchers) == 0 {
+		return nil, fmt.Errorf("%w: no matchers", ErrBadSilence)
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	if !s.EndsAt.After(s.StartsAt) || !s.EndsAt.After(now) {
+		return nil, fmt.Errorf("%w: endsAt must be after startsAt and now", ErrBadSilence)
+	}
+
+	c := *s
+	c.ID = newID()
+	c.Tenant = domain.TenantFromContext(ctx)
+	c.Matchers = maps.Clone(s.Matchers)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.silences[c.ID] = &c
+	if err := n.save(); err != nil {
+		return nil, err
+	}
+
+	res := c
+	return &res, nil
+}
+
+// DeleteSilence снимает тишину тенанта досрочно.
+func (n *Notifier) DeleteSilence(ctx context.Context, id string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, ok := n.silences[id]
+	if !ok || s.Tenant != domain.TenantFromContext(ctx) {
+		return ErrSilenceNotFound
+	}
+	delete(n.silences, id)
+
+	return n.save()
+}
+
+// silenced вызывается под блокировкой.
+func (n *Notifier) silenced(a *domain.Alert, now time.Time) bool {
+	for _, s := range n.silences {
+		if s.active(now) && s.matches(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package otlp
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/AA122AA/metring/internal/delta"
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+)
+
+type Metrics interface {
+	Updates(ctx context.Context, metrics []*domain.MetricsJSON) error
+}
+
+// Receiver переводит OTLP метрики в модель metring:
+//   - Gauge и немонотонные Sum становятся gauge;
+//   - монотонные Sum становятся counter, накопительные переводятся в дельты по серии;
+//   - Histogram, ExponentialHistogram и Summary раскладываются на _count, _sum и бакеты _bucket{le=...}.
+//
+// Атрибуты ресурса и точки становятся метками серии.
+type Receiver struct {
+	srv    Metrics
+	deltas *delta.Tracker
+	lg     *zap.Logger
+}
+
+func NewReceiver(ctx context.Context, srv Metrics) *Receiver {
+	return &Receiver{
+		srv:    srv,
+		deltas: delta.NewTracker(),
+		lg:     zctx.From(ctx).Named("otlp receiver"),
+	}
+}
+
+// Export записывает метрики. Состояние накопительных счётчиков сохраняется только
+// после успешной записи, чтобы повтор запроса клиентом не потерял прирост.
+func (r *Receiver) Export(ctx context.Context, data *metricspb.MetricsData) error {
+	deltas := r.deltas.Batch()
+	metrics := make([]*domain.MetricsJSON, 0)
+	for _, rm := range data.GetResourceMetrics() {
+		resource := attributes(nil, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				metrics = append(metrics, r.convert(ctx, deltas, m, resource)...)
+			}
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	r.lg.Debug("got otlp metrics", zap.Int("count", len(metrics)))
+
+	if err := r.srv.Updates(ctx, metrics); err != nil {
+		return err
+	}
+	deltas.Commit()
+
+	return nil
+}
+
+func (r *Receiver) convert(ctx context.Context, deltas *delta.Batch, m *metricspb.Metric, resource map[string]string) []*domain.MetricsJSON {
+	name := m.GetName()
+	if name == "" {
+		return nil
+	}
+
+	var metrics []*domain.MetricsJSON
+	switch d := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range d.Gauge.GetDataPoints() {
+			if v, ok := numberValue(dp); ok {
+				metrics = append(metrics, gauge(name, attributes(resource, dp.GetAttributes()), v))
+			}
+		}
+	case *metricspb.Metric_Sum:
+		cumulative := d.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range d.Sum.GetDataPoints() {
+			v, ok := numberValue(dp)
+			if !ok {
+				continue
+			}
+			labels := attributes(resource, dp.GetAttributes())
+			if d.Sum.GetIsMonotonic() {
+				metrics = append(metrics, r.counter(ctx, deltas, name, labels, v, dp.GetStartTimeUnixNano(), cumulative))
+				continue
+			}
+			metrics = append(metrics, r.sum(ctx, deltas, name, labels, v, cumulative))
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := d.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range d.Histogram.GetDataPoints() {
+			if noValue(dp.GetFlags()) {
+				continue
+			}
+			labels := attributes(resource, dp.GetAttributes())
+			start := dp.GetStartTimeUnixNano()
+			metrics = append(metrics,
+				r.counter(ctx, deltas, name+"_count", labels, float64(dp.GetCount()), start, cumulative),
+				r.sum(ctx, deltas, name+"_sum", labels, dp.GetSum(), cumulative),
+			)
+
+			var running uint64
+			bounds := dp.GetExplicitBounds()
+			for i, c := range dp.GetBucketCounts() {
+				running += c
+				le := "+Inf"
+				if i < len(bounds) {
+					le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+				}
+				metrics = append(metrics, r.counter(ctx, deltas, name+"_bucket", withLabel(labels, "le", le), float64(running), start, cumulative))
+			}
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		cumulative := d.ExponentialHistogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range d.ExponentialHistogram.GetDataPoints() {
+			if noValue(dp.GetFlags()) {
+				continue
+			}
+			labels := attributes(resource, dp.GetAttributes())
+			metrics = append(metrics,
+				r.counter(ctx, deltas, name+"_count", labels, float64(dp.GetCount()), dp.GetStartTimeUnixNano(), cumulative),
+				r.sum(ctx, deltas, name+"_sum", labels, dp.GetSum(), cumulative),
+			)
+		}
+	case *metricspb.Metric_Summary:
+		// Summary в OTLP всегда накопительный
+		for _, dp := range d.Summary.GetDataPoints() {
+			if noValue(dp.GetFlags()) {
+				continue
+			}
+			labels := attributes(resource, dp.GetAttributes())
+			metrics = append(metrics,
+				r.counter(ctx, deltas, name+"_count", labels, float64(dp.GetCount()), dp.GetStartTimeUnixNano(), true),
+				gauge(name+"_sum", labels, dp.GetSum()),
+			)
+			for _, q := range dp.GetQuantileValues() {
+				quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+				metrics = append(metrics, gauge(name, withLabel(labels, "quantile", quantile), q.GetValue()))
+			}
+		}
+	default:
+		r.lg.Debug("unsupported otlp metric data", zap.String("name", name))
+	}
+
+	return metrics
+}
+
+func (r *Receiver) counter(ctx context.Context, deltas *delta.Batch, name string, labels map[string]string, v float64, start uint64, cumulative bool) *domain.MetricsJSON {
+	var d int64
+	if cumulative {
+		d = deltas.Delta(seriesKey(ctx, name, labels), v, start)
+	} else {
+		d = int64(math.Round(v))
+	}
+
+	return &domain.MetricsJSON{
+		ID:     name,
+		MType:  domain.Counter,
+		Delta:  &d,
+		Labels: labels,
+	}
+}
+
+// sum возвращает gauge с накопленным значением: дельты складываются по серии.
+func (r *Receiver) sum(ctx context.Context, deltas *delta.Batch, name string, labels map[string]string, v float64, cumulative bool) *domain.MetricsJSON {
+	if !cumulative {
+		v = deltas.Add(seriesKey(ctx, name, labels), v)
+	}
+
+	return gauge(name, labels, v)
+}
+
+// seriesKey ключ серии в трекере дельт, у каждого тенанта свои счётчики.
+func seriesKey(ctx context.Context, name string, labels map[string]string) string {
+	return domain.TenantFromContext(ctx) + "/" + domain.SeriesKey(name, labels)
+}
+
+func gauge(name string, labels map[string]string, v float64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{
+		ID:     name,
+		MType:  domain.Gauge,
+		Value:  &v,
+		Labels: labels,
+	}
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) (float64, bool) {
+	if noValue(dp.GetFlags()) {
+		return 0, false
+	}
+
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		if math.IsNaN(v.AsDouble) || math.IsInf(v.AsDouble, 0) {
+			return 0, false
+		}
+		return v.AsDouble, true
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(v.AsInt), true
+	default:
+		return 0, false
+	}
+}
+
+func noValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// attributes добавляет атрибуты к base, не изменяя его.
+func attributes(base map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	res := make(map[string]string, len(base)+len(attrs))
+	for k, v := range base {
+		res[k] = v
+	}
+	for _, kv := range attrs {
+		if v, ok := anyValue(kv.GetValue()); ok {
+			res[kv.GetKey()] = v
+		}
+	}
+
+	return res
+}
+
+// withLabel возвращает копию labels с добавленной меткой.
+func withLabel(labels map[string]string, k, v string) map[string]string {
+	res := make(map[string]string, len(labels)+1)
+	for lk, lv := range labels {
+		res[lk] = lv
+	}
+	res[k] = v
+
+	return res
+}
+
+func anyValue(v *commonpb.AnyValue) (string, bool) {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,192 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const (
+	cumulative = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	deltaTemp  = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+)
+
+// recorder сервис метрик, запоминающий последнюю записанную пачку.
+type recorder struct {
+	got  []*domain.MetricsJSON
+	fail bool
+}
+
+func (r *recorder) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
+	if r.fail {
+		return errors.New("database is down")
+	}
+	r.got = data
+	return nil
+}
+
+// values значения записанной пачки по типу и ключу серии.
+func (r *recorder) values() map[string]float64 {
+	res := make(map[string]float64, len(r.got))
+	for _, m := range r.got {
+		key := m.MType + " " + domain.SeriesKey(m.ID, m.Labels)
+		if m.MType == domain.Counter {
+			res[key] = float64(*m.Delta)
+		} else {
+			res[key] = *m.Value
+		}
+	}
+	return res
+}
+
+func data(metrics ...*metricspb.Metric) *metricspb.MetricsData {
+	return &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+			Key:   "host",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}},
+		}}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func sum(name string, monotonic bool, temp metricspb.AggregationTemporality, start uint64, v float64) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		IsMonotonic:            monotonic,
+		AggregationTemporality: temp,
+		DataPoints: []*metricspb.NumberDataPoint{{
+			StartTimeUnixNano: start,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: v},
+		}},
+	}}}
+}
+
+func histogram(temp metricspb.AggregationTemporality, start uint64, count uint64, total float64, buckets ...uint64) *metricspb.Metric {
+	return &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+		AggregationTemporality: temp,
+		DataPoints: []*metricspb.HistogramDataPoint{{
+			StartTimeUnixNano: start,
+			Count:             count,
+			Sum:               &total,
+			ExplicitBounds:    []float64{0.1, 1},
+			BucketCounts:      buckets,
+		}},
+	}}}
+}
+
+func TestSums(t *testing.T) {
+	ctx := context.Background()
+	srv := &recorder{}
+	r := NewReceiver(ctx, srv)
+
+	export := func(metrics ...*metricspb.Metric) map[string]float64 {
+		require.NoError(t, r.Export(ctx, data(metrics...)))
+		return srv.values()
+	}
+
+	// первое наблюдение накопительного счётчика с неизвестным стартом - точка отсчёта
+	require.Equal(t, map[string]float64{
+		`counter requests{host="a"}`:    0,
+		`counter jobs{host="a"}`:        4,
+		`gauge connections{host="a"}`:   10,
+		`gauge queue_balance{host="a"}`: 3,
+	}, export(
+		sum("requests", true, cumulative, 0, 10),
+		sum("jobs", true, deltaTemp, 0, 4),
+		sum("connections", false, cumulative, 0, 10),
+		sum("queue_balance", false, deltaTemp, 0, 3),
+	))
+
+	// монотонные: накопительный переводится в прирост, дельта пишется как есть;
+	// немонотонные: накопительный - текущее значение, дельты складываются
+	require.Equal(t, map[string]float64{
+		`counter requests{host="a"}`:    5,
+		`counter jobs{host="a"}`:        2,
+		`gauge connections{host="a"}`:   7,
+		`gauge queue_balance{host="a"}`: 2,
+	}, export(
+		sum("requests", true, cumulative, 0, 15),
+		sum("jobs", true, deltaTemp, 0, 2),
+		sum("connections", false, cumulative, 0, 7),
+		sum("queue_balance", false, deltaTemp, 0, -1),
+	))
+
+	// сброс накопительного счётчика: прирост - всё новое значение
+	require.Equal(t, map[string]float64{`counter requests{host="a"}`: 3}, export(sum("requests", true, cumulative, 0, 3)))
+}
+
+func TestHistogram(t *testing.T) {
+	ctx := context.Background()
+	srv := &recorder{}
+	r := NewReceiver(ctx, srv)
+	// счётчик стартовал после запуска приёмника, первое наблюдение учитывается целиком
+	start := uint64(time.Now().Add(time.Minute).UnixNano())
+
+	require.NoError(t, r.Export(ctx, data(histogram(cumulative, start, 6, 2.5, 1, 2, 3))))
+	require.Equal(t, map[string]float64{
+		`counter latency_count{host="a"}`:            6,
+		`gauge latency_sum{host="a"}`:                2.5,
+		`counter latency_bucket{host="a",le="0.1"}`:  1,
+		`counter latency_bucket{host="a",le="1"}`:    3,
+		`counter latency_bucket{host="a",le="+Inf"}`: 6,
+	}, srv.values())
+
+	// бакеты накопительные по le, приросты считаются по каждому бакету
+	require.NoError(t, r.Export(ctx, data(histogram(cumulative, start, 8, 4, 1, 3, 4))))
+	require.Equal(t, map[string]float64{
+		`counter latency_count{host="a"}`:            2,
+		`gauge latency_sum{host="a"}`:                4,
+		`counter latency_bucket{host="a",le="0.1"}`:  0,
+		`counter latency_bucket{host="a",le="1"}`:    1,
+		`counter latency_bucket{host="a",le="+Inf"}`: 2,
+	}, srv.values())
+}
+
+func TestHistogramDelta(t *testing.T) {
+	ctx := context.Background()
+	srv := &recorder{}
+	r := NewReceiver(ctx, srv)
+
+	// дельта гистограмма: счётчики пишутся как есть, сумма накапливается в gauge
+	for range 2 {
+		require.NoError(t, r.Export(ctx, data(histogram(deltaTemp, 0, 3, 1.5, 1, 1, 1))))
+	}
+	require.Equal(t, map[string]float64{
+		`counter latency_count{host="a"}`:            3,
+		`gauge latency_sum{host="a"}`:                3,
+		`counter latency_bucket{host="a",le="0.1"}`:  1,
+		`counter latency_bucket{host="a",le="1"}`:    2,
+		`counter latency_bucket{host="a",le="+Inf"}`: 3,
+	}, srv.values())
+}
+
+func TestExportRetry(t *testing.T) {
+	ctx := context.Background()
+	srv := &recorder{}
+	r := NewReceiver(ctx, srv)
+
+	require.NoError(t, r.Export(ctx, data(sum("requests", true, cumulative, 0, 10), sum("balance", false, deltaTemp, 0, 1))))
+
+	// неудачная запись не сдвигает состояние: повтор даёт те же значения
+	srv.fail = true
+	require.Error(t, r.Export(ctx, data(sum("requests", true, cumulative, 0, 12), sum("balance", false, deltaTemp, 0, 1))))
+	srv.fail = false
+	require.NoError(t, r.Export(ctx, data(sum("requests", true, cumulative, 0, 12), sum("balance", false, deltaTemp, 0, 1))))
+	require.Equal(t, map[string]float64{
+		`counter requests{host="a"}`: 2,
+		`gauge balance{host="a"}`:    2,
+	}, srv.values())
+
+	// точки без значения пропускаются
+	skipped := sum("requests", true, cumulative, 0, 20)
+	skipped.GetSum().DataPoints[0].Flags = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+	srv.got = nil
+	require.NoError(t, r.Export(ctx, data(skipped)))
+	require.Nil(t, srv.got)
+}
@@ -0,0 +1,107 @@
+package promql
+
+import "time"
+
+// Функции над окном значений серии: f(name[5m]).
+const (
+	FuncRate        = "rate"
+	FuncIncrease    = "increase"
+	FuncAvgOverTime = "avg_over_time"
+	FuncMinOverTime = "min_over_time"
+	FuncMaxOverTime = "max_over_time"
+)
+
+var functions = map[string]bool{
+	FuncRate:        true,
+	FuncIncrease:    true,
+	FuncAvgOverTime: true,
+	FuncMinOverTime: true,
+	FuncMaxOverTime: true,
+}
+
+// Агрегации: op by (labels) (expr), topk(k, expr).
+const (
+	AggSum     = "sum"
+	AggAvg     = "avg"
+	AggMin     = "min"
+	AggMax     = "max"
+	AggCount   = "count"
+	AggTopK    = "topk"
+	AggBottomK = "bottomk"
+)
+
+var aggregations = map[string]bool{
+	AggSum:     true,
+	AggAvg:     true,
+	AggMin:     true,
+	AggMax:     true,
+	AggCount:   true,
+	AggTopK:    true,
+	AggBottomK: true,
+}
+
+// Expr разобранное выражение запроса.
+type Expr interface {
+	// Type тип результата: ResultVector, ResultScalar или ResultMatrix.
+	Type() string
+}
+
+type numberLiteral struct {
+	value float64
+}
+
+// vectorSelector значения серий в момент вычисления.
+type vectorSelector struct {
+	sel *Selector
+}
+
+// matrixSelector значения серий за окно: name[5m].
+type matrixSelector struct {
+	sel *Selector
+	rng time.Duration
+}
+
+type call struct {
+	fn  string
+	arg *matrixSelector
+}
+
+type aggregate struct {
+	op       string
+	grouping []string
+	without  bool
+	// param k для topk и bottomk
+	param Expr
+	expr  Expr
+}
+
+// binaryExpr арифметика и сравнения. Серии сопоставляются по всем меткам, кроме имени,
+// или только по меткам из on(...), или по всем, кроме ignoring(...).
+type binaryExpr struct {
+	op       string
+	lhs      Expr
+	rhs      Expr
+	matching []string
+	on       bool
+}
+
+func (*numberLiteral) Type() string  { return ResultScalar }
+func (*vectorSelector) Type() string { return ResultVector }
+func (*matrixSelector) Type() string { return ResultMatrix }
+func (*call) Type() string           { return ResultVector }
+func (*aggregate) Type() string      { return ResultVector }
+
+func (b *binaryExpr) Type() string {
+	if b.lhs.Type() == ResultScalar && b.rhs.Type() == ResultScalar {
+		return ResultScalar
+	}
+	return ResultVector
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", ">", "<", ">=", "<=":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package promql
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+// element значение одной серии в момент вычисления.
+type element struct {
+	labels map[string]string
+	v      float64
+}
+
+type vector []*element
+
+// evaluator вычисляет выражение по сериям тенанта, загруженным один раз на запрос.
+// Вычисленное значение - float64 для скаляра или vector.
+type evaluator struct {
+	ctx    context.Context
+	e      *Engine
+	series []*stored
+}
+
+func (ev *evaluator) eval(expr Expr, ts time.Time) (any, error) {
+	switch ex := expr.(type) {
+	case *numberLiteral:
+		return ex.value, nil
+	case *vectorSelector:
+		res := vector{}
+		for _, s := range ev.match(ex.sel) {
+			if v, ok := ev.valueAt(s, ts); ok {
+				res = append(res, &element{labels: s.labels, v: v})
+			}
+		}
+		return res, nil
+	case *call:
+		return ev.call(ex, ts), nil
+	case *aggregate:
+		return ev.aggregate(ex, ts)
+	case *binaryExpr:
+		return ev.binary(ex, ts)
+	}
+
+	return nil, fmt.Errorf("%w: range vector must be wrapped in a function", ErrBadQuery)
+}
+
+func (ev *evaluator) match(sel *Selector) []*stored {
+	res := make([]*stored, 0)
+	for _, s := range ev.series {
+		if sel.Matches(s.labels) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func (ev *evaluator) samples(s *stored) []Sample {
+	if !s.loaded {
+		s.samples = ev.e.samples(ev.ctx, s.m)
+		s.loaded = true
+	}
+	return s.samples
+}
+
+// valueAt значение серии в момент ts: последнее значение, принятое не позже ts.
+// Значение держится до следующего обновления, как и в хранилище.
+func (ev *evaluator) valueAt(s *stored, ts time.Time) (float64, bool) {
+	samples := ev.samples(s)
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].T.After(ts) })
+	if i == 0 {
+		return 0, false
+	}
+	return samples[i-1].V, true
+}
+
+// window значения серии за (ts-rng, ts].
+func (ev *evaluator) window(s *stored, ts time.Time, rng time.Duration) []Sample {
+	samples := ev.samples(s)
+	from := ts.Add(-rng)
+	lo := sort.Search(len(samples), func(i int) bool { return samples[i].T.After(from) })
+	hi := sort.Search(len(samples), func(i int) bool { return samples[i].T.After(ts) })
+	return samples[lo:hi]
+}
+
+func (ev *evaluator) call(c *call, ts time.Time) vector {
+	res := vector{}
+	for _, s := range ev.match(c.arg.sel) {
+		if v, ok := apply(c.fn, ev.window(s, ts, c.arg.rng)); ok {
+			res = append(res, &element{labels: dropName(s.labels), v: v})
+		}
+	}
+	return res
+}
+
+// apply функция над значениями окна. rate и increase считают прирост между первым
+// и последним значением окна без экстраполяции, сброс счётчика - прирост от нуля.
+func apply(fn string, samples []Sample) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	switch fn {
+	case FuncRate, FuncIncrease:
+		if len(samples) < 2 {
+			return 0, false
+		}
+		var inc float64
+		for i := 1; i < len(samples); i++ {
+			if d := samples[i].V - samples[i-1].V; d >= 0 {
+				inc += d
+			} else {
+				inc += samples[i].V
+			}
+		}
+		if fn == FuncIncrease {
+			return inc, true
+		}
+		return inc / samples[len(samples)-1].T.Sub(samples[0].T).Seconds(), true
+	case FuncAvgOverTime:
+		var sum float64
+		for _, s := range samples {
+			sum += s.V
+		}
+		return sum / float64(len(samples)), true
+	case FuncMinOverTime:
+		v := samples[0].V
+		for _, s := range samples[1:] {
+			v = math.Min(v, s.V)
+		}
+		return v, true
+	default:
+		v := samples[0].V
+		for _, s := range samples[1:] {
+			v = math.Max(v, s.V)
+		}
+		return v, true
+	}
+}
+
+func (ev *evaluator) aggregate(a *aggregate, ts time.Time) (vector, error) {
+	val, err := ev.eval(a.expr, ts)
+	if err != nil {
+		return nil, err
+	}
+	in := val.(vector)
+
+	groups := make(map[string]vector)
+	groupLabels := make(map[string]map[string]string)
+	for _, el := range in {
+		gl := grouped(el.labels, a.grouping, a.without)
+		key := domain.SeriesKey("", gl)
+		groups[key] = append(groups[key], el)
+		groupLabels[key] = gl
+	}
+
+	if a.op == AggTopK || a.op == AggBottomK {
+		p, err := ev.eval(a.param, ts)
+		if err != nil {
+			return nil, err
+		}
+		k := int(p.(float64))
+		res := vector{}
+		for _, key := range slices.Sorted(maps.Keys(groups)) {
+			g := groups[key]
+			sort.SliceStable(g, func(i, j int) bool {
+				if a.op == AggTopK {
+					return g[i].v > g[j].v
+				}
+				return g[i].v < g[j].v
+			})
+			res = append(res, g[:max(0, min(k, len(g)))]...)
+		}
+		return res, nil
+	}
+
+	res := vector{}
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[key]
+		v := g[0].v
+		switch a.op {
+		case AggSum, AggAvg:
+			for _, el := range g[1:] {
+				v += el.v
+			}
+			if a.op == AggAvg {
+				v /= float64(len(g))
+			}
+		case AggMin:
+			for _, el := range g[1:] {
+				v = math.Min(v, el.v)
+			}
+		case AggMax:
+			for _, el := range g[1:] {
+				v = math.Max(v, el.v)
+			}
+		case AggCount:
+			v = float64(len(g))
+		}
+		res = append(res, &element{labels: groupLabels[key], v: v})
+	}
+
+	return res, nil
+}
+
+// grouped метки группы: только из by(...) или все, кроме without(...) и имени.
+func grouped(labels map[string]string, grouping []string, without bool) map[string]string {
+	res := make(map[string]string)
+	for k, v := range labels {
+		if without {
+			if k != NameLabel && !slices.Contains(grouping, k) {
+				res[k] = v
+			}
+		} else if slices.Contains(grouping, k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func (ev *evaluator) binary(b *binaryExpr, ts time.Time) (any, error) {
+	lv, err := ev.eval(b.lhs, ts)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := ev.eval(b.rhs, ts)
+	if err != nil {
+		return nil, err
+	}
+	cmp := isComparison(b.op)
+
+	switch l := lv.(type) {
+	case float64:
+		if r, ok := rv.(float64); ok {
+			return arith(b.op, l, r), nil
+		}
+		res := vector{}
+		for _, el := range rv.(vector) {
+			switch {
+			case !cmp:
+				res = append(res, &element{labels: dropName(el.labels), v: arith(b.op, l, el.v)})
+			case compare(b.op, l, el.v):
+				res = append(res, el)
+			}
+		}
+		return res, nil
+	case vector:
+		if r, ok := rv.(float64); ok {
+			res := vector{}
+			for _, el := range l {
+				switch {
+				case !cmp:
+					res = append(res, &element{labels: dropName(el.labels), v: arith(b.op, el.v, r)})
+				case compare(b.op, el.v, r):
+					res = append(res, el)
+				}
+			}
+			return res, nil
+		}
+		return ev.vectorBinary(b, l, rv.(vector))
+	}
+
+	return nil, fmt.Errorf("%w: unexpected operand of %q", ErrBadQuery, b.op)
+}
+
+// vectorBinary сопоставляет серии один к одному по сигнатуре меток.
+func (ev *evaluator) vectorBinary(b *binaryExpr, lhs, rhs vector) (vector, error) {
+	right := make(map[string]*element, len(rhs))
+	for _, el := range rhs {
+		sig := b.signature(el.labels)
+		if _, ok := right[sig]; ok {
+			return nil, fmt.Errorf("%w: found duplicate series for the match group %s on the right side of %q", ErrBadQuery, sig, b.op)
+		}
+		right[sig] = el
+	}
+
+	seen := make(map[string]bool, len(lhs))
+	res := vector{}
+	for _, el := range lhs {
+		sig := b.signature(el.labels)
+		r, ok := right[sig]
+		if !ok {
+			continue
+		}
+		if seen[sig] {
+			return nil, fmt.Errorf("%w: found duplicate series for the match group %s on the left side of %q", ErrBadQuery, sig, b.op)
+		}
+		seen[sig] = true
+
+		if isComparison(b.op) {
+			if compare(b.op, el.v, r.v) {
+				res = append(res, el)
+			}
+			continue
+		}
+		labels := dropName(el.labels)
+		if b.on {
+			labels = grouped(labels, b.matching, false)
+		} else {
+			labels = grouped(labels, b.matching, true)
+		}
+		res = append(res, &element{labels: labels, v: arith(b.op, el.v, r.v)})
+	}
+
+	return res, nil
+}
+
+// signature метки, по которым сопоставляются серии.
+func (b *binaryExpr) signature(labels map[string]string) string {
+	return domain.SeriesKey("", grouped(labels, b.matching, !b.on))
+}
+
+func arith(op string, l, r float64) float64 {
+	switch op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		return l / r
+	case "%":
+		return math.Mod(l, r)
+	case "^":
+		return math.Pow(l, r)
+	}
+	return math.NaN()
+}
+
+func compare(op string, l, r float64) bool {
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case ">":
+		return l > r
+	case "<":
+		return l < r
+	case ">=":
+		return l >= r
+	case "<=":
+		return l <= r
+	}
+	return false
+}
+
+// dropName копия меток без имени метрики: после вычислений серия перестаёт быть исходной метрикой.
+func dropName(labels map[string]string) map[string]string {
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != NameLabel {
+			res[k] = v
+		}
+	}
+	return res
+}
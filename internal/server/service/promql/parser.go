@@ -0,0 +1,694 @@
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrBadQuery = errors.New("bad query")
+
+// Операторы сравнения меток.
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// NameLabel метка с именем метрики, как в Prometheus.
+const NameLabel = "__name__"
+
+// Matcher условие на значение метки.
+type Matcher struct {
+	Name  string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewMatcher(name, op, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Op: op, Value: value}
+	switch op {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		// как в Prometheus, регулярное выражение должно совпасть со всем значением
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad regexp %q: %v", ErrBadQuery, value, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("%w: unknown match operator %q", ErrBadQuery, op)
+	}
+
+	return m, nil
+}
+
+// Matches отсутствующая метка считается пустой строкой.
+func (m *Matcher) Matches(v string) bool {
+	switch m.Op {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+// Selector выбирает серии: name{label="v", ...}. Имя переводится в matcher по __name__,
+// имя с * или ? - шаблон: cpu.*, Heap*.
+type Selector struct {
+	Matchers []*Matcher
+}
+
+// Matches подходит ли серия с метками, включая __name__.
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, m := range s.Matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// name точное имя метрики из селектора, если оно задано через =.
+func (s *Selector) name() string {
+	for _, m := range s.Matchers {
+		if m.Name == NameLabel && m.Op == MatchEqual {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+// isNameChar символы имени метрики. Имена metring могут содержать точку и дефис,
+// а в селекторе - шаблоны * и ?, поэтому вычитание и умножение в выражениях
+// нужно отделять пробелами: a - b, a * 2.
+func isNameChar(r rune, first bool) bool {
+	if r == '_' || r == ':' || unicode.IsLetter(r) {
+		return true
+	}
+	if first {
+		return false
+	}
+	return r == '.' || r == '-' || r == '*' || r == '?' || unicode.IsDigit(r)
+}
+
+// operators от длинных к коротким, чтобы >= не разбиралось как > и =.
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "=", ">", "<", "+", "-", "*", "/", "%", "^"}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	rs := []rune(input)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '{':
+			tokens = append(tokens, token{kind: tokLBrace, val: "{", pos: i})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{kind: tokRBrace, val: "}", pos: i})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, val: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, val: ")", pos: i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{kind: tokLBracket, val: "[", pos: i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{kind: tokRBracket, val: "]", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, val: ",", pos: i})
+			i++
+		case r == '"' || r == '\'' || r == '`':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' && r != '`' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrBadQuery, i)
+			}
+			val, err := unquote(string(rs[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad string at %d", ErrBadQuery, i)
+			}
+			tokens = append(tokens, token{kind: tokString, val: val, pos: i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || unicode.IsLetter(rs[j]) || rs[j] == '.' ||
+				// знак порядка: 1e-3
+				((rs[j] == '-' || rs[j] == '+') && (rs[j-1] == 'e' || rs[j-1] == 'E'))) {
+				j++
+			}
+			val := string(rs[i:j])
+			if _, err := strconv.ParseFloat(val, 64); err == nil {
+				tokens = append(tokens, token{kind: tokNumber, val: val, pos: i})
+			} else if _, err := ParseDuration(val); err == nil {
+				tokens = append(tokens, token{kind: tokDuration, val: val, pos: i})
+			} else {
+				return nil, fmt.Errorf("%w: bad number %q at %d", ErrBadQuery, val, i)
+			}
+			i = j
+		case isNameChar(r, true):
+			j := i + 1
+			for j < len(rs) && isNameChar(rs[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, val: string(rs[i:j]), pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(string(rs[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrBadQuery, r, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, val: op, pos: i})
+			i += len([]rune(op))
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(rs)}), nil
+}
+
+// unquote строки в двойных, одинарных и обратных кавычках, экранирование как в Go.
+func unquote(raw string) (string, error) {
+	switch raw[0] {
+	case '`':
+		return raw[1 : len(raw)-1], nil
+	case '\'':
+		inner := strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`)
+		return strconv.Unquote(`"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`)
+	default:
+		return strconv.Unquote(raw)
+	}
+}
+
+// parser разбирает запрос по токенам.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s", what)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	got := t.val
+	if t.kind == tokEOF {
+		got = "end of query"
+	}
+	return fmt.Errorf("%w: %s, got %q at %d", ErrBadQuery, fmt.Sprintf(format, args...), got, t.pos)
+}
+
+// ParseSelector разбирает селектор серий: name, name{...} или {...}.
+func ParseSelector(input string) (*Selector, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	sel, err := p.selector()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected token after selector")
+	}
+
+	return sel, nil
+}
+
+// selector name{...}: имя необязательно, но тогда нужен хотя бы один matcher,
+// который не совпадает с пустой строкой, как в Prometheus.
+func (p *parser) selector() (*Selector, error) {
+	sel := &Selector{}
+	if t := p.peek(); t.kind == tokIdent {
+		p.next()
+		m, _ := NewMatcher(NameLabel, MatchEqual, t.val)
+		if strings.ContainsAny(t.val, "*?") {
+			m, _ = NewMatcher(NameLabel, MatchRegexp, globToRegexp(t.val))
+		}
+		sel.Matchers = append(sel.Matchers, m)
+	}
+
+	if p.peek().kind == tokLBrace {
+		p.next()
+		for p.peek().kind != tokRBrace {
+			name, err := p.expect(tokIdent, "label name")
+			if err != nil {
+				return nil, err
+			}
+			op := p.next()
+			if op.kind != tokOp {
+				return nil, p.errorf(op, "expected match operator")
+			}
+			val, err := p.expect(tokString, "quoted label value")
+			if err != nil {
+				return nil, err
+			}
+			m, err := NewMatcher(name.val, op.val, val.val)
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+
+			if p.peek().kind == tokComma {
+				p.next()
+				continue
+			}
+			if p.peek().kind != tokRBrace {
+				return nil, p.errorf(p.peek(), "expected , or }")
+			}
+		}
+		p.next()
+	}
+
+	if len(sel.Matchers) == 0 {
+		return nil, p.errorf(p.peek(), "expected selector")
+	}
+	empty := true
+	for _, m := range sel.Matchers {
+		if !m.Matches("") {
+			empty = false
+		}
+	}
+	if empty {
+		return nil, fmt.Errorf("%w: selector must contain at least one non-empty matcher", ErrBadQuery)
+	}
+
+	return sel, nil
+}
+
+// ParseExpr разбирает выражение: селекторы, функции над окном, агрегации
+// и арифметику с сравнениями между сериями и числами.
+func ParseExpr(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected token after expression")
+	}
+
+	return expr, nil
+}
+
+// Приоритеты бинарных операторов от низшего к высшему. ^ разбирается отдельно,
+// он правоассоциативен и сильнее унарного минуса.
+var precedence = [][]string{
+	{"==", "!=", ">", "<", ">=", "<="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	lhs, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || !slices.Contains(precedence[level], t.val) {
+			return lhs, nil
+		}
+		p.next()
+		b := &binaryExpr{op: t.val, lhs: lhs}
+		if err := p.matching(b); err != nil {
+			return nil, err
+		}
+		if b.rhs, err = p.binary(level + 1); err != nil {
+			return nil, err
+		}
+		if err := checkBinary(b, t); err != nil {
+			return nil, err
+		}
+		lhs = b
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	t := p.peek()
+	if t.kind != tokOp || (t.val != "-" && t.val != "+") {
+		return p.power()
+	}
+	p.next()
+	expr, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if t.val == "+" {
+		return expr, nil
+	}
+	if n, ok := expr.(*numberLiteral); ok {
+		return &numberLiteral{value: -n.value}, nil
+	}
+	b := &binaryExpr{op: "*", lhs: &numberLiteral{value: -1}, rhs: expr}
+	if err := checkBinary(b, t); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (p *parser) power() (Expr, error) {
+	lhs, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp || t.val != "^" {
+		return lhs, nil
+	}
+	p.next()
+	b := &binaryExpr{op: "^", lhs: lhs}
+	if err := p.matching(b); err != nil {
+		return nil, err
+	}
+	if b.rhs, err = p.unary(); err != nil {
+		return nil, err
+	}
+	if err := checkBinary(b, t); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// matching необязательные on(...) или ignoring(...) после оператора.
+func (p *parser) matching(b *binaryExpr) error {
+	t := p.peek()
+	if t.kind != tokIdent || (t.val != "on" && t.val != "ignoring") || p.tokens[p.pos+1].kind != tokLParen {
+		return nil
+	}
+	p.next()
+	labels, err := p.labelList()
+	if err != nil {
+		return err
+	}
+	b.on, b.matching = t.val == "on", labels
+	if b.matching == nil {
+		b.matching = []string{}
+	}
+
+	return nil
+}
+
+func checkBinary(b *binaryExpr, t token) error {
+	lt, rt := b.lhs.Type(), b.rhs.Type()
+	if lt == ResultMatrix || rt == ResultMatrix {
+		return fmt.Errorf("%w: range vector in binary expression %q at %d", ErrBadQuery, b.op, t.pos)
+	}
+	if lt == ResultScalar && rt == ResultScalar && isComparison(b.op) {
+		return fmt.Errorf("%w: comparison between scalars at %d", ErrBadQuery, t.pos)
+	}
+	if b.matching != nil && (lt == ResultScalar || rt == ResultScalar) {
+		return fmt.Errorf("%w: vector matching only allowed between vectors at %d", ErrBadQuery, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		v, _ := strconv.ParseFloat(t.val, 64)
+		return &numberLiteral{value: v}, nil
+	case tokLParen:
+		p.next()
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokIdent:
+		next := p.tokens[p.pos+1]
+		if aggregations[t.val] && (next.kind == tokLParen || (next.kind == tokIdent && (next.val == "by" || next.val == "without"))) {
+			return p.aggregate()
+		}
+		if next.kind == tokLParen {
+			return p.call()
+		}
+		return p.selectorExpr()
+	case tokLBrace:
+		return p.selectorExpr()
+	}
+
+	return nil, p.errorf(t, "expected expression")
+}
+
+// selectorExpr селектор с необязательным окном: name{...}[5m].
+func (p *parser) selectorExpr() (Expr, error) {
+	sel, err := p.selector()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokLBracket {
+		return &vectorSelector{sel: sel}, nil
+	}
+	p.next()
+	t, err := p.expect(tokDuration, "range duration")
+	if err != nil {
+		return nil, err
+	}
+	rng, _ := ParseDuration(t.val)
+	if _, err := p.expect(tokRBracket, "]"); err != nil {
+		return nil, err
+	}
+
+	return &matrixSelector{sel: sel, rng: rng}, nil
+}
+
+func (p *parser) call() (Expr, error) {
+	name := p.next()
+	if !functions[name.val] {
+		return nil, fmt.Errorf("%w: unknown function %q at %d", ErrBadQuery, name.val, name.pos)
+	}
+	p.next()
+	arg, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := arg.(*matrixSelector)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s() expects a range vector like name[5m]", ErrBadQuery, name.val)
+	}
+	if _, err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+
+	return &call{fn: name.val, arg: m}, nil
+}
+
+// aggregate op [by|without (labels)] ([k,] expr) [by|without (labels)].
+func (p *parser) aggregate() (Expr, error) {
+	agg := &aggregate{op: p.next().val}
+	grouped, err := p.grouping(agg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+	if agg.op == AggTopK || agg.op == AggBottomK {
+		if agg.param, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if agg.param.Type() != ResultScalar {
+			return nil, fmt.Errorf("%w: %s() expects a number as the first argument", ErrBadQuery, agg.op)
+		}
+		if _, err := p.expect(tokComma, ","); err != nil {
+			return nil, err
+		}
+	}
+	if agg.expr, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if agg.expr.Type() != ResultVector {
+		return nil, fmt.Errorf("%w: %s() expects an instant vector", ErrBadQuery, agg.op)
+	}
+	if _, err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+
+	if !grouped {
+		if _, err := p.grouping(agg); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+func (p *parser) grouping(agg *aggregate) (bool, error) {
+	t := p.peek()
+	if t.kind != tokIdent || (t.val != "by" && t.val != "without") {
+		return false, nil
+	}
+	p.next()
+	labels, err := p.labelList()
+	if err != nil {
+		return false, err
+	}
+	agg.grouping, agg.without = labels, t.val == "without"
+
+	return true, nil
+}
+
+// labelList (a, b, c), список может быть пустым.
+func (p *parser) labelList() ([]string, error) {
+	if _, err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for p.peek().kind != tokRParen {
+		t, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, t.val)
+		if p.peek().kind == tokComma {
+			p.next()
+			continue
+		}
+		if p.peek().kind != tokRParen {
+			return nil, p.errorf(p.peek(), "expected , or )")
+		}
+	}
+	p.next()
+
+	return labels, nil
+}
+
+// globToRegexp шаблон имени в регулярное выражение: * - любые символы, ? - один символ.
+func globToRegexp(glob string) string {
+	re := regexp.QuoteMeta(glob)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	return strings.ReplaceAll(re, `\?`, ".")
+}
+
+// ParseDuration разбирает длительность в формате Prometheus: 30s, 5m, 1h30m, 2d, 1w.
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("%w: bad duration %q", ErrBadQuery, s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: bad duration %q", ErrBadQuery, s)
+		}
+		rest = rest[i:]
+		j := 0
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+		unit, ok := units[rest[:j]]
+		if !ok {
+			return 0, fmt.Errorf("%w: bad duration %q", ErrBadQuery, s)
+		}
+		total += time.Duration(n) * unit
+		rest = rest[j:]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("%w: bad duration %q", ErrBadQuery, s)
+	}
+
+	return total, nil
+}
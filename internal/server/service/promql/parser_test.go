@@ -0,0 +1,142 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		labels  map[string]string
+		matches bool
+		wantErr bool
+	}{
+		{name: "Name only", input: "Alloc", labels: map[string]string{"__name__": "Alloc"}, matches: true},
+		{name: "Dotted name", input: "cpu.usage-total", labels: map[string]string{"__name__": "cpu.usage-total"}, matches: true},
+		{name: "Other name", input: "Alloc", labels: map[string]string{"__name__": "HeapAlloc"}},
+		{
+			name:    "Labels",
+			input:   `Alloc{host="a", dc!='b'}`,
+			labels:  map[string]string{"__name__": "Alloc", "host": "a", "dc": "c"},
+			matches: true,
+		},
+		{
+			name:   "Not equal",
+			input:  `Alloc{dc!="b"}`,
+			labels: map[string]string{"__name__": "Alloc", "dc": "b"},
+		},
+		{
+			name:    "Regexp is anchored",
+			input:   `{__name__=~"Heap.*", host!~"b"}`,
+			labels:  map[string]string{"__name__": "HeapAlloc", "host": "ab"},
+			matches: true,
+		},
+		{
+			name:   "Regexp does not match part",
+			input:  `{__name__=~"Heap"}`,
+			labels: map[string]string{"__name__": "HeapAlloc"},
+		},
+		{
+			name:    "Missing label is empty",
+			input:   `Alloc{host=""}`,
+			labels:  map[string]string{"__name__": "Alloc"},
+			matches: true,
+		},
+		{name: "Glob", input: "Heap*", labels: map[string]string{"__name__": "HeapAlloc"}, matches: true},
+		{name: "Glob is anchored", input: "Heap?", labels: map[string]string{"__name__": "HeapAlloc"}},
+		{name: "Empty", input: "", wantErr: true},
+		{name: "Only empty matchers", input: `{host=~".*"}`, wantErr: true},
+		{name: "Unquoted value", input: `Alloc{host=a}`, wantErr: true},
+		{name: "Unterminated", input: `Alloc{host="a"`, wantErr: true},
+		{name: "Bad regexp", input: `Alloc{host=~"("}`, wantErr: true},
+		{name: "Trailing tokens", input: `Alloc Alloc`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.matches, sel.Matches(tt.labels))
+		})
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		input   string
+		typ     string
+		wantErr bool
+	}{
+		{input: "Alloc", typ: ResultVector},
+		{input: "Alloc[5m]", typ: ResultMatrix},
+		{input: "1 + 2 * 3", typ: ResultScalar},
+		{input: "-2 ^ 2", typ: ResultScalar},
+		{input: "1e-3", typ: ResultScalar},
+		{input: `rate(PollCount{host="a"}[5m])`, typ: ResultVector},
+		{input: "increase(cpu.*[1h]) > 10", typ: ResultVector},
+		{input: "sum by (host) (avg_over_time(Alloc[5m]))", typ: ResultVector},
+		{input: "sum(Alloc) without (host)", typ: ResultVector},
+		{input: "topk(3, Alloc)", typ: ResultVector},
+		{input: "HeapAlloc / on (host) HeapSys * 100", typ: ResultVector},
+		{input: "-Alloc", typ: ResultVector},
+		// метрика может называться как агрегация
+		{input: "sum + 1", typ: ResultVector},
+		{input: "rate(Alloc)", wantErr: true},
+		{input: "unknown(Alloc[5m])", wantErr: true},
+		{input: "Alloc[5m] + 1", wantErr: true},
+		{input: "1 > 2", wantErr: true},
+		{input: "1 + on (host) Alloc", wantErr: true},
+		{input: "topk(Alloc)", wantErr: true},
+		{input: "sum(Alloc[5m])", wantErr: true},
+		{input: "(Alloc", wantErr: true},
+		{input: "Alloc +", wantErr: true},
+		{input: "Alloc[5]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := ParseExpr(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.typ, expr.Type())
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "30s", want: 30 * time.Second},
+		{input: "1h30m", want: 90 * time.Minute},
+		{input: "500ms", want: 500 * time.Millisecond},
+		{input: "2d", want: 48 * time.Hour},
+		{input: "1w", want: 7 * 24 * time.Hour},
+		{input: "", wantErr: true},
+		{input: "5", wantErr: true},
+		{input: "5x", wantErr: true},
+		{input: "0s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := ParseDuration(tt.input)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, d)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package promql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// maxPoints предел точек на серию в ответе query_range, как в Prometheus.
+const maxPoints = 11000
+
+// Типы результата в терминах Prometheus HTTP API.
+const (
+	ResultVector = "vector"
+	ResultMatrix = "matrix"
+	ResultScalar = "scalar"
+)
+
+type Metrics interface {
+	GetAll(ctx context.Context) (map[string]*domain.Metrics, error)
+}
+
+type History interface {
+	Points(ctx context.Context, key string) []history.Point
+}
+
+type Sample struct {
+	T time.Time
+	V float64
+}
+
+// Series серия результата: метки и значения по времени. У серий из хранилища
+// имя метрики в метке __name__, после функций и арифметики имени нет.
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+type Result struct {
+	Type   string
+	Series []*Series
+	// Scalar значение для ResultScalar
+	Scalar *Sample
+}
+
+// stored серия из хранилища с метками в виде Prometheus. Значения по времени
+// загружаются при первом обращении.
+type stored struct {
+	labels  map[string]string
+	m       *domain.Metrics
+	samples []Sample
+	loaded  bool
+}
+
+// Engine вычисляет выражения по текущим значениям метрик и их истории.
+type Engine struct {
+	srv     Metrics
+	history History
+	lg      *zap.Logger
+}
+
+func NewEngine(ctx context.Context, srv Metrics, history History) *Engine {
+	return &Engine{
+		srv:     srv,
+		history: history,
+		lg:      zctx.From(ctx).Named("query engine"),
+	}
+}
+
+// Instant значение выражения в момент ts: вектор, скаляр или, для селектора
+// с окном name[5m], значения серий за окно.
+func (e *Engine) Instant(ctx context.Context, q string, ts time.Time) (*Result, error) {
+	expr, err := ParseExpr(q)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := e.evaluator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := expr.(*matrixSelector); ok {
+		res := &Result{Type: ResultMatrix, Series: make([]*Series, 0)}
+		for _, s := range ev.match(m.sel) {
+			if w := ev.window(s, ts, m.rng); len(w) > 0 {
+				res.Series = append(res.Series, &Series{Labels: s.labels, Samples: w})
+			}
+		}
+		return res, nil
+	}
+
+	val, err := ev.eval(expr, ts)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := val.(float64); ok {
+		return &Result{Type: ResultScalar, Scalar: &Sample{T: ts, V: v}}, nil
+	}
+	acc := make(map[string]*Series)
+	if err := collect(acc, val.(vector), ts); err != nil {
+		return nil, err
+	}
+
+	return &Result{Type: ResultVector, Series: sortedSeries(acc)}, nil
+}
+
+// Range значения выражения с start по end с шагом step, всегда матрица.
+// Скаляр отдаётся серией без меток.
+func (e *Engine) Range(ctx context.Context, q string, start, end time.Time, step time.Duration) (*Result, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: end is before start", ErrBadQuery)
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("%w: step must be positive", ErrBadQuery)
+	}
+	if end.Sub(start)/step >= maxPoints {
+		return nil, fmt.Errorf("%w: exceeded maximum resolution of %d points per series, increase step", ErrBadQuery, maxPoints)
+	}
+
+	expr, err := ParseExpr(q)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Type() == ResultMatrix {
+		return nil, fmt.Errorf("%w: range vector is not allowed in range query", ErrBadQuery)
+	}
+	ev, err := e.evaluator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	acc := make(map[string]*Series)
+	for ts := start; !ts.After(end); ts = ts.Add(step) {
+		val, err := ev.eval(expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := val.(float64); ok {
+			val = vector{{labels: map[string]string{}, v: v}}
+		}
+		if err := collect(acc, val.(vector), ts); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{Type: ResultMatrix, Series: sortedSeries(acc)}, nil
+}
+
+// collect добавляет значения вектора в момент ts к сериям результата.
+func collect(acc map[string]*Series, vec vector, ts time.Time) error {
+	for _, el := range vec {
+		key := domain.SeriesKey("", el.labels)
+		s, ok := acc[key]
+		if !ok {
+			s = &Series{Labels: el.labels}
+			acc[key] = s
+		}
+		if n := len(s.Samples); n > 0 && s.Samples[n-1].T.Equal(ts) {
+			return fmt.Errorf("%w: vector cannot contain metrics with the same labelset %s", ErrBadQuery, key)
+		}
+		s.Samples = append(s.Samples, Sample{T: ts, V: el.v})
+	}
+	return nil
+}
+
+// sortedSeries серии результата в порядке меток.
+func sortedSeries(acc map[string]*Series) []*Series {
+	res := make([]*Series, 0, len(acc))
+	for _, key := range slices.Sorted(maps.Keys(acc)) {
+		res = append(res, acc[key])
+	}
+	return res
+}
+
+func (e *Engine) evaluator(ctx context.Context) (*evaluator, error) {
+	all, err := e.selectSeries(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &evaluator{ctx: ctx, e: e, series: all}, nil
+}
+
+// Series метки серий, подходящих хотя бы под один селектор.
+func (e *Engine) Series(ctx context.Context, matchers []string) ([]map[string]string, error) {
+	all, err := e.matchAny(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]map[string]string, 0, len(all))
+	for _, s := range all {
+		res = append(res, s.labels)
+	}
+
+	return res, nil
+}
+
+// LabelNames имена меток серий, без селекторов - всех серий тенанта.
+func (e *Engine) LabelNames(ctx context.Context, matchers []string) ([]string, error) {
+	all, err := e.matchAny(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for _, s := range all {
+		for k := range s.labels {
+			names[k] = struct{}{}
+		}
+	}
+
+	return sortedKeys(names), nil
+}
+
+// LabelValues значения метки name у серий, без селекторов - у всех серий тенанта.
+func (e *Engine) LabelValues(ctx context.Context, name string, matchers []string) ([]string, error) {
+	all, err := e.matchAny(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]struct{})
+	for _, s := range all {
+		if v, ok := s.labels[name]; ok {
+			values[v] = struct{}{}
+		}
+	}
+
+	return sortedKeys(values), nil
+}
+
+func (e *Engine) matchAny(ctx context.Context, matchers []string) ([]*stored, error) {
+	if len(matchers) == 0 {
+		return e.selectSeries(ctx, nil)
+	}
+
+	seen := make(map[string]bool)
+	res := make([]*stored, 0)
+	for _, m := range matchers {
+		sel, err := ParseSelector(m)
+		if err != nil {
+			return nil, err
+		}
+		found, err := e.selectSeries(ctx, sel)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range found {
+			key := domain.SeriesKey("", s.labels)
+			if !seen[key] {
+				seen[key] = true
+				res = append(res, s)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// selectSeries серии тенанта, подходящие под селектор (nil - все), в порядке ключей.
+func (e *Engine) selectSeries(ctx context.Context, sel *Selector) ([]*stored, error) {
+	all, err := e.srv.GetAll(ctx)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if errors.Is(err, er) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := make([]*stored, 0)
+	for _, k := range slices.Sorted(maps.Keys(all)) {
+		m := all[k]
+		if sel != nil && sel.name() != "" && m.ID != sel.name() {
+			continue
+		}
+		labels := make(map[string]string, len(m.Labels)+1)
+		maps.Copy(labels, m.Labels)
+		labels[NameLabel] = m.ID
+		if sel != nil && !sel.Matches(labels) {
+			continue
+		}
+		res = append(res, &stored{labels: labels, m: m})
+	}
+
+	return res, nil
+}
+
+// samples значения серии из истории. Без истории - одно текущее значение
+// с момента последнего обновления.
+func (e *Engine) samples(ctx context.Context, m *domain.Metrics) []Sample {
+	var res []Sample
+	if e.history != nil {
+		for _, p := range e.history.Points(ctx, m.Key()) {
+			res = append(res, Sample{T: p.At, V: p.Value})
+		}
+	}
+	if len(res) > 0 {
+		return res
+	}
+	if v, ok := m.Number(); ok {
+		return []Sample{{T: m.UpdatedAt, V: v}}
+	}
+
+	return nil
+}
+
+// sortedKeys всегда не nil, чтобы в JSON был пустой список, а не null.
+func sortedKeys(m map[string]struct{}) []string {
+	res := make([]string, 0, len(m))
+	return append(res, slices.Sorted(maps.Keys(m))...)
+}
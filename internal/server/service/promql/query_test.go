@@ -0,0 +1,204 @@
+package promql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/history"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMetrics map[string]*domain.Metrics
+
+func (f fakeMetrics) GetAll(ctx context.Context) (map[string]*domain.Metrics, error) {
+	if len(f) == 0 {
+		return nil, repository.NewEmptyRepoError(nil)
+	}
+	return f, nil
+}
+
+func gauge(name string, v float64, at time.Time, labels map[string]string) *domain.Metrics {
+	return &domain.Metrics{ID: name, MType: domain.Gauge, Value: &v, Labels: labels, UpdatedAt: at}
+}
+
+func newTestEngine(t *testing.T, start time.Time) *Engine {
+	t.Helper()
+	ctx := context.Background()
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	srv := fakeMetrics{}
+	for i, v := range []float64{1, 2, 3} {
+		m := gauge("Alloc", v, start.Add(time.Duration(i)*time.Minute), map[string]string{"host": "a"})
+		hist.Publish(ctx, []*domain.Metrics{m})
+		srv[m.Key()] = m
+	}
+	// без истории отдаётся только текущее значение
+	m := gauge("Alloc", 10, start.Add(time.Minute), map[string]string{"host": "b"})
+	srv[m.Key()] = m
+	set := &domain.Metrics{ID: "Users", MType: domain.Set, Set: &domain.SetData{}, UpdatedAt: start}
+	srv[set.Key()] = set
+
+	return NewEngine(ctx, srv, hist)
+}
+
+func TestInstant(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	e := newTestEngine(t, start)
+
+	res, err := e.Instant(ctx, `Alloc`, start.Add(90*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ResultVector, res.Type)
+	require.Len(t, res.Series, 2)
+	require.Equal(t, map[string]string{"__name__": "Alloc", "host": "a"}, res.Series[0].Labels)
+	require.Equal(t, 2.0, res.Series[0].Samples[0].V)
+	require.Equal(t, 10.0, res.Series[1].Samples[0].V)
+
+	// до первого значения серии нет
+	res, err = e.Instant(ctx, `Alloc{host="b"}`, start)
+	require.NoError(t, err)
+	require.Empty(t, res.Series)
+
+	_, err = e.Instant(ctx, `Alloc{`, start)
+	require.ErrorIs(t, err, ErrBadQuery)
+}
+
+func TestRange(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	e := newTestEngine(t, start)
+
+	res, err := e.Range(ctx, `Alloc{host="a"}`, start.Add(-time.Minute), start.Add(3*time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, ResultMatrix, res.Type)
+	require.Len(t, res.Series, 1)
+	require.Equal(t, []Sample{
+		{T: start, V: 1},
+		{T: start.Add(time.Minute), V: 2},
+		{T: start.Add(2 * time.Minute), V: 3},
+		{T: start.Add(3 * time.Minute), V: 3},
+	}, res.Series[0].Samples)
+
+	_, err = e.Range(ctx, `Alloc`, start, start.Add(24*time.Hour), time.Second)
+	require.ErrorIs(t, err, ErrBadQuery)
+	_, err = e.Range(ctx, `Alloc`, start, start.Add(-time.Second), time.Second)
+	require.ErrorIs(t, err, ErrBadQuery)
+}
+
+func TestLabels(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEngine(t, time.Now())
+
+	names, err := e.LabelNames(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"__name__", "host"}, names)
+
+	values, err := e.LabelValues(ctx, "host", []string{`Alloc{host!="a"}`, `Alloc{host="b"}`})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, values)
+
+	values, err = e.LabelValues(ctx, "__name__", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Alloc", "Users"}, values)
+
+	series, err := e.Series(ctx, []string{`{__name__=~"A.*"}`, `Alloc{host="a"}`})
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	empty := NewEngine(ctx, fakeMetrics{}, nil)
+	names, err = empty.LabelNames(ctx, nil)
+	require.NoError(t, err)
+	require.NotNil(t, names)
+	require.Empty(t, names)
+}
+
+func TestEval(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	hist := history.NewHistory(history.Config{Points: 10, Resolution: 1})
+	srv := fakeMetrics{}
+	add := func(m *domain.Metrics) {
+		hist.Publish(ctx, []*domain.Metrics{m})
+		srv[m.Key()] = m
+	}
+	for i, v := range []float64{0, 60, 120, 30} {
+		at := start.Add(time.Duration(i) * time.Minute)
+		add(gauge("requests", v, at, map[string]string{"host": "a"}))
+		add(gauge("requests", float64(i*30), at, map[string]string{"host": "b"}))
+	}
+	add(gauge("HeapAlloc", 100, start, map[string]string{"host": "a"}))
+	add(gauge("HeapSys", 400, start, map[string]string{"host": "a"}))
+	add(gauge("HeapAlloc", 50, start, map[string]string{"host": "b"}))
+	add(gauge("HeapSys", 100, start, map[string]string{"host": "b"}))
+	e := NewEngine(ctx, srv, hist)
+	ts := start.Add(3 * time.Minute)
+
+	tests := []struct {
+		query   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		// сброс счётчика считается приростом от нуля
+		{query: `increase(requests{host="a"}[5m])`, want: map[string]float64{`{host="a"}`: 150}},
+		{query: `rate(requests{host="b"}[5m])`, want: map[string]float64{`{host="b"}`: 0.5}},
+		{query: `avg_over_time(requests{host="b"}[2m])`, want: map[string]float64{`{host="b"}`: 75}},
+		{query: `sum by (host) (increase(requests[5m]))`, want: map[string]float64{`{host="a"}`: 150, `{host="b"}`: 90}},
+		{query: `sum(increase(requests[5m]))`, want: map[string]float64{``: 240}},
+		{query: `HeapAlloc / HeapSys * 100`, want: map[string]float64{`{host="a"}`: 25, `{host="b"}`: 50}},
+		{query: `HeapAlloc / on (host) HeapSys`, want: map[string]float64{`{host="a"}`: 0.25, `{host="b"}`: 0.5}},
+		{query: `topk(1, HeapAlloc)`, want: map[string]float64{`{__name__="HeapAlloc",host="a"}`: 100}},
+		{query: `bottomk(1, HeapAlloc)`, want: map[string]float64{`{__name__="HeapAlloc",host="b"}`: 50}},
+		{query: `max by (host) (Heap*)`, want: map[string]float64{`{host="a"}`: 400, `{host="b"}`: 100}},
+		{query: `count(Heap*)`, want: map[string]float64{``: 4}},
+		{
+			query: `Heap* > 90`,
+			want: map[string]float64{
+				`{__name__="HeapAlloc",host="a"}`: 100,
+				`{__name__="HeapSys",host="a"}`:   400,
+				`{__name__="HeapSys",host="b"}`:   100,
+			},
+		},
+		{query: `Unknown + 1`, want: map[string]float64{}},
+		{query: `avg_over_time(Heap*[5m])`, wantErr: true},
+		{query: `HeapAlloc + ignoring (host) HeapSys`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res, err := e.Instant(ctx, tt.query, ts)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, ResultVector, res.Type)
+			got := make(map[string]float64)
+			for _, s := range res.Series {
+				got[domain.SeriesKey("", s.Labels)] = s.Samples[0].V
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	res, err := e.Instant(ctx, `2 * 3 ^ 2`, ts)
+	require.NoError(t, err)
+	require.Equal(t, ResultScalar, res.Type)
+	require.Equal(t, 18.0, res.Scalar.V)
+
+	res, err = e.Instant(ctx, `requests{host="b"}[90s]`, ts)
+	require.NoError(t, err)
+	require.Equal(t, ResultMatrix, res.Type)
+	require.Len(t, res.Series[0].Samples, 2)
+
+	res, err = e.Range(ctx, `sum(requests)`, start, ts, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, ResultMatrix, res.Type)
+	require.Len(t, res.Series, 1)
+	require.Equal(t, []Sample{
+		{T: start, V: 0},
+		{T: start.Add(time.Minute), V: 90},
+		{T: start.Add(2 * time.Minute), V: 180},
+		{T: ts, V: 120},
+	}, res.Series[0].Samples)
+}
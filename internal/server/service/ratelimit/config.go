@@ -0,0 +1,7 @@
+package ratelimit
+
+type Config struct {
+	// Rate запросов в секунду на клиента, 0 - без ограничения.
+	Rate  float64 `json:"rateLimit" yaml:"rateLimit" env:"RATE_LIMIT"`
+	Burst int     `json:"rateBurst" yaml:"rateBurst" env:"RATE_BURST" default:"20"`
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// cleanupInterval как часто удаляются вёдра клиентов, которые давно не присылали запросов.
+const cleanupInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter token bucket на каждого клиента (тенант или IP).
+// Ведро вмещает Burst запросов и пополняется со скоростью Rate в секунду.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewLimiter(ctx context.Context, cfg Config) *Limiter {
+	return &Limiter{
+		rate:    cfg.Rate,
+		burst:   math.Max(float64(cfg.Burst), 1),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+		lg:      zctx.From(ctx).Named("rate limiter"),
+	}
+}
+
+// Allow забирает токен из ведра клиента. Если токенов нет, возвращает время до появления следующего.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *Limiter) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if l.rate <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			l.cleanup()
+		}
+	}
+}
+
+// cleanup удаляет полные вёдра: для клиента они ничем не отличаются от нового.
+func (l *Limiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for client, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewLimiter(ctx, Config{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	// ведро вмещает burst запросов подряд
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("agent-a")
+		require.True(t, ok)
+	}
+	ok, wait := l.Allow("agent-a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// у другого клиента своё ведро
+	ok, _ = l.Allow("agent-b")
+	require.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("agent-a")
+	require.True(t, ok)
+
+	now = now.Add(time.Minute)
+	l.cleanup()
+	require.Empty(t, l.buckets)
+}
+
+func TestDisabled(t *testing.T) {
+	l := NewLimiter(context.Background(), Config{})
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("agent")
+		require.True(t, ok)
+	}
+}
@@ -0,0 +1,7 @@
+package recording
+
+type Config struct {
+	RulesFile string `json:"recordingRules" yaml:"recordingRules" env:"RECORDING_RULES"`
+	// EvalInterval как часто вычисляются правила без своего interval (секунды).
+	EvalInterval int `json:"recordingEvalInterval" yaml:"recordingEvalInterval" env:"RECORDING_EVAL_INTERVAL" default:"60"`
+}
@@ -0,0 +1,230 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+// tick как часто проверяется, каким правилам пора вычисляться.
+const tick = time.Second
+
+// Состояние последнего вычисления правила.
+const (
+	HealthUnknown = "unknown"
+	HealthOK      = "ok"
+	HealthErr     = "err"
+)
+
+type Metrics interface {
+	Find(ctx context.Context, name, mType string, labels map[string]string) (map[string]*domain.Metrics, error)
+	Updates(ctx context.Context, data []*domain.MetricsJSON) error
+}
+
+type Querier interface {
+	Instant(ctx context.Context, q string, ts time.Time) (*promql.Result, error)
+}
+
+// Status правило и результат его последнего вычисления.
+type Status struct {
+	*Rule
+	Health         string    `json:"health"`
+	LastError      string    `json:"lastError,omitempty"`
+	LastEvaluation time.Time `json:"lastEvaluation"`
+	// EvaluationTime длительность последнего вычисления в секундах
+	EvaluationTime float64 `json:"evaluationTime"`
+	// Series сколько серий записано последним вычислением
+	Series int `json:"series"`
+	// Failures сколько вычислений подряд завершились ошибкой
+	Failures int `json:"failures"`
+}
+
+// Engine периодически вычисляет правила записи и сохраняет результаты через сервис метрик,
+// так что они попадают в историю, стримы и экспорт как обычные метрики.
+type Engine struct {
+	srv      Metrics
+	querier  Querier
+	rules    []*Rule
+	interval time.Duration
+
+	mu     sync.RWMutex
+	status []*Status
+	next   []time.Time
+	now    func() time.Time
+
+	lg *zap.Logger
+}
+
+func NewEngine(ctx context.Context, cfg Config, srv Metrics, querier Querier, rules []*Rule) *Engine {
+	e := &Engine{
+		srv:      srv,
+		querier:  querier,
+		rules:    rules,
+		interval: time.Duration(cfg.EvalInterval) * time.Second,
+		status:   make([]*Status, len(rules)),
+		next:     make([]time.Time, len(rules)),
+		now:      time.Now,
+		lg:       zctx.From(ctx).Named("recording engine"),
+	}
+	for i, r := range rules {
+		e.status[i] = &Status{Rule: r, Health: HealthUnknown}
+	}
+
+	return e
+}
+
+func (e *Engine) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(e.rules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.lg.Info("got cancellation, returning")
+			return
+		case <-ticker.C:
+			e.Eval(ctx)
+		}
+	}
+}
+
+// Eval вычисляет правила, у которых подошло время.
+func (e *Engine) Eval(ctx context.Context) {
+	now := e.now()
+	for i, r := range e.rules {
+		interval := r.interval
+		if interval == 0 {
+			interval = e.interval
+		}
+		if interval <= 0 || now.Before(e.next[i]) {
+			continue
+		}
+		e.next[i] = now.Add(interval)
+
+		n, err := e.evalRule(domain.WithTenant(ctx, r.Tenant), r, now)
+		e.report(i, now, e.now().Sub(now), n, err)
+	}
+}
+
+// Rules возвращает правила тенанта с результатом последнего вычисления.
+func (e *Engine) Rules(ctx context.Context) []*Status {
+	tenant := domain.TenantFromContext(ctx)
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make([]*Status, 0)
+	for _, s := range e.status {
+		if s.Tenant == tenant {
+			c := *s
+			res = append(res, &c)
+		}
+	}
+
+	return res
+}
+
+func (e *Engine) report(i int, at time.Time, took time.Duration, series int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := e.status[i]
+	s.LastEvaluation = at
+	s.EvaluationTime = took.Seconds()
+	if err != nil {
+		s.Health = HealthErr
+		s.LastError = err.Error()
+		s.Failures++
+		e.lg.Error("error while evaluating recording rule", zap.String("record", s.Record), zap.Int("failures", s.Failures), zap.Error(err))
+		return
+	}
+	s.Health = HealthOK
+	s.LastError = ""
+	s.Failures = 0
+	s.Series = series
+}
+
+// evalRule вычисляет правило и записывает результат, возвращает число записанных серий.
+// Значения NaN и Inf, например после деления на ноль, пропускаются.
+func (e *Engine) evalRule(ctx context.Context, r *Rule, now time.Time) (int, error) {
+	res, err := e.querier.Instant(ctx, r.Expr, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var series []*promql.Series
+	if res.Type == promql.ResultScalar {
+		series = []*promql.Series{{Labels: map[string]string{}, Samples: []promql.Sample{*res.Scalar}}}
+	} else {
+		series = res.Series
+	}
+
+	data := make([]*domain.MetricsJSON, 0, len(series))
+	for _, s := range series {
+		v := s.Samples[len(s.Samples)-1].V
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		labels := maps.Clone(s.Labels)
+		delete(labels, promql.NameLabel)
+		maps.Copy(labels, r.Labels)
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		m := &domain.MetricsJSON{ID: r.Record, MType: r.Type, Labels: labels}
+		if r.Type == domain.Counter {
+			delta, err := e.counterDelta(ctx, r.Record, labels, int64(math.Round(v)))
+			if err != nil {
+				return 0, err
+			}
+			m.Delta = &delta
+		} else {
+			m.Value = &v
+		}
+		data = append(data, m)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if err := e.srv.Updates(ctx, data); err != nil {
+		return 0, fmt.Errorf("can not write %s: %w", r.Record, err)
+	}
+
+	return len(data), nil
+}
+
+// counterDelta прирост, после которого counter будет равен target.
+// Если результат уменьшился, например после сброса исходных счётчиков, прирост отрицательный.
+func (e *Engine) counterDelta(ctx context.Context, name string, labels map[string]string, target int64) (int64, error) {
+	found, err := e.srv.Find(ctx, name, domain.Counter, labels)
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if errors.Is(err, er) {
+			return target, nil
+		}
+		return 0, err
+	}
+
+	cur, ok := found[domain.SeriesKey(name, labels)]
+	if !ok || cur.Delta == nil {
+		return target, nil
+	}
+
+	return target - *cur.Delta, nil
+}
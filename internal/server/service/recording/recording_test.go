@@ -0,0 +1,149 @@
+package recording
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		name     string
+		rule     *Rule
+		mType    string
+		interval time.Duration
+		pass     bool
+	}{
+		{name: "Gauge by default", rule: &Rule{Record: "mem_used_ratio", Expr: "HeapAlloc / Sys"}, mType: domain.Gauge, pass: true},
+		{name: "Counter with interval", rule: &Rule{Record: "requests", Expr: "sum(requests)", Type: domain.Counter, Interval: "15s"}, mType: domain.Counter, interval: 15 * time.Second, pass: true},
+		{name: "Scalar", rule: &Rule{Record: "one", Expr: "1"}, mType: domain.Gauge, pass: true},
+		{name: "No record", rule: &Rule{Expr: "HeapAlloc"}},
+		{name: "Bad expr", rule: &Rule{Record: "x", Expr: "rate(HeapAlloc)"}},
+		{name: "Range vector", rule: &Rule{Record: "x", Expr: "HeapAlloc[5m]"}},
+		{name: "Bad type", rule: &Rule{Record: "x", Expr: "HeapAlloc", Type: domain.Histogram}},
+		{name: "Bad interval", rule: &Rule{Record: "x", Expr: "HeapAlloc", Interval: "often"}},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := tCase.rule.Compile()
+			if !tCase.pass {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tCase.mType, tCase.rule.Type)
+			require.Equal(t, tCase.interval, tCase.rule.interval)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - record: mem_used_ratio
+    expr: HeapAlloc / Sys
+  - record: requests_total
+    expr: sum(requests)
+    type: counter
+    interval: 30s
+`), 0o600))
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, 30*time.Second, rules[1].interval)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - record: x
+    expr: HeapAlloc
+  - record: x
+    expr: Sys
+`), 0o600))
+	_, err = LoadRules(path)
+	require.Error(t, err)
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	rules := []*Rule{
+		{Record: "mem_used_ratio", Expr: "HeapAlloc / Sys", Labels: map[string]string{"source": "recording"}},
+		{Record: "requests_total", Expr: "sum(requests)", Type: domain.Counter, Interval: "2m"},
+		{Record: "broken", Expr: "requests + ignoring (host) Sys"},
+	}
+	for _, r := range rules {
+		require.NoError(t, r.Compile())
+	}
+
+	// значения без истории видны с момента обновления
+	now := time.Now().Add(time.Hour)
+	e := NewEngine(ctx, Config{EvalInterval: 60}, srv, promql.NewEngine(ctx, srv, nil), rules)
+	e.now = func() time.Time { return now }
+
+	update := func(data ...*domain.MetricsJSON) {
+		require.NoError(t, srv.Updates(ctx, data))
+	}
+	gauge := func(name string, v float64, labels map[string]string) *domain.MetricsJSON {
+		return &domain.MetricsJSON{ID: name, MType: domain.Gauge, Value: &v, Labels: labels}
+	}
+	counter := func(host string, d int64) *domain.MetricsJSON {
+		return &domain.MetricsJSON{ID: "requests", MType: domain.Counter, Delta: &d, Labels: map[string]string{"host": host}}
+	}
+	value := func(mType, name string, labels map[string]string) string {
+		v, err := srv.Get(ctx, &domain.MetricsJSON{ID: name, MType: mType, Labels: labels})
+		require.NoError(t, err)
+		return v
+	}
+	health := func() map[string]string {
+		res := make(map[string]string)
+		for _, s := range e.Rules(ctx) {
+			res[s.Record] = s.Health
+		}
+		return res
+	}
+
+	require.Equal(t, map[string]string{
+		"mem_used_ratio": HealthUnknown,
+		"requests_total": HealthUnknown,
+		"broken":         HealthUnknown,
+	}, health())
+
+	update(gauge("HeapAlloc", 25, nil), gauge("Sys", 100, nil), counter("a", 10), counter("b", 5))
+	e.Eval(ctx)
+	require.Equal(t, "0.25", value(domain.Gauge, "mem_used_ratio", map[string]string{"source": "recording"}))
+	require.Equal(t, "15", value(domain.Counter, "requests_total", nil))
+	require.Equal(t, map[string]string{
+		"mem_used_ratio": HealthOK,
+		"requests_total": HealthOK,
+		"broken":         HealthErr,
+	}, health())
+
+	// requests_total ещё не пора вычислять
+	now = now.Add(time.Minute)
+	update(gauge("HeapAlloc", 50, nil), counter("a", 10))
+	e.Eval(ctx)
+	require.Equal(t, "0.5", value(domain.Gauge, "mem_used_ratio", map[string]string{"source": "recording"}))
+	require.Equal(t, "15", value(domain.Counter, "requests_total", nil))
+
+	now = now.Add(time.Minute)
+	e.Eval(ctx)
+	require.Equal(t, "25", value(domain.Counter, "requests_total", nil))
+
+	for _, s := range e.Rules(ctx) {
+		if s.Record == "broken" {
+			require.Equal(t, 3, s.Failures)
+			require.Contains(t, s.LastError, "duplicate series")
+		}
+	}
+	require.Empty(t, e.Rules(domain.WithTenant(ctx, "acme")))
+}
@@ -0,0 +1,99 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/service/promql"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule правило записи: результат Expr сохраняется как метрика Record.
+// Каждая серия результата - отдельная серия Record со своими метками и метками правила.
+//
+//	record: mem_used_ratio
+//	expr: HeapAlloc / Sys
+//
+// Type - gauge или counter. Counter пишется так, чтобы его значение совпало с результатом.
+type Rule struct {
+	Record   string            `json:"record" yaml:"record"`
+	Expr     string            `json:"expr" yaml:"expr"`
+	Type     string            `json:"type,omitempty" yaml:"type"`
+	Interval string            `json:"interval,omitempty" yaml:"interval"`
+	Tenant   string            `json:"tenant,omitempty" yaml:"tenant"`
+	Labels   map[string]string `json:"labels,omitempty" yaml:"labels"`
+
+	interval time.Duration
+}
+
+type rulesFile struct {
+	Rules []*Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadRules читает правила из YAML или JSON файла.
+func LoadRules(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read recording rules file: %w", err)
+	}
+
+	var f rulesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("can not parse recording rules file: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(f.Rules))
+	var errs []error
+	for _, r := range f.Rules {
+		if err := r.Compile(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		key := r.Tenant + "/" + domain.SeriesKey(r.Record, r.Labels)
+		if _, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("duplicate recording rule %q", r.Record))
+		}
+		seen[key] = struct{}{}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return f.Rules, nil
+}
+
+// Compile проверяет Expr, Type и Interval.
+func (r *Rule) Compile() error {
+	if r.Record == "" {
+		return fmt.Errorf("recording rule without record: %q", r.Expr)
+	}
+
+	expr, err := promql.ParseExpr(r.Expr)
+	if err != nil {
+		return fmt.Errorf("recording rule %s: %w", r.Record, err)
+	}
+	if expr.Type() == promql.ResultMatrix {
+		return fmt.Errorf("recording rule %s: expr must return an instant vector or a scalar", r.Record)
+	}
+
+	switch r.Type {
+	case "":
+		r.Type = domain.Gauge
+	case domain.Gauge, domain.Counter:
+	default:
+		return fmt.Errorf("recording rule %s: type must be gauge or counter, got %q", r.Record, r.Type)
+	}
+
+	if r.Interval != "" {
+		d, err := time.ParseDuration(r.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("recording rule %s: bad interval %q", r.Record, r.Interval)
+		}
+		r.interval = d
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package remotewrite
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Минимальная реализация сообщений prometheus/prompb, нужных для remote_write.
+// Тянуть весь prometheus ради трёх сообщений не хочется, поэтому кодируем руками.
+
+type MetricType int32
+
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeCounter
+	MetricTypeGauge
+	MetricTypeHistogram
+	MetricTypeGaugeHistogram
+	MetricTypeSummary
+	MetricTypeInfo
+	MetricTypeStateset
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type MetricMetadata struct {
+	Type             MetricType
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+func (wr *WriteRequest) Unmarshal(b []byte) error {
+	return parseMessage(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			ts := TimeSeries{}
+			if err := ts.unmarshal(v); err != nil {
+				return fmt.Errorf("bad timeseries: %w", err)
+			}
+			wr.Timeseries = append(wr.Timeseries, ts)
+		case 3:
+			md := MetricMetadata{}
+			if err := md.unmarshal(v); err != nil {
+				return fmt.Errorf("bad metadata: %w", err)
+			}
+			wr.Metadata = append(wr.Metadata, md)
+		}
+		return nil
+	})
+}
+
+func (wr *WriteRequest) Marshal() []byte {
+	var b []byte
+	for _, ts := range wr.Timeseries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts.marshal())
+	}
+	for _, md := range wr.Metadata {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, md.marshal())
+	}
+
+	return b
+}
+
+func (ts *TimeSeries) unmarshal(b []byte) error {
+	return parseMessage(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			l := Label{}
+			if err := l.unmarshal(v); err != nil {
+				return fmt.Errorf("bad label: %w", err)
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			s := Sample{}
+			if err := s.unmarshal(v); err != nil {
+				return fmt.Errorf("bad sample: %w", err)
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+}
+
+func (ts *TimeSeries) marshal() []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l.marshal())
+	}
+	for _, s := range ts.Samples {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, s.marshal())
+	}
+
+	return b
+}
+
+func (l *Label) unmarshal(b []byte) error {
+	return parseMessage(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			l.Name = string(v)
+		case 2:
+			l.Value = string(v)
+		}
+		return nil
+	})
+}
+
+func (l *Label) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, l.Name)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, l.Value)
+
+	return b
+}
+
+func (s *Sample) unmarshal(b []byte) error {
+	return parseMessage(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			if typ != protowire.Fixed64Type {
+				return fmt.Errorf("bad value wire type %v", typ)
+			}
+			bits, _ := protowire.ConsumeFixed64(v)
+			s.Value = math.Float64frombits(bits)
+		case 2:
+			if typ != protowire.VarintType {
+				return fmt.Errorf("bad timestamp wire type %v", typ)
+			}
+			ts, _ := protowire.ConsumeVarint(v)
+			s.Timestamp = int64(ts)
+		}
+		return nil
+	})
+}
+
+func (s *Sample) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(s.Value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Timestamp))
+
+	return b
+}
+
+func (md *MetricMetadata) unmarshal(b []byte) error {
+	return parseMessage(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			t, _ := protowire.ConsumeVarint(v)
+			md.Type = MetricType(t)
+		case 2:
+			md.MetricFamilyName = string(v)
+		case 4:
+			md.Help = string(v)
+		case 5:
+			md.Unit = string(v)
+		}
+		return nil
+	})
+}
+
+func (md *MetricMetadata) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(md.Type))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, md.MetricFamilyName)
+	if md.Help != "" {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, md.Help)
+	}
+	if md.Unit != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, md.Unit)
+	}
+
+	return b
+}
+
+// parseMessage обходит поля сообщения и отдаёт их в f.
+// Для varint и fixed64 полей v содержит сырые байты значения.
+func parseMessage(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			val, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			v, n = val, m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			v = b[:n]
+		}
+
+		if err := f(num, typ, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
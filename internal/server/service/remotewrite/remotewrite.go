@@ -0,0 +1,187 @@
+package remotewrite
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AA122AA/metring/internal/delta"
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+const nameLabel = "__name__"
+
+type Metrics interface {
+	Updates(ctx context.Context, metrics []*domain.MetricsJSON) error
+}
+
+// Receiver принимает Prometheus remote_write.
+// Тип серии берётся из присланных метаданных, а если их нет - угадывается по суффиксу имени.
+// Накопительные счётчики переводятся в дельты по серии, для gauge берётся последний семпл.
+type Receiver struct {
+	srv    Metrics
+	deltas *delta.Tracker
+
+	mu    sync.RWMutex
+	types map[string]MetricType
+
+	lg *zap.Logger
+}
+
+func NewReceiver(ctx context.Context, srv Metrics) *Receiver {
+	return &Receiver{
+		srv:    srv,
+		deltas: delta.NewTracker(),
+		types:  make(map[string]MetricType),
+		lg:     zctx.From(ctx).Named("remote write receiver"),
+	}
+}
+
+// Write записывает семплы. Состояние накопительных счётчиков сохраняется только после
+// успешной записи: Prometheus повторяет запрос после 5xx с теми же семплами.
+func (r *Receiver) Write(ctx context.Context, req *WriteRequest) error {
+	r.rememberMetadata(ctx, req.Metadata)
+
+	deltas := r.deltas.Batch()
+	metrics := make([]*domain.MetricsJSON, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			labels[l.Name] = l.Value
+		}
+		name := labels[nameLabel]
+		if name == "" {
+			continue
+		}
+		delete(labels, nameLabel)
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		samples := ts.Samples
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+
+		if m := r.convert(ctx, deltas, name, labels, samples); m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	r.lg.Debug("got remote write metrics", zap.Int("count", len(metrics)))
+
+	if err := r.srv.Updates(ctx, metrics); err != nil {
+		return err
+	}
+	deltas.Commit()
+
+	return nil
+}
+
+func (r *Receiver) convert(ctx context.Context, deltas *delta.Batch, name string, labels map[string]string, samples []Sample) *domain.MetricsJSON {
+	if r.isCounter(ctx, name) {
+		series := domain.TenantFromContext(ctx) + "/" + domain.SeriesKey(name, labels)
+
+		var (
+			total int64
+			seen  bool
+		)
+		for _, s := range samples {
+			// NaN - в том числе staleness marker
+			if math.IsNaN(s.Value) {
+				continue
+			}
+			total += deltas.Delta(series, s.Value, 0)
+			seen = true
+		}
+		if !seen {
+			return nil
+		}
+
+		return &domain.MetricsJSON{
+			ID:     name,
+			MType:  domain.Counter,
+			Delta:  &total,
+			Labels: labels,
+		}
+	}
+
+	for i := len(samples) - 1; i >= 0; i-- {
+		v := samples[i].Value
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		return &domain.MetricsJSON{
+			ID:     name,
+			MType:  domain.Gauge,
+			Value:  &v,
+			Labels: labels,
+		}
+	}
+
+	return nil
+}
+
+func (r *Receiver) rememberMetadata(ctx context.Context, mds []MetricMetadata) {
+	if len(mds) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, md := range mds {
+		r.types[typeKey(ctx, md.MetricFamilyName)] = md.Type
+	}
+}
+
+func (r *Receiver) metricType(ctx context.Context, name string) (MetricType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[typeKey(ctx, name)]
+	return t, ok
+}
+
+func (r *Receiver) isCounter(ctx context.Context, name string) bool {
+	if t, ok := r.metricType(ctx, name); ok && t != MetricTypeUnknown {
+		return t == MetricTypeCounter
+	}
+
+	family, suffix := splitSuffix(name)
+	if t, ok := r.metricType(ctx, family); ok && t != MetricTypeUnknown {
+		switch t {
+		case MetricTypeCounter:
+			return true
+		case MetricTypeHistogram, MetricTypeSummary:
+			return suffix == "_count" || suffix == "_bucket"
+		default:
+			return false
+		}
+	}
+
+	return suffix == "_total" || suffix == "_count" || suffix == "_bucket"
+}
+
+// typeKey ключ типа в кэше метаданных, тенанты могут по-разному объявлять одно имя.
+func typeKey(ctx context.Context, name string) string {
+	return domain.TenantFromContext(ctx) + "/" + name
+}
+
+func splitSuffix(name string) (string, string) {
+	for _, suffix := range []string{"_total", "_count", "_bucket", "_sum"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), suffix
+		}
+	}
+
+	return name, ""
+}
@@ -0,0 +1,104 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func series(name string, samples ...Sample) TimeSeries {
+	return TimeSeries{
+		Labels:  []Label{{Name: nameLabel, Value: name}, {Name: "instance", Value: "a:9100"}},
+		Samples: samples,
+	}
+}
+
+func TestWriteRequestRoundTrip(t *testing.T) {
+	req := &WriteRequest{
+		Timeseries: []TimeSeries{series("up", Sample{Value: 1, Timestamp: 1700000000000})},
+		Metadata:   []MetricMetadata{{Type: MetricTypeGauge, MetricFamilyName: "up", Help: "target is up"}},
+	}
+
+	got := &WriteRequest{}
+	require.NoError(t, got.Unmarshal(req.Marshal()))
+	require.Equal(t, req, got)
+
+	require.Error(t, got.Unmarshal([]byte{0x0a, 0xff}))
+}
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	r := NewReceiver(ctx, srv)
+
+	get := func(name, mType string) *domain.MetricsJSON {
+		m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: name, MType: mType, Labels: map[string]string{"instance": "a:9100"}})
+		require.NoError(t, err)
+		return m
+	}
+
+	err := r.Write(ctx, &WriteRequest{
+		Timeseries: []TimeSeries{
+			series("http_requests_total", Sample{Value: 15, Timestamp: 2}, Sample{Value: 10, Timestamp: 1}),
+			series("temperature", Sample{Value: 20, Timestamp: 1}, Sample{Value: 21, Timestamp: 2}, Sample{Value: math.NaN(), Timestamp: 3}),
+			series("jobs_done", Sample{Value: 4, Timestamp: 1}),
+		},
+		Metadata: []MetricMetadata{{Type: MetricTypeCounter, MetricFamilyName: "jobs_done"}},
+	})
+	require.NoError(t, err)
+
+	// первый семпл счётчика - точка отсчёта, в metring попадают только приросты после него
+	require.Equal(t, int64(5), *get("http_requests_total", domain.Counter).Delta)
+	require.Equal(t, float64(21), *get("temperature", domain.Gauge).Value)
+	require.Equal(t, int64(0), *get("jobs_done", domain.Counter).Delta)
+
+	// Следующая запись того же накопительного счётчика добавляет только прирост
+	err = r.Write(ctx, &WriteRequest{
+		Timeseries: []TimeSeries{
+			series("http_requests_total", Sample{Value: 18, Timestamp: 3}),
+			series("jobs_done", Sample{Value: 6, Timestamp: 2}),
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(8), *get("http_requests_total", domain.Counter).Delta)
+	require.Equal(t, int64(2), *get("jobs_done", domain.Counter).Delta)
+}
+
+// flakyMetrics сервис метрик, запись в который не удаётся, пока fail не сброшен.
+type flakyMetrics struct {
+	*metrics.Metrics
+	fail bool
+}
+
+func (f *flakyMetrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
+	if f.fail {
+		return errors.New("database is down")
+	}
+	return f.Metrics.Updates(ctx, data)
+}
+
+func TestWriteRetry(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyMetrics{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage())}
+	r := NewReceiver(ctx, srv)
+
+	require.NoError(t, r.Write(ctx, &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: 10, Timestamp: 1})}}))
+
+	// Prometheus повторяет неудачный запрос с теми же семплами, прирост не теряется
+	req := &WriteRequest{Timeseries: []TimeSeries{series("http_requests_total", Sample{Value: 15, Timestamp: 2})}}
+	srv.fail = true
+	require.Error(t, r.Write(ctx, req))
+	srv.fail = false
+	require.NoError(t, r.Write(ctx, req))
+
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "http_requests_total", MType: domain.Counter, Labels: map[string]string{"instance": "a:9100"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta)
+}
@@ -63,7 +63,8 @@ func (s *Saver) Run(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
-func (s *Saver) WriteSync(data *domain.MetricsJSON) error {
+// WriteSync сохраняет метрику в файл сразу, тенант берётся из ctx.
+func (s *Saver) WriteSync(ctx context.Context, data *domain.MetricsJSON) error {
 	if s.StoreInterval != 0 {
 		return nil
 	}
@@ -74,6 +75,8 @@ func (s *Saver) WriteSync(data *domain.MetricsJSON) error {
 	}
 
 	metric := domain.TransformFromJSON(data)
+	metric.UpdatedAt = time.Now()
+	metric.Tenant = domain.TenantFromContext(ctx)
 
 	if empty {
 		return s.writeNewMetric(metric)
@@ -82,6 +85,11 @@ func (s *Saver) WriteSync(data *domain.MetricsJSON) error {
 	if index, ok := contains(metrics, metric); ok {
 		metrics[index].Delta = metric.Delta
 		metrics[index].Value = metric.Value
+		metrics[index].Histogram = metric.Histogram
+		metrics[index].Summary = metric.Summary
+		metrics[index].Set = metric.Set
+		metrics[index].Timer = metric.Timer
+		metrics[index].UpdatedAt = metric.UpdatedAt
 	} else {
 		metrics = append(metrics, metric)
 	}
@@ -118,9 +126,9 @@ func (s *Saver) writeNewMetric(metric *domain.Metrics) error {
 	return nil
 }
 
-func (s *Saver) WriteSyncBatch(data []*domain.MetricsJSON) error {
+func (s *Saver) WriteSyncBatch(ctx context.Context, data []*domain.MetricsJSON) error {
 	for _, metric := range data {
-		err := s.WriteSync(metric)
+		err := s.WriteSync(ctx, metric)
 		if err != nil {
 			return err
 		}
@@ -129,9 +137,41 @@ func (s *Saver) WriteSyncBatch(data []*domain.MetricsJSON) error {
 	return nil
 }
 
+// Remove удаляет серии из файла. При периодическом сохранении файл и так перезапишется
+// из репозитория, даже если серий не осталось, поэтому правим его только в синхронном режиме.
+func (s *Saver) Remove(ctx context.Context, keys []string) error {
+	if s.StoreInterval != 0 || len(keys) == 0 {
+		return nil
+	}
+
+	metrics, empty, err := s.isEmpty()
+	if err != nil || empty {
+		return err
+	}
+
+	removed := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		removed[k] = struct{}{}
+	}
+	tenant := domain.TenantFromContext(ctx)
+	kept := make(Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if _, ok := removed[m.Key()]; !ok || m.Tenant != tenant {
+			kept = append(kept, m)
+		}
+	}
+
+	err = s.writeToFile(kept)
+	if err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	return nil
+}
+
 func contains(metrics []*domain.Metrics, metric *domain.Metrics) (int, bool) {
 	for i, m := range metrics {
-		if m.ID == metric.ID {
+		if m.Key() == metric.Key() && m.Tenant == metric.Tenant {
 			return i, true
 		}
 	}
@@ -146,22 +186,32 @@ func (s *Saver) restore(ctx context.Context) {
 	}
 }
 
+// store пишет метрики всех тенантов в один файл, тенант сохраняется в каждой записи.
 func (s *Saver) store(ctx context.Context) error {
-	data, err := s.repo.GetAll(ctx)
+	tenants, err := s.repo.Tenants(ctx)
 	if err != nil {
-		// if strings.Contains(err.Error(), "no metrics") {
-		var emptyErr *repository.EmptyRepoError
-		if errors.Is(err, emptyErr) {
-			return nil
-		}
-		return fmt.Errorf("err while getting metrics from repo: %w", err)
+		return fmt.Errorf("err while getting tenants from repo: %w", err)
 	}
 
-	metrics := make(Metrics, 0, len(data))
-	for _, v := range data {
-		metrics = append(metrics, v)
-	}
+	metrics := make(Metrics, 0)
+	for _, tenant := range tenants {
+		data, err := s.repo.GetAll(domain.WithTenant(ctx, tenant))
+		if err != nil {
+			// if strings.Contains(err.Error(), "no metrics") {
+			var emptyErr *repository.EmptyRepoError
+			if errors.Is(err, emptyErr) {
+				continue
+			}
+			return fmt.Errorf("err while getting metrics from repo: %w", err)
+		}
 
+		for _, v := range data {
+			m := *v
+			m.Tenant = tenant
+			metrics = append(metrics, &m)
+		}
+	}
+	// пустой список тоже пишется, иначе удалённые серии вернутся из файла при рестарте
 	err = s.writeToFile(metrics)
 	if err != nil {
 		return fmt.Errorf("err while writing to file: %w", err)
@@ -179,8 +229,15 @@ func (s *Saver) load(ctx context.Context) error {
 	}
 
 	var errs []error
+	now := time.Now()
 	for _, m := range metrics {
-		err := s.repo.Write(ctx, m.ID, m)
+		// в старых файлах нет времени обновления, отсчитываем TTL с момента загрузки
+		if m.UpdatedAt.IsZero() {
+			m.UpdatedAt = now
+		}
+		tenant := m.Tenant
+		m.Tenant = domain.DefaultTenant
+		err := s.repo.Write(domain.WithTenant(ctx, tenant), m.Key(), m)
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -202,14 +259,9 @@ func (s *Saver) readFromFile() (Metrics, error) {
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	data, err := reader.ReadBytes(']')
-	if err != nil {
-		return nil, fmt.Errorf("failed to read from file: %w", err)
-	}
-
+	// декодер читает ровно один JSON массив, вложенные массивы (бакеты гистограмм) ему не мешают
 	var metrics Metrics
-	err = json.Unmarshal(data, &metrics)
+	err = json.NewDecoder(bufio.NewReader(file)).Decode(&metrics)
 	if err != nil {
 		return nil, fmt.Errorf("error while unmarshaling: %w", err)
 	}
@@ -42,3 +42,59 @@ func TestLoad(t *testing.T) {
 	err = saver.load(ctx)
 	require.NoError(t, err)
 }
+
+func TestRemove(t *testing.T) {
+	testJSON := `
+[
+    {
+        "id": "Alloc",
+        "type": "gauge",
+        "value": 1,
+        "labels": {"host": "a"}
+    },
+    {
+        "id": "GCSys",
+        "type": "gauge",
+        "value": 1737440
+    }
+]`
+	ctx := context.Background()
+	file, err := os.CreateTemp(os.TempDir(), "metrics.json")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(testJSON)
+	require.NoError(t, err)
+	file.Close()
+
+	cfg := Config{
+		StoreInterval:   0,
+		FileStoragePath: file.Name(),
+	}
+	saver := NewSaver(ctx, cfg, repository.NewMemStorage())
+	require.NoError(t, saver.Remove(ctx, []string{`Alloc{host="a"}`}))
+
+	metrics, err := saver.readFromFile()
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, "GCSys", metrics[0].ID)
+}
+
+func TestStoreEmpty(t *testing.T) {
+	ctx := context.Background()
+	file, err := os.CreateTemp(os.TempDir(), "metrics.json")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`[{"id": "GCSys", "type": "gauge", "value": 1}]`)
+	require.NoError(t, err)
+	file.Close()
+
+	// все серии удалены из репозитория: файл перезаписывается пустым списком
+	saver := NewSaver(ctx, Config{StoreInterval: 1, FileStoragePath: file.Name()}, repository.NewMemStorage())
+	require.NoError(t, saver.store(ctx))
+
+	metrics, err := saver.readFromFile()
+	require.NoError(t, err)
+	require.Empty(t, metrics)
+}
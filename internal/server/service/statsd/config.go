@@ -0,0 +1,10 @@
+package statsd
+
+type Config struct {
+	Addr          string `json:"statsdAddr" yaml:"statsdAddr" env:"STATSD_ADDR"`
+	FlushInterval int    `json:"statsdFlushInterval" yaml:"statsdFlushInterval" env:"STATSD_FLUSH_INTERVAL" default:"10"`
+	Percentiles   string `json:"statsdPercentiles" yaml:"statsdPercentiles" env:"STATSD_PERCENTILES" default:"50,90,95,99"`
+	// Expiry через сколько секунд без данных серия забывается: gauge больше не отправляется,
+	// дробный остаток counter отбрасывается.
+	Expiry int `json:"statsdExpiry" yaml:"statsdExpiry" env:"STATSD_EXPIRY" default:"300"`
+}
@@ -0,0 +1,139 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	typeCounter   = "c"
+	typeGauge     = "g"
+	typeTimer     = "ms"
+	typeHistogram = "h"
+)
+
+// sample одна строка StatsD пакета вида name:value|type[|@rate][|#tag:value,...]
+// Теги в формате DogStatsD становятся метками серии.
+type sample struct {
+	name     string
+	labels   map[string]string
+	mType    string
+	value    float64
+	rate     float64
+	relative bool
+}
+
+func parsePacket(packet []byte) ([]*sample, []error) {
+	var (
+		samples []*sample
+		errs    []error
+	)
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, errs
+}
+
+func parseLine(line string) (*sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("bad line %q: no metric name", line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("bad line %q: no metric type", line)
+	}
+
+	s := &sample{
+		name:  sanitizeName(name),
+		mType: parts[1],
+		rate:  1,
+	}
+
+	raw := parts[0]
+	if s.mType == typeGauge && (strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")) {
+		s.relative = true
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad line %q: bad value: %w", line, err)
+	}
+	s.value = v
+
+	switch s.mType {
+	case typeCounter, typeGauge, typeTimer, typeHistogram:
+	default:
+		return nil, fmt.Errorf("bad line %q: unsupported type %q", line, s.mType)
+	}
+
+	for _, p := range parts[2:] {
+		if strings.HasPrefix(p, "#") {
+			s.labels = parseTags(p[1:])
+			continue
+		}
+		if !strings.HasPrefix(p, "@") {
+			// прочие расширения пропускаем
+			continue
+		}
+		rate, err := strconv.ParseFloat(p[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("bad line %q: bad sample rate %q", line, p)
+		}
+		s.rate = rate
+	}
+
+	return s, nil
+}
+
+// parseTags разбирает теги вида k:v,k2:v2. Теги без значения пропускаются.
+func parseTags(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(tag, ":")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || v == "" || strings.ContainsAny(k, "{}=,\"") {
+			continue
+		}
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+
+	return labels
+}
+
+func sanitizeName(name string) string {
+	r := strings.NewReplacer(" ", "_", "/", "-")
+	return r.Replace(strings.TrimSpace(name))
+}
+
+func parsePercentiles(s string) ([]float64, error) {
+	var res []float64
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f > 100 {
+			return nil, fmt.Errorf("bad percentile %q", p)
+		}
+		res = append(res, f)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,436 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+const maxPacketSize = 65535
+
+type seriesID struct {
+	name   string
+	labels map[string]string
+}
+
+type Metrics interface {
+	Updates(ctx context.Context, metrics []*domain.MetricsJSON) error
+	GetJSON(ctx context.Context, metric *domain.MetricsJSON) (*domain.MetricsJSON, error)
+}
+
+// epsilon допуск при выделении целой части counter: деление на rate
+// даёт 6.999999999999999 вместо 7.
+const epsilon = 1e-9
+
+// Listener принимает StatsD пакеты по UDP, агрегирует их
+// и раз в FlushInterval отправляет пачкой в сервис метрик.
+type Listener struct {
+	addr          string
+	flushInterval int
+	percentiles   []float64
+	expiry        time.Duration
+
+	// Все буферы ниже хранятся по ключу серии (domain.SeriesKey),
+	// а имя и метки серии лежат в series.
+	mu          sync.Mutex
+	series      map[string]seriesID
+	seen        map[string]time.Time
+	counters    map[string]float64
+	gauges      map[string]float64
+	dirtyGauges map[string]struct{}
+	timers      map[string][]float64
+	timerCounts map[string]float64
+	// remainders дробные остатки counter и числа замеров timer после отправки,
+	// переносятся в следующий интервал
+	remainders map[string]float64
+	// pending пачка, которую не удалось записать, уходит вместе со следующей
+	pending []*domain.MetricsJSON
+	now     func() time.Time
+
+	srv Metrics
+	lg  *zap.Logger
+}
+
+func NewListener(ctx context.Context, cfg Config, srv Metrics) *Listener {
+	lg := zctx.From(ctx).Named("statsd listener")
+
+	percentiles, err := parsePercentiles(cfg.Percentiles)
+	if err != nil {
+		lg.Warn("bad percentiles, using defaults", zap.String("percentiles", cfg.Percentiles), zap.Error(err))
+		percentiles = []float64{50, 90, 95, 99}
+	}
+
+	return &Listener{
+		addr:          cfg.Addr,
+		flushInterval: cfg.FlushInterval,
+		percentiles:   percentiles,
+		expiry:        time.Duration(cfg.Expiry) * time.Second,
+		series:        make(map[string]seriesID),
+		seen:          make(map[string]time.Time),
+		counters:      make(map[string]float64),
+		gauges:        make(map[string]float64),
+		dirtyGauges:   make(map[string]struct{}),
+		timers:        make(map[string][]float64),
+		timerCounts:   make(map[string]float64),
+		remainders:    make(map[string]float64),
+		now:           time.Now,
+		srv:           srv,
+		lg:            lg,
+	}
+}
+
+func (l *Listener) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	conn, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		l.lg.Error("failed to listen udp", zap.String("addr", l.addr), zap.Error(err))
+		return
+	}
+	l.lg.Info("Start statsd listener on", zap.String("addr", conn.LocalAddr().String()))
+
+	go l.read(ctx, conn)
+
+	ticker := time.NewTicker(time.Duration(l.flushInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.lg.Info("got cancellation, returning")
+			conn.Close()
+			l.flush(context.WithoutCancel(ctx))
+			l.mu.Lock()
+			if len(l.pending) > 0 {
+				l.lg.Error("statsd metrics lost on shutdown", zap.Int("count", len(l.pending)))
+			}
+			l.mu.Unlock()
+			return
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *Listener) read(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.lg.Warn("error while reading packet", zap.Error(err))
+			continue
+		}
+		l.handle(ctx, buf[:n])
+	}
+}
+
+func (l *Listener) handle(ctx context.Context, packet []byte) {
+	samples, errs := parsePacket(packet)
+	for _, err := range errs {
+		l.lg.Debug("skip bad statsd line", zap.Error(err))
+	}
+
+	// текущие значения относительных gauge запрашиваются до блокировки,
+	// чтобы обращение к хранилищу не задерживало разбор других пакетов
+	current := l.lookup(ctx, samples)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, s := range samples {
+		key := domain.SeriesKey(s.name, s.labels)
+		// одна серия не может быть сразу counter и gauge, такая строка отбрасывается
+		if kind, ok := l.kind(key); ok && kind != kindOf(s.mType) {
+			l.lg.Debug("skip statsd line with conflicting type",
+				zap.String("series", key), zap.String("type", s.mType), zap.String("buffered", kind))
+			continue
+		}
+		l.series[key] = seriesID{name: s.name, labels: s.labels}
+		l.seen[key] = now
+
+		switch s.mType {
+		case typeCounter:
+			l.counters[key] += s.value / s.rate
+		case typeGauge:
+			if s.relative {
+				cur, ok := l.gauges[key]
+				if !ok {
+					cur = current[key]
+				}
+				l.gauges[key] = cur + s.value
+			} else {
+				l.gauges[key] = s.value
+			}
+			l.dirtyGauges[key] = struct{}{}
+		case typeTimer, typeHistogram:
+			l.timers[key] = append(l.timers[key], s.value)
+			l.timerCounts[key] += 1 / s.rate
+		}
+	}
+}
+
+// kind тип серии в буфере, вызывается под l.mu.
+func (l *Listener) kind(key string) (string, bool) {
+	if _, ok := l.counters[key]; ok {
+		return typeCounter, true
+	}
+	if _, ok := l.gauges[key]; ok {
+		return typeGauge, true
+	}
+	if _, ok := l.timers[key]; ok {
+		return typeTimer, true
+	}
+
+	return "", false
+}
+
+// kindOf histogram в StatsD - тот же timer.
+func kindOf(mType string) string {
+	if mType == typeHistogram {
+		return typeTimer
+	}
+
+	return mType
+}
+
+// lookup возвращает текущие значения относительных gauge, которых нет в буфере.
+func (l *Listener) lookup(ctx context.Context, samples []*sample) map[string]float64 {
+	ids := make(map[string]seriesID)
+	l.mu.Lock()
+	for _, s := range samples {
+		if s.mType != typeGauge || !s.relative {
+			continue
+		}
+		key := domain.SeriesKey(s.name, s.labels)
+		if _, ok := l.gauges[key]; !ok {
+			ids[key] = seriesID{name: s.name, labels: s.labels}
+		}
+	}
+	l.mu.Unlock()
+
+	res := make(map[string]float64, len(ids))
+	for key, id := range ids {
+		res[key] = l.current(ctx, id.name, id.labels)
+	}
+
+	return res
+}
+
+// current возвращает текущее значение gauge из сервиса,
+// нужно для относительных (+/-) gauge, которых ещё нет в буфере.
+func (l *Listener) current(ctx context.Context, name string, labels map[string]string) float64 {
+	m, err := l.srv.GetJSON(ctx, &domain.MetricsJSON{ID: name, MType: domain.Gauge, Labels: labels})
+	if err != nil {
+		var er *repository.EmptyRepoError
+		if !errors.Is(err, er) {
+			l.lg.Warn("cannot get current gauge value", zap.String("name", name), zap.Error(err))
+		}
+		return 0
+	}
+	if m.Value == nil {
+		return 0
+	}
+
+	return *m.Value
+}
+
+// flush отправляет накопленное. Если запись не удалась, пачка остаётся
+// и уходит со следующим flush.
+func (l *Listener) flush(ctx context.Context) {
+	metrics := l.collect()
+	if len(metrics) == 0 {
+		return
+	}
+
+	failed := l.send(ctx, metrics)
+	if len(failed) == 0 {
+		l.lg.Debug("flushed statsd metrics", zap.Int("count", len(metrics)))
+		return
+	}
+	l.mu.Lock()
+	l.pending = failed
+	l.mu.Unlock()
+}
+
+// send записывает пачку и возвращает метрики, запись которых стоит повторить.
+// Если пачку отклонили из-за отдельных метрик, она пишется по одной,
+// а отклонённые метрики отбрасываются.
+func (l *Listener) send(ctx context.Context, metrics []*domain.MetricsJSON) []*domain.MetricsJSON {
+	err := l.srv.Updates(ctx, metrics)
+	switch {
+	case err == nil:
+		return nil
+	case !domain.Rejected(err):
+		l.lg.Error("error while flushing statsd metrics, will retry", zap.Int("count", len(metrics)), zap.Error(err))
+		return metrics
+	}
+
+	failed := make([]*domain.MetricsJSON, 0)
+	for _, m := range metrics {
+		err := l.srv.Updates(ctx, []*domain.MetricsJSON{m})
+		switch {
+		case err == nil:
+		case domain.Rejected(err):
+			l.lg.Warn("statsd metric rejected", zap.String("name", m.ID), zap.String("type", m.MType), zap.Error(err))
+		default:
+			failed = append(failed, m)
+		}
+	}
+	if len(failed) > 0 {
+		l.lg.Error("error while flushing statsd metrics, will retry", zap.Int("count", len(failed)), zap.Error(err))
+	}
+
+	return failed
+}
+
+// collect забирает накопленные за интервал значения вместе с неотправленной
+// пачкой и сбрасывает буфер.
+func (l *Listener) collect() []*domain.MetricsJSON {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	metrics := make([]*domain.MetricsJSON, 0, len(l.pending)+len(l.counters)+len(l.dirtyGauges)+len(l.timers))
+	metrics = append(metrics, l.pending...)
+	for key, v := range l.counters {
+		metrics = append(metrics, counter(l.series[key], "", l.whole(key, v)))
+	}
+	for key := range l.dirtyGauges {
+		metrics = append(metrics, gauge(l.series[key], "", l.gauges[key]))
+	}
+	for key, values := range l.timers {
+		metrics = append(metrics, l.aggregateTimer(l.series[key], values, l.whole(key, l.timerCounts[key]))...)
+	}
+
+	l.pending = nil
+	l.counters = make(map[string]float64)
+	l.dirtyGauges = make(map[string]struct{})
+	l.timers = make(map[string][]float64)
+	l.timerCounts = make(map[string]float64)
+	l.expire()
+
+	return compact(metrics)
+}
+
+// whole возвращает целую часть значения с остатком прошлого интервала,
+// дробная часть переносится дальше. Вызывается под l.mu.
+func (l *Listener) whole(key string, v float64) int64 {
+	v += l.remainders[key]
+	n := math.Trunc(v + math.Copysign(epsilon, v))
+	if rest := v - n; math.Abs(rest) > epsilon {
+		l.remainders[key] = rest
+	} else {
+		delete(l.remainders, key)
+	}
+
+	return int64(n)
+}
+
+// expire забывает серии без данных дольше expiry, вызывается под l.mu.
+func (l *Listener) expire() {
+	if l.expiry <= 0 {
+		return
+	}
+	now := l.now()
+	for key, at := range l.seen {
+		if now.Sub(at) <= l.expiry {
+			continue
+		}
+		delete(l.seen, key)
+		delete(l.series, key)
+		delete(l.gauges, key)
+		delete(l.remainders, key)
+	}
+}
+
+// compact схлопывает пачку по сериям: приросты counter складываются,
+// у остальных остаётся последнее значение. Так неотправленные пачки не растут.
+func compact(metrics []*domain.MetricsJSON) []*domain.MetricsJSON {
+	res := make([]*domain.MetricsJSON, 0, len(metrics))
+	idx := make(map[string]int, len(metrics))
+	for _, m := range metrics {
+		// тип в ключе, чтобы конфликт типов дошёл до сервиса и был отклонён там
+		key := m.MType + " " + domain.SeriesKey(m.ID, m.Labels)
+		i, ok := idx[key]
+		switch {
+		case !ok:
+			idx[key] = len(res)
+			res = append(res, m)
+		case m.MType == domain.Counter:
+			d := *res[i].Delta + *m.Delta
+			res[i] = counter(seriesID{name: m.ID, labels: m.Labels}, "", d)
+		default:
+			res[i] = m
+		}
+	}
+
+	return res
+}
+
+func (l *Listener) aggregateTimer(id seriesID, values []float64, count int64) []*domain.MetricsJSON {
+	sort.Float64s(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	metrics := []*domain.MetricsJSON{
+		counter(id, ".count", count),
+		gauge(id, ".sum", sum),
+		gauge(id, ".mean", sum/float64(len(values))),
+		gauge(id, ".min", values[0]),
+		gauge(id, ".max", values[len(values)-1]),
+	}
+	for _, p := range l.percentiles {
+		metrics = append(metrics, gauge(id, ".p"+percentileSuffix(p), percentile(values, p)))
+	}
+
+	return metrics
+}
+
+// percentile считает перцентиль методом ближайшего ранга, values должны быть отсортированы.
+func percentile(values []float64, p float64) float64 {
+	idx := int(math.Ceil(p/100*float64(len(values)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return values[idx]
+}
+
+func percentileSuffix(p float64) string {
+	return strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+}
+
+func counter(id seriesID, suffix string, delta int64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{
+		ID:     id.name + suffix,
+		MType:  domain.Counter,
+		Delta:  &delta,
+		Labels: id.labels,
+	}
+}
+
+func gauge(id seriesID, suffix string, value float64) *domain.MetricsJSON {
+	return &domain.MetricsJSON{
+		ID:     id.name + suffix,
+		MType:  domain.Gauge,
+		Value:  &value,
+		Labels: id.labels,
+	}
+}
@@ -0,0 +1,184 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/AA122AA/metring/internal/server/repository"
+	"github.com/AA122AA/metring/internal/server/service/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want *sample
+		pass bool
+	}{
+		{
+			name: "Positive counter with rate",
+			line: "hits:2|c|@0.5",
+			want: &sample{name: "hits", mType: typeCounter, value: 2, rate: 0.5},
+			pass: true,
+		},
+		{
+			name: "Positive relative gauge",
+			line: "queue:-3|g",
+			want: &sample{name: "queue", mType: typeGauge, value: -3, rate: 1, relative: true},
+			pass: true,
+		},
+		{
+			name: "Positive timer with tags",
+			line: "req time:12.5|ms|#host:a,bare",
+			want: &sample{name: "req_time", labels: map[string]string{"host": "a"}, mType: typeTimer, value: 12.5, rate: 1},
+			pass: true,
+		},
+		{
+			name: "Negative no type",
+			line: "hits:2",
+			pass: false,
+		},
+		{
+			name: "Negative bad type",
+			line: "hits:2|x",
+			pass: false,
+		},
+		{
+			name: "Negative bad rate",
+			line: "hits:2|c|@2",
+			pass: false,
+		},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			s, err := parseLine(tCase.line)
+			if tCase.pass {
+				require.NoError(t, err)
+				require.Equal(t, tCase.want, s)
+				return
+			}
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemStorage()
+	srv := metrics.NewMetrics(ctx, repo)
+
+	v := float64(10)
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "queue", MType: domain.Gauge, Value: &v}))
+
+	l := NewListener(ctx, Config{FlushInterval: 10, Percentiles: "50,90"}, srv)
+	l.handle(ctx, []byte("hits:1|c\nhits:1|c|@0.5\nqueue:+5|g\nqueue:-2|g\nreq:10|ms\nreq:20|ms\nreq:30|ms\nbad line"))
+	l.flush(ctx)
+
+	get := func(name, mType string) *domain.MetricsJSON {
+		m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: name, MType: mType})
+		require.NoError(t, err)
+		return m
+	}
+
+	require.Equal(t, int64(3), *get("hits", domain.Counter).Delta)
+	require.Equal(t, float64(13), *get("queue", domain.Gauge).Value)
+	require.Equal(t, int64(3), *get("req.count", domain.Counter).Delta)
+	require.Equal(t, float64(20), *get("req.mean", domain.Gauge).Value)
+	require.Equal(t, float64(10), *get("req.min", domain.Gauge).Value)
+	require.Equal(t, float64(30), *get("req.max", domain.Gauge).Value)
+	require.Equal(t, float64(20), *get("req.p50", domain.Gauge).Value)
+	require.Equal(t, float64(30), *get("req.p90", domain.Gauge).Value)
+
+	// Второй flush с тем же counter должен прибавить дельту
+	l.handle(ctx, []byte("hits:4|c\nhits:2|c|#host:b"))
+	l.flush(ctx)
+	require.Equal(t, int64(7), *get("hits", domain.Counter).Delta)
+
+	m, err := srv.GetJSON(ctx, &domain.MetricsJSON{ID: "hits", MType: domain.Counter, Labels: map[string]string{"host": "b"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *m.Delta)
+}
+
+// flakyMetrics сервис метрик, запись в который не удаётся, пока fail не сброшен.
+type flakyMetrics struct {
+	*metrics.Metrics
+	fail bool
+}
+
+func (f *flakyMetrics) Updates(ctx context.Context, data []*domain.MetricsJSON) error {
+	if f.fail {
+		return errors.New("database is down")
+	}
+	return f.Metrics.Updates(ctx, data)
+}
+
+func TestFlushConflicts(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	v := float64(1)
+	require.NoError(t, srv.Update(ctx, &domain.MetricsJSON{ID: "load", MType: domain.Gauge, Value: &v}))
+
+	l := NewListener(ctx, Config{FlushInterval: 10}, srv)
+	// gauge в пакете с counter той же серии отбрасывается ещё при разборе,
+	// counter поверх сохранённого gauge отклоняет сервис, остальное записывается
+	l.handle(ctx, []byte("foo:1|c\nfoo:2|g\nload:3|c\nhits:5|c"))
+	l.flush(ctx)
+
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *all["foo"].Delta)
+	require.Equal(t, int64(5), *all["hits"].Delta)
+	require.Equal(t, domain.Gauge, all["load"].MType)
+	require.Empty(t, l.pending)
+}
+
+func TestFlushRetry(t *testing.T) {
+	ctx := context.Background()
+	srv := &flakyMetrics{Metrics: metrics.NewMetrics(ctx, repository.NewMemStorage()), fail: true}
+	l := NewListener(ctx, Config{FlushInterval: 10}, srv)
+
+	l.handle(ctx, []byte("hits:2|c\nload:1|g"))
+	l.flush(ctx)
+	l.handle(ctx, []byte("hits:3|c\nload:4|g"))
+	l.flush(ctx)
+	require.Len(t, l.pending, 2)
+
+	srv.fail = false
+	l.flush(ctx)
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *all["hits"].Delta)
+	require.Equal(t, float64(4), *all["load"].Value)
+}
+
+func TestFlushRemainders(t *testing.T) {
+	ctx := context.Background()
+	srv := metrics.NewMetrics(ctx, repository.NewMemStorage())
+	l := NewListener(ctx, Config{FlushInterval: 10, Expiry: 60}, srv)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// 1/0.3 за интервал: дробные части не теряются, а доходят следующим flush
+	for range 3 {
+		l.handle(ctx, []byte("hits:1|c|@0.3\nreq:5|ms|@0.3"))
+		l.flush(ctx)
+	}
+	all, err := srv.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *all["hits"].Delta)
+	require.Equal(t, int64(10), *all["req.count"].Delta)
+
+	// серия без данных дольше Expiry забывается вместе с остатком
+	now = now.Add(time.Minute + time.Second)
+	l.handle(ctx, []byte("load:1|g"))
+	now = now.Add(time.Minute + time.Second)
+	l.flush(ctx)
+	require.Empty(t, l.remainders)
+	require.Empty(t, l.gauges)
+	require.Empty(t, l.series)
+}
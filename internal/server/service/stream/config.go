@@ -0,0 +1,27 @@
+package stream
+
+import "strings"
+
+type Config struct {
+	// Buffer сколько событий ждёт отправки одному подписчику, при переполнении он отключается.
+	Buffer int `json:"streamBuffer" yaml:"streamBuffer" env:"STREAM_BUFFER" default:"256"`
+	// MaxSubscribers предел одновременных подписчиков, 0 - без ограничения.
+	MaxSubscribers int `json:"streamMaxSubscribers" yaml:"streamMaxSubscribers" env:"STREAM_MAX_SUBSCRIBERS" default:"1000"`
+	// AllowedOrigins источники через запятую, с которых браузер может открыть WebSocket стрим
+	// (https://dash.example.com), "*" - любые. Запросы с того же хоста и без Origin разрешены всегда.
+	AllowedOrigins string `json:"streamAllowedOrigins" yaml:"streamAllowedOrigins" env:"STREAM_ALLOWED_ORIGINS"`
+}
+
+// ParseOrigins разбирает список источников через запятую.
+func ParseOrigins(s string) []string {
+	var origins []string
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		origins = append(origins, strings.TrimSuffix(o, "/"))
+	}
+
+	return origins
+}
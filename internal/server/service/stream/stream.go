@@ -0,0 +1,184 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrBadMatch       = errors.New("bad match pattern")
+	ErrTooManyStreams = errors.New("too many subscribers")
+)
+
+// Event принятое обновление серии. Data - JSON с новым значением в том же виде,
+// что отдаёт /value/, и временем обновления. Кодируется один раз на всех подписчиков,
+// чтобы не держать ссылки на значения в хранилище.
+type Event struct {
+	Name string
+	Data []byte
+}
+
+type update struct {
+	*domain.MetricsJSON
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Subscription подписка на обновления тенанта. Events закрывается, когда
+// подписчик не успевает читать и отключается хабом.
+type Subscription struct {
+	tenant  string
+	match   []string
+	events  chan *Event
+	dropped bool
+}
+
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// matches подходит ли имя под один из шаблонов, без шаблонов подходит любое.
+func (s *Subscription) matches(name string) bool {
+	if len(s.match) == 0 {
+		return true
+	}
+	for _, m := range s.match {
+		if ok, _ := path.Match(m, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub раздаёт принятые обновления подписчикам. У каждого подписчика свой буфер,
+// медленный подписчик не задерживает приём метрик, а отключается.
+type Hub struct {
+	buffer int
+	max    int
+
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+
+	lg *zap.Logger
+}
+
+func NewHub(ctx context.Context, cfg Config) *Hub {
+	return &Hub{
+		buffer: cfg.Buffer,
+		max:    cfg.MaxSubscribers,
+		subs:   make(map[*Subscription]struct{}),
+		lg:     zctx.From(ctx).Named("stream hub"),
+	}
+}
+
+// Subscribe подписывает на обновления тенанта из контекста. Шаблоны имён
+// в синтаксисе path.Match, например cpu.* или *Alloc.
+func (h *Hub) Subscribe(ctx context.Context, match []string) (*Subscription, error) {
+	for _, m := range match {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadMatch, m)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.max > 0 && len(h.subs) >= h.max {
+		return nil, ErrTooManyStreams
+	}
+	s := &Subscription{
+		tenant: domain.TenantFromContext(ctx),
+		match:  match,
+		events: make(chan *Event, h.buffer),
+	}
+	h.subs[s] = struct{}{}
+
+	return s, nil
+}
+
+// Unsubscribe убирает подписку и закрывает её канал.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drop(s)
+}
+
+// Publish отправляет обновлённые серии подписчикам их тенанта. Не блокируется:
+// подписчик с заполненным буфером отключается.
+func (h *Hub) Publish(ctx context.Context, metrics []*domain.Metrics) {
+	tenant := domain.TenantFromContext(ctx)
+
+	h.mu.RLock()
+	if len(h.subs) == 0 {
+		h.mu.RUnlock()
+		return
+	}
+	events := make([]*Event, 0, len(metrics))
+	for _, m := range metrics {
+		data, err := json.Marshal(update{MetricsJSON: domain.TransformToJSON(m), Timestamp: m.UpdatedAt})
+		if err != nil {
+			h.lg.Error("error while marshaling update", zap.String("name", m.ID), zap.Error(err))
+			continue
+		}
+		events = append(events, &Event{Name: m.ID, Data: data})
+	}
+	slow := make([]*Subscription, 0)
+	for s := range h.subs {
+		if s.tenant == tenant && !s.send(events) {
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range slow {
+		if _, ok := h.subs[s]; !ok {
+			continue
+		}
+		h.lg.Warn("dropping slow stream subscriber", zap.String("tenant", s.tenant), zap.Strings("match", s.match))
+		s.dropped = true
+		h.drop(s)
+	}
+}
+
+// send кладёт подходящие события в буфер, false - буфер переполнен.
+func (s *Subscription) send(events []*Event) bool {
+	for _, e := range events {
+		if !s.matches(e.Name) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// drop вызывается под блокировкой.
+func (h *Hub) drop(s *Subscription) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.events)
+}
+
+// Dropped отключил ли хаб подписчика за медленное чтение, проверяется после закрытия Events.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(name string, v float64) *domain.Metrics {
+	return &domain.Metrics{ID: name, MType: domain.Gauge, Value: &v, UpdatedAt: time.Now()}
+}
+
+func drain(s *Subscription) []string {
+	res := make([]string, 0)
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				return res
+			}
+			res = append(res, e.Name)
+		default:
+			return res
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	acme := domain.WithTenant(ctx, "acme")
+	h := NewHub(ctx, Config{Buffer: 10})
+
+	all, err := h.Subscribe(ctx, nil)
+	require.NoError(t, err)
+	heap, err := h.Subscribe(ctx, []string{"Heap*", "Alloc"})
+	require.NoError(t, err)
+	other, err := h.Subscribe(acme, nil)
+	require.NoError(t, err)
+
+	h.Publish(ctx, []*domain.Metrics{gauge("HeapAlloc", 1), gauge("Alloc", 2), gauge("PollCount", 3)})
+	require.Equal(t, []string{"HeapAlloc", "Alloc", "PollCount"}, drain(all))
+	require.Equal(t, []string{"HeapAlloc", "Alloc"}, drain(heap))
+	// чужой тенант обновлений не видит
+	require.Empty(t, drain(other))
+
+	h.Unsubscribe(heap)
+	h.Publish(ctx, []*domain.Metrics{gauge("HeapAlloc", 1)})
+	_, ok := <-heap.Events()
+	require.False(t, ok)
+	require.False(t, heap.Dropped())
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	h := NewHub(ctx, Config{Buffer: 2})
+
+	slow, err := h.Subscribe(ctx, nil)
+	require.NoError(t, err)
+	fast, err := h.Subscribe(ctx, nil)
+	require.NoError(t, err)
+
+	h.Publish(ctx, []*domain.Metrics{gauge("a", 1), gauge("b", 2)})
+	require.Len(t, drain(fast), 2)
+	h.Publish(ctx, []*domain.Metrics{gauge("c", 3)})
+
+	// буфер медленного переполнен: он отключён, остальные получают обновления
+	require.Equal(t, []string{"a", "b"}, drain(slow))
+	require.True(t, slow.Dropped())
+	require.Equal(t, []string{"c"}, drain(fast))
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	ctx := context.Background()
+	h := NewHub(ctx, Config{Buffer: 1, MaxSubscribers: 1})
+
+	_, err := h.Subscribe(ctx, []string{"[a"})
+	require.ErrorIs(t, err, ErrBadMatch)
+
+	s, err := h.Subscribe(ctx, nil)
+	require.NoError(t, err)
+	_, err = h.Subscribe(ctx, nil)
+	require.ErrorIs(t, err, ErrTooManyStreams)
+
+	h.Unsubscribe(s)
+	_, err = h.Subscribe(ctx, nil)
+	require.NoError(t, err)
+}
@@ -0,0 +1,6 @@
+package tenants
+
+type Config struct {
+	File     string `json:"tenantsFile" yaml:"tenantsFile" env:"TENANTS_FILE"`
+	AdminKey string `json:"adminKey" yaml:"adminKey" env:"ADMIN_KEY"`
+}
@@ -0,0 +1,190 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/go-faster/sdk/zctx"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNotFound = errors.New("tenant not found")
+	ErrInvalid  = errors.New("invalid tenant")
+	ErrKeyInUse = errors.New("api key is used by another tenant")
+)
+
+// idRe допустимые идентификаторы тенантов, id попадает в ключи хранилища.
+var idRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Tenant клиент сервера со своим пространством имён метрик и набором API ключей.
+type Tenant struct {
+	ID   string   `json:"id" yaml:"id"`
+	Keys []string `json:"keys" yaml:"keys"`
+}
+
+type file struct {
+	Tenants []*Tenant `yaml:"tenants"`
+}
+
+// Registry хранит тенантов и их ключи. Пока нет ни одного тенанта,
+// мультитенантность выключена и все запросы идут в тенант по умолчанию.
+// Если задан файл, изменения через админский API записываются в него.
+type Registry struct {
+	path string
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	keys    map[string]string
+
+	lg *zap.Logger
+}
+
+func NewRegistry(ctx context.Context, cfg Config) (*Registry, error) {
+	r := &Registry{
+		path:    cfg.File,
+		tenants: make(map[string]*Tenant),
+		keys:    make(map[string]string),
+		lg:      zctx.From(ctx).Named("tenants registry"),
+	}
+	if r.path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return r, nil
+		}
+		return nil, fmt.Errorf("can not read tenants file: %w", err)
+	}
+
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("can not parse tenants file: %w", err)
+	}
+	for _, t := range f.Tenants {
+		if err := r.put(t); err != nil {
+			return nil, err
+		}
+	}
+	r.lg.Info("loaded tenants", zap.Int("count", len(r.tenants)))
+
+	return r, nil
+}
+
+// Enabled включена ли проверка ключей.
+func (r *Registry) Enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.tenants) > 0
+}
+
+// Resolve возвращает тенанта по API ключу.
+func (r *Registry) Resolve(key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.keys[key]
+	return id, ok
+}
+
+// List возвращает тенантов, отсортированных по id.
+func (r *Registry) List() []*Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]*Tenant, 0, len(r.tenants))
+	for _, id := range slices.Sorted(maps.Keys(r.tenants)) {
+		res = append(res, r.tenants[id])
+	}
+
+	return res
+}
+
+// Put создаёт тенанта или заменяет его ключи.
+func (r *Registry) Put(t *Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.put(t); err != nil {
+		return err
+	}
+
+	return r.save()
+}
+
+// Delete удаляет тенанта и его ключи. Метрики тенанта остаются в хранилище.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.tenants[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, k := range old.Keys {
+		delete(r.keys, k)
+	}
+	delete(r.tenants, id)
+
+	return r.save()
+}
+
+func (r *Registry) put(t *Tenant) error {
+	if !idRe.MatchString(t.ID) {
+		return fmt.Errorf("%w: bad id %q", ErrInvalid, t.ID)
+	}
+	if len(t.Keys) == 0 {
+		return fmt.Errorf("%w: %s has no api keys", ErrInvalid, t.ID)
+	}
+	for _, k := range t.Keys {
+		if k == "" {
+			return fmt.Errorf("%w: %s has empty api key", ErrInvalid, t.ID)
+		}
+		if id, ok := r.keys[k]; ok && id != t.ID {
+			return fmt.Errorf("%w: tenant %s", ErrKeyInUse, t.ID)
+		}
+	}
+
+	if old, ok := r.tenants[t.ID]; ok {
+		for _, k := range old.Keys {
+			delete(r.keys, k)
+		}
+	}
+	for _, k := range t.Keys {
+		r.keys[k] = t.ID
+	}
+	r.tenants[t.ID] = &Tenant{ID: t.ID, Keys: slices.Clone(t.Keys)}
+
+	return nil
+}
+
+// save записывает тенантов в файл, вызывается под блокировкой.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	f := file{Tenants: make([]*Tenant, 0, len(r.tenants))}
+	for _, id := range slices.Sorted(maps.Keys(r.tenants)) {
+		f.Tenants = append(f.Tenants, r.tenants[id])
+	}
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("can not marshal tenants: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0o600); err != nil {
+		return fmt.Errorf("can not write tenants file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package tenants
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tenants.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+tenants:
+  - id: team-a
+    keys: [key-a]
+`), 0o600))
+
+	r, err := NewRegistry(ctx, Config{File: path})
+	require.NoError(t, err)
+	require.True(t, r.Enabled())
+
+	id, ok := r.Resolve("key-a")
+	require.True(t, ok)
+	require.Equal(t, "team-a", id)
+	_, ok = r.Resolve("key-b")
+	require.False(t, ok)
+
+	cases := []struct {
+		name   string
+		tenant *Tenant
+		pass   bool
+	}{
+		{name: "New tenant", tenant: &Tenant{ID: "team-b", Keys: []string{"key-b"}}, pass: true},
+		{name: "Key of another tenant", tenant: &Tenant{ID: "team-c", Keys: []string{"key-a"}}, pass: false},
+		{name: "Bad id", tenant: &Tenant{ID: "team/c", Keys: []string{"key-c"}}, pass: false},
+		{name: "No keys", tenant: &Tenant{ID: "team-c"}, pass: false},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := r.Put(tCase.tenant)
+			if tCase.pass {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+
+	// изменения переживают перезапуск
+	require.NoError(t, r.Delete("team-a"))
+	require.ErrorIs(t, r.Delete("team-a"), ErrNotFound)
+
+	r, err = NewRegistry(ctx, Config{File: path})
+	require.NoError(t, err)
+	require.Equal(t, []*Tenant{{ID: "team-b", Keys: []string{"key-b"}}}, r.List())
+}
@@ -0,0 +1,145 @@
+// Package templates HTML шаблоны дашборда, встроенные в бинарник.
+package templates
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AA122AA/metring/internal/server/domain"
+)
+
+//go:embed *.html
+var files embed.FS
+
+// Load разбирает встроенные шаблоны. Если задан override - каталог или glob,
+// одноимённые шаблоны из него заменяют встроенные.
+func Load(override string) (*template.Template, error) {
+	t, err := template.New("").Funcs(Funcs).ParseFS(files, "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("can not parse embedded templates: %w", err)
+	}
+	if override == "" {
+		return t, nil
+	}
+
+	pattern := override
+	if !strings.ContainsAny(pattern, "*?[") {
+		pattern = filepath.Join(override, "*.html")
+	}
+	dir, glob := filepath.Split(pattern)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("bad templates dir: %w", err)
+	}
+	matches, err := fs.Glob(os.DirFS(dir), glob)
+	if err != nil {
+		return nil, fmt.Errorf("bad templates pattern: %w", err)
+	}
+	if len(matches) == 0 {
+		return t, nil
+	}
+	if t, err = t.ParseFS(os.DirFS(dir), matches...); err != nil {
+		return nil, fmt.Errorf("can not parse templates from %s: %w", dir, err)
+	}
+
+	return t, nil
+}
+
+// Funcs функции, доступные в шаблонах.
+var Funcs = template.FuncMap{
+	"value":     value,
+	"number":    number,
+	"labels":    labels,
+	"detailURL": detailURL,
+	"sparkline": sparkline,
+}
+
+// value значение серии для показа: число или описание распределения.
+func value(m *domain.Metrics) string {
+	switch {
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	case m.Histogram != nil:
+		return m.Histogram.String()
+	case m.Summary != nil:
+		return m.Summary.String()
+	case m.Set != nil:
+		return m.Set.String()
+	case m.Timer != nil:
+		return m.Timer.String()
+	}
+	return ""
+}
+
+// number значение серии одним числом для сортировки, пусто если его нет.
+func number(m *domain.Metrics) string {
+	if v, ok := m.Number(); ok {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return ""
+}
+
+func labels(l map[string]string) string {
+	parts := make([]string, 0, len(l))
+	for _, k := range slices.Sorted(maps.Keys(l)) {
+		parts = append(parts, k+"="+l[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// detailURL страница серии, метки передаются параметрами как в /value/.
+func detailURL(m *domain.Metrics) string {
+	u := "/metric/" + url.PathEscape(m.MType) + "/" + url.PathEscape(m.ID)
+	if len(m.Labels) == 0 {
+		return u
+	}
+	q := url.Values{}
+	for k, v := range m.Labels {
+		q.Set(domain.LabelParam+k, v)
+	}
+	return u + "?" + q.Encode()
+}
+
+// sparkline SVG график значений, масштабированный по min и max.
+func sparkline(values []float64, width, height int) template.HTML {
+	if len(values) < 2 {
+		return template.HTML(fmt.Sprintf(`<svg class="sparkline" width="%d" height="%d"></svg>`, width, height))
+	}
+
+	lo, hi := slices.Min(values), slices.Max(values)
+	span := hi - lo
+	const pad = 2.0
+	w, h := float64(width)-2*pad, float64(height)-2*pad
+
+	var b strings.Builder
+	for i, v := range values {
+		x := pad + w*float64(i)/float64(len(values)-1)
+		y := pad + h/2
+		if span > 0 && !math.IsNaN(v) {
+			y = pad + h*(1-(v-lo)/span)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f", x, y)
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d"><polyline fill="none" stroke="currentColor" stroke-width="1.5" points="%s"/></svg>`,
+		width, height, width, height, b.String(),
+	))
+}
@@ -0,0 +1,40 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tmpl, err := Load("")
+	require.NoError(t, err)
+	require.NotNil(t, tmpl.Lookup("metrics.html"))
+	require.NotNil(t, tmpl.Lookup("metric.html"))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics.html"), []byte(`custom {{len .}}`), 0o600))
+
+	for _, override := range []string{dir, filepath.Join(dir, "*.html")} {
+		tmpl, err = Load(override)
+		require.NoError(t, err)
+		var b strings.Builder
+		require.NoError(t, tmpl.ExecuteTemplate(&b, "metrics.html", []int{1, 2}))
+		require.Equal(t, "custom 2", b.String())
+		// остальные шаблоны остаются встроенными
+		require.NotNil(t, tmpl.Lookup("metric.html"))
+	}
+
+	_, err = Load(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestSparkline(t *testing.T) {
+	require.NotContains(t, string(sparkline([]float64{1}, 100, 20)), "polyline")
+	require.Contains(t, string(sparkline([]float64{0, 10}, 100, 20)), `points="2.0,18.0 98.0,2.0"`)
+	// постоянное значение рисуется посередине
+	require.Contains(t, string(sparkline([]float64{5, 5}, 100, 20)), `points="2.0,10.0 98.0,10.0"`)
+}
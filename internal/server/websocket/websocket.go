@@ -0,0 +1,231 @@
+// Package websocket минимальная серверная часть RFC 6455: рукопожатие,
+// отправка текстовых кадров и обработка управляющих кадров клиента.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// CloseNormal и остальные коды закрытия из RFC 6455, раздел 7.4.1.
+	CloseNormal      = 1000
+	CloseGoingAway   = 1001
+	CloseTooBig      = 1009
+	ClosePolicy      = 1008
+	CloseServerError = 1011
+
+	// keyGUID добавляется к Sec-WebSocket-Key при вычислении Sec-WebSocket-Accept.
+	keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxFrame предел входящего кадра, клиенту стрима отправлять нечего.
+	maxFrame     = 64 << 10
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	ErrHandshake = errors.New("bad websocket handshake")
+	ErrClosed    = errors.New("websocket closed")
+)
+
+// Conn соединение после рукопожатия. Писать можно из разных горутин,
+// читать - из одной.
+type Conn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+
+	mu sync.Mutex
+}
+
+// Upgrade проверяет заголовки рукопожатия и забирает соединение у http сервера.
+// При ошибке клиенту уже отправлен ответ 400.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet,
+		!headerContains(r.Header, "Connection", "upgrade"),
+		!headerContains(r.Header, "Upgrade", "websocket"),
+		r.Header.Get("Sec-WebSocket-Version") != "13",
+		key == "":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("can not hijack connection: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := brw.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, rd: brw.Reader}, nil
+}
+
+// AcceptKey значение Sec-WebSocket-Accept для ключа клиента.
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText отправляет текстовое сообщение одним кадром.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// WriteClose отправляет кадр закрытия с кодом и причиной.
+func (c *Conn) WriteClose(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return c.writeFrame(opClose, append(payload, reason...))
+}
+
+// Ping отправляет ping, клиент должен ответить pong.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReadLoop читает кадры клиента до закрытия: отвечает на ping, пропускает
+// данные. Возвращает ErrClosed, если клиент закрыл соединение.
+func (c *Conn) ReadLoop() error {
+	for {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return err
+			}
+		case opClose:
+			code := uint16(CloseNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			_ = c.WriteClose(code, "")
+			return ErrClosed
+		case opText, opBinary, opContinuation, opPong:
+		default:
+			_ = c.WriteClose(ClosePolicy, "unknown opcode")
+			return fmt.Errorf("unknown opcode %d", op)
+		}
+	}
+}
+
+func (c *Conn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rd, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	// кадры клиента обязаны быть замаскированы
+	if !masked {
+		_ = c.WriteClose(ClosePolicy, "unmasked frame")
+		return 0, nil, fmt.Errorf("unmasked client frame")
+	}
+	if n > maxFrame {
+		_ = c.WriteClose(CloseTooBig, "")
+		return 0, nil, fmt.Errorf("frame too big: %d", n)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rd, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return op, payload, nil
+}
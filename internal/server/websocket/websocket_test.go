@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// пример из RFC 6455, раздел 1.3
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "Plain request", headers: map[string]string{}},
+		{name: "No key", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}},
+		{name: "Old version", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "x"}},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tCase.headers {
+				r.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			_, err := Upgrade(rec, r)
+			require.ErrorIs(t, err, ErrHandshake)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
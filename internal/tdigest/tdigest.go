@@ -0,0 +1,110 @@
+// Package tdigest реализует упрощённый merging t-digest для потоковой оценки квантилей.
+// Дайджест хранится как список центроидов, что позволяет складывать дайджесты
+// с разных агентов и хранить их в JSON.
+package tdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression ограничивает число центроидов (примерно compression/2..compression).
+const DefaultCompression = 100
+
+type Centroid struct {
+	Mean   float64 `json:"mean"`
+	Weight float64 `json:"weight"`
+}
+
+// Merge объединяет наборы центроидов и сжимает результат.
+func Merge(compression float64, sets ...[]Centroid) []Centroid {
+	var n int
+	for _, s := range sets {
+		n += len(s)
+	}
+	all := make([]Centroid, 0, n)
+	for _, s := range sets {
+		all = append(all, s...)
+	}
+
+	return Compress(all, compression)
+}
+
+// Compress сортирует центроиды и сливает соседние, пока это допускает функция масштаба k1.
+func Compress(cs []Centroid, compression float64) []Centroid {
+	if len(cs) == 0 {
+		return nil
+	}
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+
+	sorted := make([]Centroid, len(cs))
+	copy(sorted, cs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Mean < sorted[j].Mean })
+
+	var total float64
+	for _, c := range sorted {
+		total += c.Weight
+	}
+
+	res := make([]Centroid, 0, int(compression))
+	cur := sorted[0]
+	var seen float64
+	for _, c := range sorted[1:] {
+		q0 := seen / total
+		q2 := (seen + cur.Weight + c.Weight) / total
+		if scale(q2, compression)-scale(q0, compression) <= 1 {
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / (cur.Weight + c.Weight)
+			cur.Weight += c.Weight
+			continue
+		}
+		res = append(res, cur)
+		seen += cur.Weight
+		cur = c
+	}
+
+	return append(res, cur)
+}
+
+// Quantile оценивает квантиль q (0..1), интерполируя между центрами центроидов.
+// Центроиды должны быть отсортированы (результат Compress).
+func Quantile(cs []Centroid, q float64) float64 {
+	if len(cs) == 0 {
+		return math.NaN()
+	}
+	if len(cs) == 1 {
+		return cs[0].Mean
+	}
+
+	var total float64
+	for _, c := range cs {
+		total += c.Weight
+	}
+
+	target := q * total
+	if target <= cs[0].Weight/2 {
+		return cs[0].Mean
+	}
+	last := cs[len(cs)-1]
+	if target >= total-last.Weight/2 {
+		return last.Mean
+	}
+
+	// center - накопленный вес до центра текущего центроида
+	center := cs[0].Weight / 2
+	for i := 0; i < len(cs)-1; i++ {
+		next := center + (cs[i].Weight+cs[i+1].Weight)/2
+		if target <= next {
+			return cs[i].Mean + (cs[i+1].Mean-cs[i].Mean)*(target-center)/(next-center)
+		}
+		center = next
+	}
+
+	return last.Mean
+}
+
+// scale функция масштаба k1: центроиды у хвостов распределения остаются маленькими.
+func scale(q, compression float64) float64 {
+	return compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
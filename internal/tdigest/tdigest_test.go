@@ -0,0 +1,59 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantile(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	var cs []Centroid
+	for i := 0; i < 10000; i++ {
+		cs = append(cs, Centroid{Mean: r.Float64() * 100, Weight: 1})
+		// сжимаем порциями, как при потоковых обновлениях
+		if len(cs) > 500 {
+			cs = Compress(cs, DefaultCompression)
+		}
+	}
+	cs = Compress(cs, DefaultCompression)
+
+	require.LessOrEqual(t, len(cs), DefaultCompression)
+
+	cases := []struct {
+		q    float64
+		want float64
+	}{
+		{q: 0.5, want: 50},
+		{q: 0.9, want: 90},
+		{q: 0.99, want: 99},
+	}
+	for _, tCase := range cases {
+		require.InDelta(t, tCase.want, Quantile(cs, tCase.q), 1.5)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var a, b []Centroid
+	for i := 1; i <= 500; i++ {
+		a = append(a, Centroid{Mean: float64(i), Weight: 1})
+		b = append(b, Centroid{Mean: float64(i + 500), Weight: 1})
+	}
+
+	cs := Merge(DefaultCompression, Compress(a, DefaultCompression), Compress(b, DefaultCompression))
+
+	var total float64
+	for _, c := range cs {
+		total += c.Weight
+	}
+	require.Equal(t, float64(1000), total)
+	require.InDelta(t, 500, Quantile(cs, 0.5), 10)
+}
+
+func TestQuantileEmpty(t *testing.T) {
+	require.True(t, math.IsNaN(Quantile(nil, 0.5)))
+	require.Equal(t, float64(3), Quantile([]Centroid{{Mean: 3, Weight: 2}}, 0.9))
+}
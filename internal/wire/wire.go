@@ -0,0 +1,52 @@
+// Package wire общие соглашения агента и сервера: типы метрик, ключ серии
+// и заголовки агента. Не зависит от серверного кода, чтобы агент не тянул его за собой.
+package wire
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Типы метрик, которые присылает агент.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Заголовки, которыми агент представляется серверу в каждом запросе.
+const (
+	HeaderAgentID         = "X-Agent-Id"
+	HeaderAgentHostname   = "X-Agent-Hostname"
+	HeaderAgentVersion    = "X-Agent-Version"
+	HeaderAgentCollectors = "X-Agent-Collectors"
+)
+
+// SeriesKey собирает идентификатор серии: имя и отсортированные метки в виде name{a="1",b="2"}.
+// Для метрики без меток идентификатор совпадает с именем.
+func SeriesKey(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(id)
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
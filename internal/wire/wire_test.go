@@ -0,0 +1,25 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "up", want: "up"},
+		{name: "up", labels: map[string]string{}, want: "up"},
+		{name: "up", labels: map[string]string{"job": "node", "host": "a"}, want: `up{host="a",job="node"}`},
+		{name: "up", labels: map[string]string{"path": `a"b`}, want: `up{path="a\"b"}`},
+	}
+	for _, tCase := range cases {
+		t.Run(tCase.want, func(t *testing.T) {
+			require.Equal(t, tCase.want, SeriesKey(tCase.name, tCase.labels))
+		})
+	}
+}